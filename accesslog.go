@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// NewAccessLogger returns the logger the RequestLogger writes the
+// access log to, provided as `name:"access"`: the "http.access" logger
+// of the LoggerFactory. By default that's the app's logger;
+// Config.Log.Access.Output sends it to "stdout", "stderr" or a file
+// instead, so that it can be shipped and kept apart, as
+// Config.Log.Loggers can.
+func NewAccessLogger(loggers *LoggerFactory) *slog.Logger {
+	return loggers.Named(loggerAccess)
+}
+
+// rotatingFile is a log file that is rotated before a write would take
+// it past maxSize bytes: the file is renamed with a ".1" suffix, older
+// backups are shifted to ".2", ".3"... and those past maxBackups are
+// removed.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	perm       os.FileMode
+	f          *os.File
+	size       int64
+}
+
+// newRotatingFile opens the log file at path, appending to it, creating
+// it with the given mode. A maxSize of zero selects 100 MiB and a
+// maxBackups of zero 5.
+func newRotatingFile(path string, maxSize int64, maxBackups int, perm os.FileMode) (*rotatingFile, error) {
+	if maxSize <= 0 {
+		maxSize = 100 << 20
+	}
+	if maxBackups <= 0 {
+		maxBackups = 5
+	}
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups, perm: perm}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, rf.perm)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, fi.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return 0, fs.ErrClosed
+	}
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, fmt.Errorf("rotate %s: %w", rf.path, err)
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file to the first backup and opens a new
+// one.
+func (rf *rotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	rf.f = nil
+	backup := func(i int) string { return rf.path + "." + strconv.Itoa(i) }
+	if err := os.Remove(backup(rf.maxBackups)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for i := rf.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(backup(i), backup(i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(rf.path, backup(1)); err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation.
+const listenFDsStart = 3
+
+// publicSocket is the name, set with FileDescriptorName= in the socket
+// unit, of the activated socket the HTTP server is served on.
+const publicSocket = "public"
+
+// activatedSocket is a socket passed by systemd.
+type activatedSocket struct {
+	fd   uintptr
+	name string
+}
+
+// activatedSockets returns the sockets passed by systemd socket
+// activation, read from LISTEN_FDS, LISTEN_PID and LISTEN_FDNAMES. The
+// variables are unset so that they aren't passed on to other processes.
+// The sockets belong to the process: they're read once, and shared by
+// all the apps running in it.
+var activatedSockets = sync.OnceValues(func() ([]activatedSocket, error) {
+	fds, pid := os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_PID")
+	names := os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if fds == "" {
+		return nil, nil
+	}
+	// The variables are meant for the process systemd started, not for
+	// its children.
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("LISTEN_FDS: invalid count %q", fds)
+	}
+	var split []string
+	if names != "" {
+		split = strings.Split(names, ":")
+	}
+	sockets := make([]activatedSocket, n)
+	for i := range sockets {
+		sockets[i].fd = uintptr(listenFDsStart + i)
+		if i < len(split) {
+			sockets[i].name = split[i]
+		}
+	}
+	return sockets, nil
+})
+
+// activatedListener returns a listener on the activated socket with
+// the given name, or nil if there's none.
+func activatedListener(name string) (net.Listener, uintptr, error) {
+	sockets, err := activatedSockets()
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, s := range sockets {
+		if socketName(s, len(sockets)) != name {
+			continue
+		}
+		f := os.NewFile(s.fd, s.name)
+		defer f.Close()
+		ln, err := net.FileListener(f)
+		if err != nil {
+			return nil, 0, fmt.Errorf("activated socket %d (%s): %w", s.fd, s.name, err)
+		}
+		return ln, s.fd, nil
+	}
+	return nil, 0, nil
+}
+
+// socketName returns the name of the server to serve s, one of n
+// activated sockets. A single socket without a name, as systemd passes
+// when the unit doesn't set FileDescriptorName=, is the public one.
+func socketName(s activatedSocket, n int) string {
+	if n == 1 && (s.name == "" || s.name == "unknown") {
+		return publicSocket
+	}
+	return s.name
+}
+
+// warnUnusedSockets logs the activated sockets that aren't served,
+// given the names of the servers.
+func warnUnusedSockets(log *slog.Logger, servers ...string) {
+	sockets, _ := activatedSockets()
+	for _, s := range sockets {
+		if !slices.Contains(servers, socketName(s, len(sockets))) {
+			log.Warn("No server for activated socket", slog.Int("fd", int(s.fd)), slog.String("name", s.name))
+		}
+	}
+}
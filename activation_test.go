@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestActivationHelper is run by TestActivatedListener as the process
+// systemd would start: it reports, one "listener" line per name listed
+// in ACTIVATION_TEST_NAMES, the address of the listener it's handed.
+func TestActivationHelper(t *testing.T) {
+	names, ok := os.LookupEnv("ACTIVATION_TEST_NAMES")
+	if !ok {
+		return
+	}
+	pid := os.Getpid()
+	if os.Getenv("ACTIVATION_TEST_OTHER_PID") != "" {
+		pid = os.Getppid()
+	}
+	os.Setenv("LISTEN_PID", strconv.Itoa(pid))
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, name := range strings.Split(names, ",") {
+		ln, _, err := activatedListener(name)
+		switch {
+		case err != nil:
+			t.Fatal(err)
+		case ln == nil:
+			fmt.Printf("listener %s none\n", name)
+		default:
+			fmt.Printf("listener %s %s\n", name, ln.Addr())
+			ln.Close()
+		}
+	}
+	for _, env := range []string{"LISTEN_FDS", "LISTEN_PID", "LISTEN_FDNAMES"} {
+		if v, ok := os.LookupEnv(env); ok {
+			t.Errorf("%s=%s left set", env, v)
+		}
+	}
+	// Servers without an activated socket listen on their address.
+	ln, err := listen("unactivated", "127.0.0.1:0", log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+}
+
+func TestActivatedListener(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		fdNames  string
+		sockets  int
+		otherPID bool
+		// want is the index of the socket of each name, or -1 for none.
+		want map[string]int
+	}{
+		{"named", "public:admin", 2, false, map[string]int{publicSocket: 0, "admin": 1, "metrics": -1}},
+		{"single unnamed", "", 1, false, map[string]int{publicSocket: 0}},
+		{"single unknown", "unknown", 1, false, map[string]int{publicSocket: 0}},
+		{"unnamed of several", "", 2, false, map[string]int{publicSocket: -1}},
+		{"other process", "public", 1, true, map[string]int{publicSocket: -1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				addrs []string
+				files []*os.File
+				names []string
+			)
+			for range tc.sockets {
+				ln, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer ln.Close()
+				f, err := ln.(*net.TCPListener).File()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				addrs = append(addrs, ln.Addr().String())
+				files = append(files, f)
+			}
+			for name := range tc.want {
+				names = append(names, name)
+			}
+
+			cmd := exec.Command(os.Args[0], "-test.run=^TestActivationHelper$", "-test.count=1")
+			cmd.ExtraFiles = files
+			cmd.Env = append(os.Environ(),
+				"ACTIVATION_TEST_NAMES="+strings.Join(names, ","),
+				fmt.Sprintf("LISTEN_FDS=%d", tc.sockets),
+			)
+			if tc.fdNames != "" {
+				cmd.Env = append(cmd.Env, "LISTEN_FDNAMES="+tc.fdNames)
+			}
+			if tc.otherPID {
+				cmd.Env = append(cmd.Env, "ACTIVATION_TEST_OTHER_PID=1")
+			}
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("helper: %v\n%s", err, out)
+			}
+			got := make(map[string]string)
+			for _, line := range strings.Split(string(out), "\n") {
+				if fields := strings.Fields(line); len(fields) == 3 && fields[0] == "listener" {
+					got[fields[1]] = fields[2]
+				}
+			}
+			for name, i := range tc.want {
+				want := "none"
+				if i >= 0 {
+					want = addrs[i]
+				}
+				if got[name] != want {
+					t.Errorf("listener %s: got %s, want %s\n%s", name, got[name], want, out)
+				}
+			}
+		})
+	}
+}
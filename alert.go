@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/uberfx/clock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Types of the alerts of the ErrorRateMonitor.
+const (
+	alertErrorRate          = "error_rate"
+	alertErrorRateRecovered = "error_rate_recovered"
+)
+
+// AlertEvent is an alert, as sent to the Alerters.
+type AlertEvent struct {
+	Type    string    `json:"type"`
+	App     string    `json:"app"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	// ErrorRate is the share of the Requests over Window answered with a
+	// server error, the Errors, and Threshold the rate alerted from.
+	ErrorRate float64 `json:"error_rate"`
+	Errors    int64   `json:"errors"`
+	Requests  int64   `json:"requests"`
+	Window    string  `json:"window"`
+	Threshold float64 `json:"threshold"`
+}
+
+// Alerter sends alerts somewhere someone will see them.
+type Alerter interface {
+	Alert(ctx context.Context, e AlertEvent) error
+}
+
+// AsAlerter annotates the given constructor to state that it provides
+// an alerter to the "alerters" group.
+func AsAlerter(f any) any {
+	return AsGroupMember[Alerter]("alerters", f)
+}
+
+// ErrorRateMonitor is the component alerting when the app answers too
+// many requests with server errors. Every Config.Alerts.Interval, it
+// takes the share of the requests counted in http_requests_total over
+// the last Config.Alerts.Window that got a 5xx, and once it reaches
+// Config.Alerts.Threshold, with at least Config.Alerts.MinRequests
+// requests in the window, it sends an error_rate alert to the alerters
+// of the "alerters" group; once it drops back under, an
+// error_rate_recovered one. Alerts of a type are sent at most once per
+// Config.Alerts.Cooldown: a change of state coming sooner is only
+// alerted once the cooldown is over, if it still holds, so that a rate
+// hovering around the threshold doesn't flood anyone. With
+// Config.Alerts.Webhook.URL set, alerts are also posted there, see
+// WebhookAlerter.
+type ErrorRateMonitor struct {
+	alerters    []Alerter
+	app         string
+	interval    time.Duration
+	window      time.Duration
+	threshold   float64
+	minRequests int64
+	cooldown    time.Duration
+	clock       clock.Clock
+	log         *slog.Logger
+	// counts returns the requests counted so far, and the server errors
+	// among them.
+	counts func() (requests, errors int64, err error)
+
+	samples  []errorRateSample
+	alerting bool
+	lastSent map[string]time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// errorRateSample is the counts taken at a time.
+type errorRateSample struct {
+	at               time.Time
+	requests, errors int64
+}
+
+// NewErrorRateMonitor builds a new ErrorRateMonitor.
+func NewErrorRateMonitor(alerters []Alerter, cfg *Config, client *http.Client, clk clock.Clock, log *slog.Logger, reg *prometheus.Registry) *ErrorRateMonitor {
+	c := cfg.Alerts
+	m := &ErrorRateMonitor{
+		app:         cmp.Or(cfg.App.Name, "uberfx"),
+		interval:    time.Duration(c.Interval),
+		window:      time.Duration(c.Window),
+		threshold:   c.Threshold,
+		minRequests: int64(c.MinRequests),
+		cooldown:    time.Duration(c.Cooldown),
+		clock:       clk,
+		log:         log,
+		counts:      func() (int64, int64, error) { return requestCounts(reg) },
+		lastSent:    make(map[string]time.Time),
+	}
+	if m.interval <= 0 {
+		m.interval = 10 * time.Second
+	}
+	if m.window <= 0 {
+		m.window = time.Minute
+	}
+	if m.threshold <= 0 {
+		m.threshold = 0.05
+	}
+	if m.minRequests <= 0 {
+		m.minRequests = 20
+	}
+	if m.cooldown <= 0 {
+		m.cooldown = 5 * time.Minute
+	}
+	if c.Webhook.URL != "" {
+		alerters = append(alerters, NewWebhookAlerter(c.Webhook, client))
+	}
+	m.alerters = alerters
+	return m
+}
+
+func (*ErrorRateMonitor) Name() string {
+	return "error-rate-monitor"
+}
+
+func (*ErrorRateMonitor) Priority() int {
+	return priorityAlerts
+}
+
+// Start checks the error rate on its interval, in the background.
+func (m *ErrorRateMonitor) Start(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		t := m.clock.NewTicker(m.interval)
+		defer t.Stop()
+		for {
+			m.check(ctx)
+			select {
+			case <-t.C():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (m *ErrorRateMonitor) Stop(ctx context.Context) error {
+	m.cancel()
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// check takes the counts, and alerts if the error rate over the window
+// has crossed the threshold either way.
+func (m *ErrorRateMonitor) check(ctx context.Context) {
+	requests, errors, err := m.counts()
+	if err != nil {
+		m.log.Error("Failed to count requests for the error rate", slog.String("err", err.Error()))
+		return
+	}
+	now := m.clock.Now()
+	m.samples = append(m.samples, errorRateSample{at: now, requests: requests, errors: errors})
+	// The oldest sample kept is the last one at least a window old, from
+	// which the window is measured.
+	for len(m.samples) > 1 && !m.samples[1].at.After(now.Add(-m.window)) {
+		m.samples = m.samples[1:]
+	}
+	first := m.samples[0]
+	requests, errors = requests-first.requests, errors-first.errors
+	var rate float64
+	if requests > 0 {
+		rate = float64(errors) / float64(requests)
+	}
+	high := requests >= m.minRequests && rate >= m.threshold
+
+	e := AlertEvent{
+		App:       m.app,
+		Time:      now,
+		ErrorRate: rate,
+		Errors:    errors,
+		Requests:  requests,
+		Window:    m.window.String(),
+		Threshold: m.threshold,
+	}
+	switch {
+	case high && !m.alerting:
+		e.Type = alertErrorRate
+		e.Message = fmt.Sprintf("%.1f%% of the requests of the last %s failed with a server error, over the threshold of %.1f%%",
+			rate*100, m.window, m.threshold*100)
+	case !high && m.alerting:
+		e.Type = alertErrorRateRecovered
+		e.Message = fmt.Sprintf("%.1f%% of the requests of the last %s failed with a server error, back under the threshold of %.1f%%",
+			rate*100, m.window, m.threshold*100)
+	default:
+		return
+	}
+	if last, ok := m.lastSent[e.Type]; ok && now.Sub(last) < m.cooldown {
+		return
+	}
+	m.lastSent[e.Type] = now
+	m.alerting = high
+	m.send(ctx, e)
+}
+
+// send sends e to every alerter, logging those failing.
+func (m *ErrorRateMonitor) send(ctx context.Context, e AlertEvent) {
+	for _, a := range m.alerters {
+		if err := a.Alert(ctx, e); err != nil {
+			m.log.Error("Failed to send alert", slog.String("alert", e.Type), slog.String("alerter", fmt.Sprintf("%T", a)), slog.String("err", err.Error()))
+		}
+	}
+}
+
+// requestCounts returns the requests counted so far in the
+// http_requests_total of reg, and the server errors among them.
+func requestCounts(reg *prometheus.Registry) (requests, errors int64, err error) {
+	families, err := reg.Gather()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, f := range families {
+		if f.GetName() != "http_requests_total" {
+			continue
+		}
+		for _, metric := range f.GetMetric() {
+			n := int64(metric.GetCounter().GetValue())
+			requests += n
+			for _, l := range metric.GetLabel() {
+				if l.GetName() == "status" && strings.HasPrefix(l.GetValue(), "5") {
+					errors += n
+				}
+			}
+		}
+	}
+	return requests, errors, nil
+}
+
+// LogAlerter is the Alerter logging alerts, as warnings, and recoveries
+// as information.
+type LogAlerter struct {
+	log *slog.Logger
+}
+
+// NewLogAlerter builds a new LogAlerter.
+func NewLogAlerter(log *slog.Logger) *LogAlerter {
+	return &LogAlerter{log: log}
+}
+
+func (a *LogAlerter) Alert(ctx context.Context, e AlertEvent) error {
+	level := slog.LevelWarn
+	if e.Type == alertErrorRateRecovered {
+		level = slog.LevelInfo
+	}
+	a.log.Log(ctx, level, "Alert: "+e.Message,
+		slog.String("alert", e.Type),
+		slog.Float64("error_rate", e.ErrorRate),
+		slog.Int64("errors", e.Errors),
+		slog.Int64("requests", e.Requests),
+		slog.String("window", e.Window),
+	)
+	return nil
+}
+
+// WebhookAlerter is the Alerter posting alerts, as JSON AlertEvents, to
+// Config.Alerts.Webhook.URL with the app's client. Posts time out after
+// Config.Alerts.Webhook.Timeout; any status but a 2xx is a failure.
+type WebhookAlerter struct {
+	url     string
+	timeout time.Duration
+	client  *http.Client
+}
+
+// NewWebhookAlerter builds a new WebhookAlerter.
+func NewWebhookAlerter(cfg AlertWebhookConfig, client *http.Client) *WebhookAlerter {
+	a := &WebhookAlerter{url: cfg.URL, timeout: time.Duration(cfg.Timeout), client: client}
+	if a.timeout <= 0 {
+		a.timeout = 5 * time.Second
+	}
+	return a
+}
+
+func (a *WebhookAlerter) Alert(ctx context.Context, e AlertEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("alert webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+)
+
+// recordingAlerter is an Alerter recording the alerts sent to it.
+type recordingAlerter struct {
+	alerts []AlertEvent
+}
+
+func (a *recordingAlerter) Alert(_ context.Context, e AlertEvent) error {
+	a.alerts = append(a.alerts, e)
+	return nil
+}
+
+func TestErrorRateMonitor(t *testing.T) {
+	cfg := &Config{}
+	cfg.Alerts.Threshold = 0.1
+	cfg.Alerts.MinRequests = 10
+	cfg.Alerts.Window = Duration(time.Minute)
+	cfg.Alerts.Cooldown = Duration(5 * time.Minute)
+	clk := testsupport.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	alerter := &recordingAlerter{}
+	m := NewErrorRateMonitor([]Alerter{alerter}, cfg, http.DefaultClient, clk, slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+
+	var requests, errors int64
+	m.counts = func() (int64, int64, error) { return requests, errors, nil }
+	// step advances the clock by d, counts the given requests and server
+	// errors, and checks the rate, returning the type of the alert sent,
+	// if any.
+	step := func(d time.Duration, newRequests, newErrors int64) string {
+		clk.Advance(d)
+		requests += newRequests
+		errors += newErrors
+		sent := len(alerter.alerts)
+		m.check(context.Background())
+		switch len(alerter.alerts) - sent {
+		case 0:
+			return ""
+		case 1:
+			return alerter.alerts[sent].Type
+		}
+		t.Fatalf("sent %d alerts at once", len(alerter.alerts)-sent)
+		return ""
+	}
+
+	for i, tc := range []struct {
+		after            time.Duration
+		requests, errors int64
+		want             string
+	}{
+		{0, 0, 0, ""},
+		// Too few requests to tell.
+		{5 * time.Second, 5, 5, ""},
+		{5 * time.Second, 95, 15, alertErrorRate},
+		// Still over the threshold, already alerted.
+		{10 * time.Second, 10, 2, ""},
+		{10 * time.Second, 900, 0, alertErrorRateRecovered},
+		// Back over within the cooldown of the first alert.
+		{10 * time.Second, 1000, 500, ""},
+		// The cooldown is over, and the rate over the last window still
+		// high.
+		{5 * time.Minute, 100, 50, alertErrorRate},
+	} {
+		if got := step(tc.after, tc.requests, tc.errors); got != tc.want {
+			t.Errorf("step %d: got alert %q, want %q", i, got, tc.want)
+		}
+	}
+
+	e := alerter.alerts[0]
+	if e.App != "uberfx" || e.Requests != 100 || e.Errors != 20 || e.ErrorRate != 0.2 || e.Window != "1m0s" || e.Threshold != 0.1 {
+		t.Errorf("got first alert %+v", e)
+	}
+}
+
+func TestWebhookAlerter(t *testing.T) {
+	var got AlertEvent
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s with content type %q, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	a := NewWebhookAlerter(AlertWebhookConfig{URL: srv.URL}, srv.Client())
+	e := AlertEvent{
+		Type:      alertErrorRate,
+		App:       "shop",
+		Time:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Message:   "too many errors",
+		ErrorRate: 0.25,
+		Errors:    25,
+		Requests:  100,
+		Window:    "1m0s",
+		Threshold: 0.1,
+	}
+	if err := a.Alert(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	if got != e {
+		t.Errorf("posted %+v, want %+v", got, e)
+	}
+
+	status = http.StatusBadGateway
+	if err := a.Alert(context.Background(), e); err == nil {
+		t.Error("got no error for a 502 answer")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+
+	"example.com/uberfx/httpjson"
+)
+
+// PlainJSONRoute is implemented by routes whose JSON responses are
+// always plain, without the envelope and as encoding/json names their
+// fields, such as documents meant for tools rather than API clients.
+type PlainJSONRoute interface {
+	PlainJSON() bool
+}
+
+// APIResponses is middleware shaping the JSON responses written with
+// httpjson, errors included, as Config.API says: wrapped in an
+// envelope, and with fields named in a given case. As route
+// middleware, it keeps responses plain for the routes listed in
+// Config.API.PlainRoutes or implementing PlainJSONRoute.
+type APIResponses struct {
+	opts  httpjson.Options
+	plain []string
+}
+
+// NewAPIResponses builds a new APIResponses.
+func NewAPIResponses(cfg *Config) (*APIResponses, error) {
+	fc, err := httpjson.ParseFieldCase(cfg.API.FieldCase)
+	if err != nil {
+		return nil, err
+	}
+	return &APIResponses{
+		opts:  httpjson.Options{Envelope: cfg.API.Envelope, FieldCase: fc},
+		plain: cfg.API.PlainRoutes,
+	}, nil
+}
+
+func (*APIResponses) Order() int {
+	return orderAPIResponses
+}
+
+func (m *APIResponses) Wrap(next http.Handler) http.Handler {
+	if m.opts == (httpjson.Options{}) {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(httpjson.WithOptions(r.Context(), m.opts)))
+	})
+}
+
+func (m *APIResponses) WrapRoute(route Route, next http.Handler) http.Handler {
+	if m.opts == (httpjson.Options{}) || !m.isPlain(route) {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(httpjson.WithOptions(r.Context(), httpjson.Options{})))
+	})
+}
+
+func (m *APIResponses) isPlain(route Route) bool {
+	for _, want := range m.plain {
+		if matchRoute(want, route.Pattern()) {
+			return true
+		}
+	}
+	p, ok := route.(PlainJSONRoute)
+	return ok && p.PlainJSON()
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/uberfx/clock"
+	"example.com/uberfx/httpjson"
+	"example.com/uberfx/reqctx"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// apiKeyHeader carries the API key of a request.
+const apiKeyHeader = "X-API-Key"
+
+// Errors reported for requests with an API key that may not be used.
+var (
+	ErrInvalidAPIKey  = errors.New("invalid API key")
+	ErrAPIKeyDisabled = errors.New("this API key is disabled")
+	// ErrRateLimited is reported, wrapped in a *RateLimitError, for
+	// requests over the rate limit of their key.
+	ErrRateLimited = errors.New("rate limit exceeded")
+)
+
+// RateLimitError is the error of a request turned away for going over
+// its rate limit.
+type RateLimitError struct {
+	// Wait is how long until the request would be allowed.
+	Wait time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return ErrRateLimited.Error()
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// RetryAfter is sent to the client as the Retry-After header, see
+// ErrorWriter.Write.
+func (e *RateLimitError) RetryAfter() time.Duration {
+	return e.Wait
+}
+
+// APIKey describes an API key, identified by its name.
+type APIKey struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles,omitempty"`
+	// RateLimit is how many requests per second the key may make, in
+	// bursts of up to Burst; zero means no limit.
+	RateLimit float64 `json:"rate_limit,omitempty"`
+	Burst     int     `json:"burst,omitempty"`
+	Enabled   bool    `json:"enabled"`
+}
+
+// APIKeyStore holds the API keys accepted by the APIKeyAuth.
+type APIKeyStore interface {
+	// Lookup returns the API key of the given value, or false if there's
+	// none.
+	Lookup(ctx context.Context, key string) (APIKey, bool, error)
+	// Keys returns all the API keys, by name.
+	Keys(ctx context.Context) ([]APIKey, error)
+}
+
+// NewAPIKeyStore builds the APIKeyStore of Config.Auth: a
+// FileAPIKeyStore if Config.Auth.APIKeysFile is set, else a
+// MemoryAPIKeyStore of Config.Auth.APIKeys.
+func NewAPIKeyStore(cfg *Config) (APIKeyStore, error) {
+	if cfg.Auth.APIKeysFile != "" {
+		return NewFileAPIKeyStore(cfg.Auth.APIKeysFile)
+	}
+	return NewMemoryAPIKeyStore(cfg.Auth.APIKeys)
+}
+
+// MemoryAPIKeyStore is an APIKeyStore that keeps keys in memory. Keys
+// are looked up by their hash, as StaticTokens are.
+type MemoryAPIKeyStore struct {
+	keys atomic.Pointer[apiKeySet]
+}
+
+type apiKeySet struct {
+	byHash map[[sha256.Size]byte]APIKey
+	byName []APIKey
+}
+
+// NewMemoryAPIKeyStore builds a new MemoryAPIKeyStore of the given keys.
+func NewMemoryAPIKeyStore(keys []APIKeyConfig) (*MemoryAPIKeyStore, error) {
+	s := &MemoryAPIKeyStore{}
+	if err := s.SetKeys(keys); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetKeys replaces the keys of the store. On error, the keys are left
+// as they were.
+func (s *MemoryAPIKeyStore) SetKeys(keys []APIKeyConfig) error {
+	set := &apiKeySet{byHash: make(map[[sha256.Size]byte]APIKey, len(keys))}
+	names := make(map[string]bool, len(keys))
+	for i, c := range keys {
+		switch {
+		case c.Key == "" || c.Name == "":
+			return fmt.Errorf("API key %d: want a key and a name", i)
+		case names[c.Name]:
+			return fmt.Errorf("API key %q listed twice", c.Name)
+		case c.RateLimit < 0 || c.Burst < 0:
+			return fmt.Errorf("API key %q: negative rate limit", c.Name)
+		}
+		hash := sha256.Sum256([]byte(c.Key))
+		if _, ok := set.byHash[hash]; ok {
+			return fmt.Errorf("API key %q: key of another API key", c.Name)
+		}
+		names[c.Name] = true
+		k := APIKey{Name: c.Name, Roles: c.Roles, RateLimit: c.RateLimit, Burst: c.Burst, Enabled: !c.Disabled}
+		if k.RateLimit > 0 && k.Burst == 0 {
+			k.Burst = max(int(math.Ceil(k.RateLimit)), 1)
+		}
+		set.byHash[hash] = k
+		set.byName = append(set.byName, k)
+	}
+	sort.Slice(set.byName, func(i, j int) bool { return set.byName[i].Name < set.byName[j].Name })
+	s.keys.Store(set)
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) Lookup(_ context.Context, key string) (APIKey, bool, error) {
+	k, ok := s.keys.Load().byHash[sha256.Sum256([]byte(key))]
+	return k, ok, nil
+}
+
+func (s *MemoryAPIKeyStore) Keys(context.Context) ([]APIKey, error) {
+	return s.keys.Load().byName, nil
+}
+
+// FileAPIKeyStore is an APIKeyStore of the keys listed in a JSON file,
+// in the form of Config.Auth.APIKeys. The file is re-read by Reload,
+// which the SecretReloader calls on SIGHUP.
+type FileAPIKeyStore struct {
+	*MemoryAPIKeyStore
+	path string
+}
+
+// NewFileAPIKeyStore builds a new FileAPIKeyStore of the keys in the
+// file at path.
+func NewFileAPIKeyStore(path string) (*FileAPIKeyStore, error) {
+	s := &FileAPIKeyStore{MemoryAPIKeyStore: &MemoryAPIKeyStore{}, path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the file. On error, the keys are left as they were.
+func (s *FileAPIKeyStore) Reload() error {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("API keys: %w", err)
+	}
+	var keys []APIKeyConfig
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return fmt.Errorf("API keys: %s: %w", s.path, err)
+	}
+	if err := s.SetKeys(keys); err != nil {
+		return fmt.Errorf("API keys: %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// APIKeyAuth is middleware that authenticates requests carrying an
+// X-API-Key header with the APIKeyStore, making the name of the key
+// their principal, with its roles, as BearerAuth does for tokens; a
+// request carrying both is authenticated by its key. Requests with an
+// unknown key get a 401, those with a disabled one a 403, and those over
+// the rate limit of their key a 429 with a Retry-After header. The
+// requests of each key are counted by result, in
+// http_api_key_requests_total and at GET /admin/apikeys/usage.
+type APIKeyAuth struct {
+	store    APIKeyStore
+	errs     *ErrorWriter
+	clock    clock.Clock
+	limiter  *rateLimiter
+	requests *prometheus.CounterVec
+
+	mu    sync.Mutex
+	usage map[string]*apiKeyUsage
+}
+
+// apiKeyUsage counts the requests of a key.
+type apiKeyUsage struct {
+	allowed     atomic.Int64
+	rateLimited atomic.Int64
+	disabled    atomic.Int64
+	// lastUsed is the time of the last request, in Unix nanoseconds.
+	lastUsed atomic.Int64
+}
+
+// NewAPIKeyAuth builds a new APIKeyAuth.
+func NewAPIKeyAuth(store APIKeyStore, errs *ErrorWriter, clk clock.Clock, reg *prometheus.Registry) *APIKeyAuth {
+	m := &APIKeyAuth{
+		store:   store,
+		errs:    errs,
+		clock:   clk,
+		limiter: newRateLimiter(clk),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_api_key_requests_total",
+			Help: "Requests made with an API key, by key name and result.",
+		}, []string{"key", "result"}),
+		usage: make(map[string]*apiKeyUsage),
+	}
+	reg.MustRegister(m.requests)
+	return m
+}
+
+func (*APIKeyAuth) Order() int {
+	return orderAPIKeyAuth
+}
+
+func (m *APIKeyAuth) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get(apiKeyHeader)
+		if value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, ok, err := m.store.Lookup(r.Context(), value)
+		if err != nil {
+			m.errs.Write(w, r, err)
+			return
+		}
+		if !ok {
+			m.errs.Write(w, r, ErrInvalidAPIKey)
+			return
+		}
+		usage := m.usageOf(key.Name)
+		usage.lastUsed.Store(m.clock.Now().UnixNano())
+		if !key.Enabled {
+			usage.disabled.Add(1)
+			m.requests.WithLabelValues(key.Name, "disabled").Inc()
+			m.errs.Write(w, r, ErrAPIKeyDisabled)
+			return
+		}
+		if ok, wait := m.limiter.allow(key.Name, key.RateLimit, key.Burst); !ok {
+			usage.rateLimited.Add(1)
+			m.requests.WithLabelValues(key.Name, "rate_limited").Inc()
+			m.errs.Write(w, r, &RateLimitError{Wait: wait})
+			return
+		}
+		usage.allowed.Add(1)
+		m.requests.WithLabelValues(key.Name, "allowed").Inc()
+		ctx := reqctx.WithPrincipal(r.Context(), key.Name)
+		ctx = reqctx.WithRoles(ctx, key.Roles)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (m *APIKeyAuth) usageOf(name string) *apiKeyUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.usage[name]
+	if !ok {
+		u = &apiKeyUsage{}
+		m.usage[name] = u
+	}
+	return u
+}
+
+// APIKeyUsage is the usage of an API key since the app started.
+type APIKeyUsage struct {
+	APIKey
+	Requests    int64      `json:"requests"`
+	RateLimited int64      `json:"rate_limited"`
+	Disabled    int64      `json:"disabled"`
+	LastUsed    *time.Time `json:"last_used,omitempty"`
+}
+
+// Usage returns the usage of the keys of the store, by name.
+func (m *APIKeyAuth) Usage(ctx context.Context) ([]APIKeyUsage, error) {
+	keys, err := m.store.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	usage := make([]APIKeyUsage, 0, len(keys))
+	for _, k := range keys {
+		u := m.usageOf(k.Name)
+		ku := APIKeyUsage{
+			APIKey:      k,
+			Requests:    u.allowed.Load(),
+			RateLimited: u.rateLimited.Load(),
+			Disabled:    u.disabled.Load(),
+		}
+		if last := u.lastUsed.Load(); last != 0 {
+			t := time.Unix(0, last).UTC()
+			ku.LastUsed = &t
+		}
+		usage = append(usage, ku)
+	}
+	return usage, nil
+}
+
+// APIKeyUsageHandler reports the usage of each API key at GET
+// /admin/apikeys/usage, see APIKeyAuth.Usage.
+type APIKeyUsageHandler struct {
+	auth *APIKeyAuth
+	errs *ErrorWriter
+}
+
+// NewAPIKeyUsageHandler builds a new APIKeyUsageHandler.
+func NewAPIKeyUsageHandler(auth *APIKeyAuth, errs *ErrorWriter) *APIKeyUsageHandler {
+	return &APIKeyUsageHandler{auth: auth, errs: errs}
+}
+
+func (*APIKeyUsageHandler) Pattern() string {
+	return "GET /admin/apikeys/usage"
+}
+
+func (h *APIKeyUsageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	usage, err := h.auth.Usage(r.Context())
+	if err != nil {
+		h.errs.Write(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	_ = httpjson.Respond(w, r, http.StatusOK, usage)
+}
+
+// rateLimiter is a token bucket per key, refilled at the rate given on
+// each request.
+type rateLimiter struct {
+	clock clock.Clock
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(clk clock.Clock) *rateLimiter {
+	return &rateLimiter{clock: clk, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow reports whether a request of key is allowed at rate per second,
+// in bursts of up to burst, taking a token if so; if not, it returns how
+// long until the next token. A zero rate allows every request.
+func (l *rateLimiter) allow(key string, rate float64, burst int) (bool, time.Duration) {
+	if rate <= 0 {
+		return true, 0
+	}
+	now := l.clock.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*rate, float64(burst))
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
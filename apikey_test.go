@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/uberfx/reqctx"
+	"example.com/uberfx/testsupport"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newAPIKeyTest builds an APIKeyAuth of store in front of a handler
+// answering with the principal and roles of the request.
+func newAPIKeyTest(t *testing.T, store APIKeyStore) (*APIKeyAuth, http.Handler, *testsupport.FakeClock) {
+	t.Helper()
+	clk := testsupport.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	auth := NewAPIKeyAuth(store, newTestErrorWriter(t), clk, prometheus.NewRegistry())
+	h := auth.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %v", reqctx.Principal(r.Context()), reqctx.Roles(r.Context()))
+	}))
+	return auth, h, clk
+}
+
+func withAPIKey(h http.Handler, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if key != "" {
+		req.Header.Set(apiKeyHeader, key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	store, err := NewMemoryAPIKeyStore([]APIKeyConfig{
+		{Key: "ops-key", Name: "ops", Roles: []string{"admin"}},
+		{Key: "a-key", Name: "a", RateLimit: 1},
+		{Key: "b-key", Name: "b", RateLimit: 1},
+		{Key: "old-key", Name: "old", Disabled: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, h, clk := newAPIKeyTest(t, store)
+
+	for _, tc := range []struct {
+		key    string
+		status int
+		body   string
+	}{
+		{"ops-key", http.StatusOK, "ops [admin]"},
+		{"", http.StatusOK, " []"},
+		{"nope", http.StatusUnauthorized, ""},
+		{"old-key", http.StatusForbidden, ""},
+	} {
+		rec := withAPIKey(h, tc.key)
+		if rec.Code != tc.status || (tc.body != "" && rec.Body.String() != tc.body) {
+			t.Errorf("key %q: got %d %q, want %d %q", tc.key, rec.Code, rec.Body, tc.status, tc.body)
+		}
+	}
+
+	// Each key has its own bucket.
+	if rec := withAPIKey(h, "a-key"); rec.Code != http.StatusOK {
+		t.Errorf("first request of a: got %d", rec.Code)
+	}
+	if rec := withAPIKey(h, "a-key"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("second request of a: got %d with Retry-After %q, want 429 with 1", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := withAPIKey(h, "b-key"); rec.Code != http.StatusOK {
+		t.Errorf("first request of b: got %d, want 200 with a over its limit", rec.Code)
+	}
+	clk.Advance(time.Second)
+	if rec := withAPIKey(h, "a-key"); rec.Code != http.StatusOK {
+		t.Errorf("request of a a second later: got %d", rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	NewAPIKeyUsageHandler(auth, newTestErrorWriter(t)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/apikeys/usage", nil))
+	var usage []APIKeyUsage
+	if err := json.Unmarshal(rec.Body.Bytes(), &usage); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("usage: got %d %s", rec.Code, rec.Body)
+	}
+	var got []string
+	for _, u := range usage {
+		last := "never"
+		if u.LastUsed != nil {
+			last = u.LastUsed.Format(time.TimeOnly)
+		}
+		got = append(got, fmt.Sprintf("%s:%d/%d/%d@%s", u.Name, u.Requests, u.RateLimited, u.Disabled, last))
+	}
+	want := "a:2/1/0@00:00:01 b:1/0/0@00:00:00 old:0/0/1@00:00:00 ops:1/0/0@00:00:00"
+	if strings.Join(got, " ") != want {
+		t.Errorf("usage: got %s, want %s", strings.Join(got, " "), want)
+	}
+}
+
+func TestFileAPIKeyStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`[{"key": "ci-key", "name": "ci"}]`)
+	store, err := NewFileAPIKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, h, _ := newAPIKeyTest(t, store)
+	if rec := withAPIKey(h, "ci-key"); rec.Code != http.StatusOK {
+		t.Fatalf("before the reload: got %d", rec.Code)
+	}
+
+	write(`[{"key": "ci-key", "name": "ci", "disabled": true}]`)
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if rec := withAPIKey(h, "ci-key"); rec.Code != http.StatusForbidden {
+		t.Errorf("after disabling the key: got %d, want 403", rec.Code)
+	}
+
+	// A broken file leaves the keys as they were.
+	write(`[{"key": "ci-key"}]`)
+	if err := store.Reload(); err == nil {
+		t.Error("got no error reloading a key without a name")
+	}
+	if rec := withAPIKey(h, "ci-key"); rec.Code != http.StatusForbidden {
+		t.Errorf("after a failed reload: got %d, want 403", rec.Code)
+	}
+}
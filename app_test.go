@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/uberfx/clock"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/fx"
+)
+
+// startTestApp starts the app on an ephemeral port of the loopback
+// interface, with its config edited by configure, and returns its base
+// URL. The app is stopped once the test is over.
+func startTestApp(t *testing.T, configure func(*Config), opts ...fx.Option) string {
+	t.Helper()
+	var info *ServerInfo
+	opts = append(opts, fx.Populate(&info), fx.Decorate(func(c *Config) *Config {
+		cfg := *c
+		cfg.Server.Addr = "127.0.0.1:0"
+		cfg.Log.FxEvents = "on-error"
+		if configure != nil {
+			configure(&cfg)
+		}
+		return &cfg
+	}))
+	app := NewApp(opts...)
+	if err := app.Err(); err != nil {
+		t.Fatalf("build app: %v", err)
+	}
+	stop, err := startApp(app)
+	if err != nil {
+		t.Fatalf("start app: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := stop(); err != nil {
+			t.Errorf("stop app: %v", err)
+		}
+	})
+	return "http://" + info.Addr().String()
+}
+
+// withTokens has the app accept the bearer tokens "admin-token" of ann,
+// an admin, and "user-token" of bob, who holds no role.
+func withTokens(cfg *Config) {
+	cfg.Auth.Tokens = []string{"ann:admin-token", "bob:user-token"}
+	cfg.Auth.Roles = map[string][]string{"ann": {"admin"}}
+}
+
+// do sends a request with the given bearer token, if any, and returns
+// the status and body of the response.
+func do(t *testing.T, method, url, token, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+// newTestErrorWriter builds an ErrorWriter for the components tested
+// outside of an app.
+func newTestErrorWriter(t *testing.T) *ErrorWriter {
+	t.Helper()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	catalog, err := NewCatalog(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewErrorWriter(log, prometheus.NewRegistry(), catalog, clock.Real())
+}
+
+// TestTwoApps runs two apps side by side in one process, in different
+// envs, and checks that neither sees the state of the other.
+func TestTwoApps(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"base.json":    `{"env": "staging"}`,
+		"staging.json": `{"app": {"name": "staging-app"}}`,
+		"test.json":    `{"app": {"name": "test-app"}}`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("CONFIG_FILE", filepath.Join(dir, "base.json"))
+
+	var staging, test *Config
+	stagingURL := startTestApp(t, withTokens, fx.Populate(&staging))
+	testURL := startTestApp(t, withTokens, WithEnv("test"), fx.Populate(&test))
+	if staging.Env != "staging" || staging.App.Name != "staging-app" {
+		t.Errorf("first app: got env %q, name %q, want staging, staging-app", staging.Env, staging.App.Name)
+	}
+	if test.Env != "test" || test.App.Name != "test-app" {
+		t.Errorf("second app: got env %q, name %q, want test, test-app", test.Env, test.App.Name)
+	}
+
+	if status, _ := do(t, http.MethodPost, stagingURL+"/echo", "", `"hello"`); status != http.StatusOK {
+		t.Fatalf("echo: got %d", status)
+	}
+	requestBytes := func(base string) int64 {
+		status, body := do(t, http.MethodGet, base+"/debug/vars", "admin-token", "")
+		var vars struct {
+			Bytes int64 `json:"http_request_body_bytes"`
+		}
+		if err := json.Unmarshal([]byte(body), &vars); status != http.StatusOK || err != nil {
+			t.Fatalf("debug vars: got %d %v %s", status, err, body)
+		}
+		return vars.Bytes
+	}
+	if n := requestBytes(stagingURL); n != 7 {
+		t.Errorf("first app: got %d request bytes, want 7", n)
+	}
+	if n := requestBytes(testURL); n != 0 {
+		t.Errorf("second app: got %d request bytes, want 0", n)
+	}
+}
+
+func TestStartTimeout(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(`{"app": {"start_timeout": "150ms"}, "log": {"fx_events": "on-error"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", file)
+
+	migrate := fx.Invoke(func(lc fx.Lifecycle) {
+		lc.Append(fx.StartHook(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}))
+	})
+	app := NewApp(ephemeralAddr, migrate)
+	if got := app.StartTimeout(); got != 150*time.Millisecond {
+		t.Fatalf("start timeout = %s, want 150ms", got)
+	}
+	stop, err := startApp(app)
+	if err == nil {
+		stop()
+		t.Fatal("app started, want it to time out")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "150ms") {
+		t.Errorf("got error %q, want a deadline error naming the 150ms timeout", err)
+	}
+}
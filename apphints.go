@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.uber.org/fx"
+)
+
+// HintError is an error building the app, along with a hint at how to
+// fix it: where the missing provider goes and which helper provides it.
+// The original error is kept for errors.Is and errors.As.
+type HintError struct {
+	Err  error
+	Hint string
+}
+
+func (e *HintError) Error() string {
+	return e.Err.Error() + "\nhint: " + e.Hint
+}
+
+func (e *HintError) Unwrap() error {
+	return e.Err
+}
+
+// providerHints names, for the types provided in NewApp that new code
+// most often depends on, where they come from.
+var providerHints = map[string]string{
+	"*main.Config":                "the Config is provided by NewConfig in NewApp; outside of it, add fx.Provide(NewConfig), or fx.Supply(&Config{...}) for a fixed one",
+	"*slog.Logger":                `the logger is provided by the "logging" module in NewApp; outside of it, add fx.Supply(slog.Default())`,
+	`*slog.Logger[name="access"]`: `the access logger is provided by NewAccessLogger in the "logging" module of NewApp, and is taken with fx.ParamTags(` + "`name:\"access\"`" + `)`,
+	"*main.LoggerFactory":         `the named loggers of components are provided by the LoggerFactory of the "logging" module in NewApp`,
+	"clock.Clock":                 "the clock is provided by clock.Real in NewApp; tests replace it with a testsupport.FakeClock, with fx.Decorate",
+	"*prometheus.Registry":        "the metrics registry is provided by NewMetricsRegistry in NewApp",
+	"*main.ErrorWriter":           "the ErrorWriter is provided by NewErrorWriter in NewApp, and needs NewCatalog",
+	"*main.Catalog":               "the message catalog is provided by NewCatalog in NewApp",
+	"*main.ShutdownRecorder":      "shut the app down through the ShutdownRecorder provided by NewShutdownRecorder in NewApp, rather than fx.Shutdowner",
+	"main.FeatureFlags":           "feature flags are provided by NewConfigFlags in NewApp, annotated with fx.As(new(FeatureFlags))",
+	"main.TokenValidator":         "token validation is provided by NewStaticTokens in NewApp, annotated with fx.As(new(TokenValidator))",
+	"main.SessionStore":           "sessions are stored by NewMemorySessionStore in NewApp, annotated with fx.As(new(SessionStore))",
+	"main.APIKeyStore":            "API keys are stored by the APIKeyStore NewAPIKeyStore selects with auth.api_keys_file in NewApp",
+	"main.AuditSink":              "audit records are written by NewSlogAuditSink in NewApp, annotated with fx.As(new(AuditSink))",
+	"main.QuotaStore":             "quotas are stored by NewMemoryQuotaStore in NewApp, annotated with fx.As(new(QuotaStore))",
+	"*main.SessionManager":        "sessions are managed by NewSessionManager in NewApp",
+	"*main.EventBus":              "events are published on the EventBus provided by NewEventBus in NewApp",
+	"*http.Client":                "the outbound client is provided by NewHTTPClient in NewApp",
+	"*main.Readiness":             "readiness is provided by NewReadiness in NewApp",
+	"*main.BuildInfo":             "build info is provided by NewBuildInfo in NewApp",
+	"main.Greeter":                "greetings are made by the Greeter NewGreeter selects with hello.backend in NewApp",
+	"*main.ConnTracker":           "connections are tracked by NewConnTracker in NewApp",
+	"*main.ServerTLS":             "the server's TLS setup is provided by NewServerTLS in NewApp",
+	"*main.Provisions":            "provisions are supplied with fx.Supply in NewApp",
+	"*main.RouteTable":            "the route table is provided by NewRouteTable in NewApp",
+	"*main.GreetingStats":         "greeting stats are provided by NewGreetingStats in NewApp",
+	"*main.Maintenance":           "maintenance mode is provided by NewMaintenance in NewApp",
+	"*main.WorkerPool":            "CPU-heavy work is run by the WorkerPool provided by NewWorkerPool in NewApp",
+	"*main.ComponentCoordinator":  `components are coordinated by NewComponentCoordinator in NewApp, which takes the "components" group`,
+}
+
+// groupHints names, for the types collected in value groups, the group
+// and the helper providing into it.
+var groupHints = map[string]struct{ group, helper string }{
+	"main.Route":           {"routes", "AsRoute"},
+	"main.RouteRegistrar":  {"registrars", "AsRegistrar"},
+	"main.Middleware":      {"middleware", "AsMiddleware"},
+	"main.RouteMiddleware": {"route_middleware", "AsRouteMiddleware"},
+	"main.Component":       {"components", "AsComponent"},
+	"main.Subscriber":      {"subscribers", "AsSubscriber"},
+	"main.Transformer":     {"transformers", "AsTransformer"},
+	"main.Warmer":          {"warmers", "AsWarmer"},
+	"main.SmokeCheck":      {"smokechecks", "AsSmokeCheck"},
+	"main.HealthChecker":   {"health_checkers", "AsHealthChecker"},
+	"main.Alerter":         {"alerters", "AsAlerter"},
+}
+
+// missingTypes matches the types dig reports missing, as in "missing
+// types: *main.Config; main.Route".
+var (
+	missingTypes = regexp.MustCompile(`missing types?: (.+)$`)
+	didYouMean   = regexp.MustCompile(`^(.+?)(?: \(did you mean (.+)\?\))?$`)
+)
+
+// explainAppError wraps err, an error building the app, in a HintError
+// if it's one of the common wiring mistakes: a dependency on a type
+// provided elsewhere in NewApp, on a member of a value group taken
+// alone, or on a pointer where the value is provided, or the reverse.
+// Other errors are returned as they are.
+func explainAppError(err error) error {
+	if err == nil {
+		return nil
+	}
+	m := missingTypes.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	var hints []string
+	for _, t := range strings.Split(m[1], "; ") {
+		if hint := missingTypeHint(didYouMean.FindStringSubmatch(t)[1:]); hint != "" {
+			hints = append(hints, hint)
+		}
+	}
+	if len(hints) == 0 {
+		return err
+	}
+	return &HintError{Err: err, Hint: strings.Join(hints, "; ")}
+}
+
+// missingTypeHint returns the hint for a missing type, given as the type
+// and the type dig suggests instead, if any.
+func missingTypeHint(m []string) string {
+	typ, suggested := m[0], m[1]
+	if suggested != "" {
+		return fmt.Sprintf("%s is provided, not %s: have its constructor return %s, or depend on %s",
+			suggested, typ, typ, suggested)
+	}
+	if g, ok := groupHints[typ]; ok {
+		return fmt.Sprintf("%s values are collected in the %q value group: provide them with %s and take them all as []%s with fx.ParamTags(`group:%q`)",
+			typ, g.group, g.helper, typ, g.group)
+	}
+	if hint, ok := providerHints[typ]; ok {
+		return hint
+	}
+	if strings.HasPrefix(strings.TrimPrefix(typ, "*"), "main.") {
+		return fmt.Sprintf("nothing provides %s: add its constructor to fx.Provide in NewApp, wrapped in AsRoute, AsRegistrar, AsMiddleware, AsRouteMiddleware or AsComponent if it's a route, middleware or component", typ)
+	}
+	return ""
+}
+
+// checkValueGroups fails the app if a value group is provided into but
+// taken by nothing, while a group of the same type is taken: the group
+// tag is most likely misspelled, as in `group:"route"` for "routes".
+// dig can't tell these apart from groups that are simply empty.
+func checkValueGroups(dot fx.DotGraph) error {
+	g, err := parseDotGraph(dot, nil)
+	if err != nil {
+		return fmt.Errorf("check value groups: %w", err)
+	}
+	consumed := make(map[string]bool)
+	for _, n := range g.Nodes {
+		for _, dep := range n.DependsOn {
+			consumed[dep] = true
+		}
+	}
+	taken := make(map[string][]string) // by type
+	for _, grp := range g.Groups {
+		if consumed[groupNodeID(grp)] {
+			taken[grp.Type] = append(taken[grp.Type], grp.Name)
+		}
+	}
+	var problems []string
+	for _, grp := range g.Groups {
+		if len(grp.Members) == 0 || consumed[groupNodeID(grp)] || len(taken[grp.Type]) == 0 {
+			continue
+		}
+		names := taken[grp.Type]
+		sort.Strings(names)
+		hint := fmt.Sprintf("did you mean %s?", strings.Join(quoteAll(names), " or "))
+		if h, ok := groupHints[grp.Type]; ok && slices.Contains(names, h.group) {
+			hint += fmt.Sprintf(" %s provides into %q", h.helper, h.group)
+		}
+		problems = append(problems, fmt.Sprintf("value group %q of %s is provided but never taken; %s", grp.Name, grp.Type, hint))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return &HintError{
+		Err:  errors.New("value groups provided but never taken"),
+		Hint: strings.Join(problems, "; "),
+	}
+}
+
+// groupNodeID returns the ID of the node of grp in the DOT graph.
+func groupNodeID(grp GraphGroup) string {
+	return fmt.Sprintf("[type=%s group=%s]", grp.Type, grp.Name)
+}
+
+func quoteAll(ss []string) []string {
+	out := make([]string, len(ss))
+	for i, s := range ss {
+		out[i] = fmt.Sprintf("%q", s)
+	}
+	return out
+}
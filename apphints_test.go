@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/fx"
+)
+
+type unprovided struct{}
+
+func TestExplainAppError(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		app  []fx.Option
+		hint string
+	}{
+		{"known type", []fx.Option{fx.Invoke(func(*Config) {})},
+			"the Config is provided by NewConfig in NewApp"},
+		{"value for a pointer", []fx.Option{fx.Supply(Config{}), fx.Invoke(func(*Config) {})},
+			"main.Config is provided, not *main.Config: have its constructor return *main.Config, or depend on main.Config"},
+		{"group member", []fx.Option{fx.Invoke(func(Route) {})},
+			"main.Route values are collected in the \"routes\" value group: provide them with AsRoute"},
+		{"unknown type", []fx.Option{fx.Invoke(func(*unprovided) {})},
+			"nothing provides *main.unprovided: add its constructor to fx.Provide in NewApp"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cause := fx.ValidateApp(append(tc.app, fx.NopLogger)...)
+			err := explainAppError(cause)
+			var hint *HintError
+			if !errors.As(err, &hint) {
+				t.Fatalf("got %v, want a *HintError", err)
+			}
+			if !strings.HasPrefix(hint.Hint, tc.hint) {
+				t.Errorf("got hint %q, want %q", hint.Hint, tc.hint)
+			}
+			// dig's errors can't be compared, so the chain is checked
+			// for an error of the same type instead.
+			target := reflect.New(reflect.TypeOf(cause))
+			if !errors.As(err, target.Interface()) || !strings.Contains(err.Error(), cause.Error()) {
+				t.Errorf("got %v, want it to wrap %v", err, cause)
+			}
+		})
+	}
+
+	other := errors.New("boom")
+	if err := explainAppError(other); err != other {
+		t.Errorf("got %v for an unrelated error, want it as it is", err)
+	}
+}
+
+func TestCheckValueGroups(t *testing.T) {
+	quietConfig(t)
+	misnamed := fx.Provide(fx.Annotate(
+		func() Route {
+			return newFuncRoute("/misnamed", http.MethodGet, func(http.ResponseWriter, *http.Request) {})
+		},
+		fx.ResultTags(`group:"route"`),
+	))
+	err := NewApp(misnamed).Err()
+	var hint *HintError
+	if !errors.As(err, &hint) {
+		t.Fatalf("got %v, want a *HintError", err)
+	}
+	want := `value group "route" of main.Route is provided but never taken; did you mean "kv_routes" or "routes"? AsRoute provides into "routes"`
+	if !strings.Contains(hint.Hint, want) {
+		t.Errorf("got hint %q, want %q", hint.Hint, want)
+	}
+
+	if err := NewApp().Err(); err != nil {
+		t.Errorf("well-wired app: got %v", err)
+	}
+}
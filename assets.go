@@ -0,0 +1,29 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// assets holds the files shipped inside the binary, so that it runs
+// without any files next to it: the default configuration, the message
+// catalogs, the templates of the dashboard and of the page served when
+// the app has no routes, and the static page served when no static
+// directory is configured.
+//
+//go:embed assets
+var assets embed.FS
+
+// embeddedFile returns the named embedded asset.
+func embeddedFile(name string) ([]byte, error) {
+	return assets.ReadFile("assets/" + name)
+}
+
+// embeddedDir returns the named embedded asset directory.
+func embeddedDir(name string) fs.FS {
+	sub, err := fs.Sub(assets, "assets/"+name)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"example.com/uberfx/clock"
+	"example.com/uberfx/reqctx"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AuditEntry records a single state-changing request.
+type AuditEntry struct {
+	Time      time.Time
+	Principal string
+	Method    string
+	Path      string
+	Status    int
+	// BodySHA256 is the hex digest of the request body, or of its first
+	// BodyBytes bytes if Truncated is set.
+	BodySHA256 string
+	BodyBytes  int64
+	Truncated  bool
+}
+
+// AuditSink stores audit entries.
+type AuditSink interface {
+	Write(ctx context.Context, e AuditEntry) error
+}
+
+// SlogAuditSink is an AuditSink that writes entries through the
+// "audit" logger of the LoggerFactory.
+type SlogAuditSink struct {
+	handler slog.Handler
+}
+
+// NewSlogAuditSink builds a SlogAuditSink. By default it writes JSON
+// lines to the file configured in Config.Audit.Path, or to stderr if
+// there is none.
+func NewSlogAuditSink(loggers *LoggerFactory) *SlogAuditSink {
+	return &SlogAuditSink{handler: loggers.Named(loggerAudit).Handler()}
+}
+
+func (s *SlogAuditSink) Write(ctx context.Context, e AuditEntry) error {
+	if !s.handler.Enabled(ctx, slog.LevelInfo) {
+		return nil
+	}
+	r := slog.NewRecord(e.Time, slog.LevelInfo, "audit", 0)
+	r.AddAttrs(
+		slog.String("principal", e.Principal),
+		slog.String("method", e.Method),
+		slog.String("path", e.Path),
+		slog.Int("status", e.Status),
+		slog.String("body_sha256", e.BodySHA256),
+		slog.Int64("body_bytes", e.BodyBytes),
+		slog.Bool("body_truncated", e.Truncated),
+	)
+	return s.handler.Handle(ctx, r)
+}
+
+// AuditLogger records audit entries to a sink. Sink failures are logged
+// and counted but never surface to the caller.
+type AuditLogger struct {
+	sink     AuditSink
+	log      *slog.Logger
+	failures prometheus.Counter
+}
+
+// NewAuditLogger builds a new AuditLogger.
+func NewAuditLogger(sink AuditSink, log *slog.Logger, reg *prometheus.Registry) *AuditLogger {
+	a := &AuditLogger{
+		sink: sink,
+		log:  log,
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "audit_sink_failures_total",
+			Help: "Audit entries that could not be written to the sink.",
+		}),
+	}
+	reg.MustRegister(a.failures)
+	return a
+}
+
+// Record writes e to the sink.
+func (a *AuditLogger) Record(ctx context.Context, e AuditEntry) {
+	if err := a.sink.Write(ctx, e); err != nil {
+		a.failures.Inc()
+		a.log.Error("Failed to write audit entry",
+			slog.String("method", e.Method),
+			slog.String("path", e.Path),
+			slog.String("err", err.Error()))
+	}
+}
+
+// AuditMiddleware records an audit entry for every request that isn't
+// GET, HEAD or OPTIONS once it completes, whether or not it succeeded.
+// The body is hashed as the handler reads it; what the handler left
+// unread is drained, up to the body limit, so that the entry hashes the
+// body the client sent rather than the part the handler read.
+type AuditMiddleware struct {
+	audit *AuditLogger
+	limit int64
+	clock clock.Clock
+}
+
+// NewAuditMiddleware builds a new AuditMiddleware.
+func NewAuditMiddleware(audit *AuditLogger, cfg *Config, clk clock.Clock) *AuditMiddleware {
+	return &AuditMiddleware{audit: audit, limit: cfg.Server.BodyLimit(), clock: clk}
+}
+
+func (*AuditMiddleware) Order() int {
+	return orderAudit
+}
+
+func (m *AuditMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body := &hashingReader{r: r.Body, h: sha256.New(), limit: m.limit}
+		r.Body = body
+		rec := newResponseRecorder(w)
+		defer func() {
+			p := recover()
+			status := rec.Status()
+			if p != nil {
+				status = http.StatusInternalServerError
+			} else if status == 0 {
+				status = http.StatusOK
+			}
+			body.drain()
+			m.audit.Record(r.Context(), AuditEntry{
+				Time:       m.clock.Now(),
+				Principal:  reqctx.Principal(r.Context()),
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Status:     status,
+				BodySHA256: hex.EncodeToString(body.h.Sum(nil)),
+				BodyBytes:  body.n,
+				Truncated:  body.truncated,
+			})
+			if p != nil {
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// hashingReader hashes up to limit bytes of what's read through it.
+type hashingReader struct {
+	r         io.ReadCloser
+	h         hash.Hash
+	limit     int64
+	n         int64
+	truncated bool
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	hashed := max(min(int64(n), r.limit-r.n), 0)
+	r.h.Write(p[:hashed])
+	r.n += hashed
+	if hashed < int64(n) {
+		r.truncated = true
+	}
+	return n, err
+}
+
+// drain reads, and hashes, what's left of the body up to one byte past
+// the limit, enough to tell whether it's truncated.
+func (r *hashingReader) drain() {
+	if r.truncated {
+		return
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(r, r.limit-r.n+1))
+}
+
+func (r *hashingReader) Close() error {
+	return r.r.Close()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// auditEntries is an AuditSink keeping the entries written to it.
+type auditEntries []AuditEntry
+
+func (s *auditEntries) Write(_ context.Context, e AuditEntry) error {
+	*s = append(*s, e)
+	return nil
+}
+
+func TestAuditMiddlewareBodyHash(t *testing.T) {
+	const body = "seventeen bytes!!"
+	sum := sha256.Sum256([]byte(body))
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name      string
+		limit     int64
+		read      int64
+		bytes     int64
+		truncated bool
+		hash      string
+	}{
+		{name: "unread", limit: 1 << 20, read: 0, bytes: 17, hash: hex.EncodeToString(sum[:])},
+		{name: "part read", limit: 1 << 20, read: 5, bytes: 17, hash: hex.EncodeToString(sum[:])},
+		{name: "over the limit", limit: 8, read: 0, bytes: 8, truncated: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var sink auditEntries
+			cfg := &Config{}
+			cfg.Server.MaxBodyBytes = tc.limit
+			audit := NewAuditLogger(&sink, slog.New(slog.NewTextHandler(io.Discard, nil)), prometheus.NewRegistry())
+			h := NewAuditMiddleware(audit, cfg, testsupport.NewFakeClock(start)).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.CopyN(io.Discard, r.Body, tc.read)
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(body)))
+			if len(sink) != 1 {
+				t.Fatalf("got %d entries, want 1", len(sink))
+			}
+			e := sink[0]
+			if !e.Time.Equal(start) {
+				t.Errorf("got entry time %s, want the clock's %s", e.Time, start)
+			}
+			if e.BodyBytes != tc.bytes || e.Truncated != tc.truncated || tc.hash != "" && e.BodySHA256 != tc.hash {
+				t.Errorf("got %d bytes, truncated %t, hash %s; want %d, %t, %s", e.BodyBytes, e.Truncated, e.BodySHA256, tc.bytes, tc.truncated, tc.hash)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"example.com/uberfx/reqctx"
+)
+
+// Errors reported for requests that aren't authenticated as they must.
+var (
+	ErrInvalidToken    = errors.New("invalid bearer token")
+	ErrUnauthenticated = errors.New("authentication required")
+)
+
+// TokenValidator validates bearer tokens, returning the principal a
+// token authenticates and its roles, or ErrInvalidToken.
+type TokenValidator interface {
+	Validate(ctx context.Context, token string) (principal string, roles []string, err error)
+}
+
+// BearerAuth is middleware that authenticates requests carrying an
+// "Authorization: Bearer" header with the TokenValidator, making the
+// principal and its roles available through reqctx.Principal and
+// reqctx.Roles. Requests with an invalid token get a 401; those without
+// one go on anonymously, for the routes to require roles of, see
+// Authorization.
+type BearerAuth struct {
+	tokens TokenValidator
+	errs   *ErrorWriter
+}
+
+// NewBearerAuth builds a new BearerAuth.
+func NewBearerAuth(tokens TokenValidator, errs *ErrorWriter) *BearerAuth {
+	return &BearerAuth{tokens: tokens, errs: errs}
+}
+
+func (*BearerAuth) Order() int {
+	return orderAuth
+}
+
+func (m *BearerAuth) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal, roles, err := m.tokens.Validate(r.Context(), strings.TrimSpace(token))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			m.errs.Write(w, r, err)
+			return
+		}
+		ctx := reqctx.WithPrincipal(r.Context(), principal)
+		ctx = reqctx.WithRoles(ctx, roles)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// StaticTokens is the TokenValidator of the tokens listed in
+// Config.Auth.Tokens, as "principal:token" entries, granting the
+// principals the roles listed in Config.Auth.Roles. Tokens are looked up
+// by their hash, so that the lookup doesn't leak how much of a token
+// matched. They can be replaced at runtime by SetTokens, as the
+// SecretReloader does on SIGHUP.
+type StaticTokens struct {
+	tokens atomic.Pointer[staticTokenSet]
+}
+
+type staticTokenSet struct {
+	principals map[[sha256.Size]byte]string
+	roles      map[string][]string
+}
+
+// NewStaticTokens builds a new StaticTokens.
+func NewStaticTokens(cfg *Config) (*StaticTokens, error) {
+	v := &StaticTokens{}
+	if err := v.SetTokens(cfg.Auth.Tokens, cfg.Auth.Roles); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// SetTokens replaces the tokens, and the roles of their principals. On
+// error, the tokens are left as they were.
+func (v *StaticTokens) SetTokens(tokens []string, roles map[string][]string) error {
+	set := &staticTokenSet{
+		principals: make(map[[sha256.Size]byte]string, len(tokens)),
+		roles:      roles,
+	}
+	for i, entry := range tokens {
+		principal, token, ok := strings.Cut(entry, ":")
+		if !ok || principal == "" || token == "" {
+			return fmt.Errorf("auth token %d: want principal:token", i)
+		}
+		set.principals[sha256.Sum256([]byte(token))] = principal
+	}
+	v.tokens.Store(set)
+	return nil
+}
+
+func (v *StaticTokens) Validate(_ context.Context, token string) (string, []string, error) {
+	set := v.tokens.Load()
+	principal, ok := set.principals[sha256.Sum256([]byte(token))]
+	if !ok {
+		return "", nil, ErrInvalidToken
+	}
+	return principal, set.roles[principal], nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"example.com/uberfx/httpjson"
+	"example.com/uberfx/reqctx"
+)
+
+// AuthorizedRoute is implemented by routes that may only be used by
+// principals holding all of the given roles.
+type AuthorizedRoute interface {
+	RequiredRoles() []string
+}
+
+// Authorization is route middleware enforcing the roles routes require,
+// taken from Config.Auth.RouteRoles by pattern, or else the route's
+// AuthorizedRoute method. Admin and debug routes, under /admin and
+// /debug, that are given no roles either way require the "admin" role:
+// they're closed unless Config.Auth.RouteRoles opens them, with an
+// empty list. Anonymous requests to such routes get a 401, and those of
+// principals lacking roles a 403 listing the missing ones. Routes
+// requiring no roles are left alone.
+type Authorization struct {
+	routeRoles map[string][]string
+	errs       *ErrorWriter
+	catalog    *Catalog
+}
+
+// NewAuthorization builds a new Authorization.
+func NewAuthorization(cfg *Config, errs *ErrorWriter, catalog *Catalog) *Authorization {
+	return &Authorization{routeRoles: cfg.Auth.RouteRoles, errs: errs, catalog: catalog}
+}
+
+func (*Authorization) Order() int {
+	return orderAuthorization
+}
+
+func (m *Authorization) WrapRoute(route Route, next http.Handler) http.Handler {
+	required, ok := m.routeRoles[route.Pattern()]
+	if ar, authorized := route.(AuthorizedRoute); !ok && authorized {
+		required = ar.RequiredRoles()
+	}
+	if _, path := splitPattern(route.Pattern()); !ok && len(required) == 0 && internalPath(path) {
+		required = []string{"admin"}
+	}
+	if len(required) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reqctx.Principal(r.Context()) == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			m.errs.Write(w, r, ErrUnauthenticated)
+			return
+		}
+		roles := reqctx.Roles(r.Context())
+		var missing []string
+		for _, role := range required {
+			if !slices.Contains(roles, role) {
+				missing = append(missing, role)
+			}
+		}
+		if len(missing) > 0 {
+			m.forbid(w, r, missing)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rolesProblem is the problem+json body of requests rejected for
+// lacking roles.
+type rolesProblem struct {
+	Problem
+	MissingRoles []string `json:"missing_roles"`
+}
+
+func (m *Authorization) forbid(w http.ResponseWriter, r *http.Request, missing []string) {
+	locale := m.catalog.Negotiate(r.Header.Get("Accept-Language"))
+	title, ok := m.catalog.Message(locale, "title.403", nil)
+	if !ok {
+		title = http.StatusText(http.StatusForbidden)
+	}
+	detail, _ := m.catalog.Message(locale, "detail.missing_roles", map[string]string{"roles": strings.Join(missing, ", ")})
+	h := w.Header()
+	h.Set("Content-Language", locale)
+	h.Add("Vary", "Accept-Language")
+	h.Set("Content-Type", "application/problem+json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	_ = httpjson.RespondError(w, r, http.StatusForbidden, rolesProblem{
+		Problem: Problem{
+			Title:    title,
+			Status:   http.StatusForbidden,
+			Detail:   detail,
+			Instance: r.URL.Path,
+		},
+		MissingRoles: missing,
+	})
+}
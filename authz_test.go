@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAuthorizationInternalRoutes(t *testing.T) {
+	base := startTestApp(t, func(cfg *Config) {
+		withTokens(cfg)
+		cfg.Auth.RouteRoles = map[string][]string{"GET /debug/stats": {}}
+	})
+	for _, tc := range []struct {
+		path, token string
+		want        int
+	}{
+		{"/admin/errors", "", http.StatusUnauthorized},
+		{"/admin/errors", "user-token", http.StatusForbidden},
+		{"/admin/errors", "admin-token", http.StatusOK},
+		{"/debug/config", "", http.StatusUnauthorized},
+		{"/debug/config", "admin-token", http.StatusOK},
+		// Opened by Config.Auth.RouteRoles.
+		{"/debug/stats", "", http.StatusOK},
+		{"/hello", "", http.StatusOK},
+	} {
+		if status, body := do(t, http.MethodGet, base+tc.path, tc.token, ""); status != tc.want {
+			t.Errorf("GET %s with %q: got %d %s, want %d", tc.path, tc.token, status, body, tc.want)
+		}
+	}
+}
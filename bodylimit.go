@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// BodyLimitedRoute is implemented by routes whose request bodies have a
+// limit of their own: zero inherits Config.Server.MaxBodyBytes and -1
+// means no limit.
+type BodyLimitedRoute interface {
+	MaxBodyBytes() int64
+}
+
+// BodyLimit caps the size of request bodies. As middleware, it caps the
+// body on the wire at Config.Server.MaxBodyBytes; as route middleware,
+// it replaces that limit with the route's own, taken from
+// Config.Server.BodyLimits by pattern, or else its BodyLimitedRoute
+// method. Bodies declaring a larger Content-Length are turned away at
+// once; handlers reading past the limit get an *http.MaxBytesError.
+// Both are reported by the ErrorWriter as a 413 stating the limit. The
+// decompressed size of gzip bodies is capped separately by the
+// GzipDecoder, whatever the route.
+type BodyLimit struct {
+	cfg   ServerConfig
+	limit int64
+	errs  *ErrorWriter
+}
+
+// NewBodyLimit builds a new BodyLimit.
+func NewBodyLimit(cfg *Config, errs *ErrorWriter) *BodyLimit {
+	return &BodyLimit{cfg: cfg.Server, limit: cfg.Server.BodyLimit(), errs: errs}
+}
+
+func (*BodyLimit) Order() int {
+	return orderBodyLimit
+}
+
+type limitedBodyKey struct{}
+
+func (m *BodyLimit) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body := &limitedBody{ReadCloser: r.Body, limit: m.limit}
+		r.Body = body
+		// Inner middleware, such as the GzipDecoder, wraps the body, so
+		// the route middleware finds it in the context.
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), limitedBodyKey{}, body)))
+	})
+}
+
+func (m *BodyLimit) WrapRoute(route Route, next http.Handler) http.Handler {
+	var declared int64
+	if bl, ok := route.(BodyLimitedRoute); ok {
+		declared = bl.MaxBodyBytes()
+	}
+	limit := m.cfg.RouteBodyLimit(route.Pattern(), declared)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limit >= 0 && r.ContentLength > limit {
+			m.errs.Write(w, r, &http.MaxBytesError{Limit: limit})
+			return
+		}
+		if body, ok := r.Context().Value(limitedBodyKey{}).(*limitedBody); ok {
+			body.limit = limit
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitedBody is a request body failing with an *http.MaxBytesError
+// once more than limit bytes have been read, unless limit is negative.
+// Unlike http.MaxBytesReader, its limit can be changed until it's read.
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.limit < 0 {
+		return b.ReadCloser.Read(p)
+	}
+	if b.read > b.limit {
+		return 0, &http.MaxBytesError{Limit: b.limit}
+	}
+	// Read one byte past the limit to tell a body of exactly the limit
+	// from a longer one.
+	if room := b.limit - b.read + 1; int64(len(p)) > room {
+		p = p[:room]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n - int(b.read-b.limit), &http.MaxBytesError{Limit: b.limit}
+	}
+	return n, err
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"example.com/uberfx/clock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrCircuitOpen is returned by the outbound client for requests to a
+// host whose circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("breakerState(%d)", int(s))
+	}
+}
+
+// breaker tracks the state of the circuit for a single host. Its
+// generation changes with every transition, so that outcomes of
+// requests allowed in an earlier state can be told apart.
+type breaker struct {
+	state     breakerState
+	gen       uint64
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+}
+
+// breakerTicket is what a request was allowed under: whether it's a
+// probe of a half-open circuit, and the generation of the circuit then.
+type breakerTicket struct {
+	probe bool
+	gen   uint64
+}
+
+// CircuitBreaker is an http.RoundTripper that stops sending requests to
+// a host after repeated failures, failing them fast with ErrCircuitOpen
+// until the host has had time to recover. Transport errors and 5xx
+// responses count as failures, except for the errors of requests whose
+// context is done.
+type CircuitBreaker struct {
+	next        http.RoundTripper
+	threshold   int
+	openFor     time.Duration
+	probes      int
+	log         *slog.Logger
+	transitions *prometheus.CounterVec
+	clock       clock.Clock
+
+	mu    sync.Mutex
+	hosts map[string]*breaker
+}
+
+// NewCircuitBreaker builds a CircuitBreaker in front of next.
+func NewCircuitBreaker(next http.RoundTripper, cfg BreakerConfig, clk clock.Clock, log *slog.Logger, reg prometheus.Registerer) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		next:      next,
+		threshold: cfg.FailureThreshold,
+		openFor:   time.Duration(cfg.OpenDuration),
+		probes:    cfg.HalfOpenProbes,
+		log:       log,
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_circuit_transitions_total",
+			Help: "Circuit breaker state transitions of the outbound HTTP client.",
+		}, []string{"host", "from", "to"}),
+		clock: clk,
+		hosts: make(map[string]*breaker),
+	}
+	if cb.threshold <= 0 {
+		cb.threshold = 5
+	}
+	if cb.openFor <= 0 {
+		cb.openFor = 30 * time.Second
+	}
+	if cb.probes <= 0 {
+		cb.probes = 1
+	}
+	reg.MustRegister(cb.transitions)
+	return cb
+}
+
+func (cb *CircuitBreaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	ticket, err := cb.allow(host)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cb.next.RoundTrip(req)
+	if err != nil && req.Context().Err() != nil {
+		// The caller gave up on the request, as the Hedger does with the
+		// attempt it doesn't use: that says nothing of the host.
+		cb.release(host, ticket)
+		return resp, err
+	}
+	cb.record(host, ticket, err == nil && resp.StatusCode < http.StatusInternalServerError)
+	return resp, err
+}
+
+// allow reports whether a request to host may proceed, and returns the
+// ticket to record its outcome with.
+func (cb *CircuitBreaker) allow(host string) (breakerTicket, error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	b := cb.hosts[host]
+	if b == nil {
+		b = &breaker{}
+		cb.hosts[host] = b
+	}
+	switch b.state {
+	case breakerOpen:
+		if cb.clock.Now().Sub(b.openedAt) < cb.openFor {
+			return breakerTicket{}, fmt.Errorf("%w for %s", ErrCircuitOpen, host)
+		}
+		cb.transition(host, b, breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probes >= cb.probes {
+			return breakerTicket{}, fmt.Errorf("%w for %s", ErrCircuitOpen, host)
+		}
+		b.probes++
+		return breakerTicket{probe: true, gen: b.gen}, nil
+	}
+	return breakerTicket{gen: b.gen}, nil
+}
+
+// record updates the circuit for host with the outcome of a request
+// allowed with ticket. In a half-open circuit, only the outcomes of its
+// own probes count: requests allowed before it went half-open don't
+// free or take up a probe.
+func (cb *CircuitBreaker) record(host string, ticket breakerTicket, ok bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	b := cb.hosts[host]
+	switch b.state {
+	case breakerClosed:
+		if ok {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= cb.threshold {
+			cb.transition(host, b, breakerOpen)
+		}
+	case breakerHalfOpen:
+		if !ticket.probe || ticket.gen != b.gen {
+			return
+		}
+		b.probes = max(b.probes-1, 0)
+		if !ok {
+			cb.transition(host, b, breakerOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= cb.probes {
+			cb.transition(host, b, breakerClosed)
+		}
+	}
+}
+
+// release frees the probe taken by a request allowed with ticket,
+// without recording an outcome.
+func (cb *CircuitBreaker) release(host string, ticket breakerTicket) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	b := cb.hosts[host]
+	if b.state == breakerHalfOpen && ticket.probe && ticket.gen == b.gen {
+		b.probes = max(b.probes-1, 0)
+	}
+}
+
+// transition moves b to the given state. It must be called with mu held.
+func (cb *CircuitBreaker) transition(host string, b *breaker, to breakerState) {
+	from := b.state
+	b.state = to
+	b.gen++
+	b.failures, b.probes, b.successes = 0, 0, 0
+	if to == breakerOpen {
+		b.openedAt = cb.clock.Now()
+	}
+	cb.transitions.WithLabelValues(host, from.String(), to.String()).Inc()
+	cb.log.Warn("Circuit breaker state changed",
+		slog.String("host", host),
+		slog.String("from", from.String()),
+		slog.String("to", to.String()))
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// roundTripFunc is an http.RoundTripper calling itself.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// respond returns a response with the given status to req.
+func respond(req *http.Request, status int) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	clk := testsupport.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	var status, calls atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer upstream.Close()
+	cfg := BreakerConfig{FailureThreshold: 2, OpenDuration: Duration(30 * time.Second)}
+	cb := NewCircuitBreaker(upstream.Client().Transport, cfg, clk, slog.New(slog.NewTextHandler(io.Discard, nil)), prometheus.NewRegistry())
+	get := func() error {
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/hello", nil)
+		resp, err := cb.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	for range 2 {
+		if err := get(); err != nil {
+			t.Fatalf("closed circuit: %v", err)
+		}
+	}
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("after 2 failures: got %v, want ErrCircuitOpen", err)
+	}
+	clk.Advance(30*time.Second - time.Nanosecond)
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("before OpenDuration: got %v, want ErrCircuitOpen", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("open circuit sent requests: %d calls, want 2", n)
+	}
+
+	// The probe fails, opening the circuit for another OpenDuration.
+	clk.Advance(time.Nanosecond)
+	if err := get(); err != nil {
+		t.Fatalf("half-open probe: %v", err)
+	}
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("after a failed probe: got %v, want ErrCircuitOpen", err)
+	}
+
+	// The probe succeeds, closing it.
+	status.Store(http.StatusOK)
+	clk.Advance(30 * time.Second)
+	for range 3 {
+		if err := get(); err != nil {
+			t.Fatalf("after a successful probe: %v", err)
+		}
+	}
+	if n := calls.Load(); n != 6 {
+		t.Errorf("got %d calls, want 6", n)
+	}
+}
+
+func TestCircuitBreakerLateOutcomes(t *testing.T) {
+	clk := testsupport.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	cfg := BreakerConfig{FailureThreshold: 1, OpenDuration: Duration(time.Second), HalfOpenProbes: 1}
+	cb := NewCircuitBreaker(nil, cfg, clk, slog.New(slog.NewTextHandler(io.Discard, nil)), prometheus.NewRegistry())
+	const host = "upstream"
+	allow := func() breakerTicket {
+		t.Helper()
+		ticket, err := cb.allow(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ticket
+	}
+
+	// A request still in flight when the circuit opens...
+	late := allow()
+	cb.record(host, allow(), false)
+	clk.Advance(time.Second)
+	probe := allow()
+	if !probe.probe {
+		t.Fatal("first request of the half-open circuit isn't a probe")
+	}
+
+	// ...doesn't free the probe taken, however many times it's recorded.
+	for range 3 {
+		cb.record(host, late, true)
+		cb.record(host, late, false)
+	}
+	if _, err := cb.allow(host); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v, want a second probe refused", err)
+	}
+	if b := cb.hosts[host]; b.state != breakerHalfOpen || b.probes != 1 {
+		t.Fatalf("got %s with %d probes, want half-open with 1", b.state, b.probes)
+	}
+
+	cb.record(host, probe, true)
+	if b := cb.hosts[host]; b.state != breakerClosed {
+		t.Errorf("got %s after the probe succeeded, want closed", b.state)
+	}
+}
+
+func TestCircuitBreakerBehindHedger(t *testing.T) {
+	clk := testsupport.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	// Every first attempt is slow, and left for the hedge once it has
+	// answered; the host itself is healthy.
+	var calls atomic.Int32
+	slow := make(chan struct{})
+	upstream := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if calls.Add(1)%2 == 1 {
+			slow <- struct{}{}
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}
+		return respond(req, http.StatusOK), nil
+	})
+	cfg := BreakerConfig{FailureThreshold: 2, OpenDuration: Duration(time.Minute)}
+	cb := NewCircuitBreaker(upstream, cfg, clk, log, prometheus.NewRegistry())
+	// abandoned is told of each attempt the breaker has seen fail.
+	abandoned := make(chan error, 5)
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := cb.RoundTrip(req)
+		if err != nil {
+			abandoned <- err
+		}
+		return resp, err
+	})
+	h := NewHedger(next, HedgeConfig{Delay: Duration(10 * time.Millisecond)}, clk, log, prometheus.NewRegistry())
+
+	for i := range 5 {
+		errs := make(chan error, 1)
+		go func() {
+			req, _ := http.NewRequest(http.MethodGet, "http://upstream/hello", nil)
+			resp, err := h.RoundTrip(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+			errs <- err
+		}()
+		<-slow
+		clk.BlockUntil(1)
+		clk.Advance(10 * time.Millisecond)
+		if err := <-errs; err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	for range 5 {
+		if err := <-abandoned; !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v from the losing attempt, want it canceled", err)
+		}
+	}
+	if b := cb.hosts["upstream"]; b.state != breakerClosed || b.failures != 0 {
+		t.Errorf("got %s with %d failures, want the canceled attempts not counted", b.state, b.failures)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"expvar"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ByteCounter is route middleware that counts the request body bytes
+// read by each route and the response bytes it writes, including those
+// exchanged over hijacked connections, for capacity planning. Bodies
+// are counted as they stream through, never buffered. The totals across
+// all routes are also served at /debug/vars, see DebugVars.
+type ByteCounter struct {
+	in       *prometheus.CounterVec
+	out      *prometheus.CounterVec
+	inTotal  *expvar.Int
+	outTotal *expvar.Int
+}
+
+// NewByteCounter builds a new ByteCounter.
+func NewByteCounter(reg *prometheus.Registry, vars *DebugVars) *ByteCounter {
+	m := &ByteCounter{
+		inTotal:  vars.NewInt("http_request_body_bytes"),
+		outTotal: vars.NewInt("http_response_body_bytes"),
+		in: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_request_body_bytes_total",
+			Help: "Request body bytes read by handlers, by route.",
+		}, []string{"route"}),
+		out: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_response_body_bytes_total",
+			Help: "Response body bytes written by handlers, by route.",
+		}, []string{"route"}),
+	}
+	reg.MustRegister(m.in, m.out)
+	return m
+}
+
+func (*ByteCounter) Order() int {
+	return orderByteCounter
+}
+
+func (m *ByteCounter) WrapRoute(route Route, next http.Handler) http.Handler {
+	pattern := route.Pattern()
+	in := m.in.WithLabelValues(pattern)
+	out := m.out.WithLabelValues(pattern)
+	countIn := func(n int) {
+		in.Add(float64(n))
+		m.inTotal.Add(int64(n))
+	}
+	countOut := func(n int) {
+		out.Add(float64(n))
+		m.outTotal.Add(int64(n))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = &countingReader{ReadCloser: r.Body, count: countIn}
+		}
+		rec := newResponseRecorder(w)
+		rec.wrapConn = func(c net.Conn) net.Conn {
+			return &countingConn{Conn: c, in: countIn, out: countOut}
+		}
+		defer func() {
+			countOut(int(rec.bytes))
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// countingReader reports the number of bytes read through it.
+type countingReader struct {
+	io.ReadCloser
+	count func(int)
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.count(n)
+	return n, err
+}
+
+// countingConn reports the number of bytes read and written through a
+// hijacked connection.
+type countingConn struct {
+	net.Conn
+	in, out func(int)
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.in(n)
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.out(n)
+	return n, err
+}
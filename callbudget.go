@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"example.com/uberfx/reqctx"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrCallBudgetExceeded is reported, wrapped in a *CallBudgetError, for
+// outbound requests made once the request they're made for has used up
+// its call budget.
+var ErrCallBudgetExceeded = errors.New("outbound call budget exceeded")
+
+// CallBudgetError is the error of an outbound request over budget.
+type CallBudgetError struct {
+	// Budget is how many outbound requests the request may make.
+	Budget int
+}
+
+func (e *CallBudgetError) Error() string {
+	return fmt.Sprintf("outbound call budget of %d calls exceeded", e.Budget)
+}
+
+func (e *CallBudgetError) Is(target error) bool {
+	return target == ErrCallBudgetExceeded
+}
+
+// CallBudgetedRoute is implemented by routes that may make more, or
+// fewer, outbound requests per request than Config.Client.CallBudget.
+type CallBudgetedRoute interface {
+	CallBudget() int
+}
+
+// callBudget counts the outbound requests made for a request.
+type callBudget struct {
+	limit int
+	used  atomic.Int64
+}
+
+type callBudgetKey struct{}
+
+// CallBudgets is route middleware giving each request a budget of
+// outbound requests through the shared client, so that a single
+// request can't fan out to any number of upstream calls. The budget is
+// Config.Client.CallBudget, unless the route declares its own with
+// CallBudgetedRoute. Requests over budget fail at once with a
+// *CallBudgetError, which the ErrorWriter reports as a 502; retries of
+// a request don't count against it. Outbound requests made outside of
+// a route, such as by background workers, aren't limited.
+type CallBudgets struct {
+	budget int
+}
+
+// NewCallBudgets builds a new CallBudgets.
+func NewCallBudgets(cfg *Config) *CallBudgets {
+	m := &CallBudgets{budget: cfg.Client.CallBudget}
+	if m.budget <= 0 {
+		m.budget = 10
+	}
+	return m
+}
+
+func (*CallBudgets) Order() int {
+	return orderCallBudget
+}
+
+func (m *CallBudgets) WrapRoute(route Route, next http.Handler) http.Handler {
+	limit := m.budget
+	if cb, ok := route.(CallBudgetedRoute); ok && cb.CallBudget() > 0 {
+		limit = cb.CallBudget()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), callBudgetKey{}, &callBudget{limit: limit})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// budgetTransport is an http.RoundTripper charging each request to the
+// call budget of the context it's made with.
+type budgetTransport struct {
+	next     http.RoundTripper
+	exceeded *prometheus.CounterVec
+}
+
+func newBudgetTransport(next http.RoundTripper, reg *prometheus.Registry) *budgetTransport {
+	t := &budgetTransport{
+		next: next,
+		exceeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_call_budget_exceeded_total",
+			Help: "Outbound requests refused for exceeding the call budget of the inbound request, by route.",
+		}, []string{"route"}),
+	}
+	reg.MustRegister(t.exceeded)
+	return t
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if b, ok := ctx.Value(callBudgetKey{}).(*callBudget); ok && b.used.Add(1) > int64(b.limit) {
+		t.exceeded.WithLabelValues(reqctx.Route(ctx)).Inc()
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, &CallBudgetError{Budget: b.limit}
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"example.com/uberfx/clock"
+	"example.com/uberfx/httpjson"
+	"example.com/uberfx/reqctx"
+)
+
+// Captures is route middleware keeping the last requests and responses
+// of a route in a ring, for a support session to browse while a client
+// reproduces an issue, as with /echo. Unlike DebugDump, nothing is
+// logged, and capturing is switched on route by route at runtime, in
+// production too, with PUT /admin/capture/{pattern}, see
+// CapturesHandler; it switches itself off once its duration is over.
+//
+// A ring keeps Config.Debug.CaptureEntries requests, with their
+// headers, credentials redacted, and the first
+// Config.Debug.CaptureBodyBytes of their bodies, the rest cut off with
+// a marker. Routes not being captured only pay an atomic load. The
+// admin routes are never captured.
+type Captures struct {
+	entries     int
+	maxBody     int
+	duration    time.Duration
+	maxDuration time.Duration
+	log         *slog.Logger
+	clock       clock.Clock
+
+	mu    sync.Mutex // guards slots against the wrapping of routes
+	slots map[string]*captureSlot
+}
+
+// captureSlot is the capture state of a route.
+type captureSlot struct {
+	pattern string
+	// active is the capture going on, if any, and last the latest one,
+	// kept for browsing once over.
+	active atomic.Pointer[captureRing]
+	last   atomic.Pointer[captureRing]
+}
+
+// captureRing is a capture of a route.
+type captureRing struct {
+	started   time.Time
+	until     time.Time
+	principal string
+
+	mu      sync.Mutex
+	entries []captureEntry
+	next    int
+	total   int64
+}
+
+// captureEntry is a request captured, and its response.
+type captureEntry struct {
+	Time      time.Time       `json:"time"`
+	RequestID string          `json:"request_id,omitempty"`
+	Elapsed   Duration        `json:"elapsed"`
+	Request   captureRequest  `json:"request"`
+	Response  captureResponse `json:"response"`
+}
+
+type captureRequest struct {
+	Method string      `json:"method"`
+	URI    string      `json:"uri"`
+	Header http.Header `json:"header"`
+	Body   captureBody `json:"body"`
+}
+
+type captureResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   captureBody `json:"body"`
+}
+
+// captureBody is a body captured: its text, cut off with a marker past
+// the cap, or a summary of binary bodies.
+type captureBody struct {
+	Data      string `json:"data"`
+	Bytes     int64  `json:"bytes"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// NewCaptures builds a new Captures, capturing no route.
+func NewCaptures(cfg *Config, clk clock.Clock, log *slog.Logger) *Captures {
+	c := &Captures{
+		entries:     cfg.Debug.CaptureEntries,
+		maxBody:     cfg.Debug.CaptureBodyBytes,
+		duration:    time.Duration(cfg.Debug.CaptureDuration),
+		maxDuration: time.Duration(cfg.Debug.MaxCaptureDuration),
+		log:         log,
+		clock:       clk,
+		slots:       make(map[string]*captureSlot),
+	}
+	if c.entries <= 0 {
+		c.entries = 50
+	}
+	if c.maxBody <= 0 {
+		c.maxBody = 4 << 10
+	}
+	if c.maxDuration <= 0 {
+		c.maxDuration = time.Hour
+	}
+	if c.duration <= 0 {
+		c.duration = min(10*time.Minute, c.maxDuration)
+	}
+	return c
+}
+
+func (*Captures) Order() int {
+	return orderCapture
+}
+
+func (c *Captures) WrapRoute(route Route, next http.Handler) http.Handler {
+	pattern := route.Pattern()
+	if _, path := splitPattern(pattern); path == "/admin" || strings.HasPrefix(path, "/admin/") {
+		return next
+	}
+	slot := &captureSlot{pattern: pattern}
+	c.mu.Lock()
+	c.slots[pattern] = slot
+	c.mu.Unlock()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ring := slot.active.Load()
+		if ring == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		now := c.clock.Now()
+		if !now.Before(ring.until) {
+			c.expire(slot, ring)
+			next.ServeHTTP(w, r)
+			return
+		}
+		reqBody := &cappedBuffer{max: c.maxBody}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, reqBody), r.Body}
+		}
+		dw := &dumpWriter{responseRecorder: newResponseRecorder(w), body: &cappedBuffer{max: c.maxBody}}
+		next.ServeHTTP(dw, r)
+
+		ring.add(c.entries, captureEntry{
+			Time:      now,
+			RequestID: reqctx.RequestID(r.Context()),
+			Elapsed:   Duration(c.clock.Now().Sub(now)),
+			Request: captureRequest{
+				Method: r.Method,
+				URI:    r.RequestURI,
+				Header: redactHeader(r.Header),
+				Body:   reqBody.captured(),
+			},
+			Response: captureResponse{
+				Status: dw.Status(),
+				Header: redactHeader(w.Header()),
+				Body:   dw.body.captured(),
+			},
+		})
+	})
+}
+
+// Start starts capturing the routes with the given pattern, or path,
+// for d, or the default duration if zero, on behalf of principal,
+// dropping what their previous captures kept.
+func (c *Captures) Start(pattern string, d time.Duration, principal string) error {
+	if d < 0 || d > c.maxDuration {
+		return fmt.Errorf("duration must be at most %s", c.maxDuration)
+	}
+	if d == 0 {
+		d = c.duration
+	}
+	slots := c.lookup(pattern)
+	if len(slots) == 0 {
+		return fmt.Errorf("no route %q", pattern)
+	}
+	now := c.clock.Now()
+	until := now.Add(d)
+	for _, slot := range slots {
+		ring := &captureRing{started: now, until: until, principal: principal}
+		slot.last.Store(ring)
+		slot.active.Store(ring)
+	}
+	c.log.Warn("Capture started",
+		slog.String("route", pattern),
+		slog.Duration("duration", d),
+		slog.String("principal", principal),
+	)
+	return nil
+}
+
+// Stop stops capturing the routes with the given pattern, or path,
+// keeping what their captures kept.
+func (c *Captures) Stop(pattern string, principal string) error {
+	slots := c.lookup(pattern)
+	if len(slots) == 0 {
+		return fmt.Errorf("no route %q", pattern)
+	}
+	for _, slot := range slots {
+		slot.active.Store(nil)
+	}
+	c.log.Warn("Capture stopped",
+		slog.String("route", pattern),
+		slog.String("principal", principal),
+	)
+	return nil
+}
+
+// expire stops the capture ring of slot once its duration is over.
+func (c *Captures) expire(slot *captureSlot, ring *captureRing) {
+	if slot.active.CompareAndSwap(ring, nil) {
+		c.log.Info("Capture over", slog.String("route", slot.pattern))
+	}
+}
+
+// lookup returns the slots of the routes with the given pattern, or
+// path, sorted by pattern.
+func (c *Captures) lookup(pattern string) []*captureSlot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var slots []*captureSlot
+	for p, slot := range c.slots {
+		if _, path := splitPattern(p); p == pattern || path == pattern {
+			slots = append(slots, slot)
+		}
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].pattern < slots[j].pattern })
+	return slots
+}
+
+// captureView is the state of the capture of a route, as browsed.
+type captureView struct {
+	Pattern   string         `json:"pattern"`
+	Enabled   bool           `json:"enabled"`
+	Started   *time.Time     `json:"started,omitempty"`
+	Until     *time.Time     `json:"until,omitempty"`
+	Principal string         `json:"principal,omitempty"`
+	Dropped   int64          `json:"dropped"`
+	Entries   []captureEntry `json:"entries"`
+}
+
+// View returns the state of the capture of the routes with the given
+// pattern, or path, with the entries kept, oldest first.
+func (c *Captures) View(pattern string) ([]captureView, error) {
+	slots := c.lookup(pattern)
+	if len(slots) == 0 {
+		return nil, fmt.Errorf("no route %q", pattern)
+	}
+	now := c.clock.Now()
+	views := make([]captureView, 0, len(slots))
+	for _, slot := range slots {
+		if ring := slot.active.Load(); ring != nil && !now.Before(ring.until) {
+			c.expire(slot, ring)
+		}
+		v := captureView{Pattern: slot.pattern, Entries: []captureEntry{}}
+		if ring := slot.last.Load(); ring != nil {
+			v.Enabled = slot.active.Load() == ring
+			v.Started, v.Until, v.Principal = &ring.started, &ring.until, ring.principal
+			v.Entries, v.Dropped = ring.snapshot()
+		}
+		views = append(views, v)
+	}
+	return views, nil
+}
+
+// add adds e to the ring, overwriting its oldest entry once it holds
+// size.
+func (r *captureRing) add(size int, e captureEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.total++
+	if len(r.entries) < size {
+		r.entries = append(r.entries, e)
+		return
+	}
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % size
+}
+
+// snapshot returns the entries of the ring, oldest first, and how many
+// were overwritten.
+func (r *captureRing) snapshot() ([]captureEntry, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]captureEntry, 0, len(r.entries))
+	out = append(out, r.entries[r.next:]...)
+	out = append(out, r.entries[:r.next]...)
+	return out, r.total - int64(len(r.entries))
+}
+
+// captured describes the body in the buffer as captured.
+func (b *cappedBuffer) captured() captureBody {
+	data := b.buf.Bytes()
+	body := captureBody{Bytes: b.total, Truncated: b.total > int64(len(data))}
+	if body.Truncated {
+		// The cap may have split a character.
+		for i := 0; i < utf8.UTFMax-1 && len(data) > 0 && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	switch {
+	case !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0:
+		body.Data = fmt.Sprintf("<binary, %d bytes>", b.total)
+	case body.Truncated:
+		body.Data = fmt.Sprintf("%s…[truncated, %d more bytes]", data, b.total-int64(len(data)))
+	default:
+		body.Data = string(data)
+	}
+	return body
+}
+
+// CapturesHandler switches the capture of a route, see Captures, with a
+// PUT of {"enabled": bool, "duration": "5m"} to
+// /admin/capture/{pattern}, the pattern being a registered one,
+// URL-escaped, as in /admin/capture/POST%20/echo, or its path, as in
+// /admin/capture/echo, and shows what it has kept at GET of the same
+// path. The duration is optional. Both require the admin role, since
+// captures hold the requests of other users. Switches are audit-logged,
+// like every PUT, and logged with the principal making them.
+type CapturesHandler struct {
+	captures *Captures
+	errs     *ErrorWriter
+}
+
+// NewCapturesHandler builds a new CapturesHandler.
+func NewCapturesHandler(captures *Captures, errs *ErrorWriter) *CapturesHandler {
+	return &CapturesHandler{captures: captures, errs: errs}
+}
+
+func (h *CapturesHandler) RegisterRoutes(r Router) {
+	r.Handle(http.MethodGet, "/admin/capture/{pattern...}", WithRoles(http.HandlerFunc(h.show), "admin"))
+	r.Handle(http.MethodPut, "/admin/capture/{pattern...}", WithRoles(http.HandlerFunc(h.set), "admin"))
+}
+
+// capturePattern returns the route pattern, or path, of the request.
+func capturePattern(r *http.Request) string {
+	pattern := Params(r.Context())["pattern"]
+	if !strings.Contains(pattern, " ") {
+		// The wildcard doesn't take the slash leading the path.
+		pattern = "/" + pattern
+	}
+	return pattern
+}
+
+func (h *CapturesHandler) show(w http.ResponseWriter, r *http.Request) {
+	views, err := h.captures.View(capturePattern(r))
+	if err != nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusNotFound, err))
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	_ = httpjson.Respond(w, r, http.StatusOK, views)
+}
+
+func (h *CapturesHandler) set(w http.ResponseWriter, r *http.Request) {
+	pattern := capturePattern(r)
+	if len(h.captures.lookup(pattern)) == 0 {
+		h.errs.Write(w, r, NewStatusError(http.StatusNotFound, fmt.Errorf("no route %q", pattern)))
+		return
+	}
+	var body struct {
+		Enabled  *bool    `json:"enabled"`
+		Duration Duration `json:"duration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, err))
+		return
+	}
+	if body.Enabled == nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, fmt.Errorf("missing %q", "enabled")))
+		return
+	}
+	principal := reqctx.Principal(r.Context())
+	var err error
+	if *body.Enabled {
+		err = h.captures.Start(pattern, time.Duration(body.Duration), principal)
+	} else {
+		err = h.captures.Stop(pattern, principal)
+	}
+	if err != nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, err))
+		return
+	}
+	h.show(w, r)
+}
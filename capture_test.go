@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+)
+
+func TestCapturesHandler(t *testing.T) {
+	for _, router := range []string{"servemux", "chi"} {
+		t.Run(router, func(t *testing.T) {
+			base := startTestApp(t, func(cfg *Config) {
+				withTokens(cfg)
+				cfg.Server.Router = router
+			})
+			for _, method := range []string{http.MethodGet, http.MethodPut} {
+				if status, _ := do(t, method, base+"/admin/capture/echo", "", `{"enabled": true}`); status != http.StatusUnauthorized {
+					t.Errorf("anonymous %s: got %d, want 401", method, status)
+				}
+			}
+			if status, body := do(t, http.MethodPut, base+"/admin/capture/echo", "admin-token", `{"enabled": true}`); status != http.StatusOK {
+				t.Fatalf("PUT /admin/capture/echo: got %d %s, want 200", status, body)
+			}
+			if status, _ := do(t, http.MethodPost, base+"/echo", "", "captured body"); status != http.StatusOK {
+				t.Fatalf("POST /echo: got %d, want 200", status)
+			}
+			status, body := do(t, http.MethodGet, base+"/admin/capture/echo", "admin-token", "")
+			if status != http.StatusOK || !strings.Contains(body, "captured body") {
+				t.Errorf("GET /admin/capture/echo: got %d %s, want the captured request", status, body)
+			}
+		})
+	}
+}
+
+func TestCaptures(t *testing.T) {
+	cfg := &Config{}
+	cfg.Debug.CaptureEntries = 2
+	cfg.Debug.CaptureBodyBytes = 8
+	cfg.Debug.CaptureDuration = Duration(time.Minute)
+	clk := testsupport.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	c := NewCaptures(cfg, clk, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var seenWriter http.ResponseWriter
+	var seenBody io.ReadCloser
+	route := newFuncRoute("/echo", http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		seenWriter, seenBody = w, r.Body
+		w.Header().Set("Set-Cookie", "session=secret")
+		io.Copy(w, r.Body)
+	})
+	h := c.WrapRoute(route, route)
+	post := func(body string) {
+		req := httptest.NewRequest(http.MethodPost, "/echo?x=1", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Disabled, the route gets the request and the writer as they are.
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("x"))
+	body := req.Body
+	h.ServeHTTP(rec, req)
+	if seenWriter != rec || seenBody != body {
+		t.Error("a route not being captured got a wrapped writer or body")
+	}
+
+	if err := c.Start("/echo", 0, "ann"); err != nil {
+		t.Fatal(err)
+	}
+	post("dropped")
+	post("short")
+	post("a body over the cap")
+	views, err := c.View("POST /echo")
+	if err != nil || len(views) != 1 {
+		t.Fatalf("got %v, %v", views, err)
+	}
+	v := views[0]
+	if !v.Enabled || v.Principal != "ann" || v.Dropped != 1 || len(v.Entries) != 2 {
+		t.Fatalf("got view %+v, want an enabled capture by ann keeping 2 of 3 entries", v)
+	}
+	e := v.Entries[0]
+	if e.Request.Method != http.MethodPost || e.Request.URI != "/echo?x=1" || e.Request.Body.Data != "short" || e.Response.Status != http.StatusOK || e.Response.Body.Data != "short" {
+		t.Errorf("got entry %+v, want the request and response of the second POST", e)
+	}
+	if got := e.Request.Header.Get("Authorization"); got != redacted {
+		t.Errorf("captured Authorization %q, want it redacted", got)
+	}
+	if got := e.Response.Header.Get("Set-Cookie"); got != redacted {
+		t.Errorf("captured Set-Cookie %q, want it redacted", got)
+	}
+	want := captureBody{Data: "a body o…[truncated, 11 more bytes]", Bytes: 19, Truncated: true}
+	if got := v.Entries[1].Request.Body; got != want {
+		t.Errorf("got truncated body %+v, want %+v", got, want)
+	}
+
+	// The capture switches itself off once its duration is over, keeping
+	// what it captured.
+	clk.Advance(time.Minute)
+	post("late")
+	views, _ = c.View("/echo")
+	if v := views[0]; v.Enabled || len(v.Entries) != 2 || v.Entries[1].Request.Body.Data != want.Data {
+		t.Errorf("after the duration, got view %+v, want the disabled capture as it was", v)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"example.com/uberfx/clock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TransportOverride, when provided, replaces the transport at the
+// bottom of the chain of the shared client, the one making the
+// connections, so that tests can answer outbound requests without a
+// network, as with testsupport.HTTPMock. The layers above it, such as
+// logging, metrics and retries, still apply. It's never provided
+// outside tests.
+type TransportOverride interface {
+	http.RoundTripper
+}
+
+// NewHTTPClient builds the shared client used for outbound requests.
+// Its transport logs every request, passes the remaining deadline on to
+// the upstream, retries idempotent requests that fail transiently,
+// hedges them if they're slow and Config.Client.Hedge is set, guards
+// each upstream host with a circuit breaker and times the phases of
+// each attempt. Headers of the inbound request are propagated as the
+// HeaderPropagator decides, requests are charged to its call budget,
+// see CallBudgets, and response bodies are capped at
+// Config.Client.MaxResponseBytes, see ReadAllLimited. The override,
+// if not nil, replaces the connecting transport, see TransportOverride.
+func NewHTTPClient(cfg *Config, clk clock.Clock, log *slog.Logger, reg *prometheus.Registry, propagator *HeaderPropagator, override TransportOverride) *http.Client {
+	timeout := time.Duration(cfg.Client.Timeout)
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	var base http.RoundTripper = override
+	if override == nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialContext = newDialContext(cfg.Client)
+		base = t
+	}
+	var rt http.RoundTripper = newTracingTransport(base, log, reg)
+	rt = NewCircuitBreaker(rt, cfg.Client.Breaker, clk, log, reg)
+	rt = NewHedger(rt, cfg.Client.Hedge, clk, log, reg)
+	rt = NewRetrier(rt, cfg.Client.Retry, clk, log)
+	rt = &deadlineTransport{next: rt, clock: clk}
+	rt = propagator.Transport(rt)
+	rt = newBudgetTransport(rt, reg)
+	rt = newResponseLimitTransport(rt, cfg.Client)
+	rt = &loggingTransport{next: rt, clock: clk, log: log}
+	return &http.Client{Transport: rt, Timeout: timeout}
+}
+
+// loggingTransport is an http.RoundTripper that logs outbound requests.
+type loggingTransport struct {
+	next  http.RoundTripper
+	clock clock.Clock
+	log   *slog.Logger
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := t.clock.Now()
+	resp, err := t.next.RoundTrip(req)
+	attrs := []any{
+		slog.String("method", req.Method),
+		slog.String("url", req.URL.Redacted()),
+		slog.Duration("duration", t.clock.Now().Sub(start)),
+	}
+	if err != nil {
+		t.log.Warn("Outbound request failed", append(attrs, slog.String("err", err.Error()))...)
+		return nil, err
+	}
+	t.log.Debug("Outbound request", append(attrs, slog.Int("status", resp.StatusCode))...)
+	return resp, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHTTPClientRetriesUnavailable(t *testing.T) {
+	mock := testsupport.NewHTTPMock()
+	mock.On("GET http://upstream/hello").
+		Respond(http.StatusServiceUnavailable, "").
+		Respond(http.StatusOK, "Hello, ann").
+		Times(2)
+	clk := testsupport.NewFakeClock(time.Now())
+	cfg := &Config{}
+	client := NewHTTPClient(cfg, clk, slog.New(slog.NewTextHandler(io.Discard, nil)), prometheus.NewRegistry(), NewHeaderPropagator(cfg), mock)
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := client.Get("http://upstream/hello")
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		done <- result{resp.StatusCode, string(body), err}
+	}()
+
+	// The retry waits out its backoff.
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if r.status != http.StatusOK || r.body != "Hello, ann" {
+			t.Errorf("got %d %q, want the 200 of the retry", r.status, r.body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request not retried")
+	}
+	mock.Verify(t)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newDialContext returns the DialContext of the outbound transport.
+// Dials time out after Config.Client.DialTimeout, and hosts listed in
+// Config.Client.Resolve are dialed at the address given there rather
+// than the one DNS has for them.
+func newDialContext(cfg ClientConfig) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	timeout := time.Duration(cfg.DialTimeout)
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	d := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	resolve := cfg.Resolve
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return d.DialContext(ctx, network, resolveAddr(resolve, addr))
+	}
+}
+
+// resolveAddr returns the address to dial for addr, a host and port,
+// according to resolve. An override without a port keeps that of addr.
+func resolveAddr(resolve map[string]string, addr string) string {
+	if len(resolve) == 0 {
+		return addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	to, ok := resolve[addr]
+	if !ok {
+		if to, ok = resolve[host]; !ok {
+			return addr
+		}
+	}
+	if _, _, err := net.SplitHostPort(to); err == nil {
+		return to
+	}
+	return net.JoinHostPort(to, port)
+}
+
+// tracingTransport is an http.RoundTripper that times the phases of
+// each outbound request with an httptrace.ClientTrace: the DNS lookup,
+// the connect, the TLS handshake, and the time to the first response
+// byte. The timings are logged at debug level and observed in the
+// http_client_phase_duration_seconds histogram. Whether the connection
+// was reused is counted.
+type tracingTransport struct {
+	next   http.RoundTripper
+	log    *slog.Logger
+	phases *prometheus.HistogramVec
+	conns  *prometheus.CounterVec
+}
+
+func newTracingTransport(next http.RoundTripper, log *slog.Logger, reg *prometheus.Registry) *tracingTransport {
+	t := &tracingTransport{
+		next: next,
+		log:  log,
+		phases: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_client_phase_duration_seconds",
+			Help:    "Duration of the phases of outbound requests: dns, connect, tls and ttfb.",
+			Buckets: []float64{.0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"phase"}),
+		conns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_connections_total",
+			Help: "Connections used by outbound requests, by whether they were reused.",
+		}, []string{"reused"}),
+	}
+	reg.MustRegister(t.phases, t.conns)
+	return t
+}
+
+// requestTiming records the phases of an outbound request.
+type requestTiming struct {
+	mu sync.Mutex
+
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	TTFB    time.Duration
+	GotConn bool
+	Reused  bool
+	Addr    string
+}
+
+func (rt *requestTiming) clientTrace() *httptrace.ClientTrace {
+	lock := func(f func()) {
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+		f()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { lock(func() { rt.dnsStart = time.Now() }) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { lock(func() { rt.DNS = time.Since(rt.dnsStart) }) },
+		ConnectStart: func(string, string) {
+			// With several addresses, the dials race; the first one
+			// starting times the connect.
+			lock(func() {
+				if rt.connectStart.IsZero() {
+					rt.connectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone: func(_, addr string, err error) {
+			lock(func() {
+				if err == nil && rt.Connect == 0 {
+					rt.Connect = time.Since(rt.connectStart)
+				}
+			})
+		},
+		TLSHandshakeStart: func() { lock(func() { rt.tlsStart = time.Now() }) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { lock(func() { rt.TLS = time.Since(rt.tlsStart) }) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			lock(func() {
+				rt.GotConn = true
+				rt.Reused = info.Reused
+				if info.Conn != nil {
+					rt.Addr = info.Conn.RemoteAddr().String()
+				}
+			})
+		},
+		GotFirstResponseByte: func() { lock(func() { rt.TTFB = time.Since(rt.start) }) },
+	}
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timing := &requestTiming{start: time.Now()}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), timing.clientTrace()))
+	resp, err := t.next.RoundTrip(req)
+
+	timing.mu.Lock()
+	defer timing.mu.Unlock()
+	if timing.GotConn {
+		t.conns.WithLabelValues(strconv.FormatBool(timing.Reused)).Inc()
+	}
+	for _, p := range []struct {
+		phase string
+		d     time.Duration
+	}{{"dns", timing.DNS}, {"connect", timing.Connect}, {"tls", timing.TLS}, {"ttfb", timing.TTFB}} {
+		if p.d > 0 {
+			t.phases.WithLabelValues(p.phase).Observe(p.d.Seconds())
+		}
+	}
+	t.log.Debug("Outbound request timing",
+		slog.String("host", req.URL.Host),
+		slog.String("addr", timing.Addr),
+		slog.Bool("reused", timing.Reused),
+		slog.Duration("dns", timing.DNS),
+		slog.Duration("connect", timing.Connect),
+		slog.Duration("tls", timing.TLS),
+		slog.Duration("ttfb", timing.TTFB),
+	)
+	return resp, err
+}
@@ -0,0 +1,95 @@
+// Package clock is the source of time of the app's components, so that
+// tests can drive it: the components waiting on timers, expiring
+// entries or rolling over periods take a Clock rather than calling the
+// time package, and tests hand them a fake one, see
+// testsupport.FakeClock.
+//
+//	t := clk.NewTimer(wait)
+//	defer t.Stop()
+//	select {
+//	case <-t.C():
+//	case <-ctx.Done():
+//	}
+package clock
+
+import (
+	"context"
+	"time"
+)
+
+// Clock tells the time, and makes timers and tickers.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a Timer sending the time on its channel once d
+	// has passed.
+	NewTimer(d time.Duration) Timer
+	// NewTicker returns a Ticker sending the time on its channel every
+	// d.
+	NewTicker(d time.Duration) Ticker
+	// AfterFunc calls f in its own goroutine once d has passed. The
+	// channel of the Timer returned is nil.
+	AfterFunc(d time.Duration, f func()) Timer
+	// Sleep waits until d has passed or ctx is done, returning ctx's
+	// error in the latter case.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// Timer is a timer of a Clock, see time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a ticker of a Clock, see time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// Real returns the Clock of the time package.
+func Real() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
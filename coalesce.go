@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// CoalescedRoute is implemented by routes whose concurrent identical
+// GET and HEAD requests may share a single execution.
+type CoalescedRoute interface {
+	CoalesceRequests() bool
+}
+
+// coalesceHeaders are the request headers that can change a response,
+// and so must match for requests to be coalesced.
+var coalesceHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language", "Authorization", "Cookie", apiKeyHeader}
+
+// coalescedCall is an execution of a handler shared by identical
+// requests.
+type coalescedCall struct {
+	done   chan struct{}
+	status int
+	header http.Header
+	body   []byte
+	// bypass is set when the response was too large to share; waiters
+	// then run the handler themselves.
+	bypass bool
+}
+
+// Coalescer is route middleware that, for routes opting in through
+// CoalescedRoute, lets concurrent identical GET and HEAD requests wait
+// for the first one and share its response, so that a slow upstream is
+// called once rather than once per request. Requests are identical if
+// their method, path, query and coalesceHeaders match. Nothing is kept
+// once the first request completes, so even error responses are only
+// shared with the requests that were waiting for them. Responses larger
+// than Config.Coalesce.MaxResponseBytes aren't shared.
+type Coalescer struct {
+	maxBytes int
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// NewCoalescer builds a new Coalescer.
+func NewCoalescer(cfg *Config) *Coalescer {
+	c := &Coalescer{maxBytes: cfg.Coalesce.MaxResponseBytes, calls: make(map[string]*coalescedCall)}
+	if c.maxBytes <= 0 {
+		c.maxBytes = 1 << 20
+	}
+	return c
+}
+
+func (*Coalescer) Order() int {
+	return orderCoalesce
+}
+
+func (c *Coalescer) WrapRoute(route Route, next http.Handler) http.Handler {
+	if cr, ok := route.(CoalescedRoute); !ok || !cr.CoalesceRequests() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := coalesceKey(r)
+
+		c.mu.Lock()
+		if call, ok := c.calls[key]; ok {
+			c.mu.Unlock()
+			<-call.done
+			if call.bypass {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for k, v := range call.header.Clone() {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(call.status)
+			_, _ = w.Write(call.body)
+			return
+		}
+		call := &coalescedCall{done: make(chan struct{})}
+		c.calls[key] = call
+		c.mu.Unlock()
+
+		bw := &bufferingWriter{ResponseWriter: w, header: make(http.Header), max: c.maxBytes}
+		completed := false
+		defer func() {
+			c.mu.Lock()
+			delete(c.calls, key)
+			c.mu.Unlock()
+			call.status, call.header, call.body = bw.status, bw.header, bw.buf.Bytes()
+			if call.status == 0 {
+				call.status = http.StatusOK
+			}
+			// Waiters run the handler themselves if it panicked.
+			call.bypass = bw.passthrough || !completed
+			close(call.done)
+		}()
+		next.ServeHTTP(bw, r)
+		bw.finish()
+		completed = true
+	})
+}
+
+func coalesceKey(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte(' ')
+	b.WriteString(r.URL.RequestURI())
+	for _, h := range coalesceHeaders {
+		b.WriteByte(0)
+		b.WriteString(strings.Join(r.Header.Values(h), ","))
+	}
+	return b.String()
+}
+
+// bufferingWriter buffers a response, up to max bytes, so that it can be
+// shared. Once the response exceeds max bytes or is flushed, it's
+// written through instead.
+type bufferingWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	status      int
+	buf         bytes.Buffer
+	max         int
+	passthrough bool
+}
+
+func (w *bufferingWriter) Header() http.Header {
+	if w.passthrough {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *bufferingWriter) WriteHeader(status int) {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bufferingWriter) Write(b []byte) (int, error) {
+	if !w.passthrough && w.buf.Len()+len(b) > w.max {
+		if err := w.switchToPassthrough(); err != nil {
+			return 0, err
+		}
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
+
+func (w *bufferingWriter) Flush() {
+	if !w.passthrough {
+		_ = w.switchToPassthrough()
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Written reports whether the handler started the response, buffered or
+// not.
+func (w *bufferingWriter) Written() bool {
+	return w.status != 0 || w.passthrough
+}
+
+// Status returns the status the handler wrote, or 0 if it wrote none.
+func (w *bufferingWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// switchToPassthrough writes out what was buffered and stops buffering.
+func (w *bufferingWriter) switchToPassthrough() error {
+	w.passthrough = true
+	w.writeBuffered()
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// finish writes out the buffered response once the handler returns.
+func (w *bufferingWriter) finish() {
+	if w.passthrough {
+		return
+	}
+	w.writeBuffered()
+	_, _ = w.ResponseWriter.Write(w.buf.Bytes())
+}
+
+func (w *bufferingWriter) writeBuffered() {
+	for k, v := range w.header {
+		w.ResponseWriter.Header()[k] = v
+	}
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// coalescedRoute is a route opting into coalescing.
+type coalescedRoute struct{ *funcRoute }
+
+func (coalescedRoute) CoalesceRequests() bool { return true }
+
+func TestCoalesceKey(t *testing.T) {
+	request := func(header, value string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/report?day=1", nil)
+		r.Header.Set(header, value)
+		return r
+	}
+	for _, header := range coalesceHeaders {
+		if coalesceKey(request(header, "a")) == coalesceKey(request(header, "b")) {
+			t.Errorf("requests with different %s headers share a key", header)
+		}
+		if coalesceKey(request(header, "a")) != coalesceKey(request(header, "a")) {
+			t.Errorf("requests with the same %s header have different keys", header)
+		}
+	}
+}
+
+func TestCoalescerSeparatesAPIKeys(t *testing.T) {
+	var calls atomic.Int32
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	route := coalescedRoute{newFuncRoute("/report", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		entered <- struct{}{}
+		<-release
+		io.WriteString(w, "report for "+r.Header.Get(apiKeyHeader))
+	})}
+	h := NewCoalescer(&Config{}).WrapRoute(route, route)
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 2)
+	for i, key := range []string{"a-key", "b-key"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/report", nil)
+			req.Header.Set(apiKeyHeader, key)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			bodies[i] = rec.Body.String()
+		}()
+		// The second request runs the handler itself while the first is
+		// still in it, rather than wait for its response.
+		select {
+		case <-entered:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("request with %s didn't run the handler", key)
+		}
+	}
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 2 || bodies[0] != "report for a-key" || bodies[1] != "report for b-key" {
+		t.Errorf("got %d calls and bodies %q, want each key served its own report", n, bodies)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/uberfx/clock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrRouteBusy is reported when a route is serving as many requests as
+// it may at once.
+var ErrRouteBusy = errors.New("too many concurrent requests to this route")
+
+// ConcurrencyLimitedRoute is implemented by routes that cap how many
+// requests they serve at once. Zero means no limit.
+type ConcurrencyLimitedRoute interface {
+	MaxConcurrency() int
+}
+
+// ConcurrencyLimiter is route middleware that caps the requests served
+// at once by each route, so that one slow route can't tie up every
+// server goroutine and starve the others. A route's limit is taken from
+// Config.Server.Concurrency.Limits by pattern, or else its
+// ConcurrencyLimitedRoute method. A request finding the route at its
+// limit waits up to Config.Server.Concurrency.MaxWait for a slot, then
+// gets a 503 with a Retry-After header.
+type ConcurrencyLimiter struct {
+	limits     map[string]int
+	wait       time.Duration
+	retryAfter string
+	errs       *ErrorWriter
+	clock      clock.Clock
+	inFlight   *prometheus.GaugeVec
+}
+
+// NewConcurrencyLimiter builds a new ConcurrencyLimiter.
+func NewConcurrencyLimiter(cfg *Config, errs *ErrorWriter, clk clock.Clock, reg *prometheus.Registry) *ConcurrencyLimiter {
+	m := &ConcurrencyLimiter{
+		limits: cfg.Server.Concurrency.Limits,
+		wait:   time.Duration(cfg.Server.Concurrency.MaxWait),
+		errs:   errs,
+		clock:  clk,
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_route_in_flight_requests",
+			Help: "Requests being served, by route, for routes with a concurrency limit.",
+		}, []string{"route"}),
+	}
+	retryAfter := time.Duration(cfg.Server.Concurrency.RetryAfter)
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+	m.retryAfter = strconv.Itoa(int((retryAfter + time.Second - 1) / time.Second))
+	reg.MustRegister(m.inFlight)
+	return m
+}
+
+func (*ConcurrencyLimiter) Order() int {
+	return orderConcurrency
+}
+
+func (m *ConcurrencyLimiter) WrapRoute(route Route, next http.Handler) http.Handler {
+	pattern := route.Pattern()
+	limit, ok := m.limits[pattern]
+	if cl, isLimited := route.(ConcurrencyLimitedRoute); !ok && isLimited {
+		limit = cl.MaxConcurrency()
+	}
+	if limit <= 0 {
+		return next
+	}
+	slots := make(chan struct{}, limit)
+	inFlight := m.inFlight.WithLabelValues(pattern)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.acquire(r, slots) {
+			w.Header().Set("Retry-After", m.retryAfter)
+			m.errs.Write(w, r, ErrRouteBusy)
+			return
+		}
+		inFlight.Inc()
+		defer func() {
+			inFlight.Dec()
+			<-slots
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire takes a slot, waiting for one up to the configured time.
+func (m *ConcurrencyLimiter) acquire(r *http.Request, slots chan struct{}) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	if m.wait <= 0 {
+		return false
+	}
+	t := m.clock.NewTimer(m.wait)
+	defer t.Stop()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-t.C():
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,838 @@
+package main
+
+import "time"
+
+// Config is the application configuration. Its durations are written
+// with a unit, as in "500ms" or "2m30s", see Duration.
+type Config struct {
+	Env     string        `json:"env"`
+	App     AppConfig     `json:"app"`
+	Log     LogConfig     `json:"log"`
+	Server  ServerConfig  `json:"server"`
+	Client  ClientConfig  `json:"client"`
+	Echo    EchoConfig    `json:"echo"`
+	Hello   HelloConfig   `json:"hello"`
+	Audit   AuditConfig   `json:"audit"`
+	Session SessionConfig `json:"session"`
+	Auth    AuthConfig    `json:"auth"`
+	API     APIConfig     `json:"api"`
+
+	Idempotency IdempotencyConfig `json:"idempotency"`
+	Coalesce    CoalesceConfig    `json:"coalesce"`
+	Static      StaticConfig      `json:"static"`
+	Warmup      WarmupConfig      `json:"warmup"`
+	Events      EventsConfig      `json:"events"`
+	Upload      UploadConfig      `json:"upload"`
+	Debug       DebugConfig       `json:"debug"`
+	Discovery   DiscoveryConfig   `json:"discovery"`
+	Shadow      ShadowConfig      `json:"shadow"`
+	Deprecation DeprecationConfig `json:"deprecation"`
+	Maintenance MaintenanceConfig `json:"maintenance"`
+	Health      HealthConfig      `json:"health"`
+	Workers     WorkersConfig     `json:"workers"`
+	SelfTest    SelfTestConfig    `json:"self_test"`
+	Alerts      AlertsConfig      `json:"alerts"`
+	// RouteToggles configures the routes switched off at runtime, see
+	// RouteToggles.
+	RouteToggles RouteTogglesConfig `json:"route_toggles"`
+	// HeaderPolicy lists the response header rules of the routes, see
+	// HeaderRule; the first rule matching a route applies.
+	HeaderPolicy []HeaderRule `json:"header_policy"`
+	// Flags are the initial feature flags, by name.
+	Flags map[string]Flag `json:"flags"`
+	// Redirects are routes redirecting a path elsewhere, see
+	// RedirectRoute.
+	Redirects []RedirectConfig `json:"redirects"`
+
+	load *configLoad
+}
+
+// NewConfig loads the configuration from its layers, see
+// loadConfigLayers: the defaults embedded in the binary, the JSON file
+// named by the CONFIG_FILE environment variable and the overlay of the
+// environment. Each layer only overrides the values it sets, see
+// mergeValue. Durations are checked against their bounds, see Duration.
+// Secrets are then taken from the environment, see
+// loadSecrets. The env of the config is env, if set.
+func NewConfig(env ConfigEnv) (*Config, error) {
+	layers, load, err := loadConfigLayers(env)
+	if err != nil {
+		return nil, err
+	}
+	cfg := mergeConfigs(layers, load)
+	if env != "" {
+		cfg.Env = string(env)
+	}
+	cfg.load = load
+	if err := validateDurations(cfg); err != nil {
+		return nil, err
+	}
+	if err := loadSecrets(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// AppConfig configures the Fx application itself.
+type AppConfig struct {
+	// Name identifies the app in the logs; it defaults to "uberfx".
+	Name string `json:"name"`
+	// StartTimeout and StopTimeout bound the time taken by all OnStart
+	// and OnStop hooks. Zero selects the Fx defaults.
+	StartTimeout Duration `json:"start_timeout"`
+	StopTimeout  Duration `json:"stop_timeout"`
+	// StopHookWarning is how long an OnStop hook may run before it's
+	// reported as slow; it defaults to 5s.
+	StopHookWarning Duration `json:"stop_hook_warning"`
+	// RestartTimeout is how long a process started to take over on
+	// SIGUSR2 has to report that it's ready; it defaults to 30s.
+	RestartTimeout Duration `json:"restart_timeout"`
+}
+
+// LogConfig configures logging.
+type LogConfig struct {
+	// FxEvents selects which Fx events are logged: all of them (the
+	// default), or with "on-error" only the events leading up to a
+	// failure.
+	FxEvents string `json:"fx_events"`
+	// Format selects how records are written: "console" (the default),
+	// human-readable, or "json".
+	Format string `json:"format"`
+	// Keys renames the core fields of the records.
+	Keys LogKeys `json:"keys"`
+	// Fields are static fields added to every record, such as the
+	// service, region or instance ID.
+	Fields map[string]string `json:"fields"`
+	// Access configures the access log, the record logged for each
+	// request.
+	Access AccessLogConfig `json:"access"`
+	// Loggers overrides the level and output of the loggers of
+	// components, by name, such as "http.access", "audit" or "fx"; see
+	// LoggerFactory.
+	Loggers map[string]NamedLoggerConfig `json:"loggers"`
+}
+
+// NamedLoggerConfig configures a logger of the LoggerFactory.
+type NamedLoggerConfig struct {
+	// Level is the least level of the records logged, "debug", "info",
+	// "warn" or "error". It defaults to that of the app's logger, or to
+	// info for a separate output.
+	Level string `json:"level"`
+	// Output is where records are written: "stdout", "stderr" or the
+	// path of a file. By default they're written by the app's logger.
+	Output string `json:"output"`
+	// Format is the format of a separate output, "json", the default, or
+	// "text".
+	Format string `json:"format"`
+	// MaxSizeBytes is the size at which a file output is rotated; it
+	// defaults to 100 MiB, and a negative size never rotates it.
+	// MaxBackups is how many rotated files are kept; it defaults to 5.
+	MaxSizeBytes int64 `json:"max_size_bytes"`
+	MaxBackups   int   `json:"max_backups"`
+}
+
+// AccessLogConfig configures the access log.
+type AccessLogConfig struct {
+	// SampleRate is the share, between 0 and 1, of the records of
+	// successful requests that are kept; by default they all are.
+	// Errors are always logged.
+	SampleRate float64 `json:"sample_rate"`
+	// SummaryInterval is how often the number of records sampled out is
+	// logged; it defaults to 1m, and may not be under 1s.
+	SummaryInterval Duration `json:"summary_interval" min:"1s"`
+	// Output is where the access log is written: "stdout", "stderr" or
+	// the path of a file. By default it's written by the app's logger,
+	// along with the other records.
+	Output string `json:"output"`
+	// Format is the format of a separate access log, "json", the
+	// default, or "text".
+	Format string `json:"format"`
+	// MaxSizeBytes is the size at which an access log file is rotated;
+	// it defaults to 100 MiB.
+	MaxSizeBytes int64 `json:"max_size_bytes"`
+	// MaxBackups is how many rotated access log files are kept; it
+	// defaults to 5.
+	MaxBackups int `json:"max_backups"`
+}
+
+// LogKeys are the names of the core fields of log records; empty names
+// keep those of the format.
+type LogKeys struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// ServerConfig configures the HTTP server.
+type ServerConfig struct {
+	// Addr is the address to listen on; it defaults to ":8098".
+	Addr string `json:"addr"`
+	// Router selects the routing backend: "servemux" (the default) or "chi".
+	Router string `json:"router"`
+	// EmptyRoutes decides what happens when no route besides the
+	// built-in ones, such as /ping, /healthz, /metrics and the admin and
+	// debug routes, is provided in the routes group, most likely through
+	// a typo in its tag: "fail" (the default) fails startup,
+	// "diagnostic" warns and serves a page at / explaining how to
+	// register routes.
+	EmptyRoutes string `json:"empty_routes"`
+	// Root configures the response to GET /, unless a route of its own
+	// is registered there, see RootHandler.
+	Root RootConfig `json:"root"`
+	// Hosts maps host patterns, exact or "*." wildcards, to the virtual
+	// hosts serving them; other hosts get the default routes.
+	Hosts map[string]string `json:"hosts"`
+	// PathPolicy decides what happens to requests for non-canonical
+	// paths such as "/hello/" or "//hello": "strict" (the default)
+	// routes them as they are, "redirect" answers with a redirect to the
+	// canonical path and "strip" routes them as if the canonical path
+	// had been requested.
+	PathPolicy string `json:"path_policy"`
+	// ActiveConnsWarning is the number of active connections above which
+	// a warning is logged; by default there's no warning.
+	ActiveConnsWarning int `json:"active_conns_warning"`
+	// MaxBodyBytes caps the size of request bodies; it defaults to 1 MiB.
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// BodyLimits overrides MaxBodyBytes by route pattern, and the limit
+	// routes declare themselves: zero inherits MaxBodyBytes and -1
+	// means no limit.
+	BodyLimits map[string]int64 `json:"body_limits"`
+	// MaxDecodedBodyBytes caps the size of gzip-encoded request bodies
+	// once decompressed; it defaults to 8 times the body limit.
+	MaxDecodedBodyBytes int64 `json:"max_decoded_body_bytes"`
+	// MaxDrainBytes is how much of a request body left unread by its
+	// handler is discarded to keep the connection alive; it defaults to
+	// 256 KiB, as much as net/http discards by itself. Connections with
+	// more left are closed.
+	MaxDrainBytes int64 `json:"max_drain_bytes"`
+	// HandlerTimeout is the default deadline of each request; it
+	// defaults to 30s.
+	HandlerTimeout Duration `json:"handler_timeout" min:"1ms"`
+	// MinRequestTimeout and MaxRequestTimeout bound the budget a caller
+	// may request with the X-Request-Timeout header. They default to 10ms
+	// and HandlerTimeout.
+	MinRequestTimeout Duration `json:"min_request_timeout" min:"1ms"`
+	MaxRequestTimeout Duration `json:"max_request_timeout" min:"1ms"`
+	// SlowRequestThreshold is how long a request may take before it's
+	// logged as slow; it defaults to 1s. SlowStackInterval is the least
+	// time between two captures of the goroutine stacks for requests
+	// running for 5 times the threshold; it defaults to 1m, and may not
+	// be under 1s.
+	SlowRequestThreshold Duration `json:"slow_request_threshold"`
+	SlowStackInterval    Duration `json:"slow_stack_interval" min:"1s"`
+	// DrainReportInterval is how often the requests still in flight are
+	// logged while the server drains on shutdown; it defaults to 1s.
+	DrainReportInterval Duration `json:"drain_report_interval" min:"100ms"`
+	// QueueTime configures the measure of how long requests were queued
+	// before the app saw them, see QueueTimer.
+	QueueTime QueueTimeConfig `json:"queue_time"`
+	// Concurrency caps the requests served at once by each route.
+	Concurrency ConcurrencyConfig `json:"concurrency"`
+	// ContentTypes restricts the media types of request bodies by route.
+	ContentTypes ContentTypeConfig `json:"content_types"`
+	// ProxyProtocol configures the reading of PROXY protocol headers
+	// sent by a load balancer ahead of each connection.
+	ProxyProtocol ProxyProtocolConfig `json:"proxy_protocol"`
+	// TLS configures serving over TLS, see ServerTLS.
+	TLS ServerTLSConfig `json:"tls"`
+}
+
+// RootConfig configures the response to GET /.
+type RootConfig struct {
+	// Mode selects the response: "descriptor" (the default) describes
+	// the service as JSON, "redirect" redirects to Redirect, "spa"
+	// serves the index page of the static files, and "none" leaves / not
+	// found.
+	Mode string `json:"mode"`
+	// Redirect is the path, or URL, redirected to in redirect mode, such
+	// as "/docs".
+	Redirect string `json:"redirect"`
+	// Docs is the path, or URL, of the service's documentation, linked
+	// from the descriptor.
+	Docs string `json:"docs"`
+}
+
+// ServerTLSConfig configures the TLS of the server.
+type ServerTLSConfig struct {
+	// Certificates are the certificate chains served, picked by SNI; TLS
+	// is off without any.
+	Certificates []TLSCertificateConfig `json:"certificates"`
+	// ClientCAFile is a PEM file of the CAs client certificates are
+	// verified against, for mutual TLS. Clients may go without one
+	// unless RequireClientCert is set.
+	ClientCAFile      string `json:"client_ca_file"`
+	RequireClientCert bool   `json:"require_client_cert"`
+	// ExpiryWarning is how long before it expires a certificate is
+	// warned about; it defaults to 30 days.
+	ExpiryWarning Duration `json:"expiry_warning"`
+}
+
+// TLSCertificateConfig names the PEM files of a certificate chain and
+// its key.
+type TLSCertificateConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// ContentTypeConfig configures the media types routes accept request
+// bodies of, see ContentTypes.
+type ContentTypeConfig struct {
+	// Accepted overrides the media types routes declare they accept, by
+	// pattern; an empty list accepts anything.
+	Accepted map[string][]string `json:"accepted"`
+	// Strict rejects requests to routes with accepted types that have a
+	// body but no Content-Type; by default they're let through.
+	Strict bool `json:"strict"`
+}
+
+// QueueTimeConfig configures the queue time of requests, taken from
+// their X-Request-Start header.
+type QueueTimeConfig struct {
+	// MaxAge is how long a request may have been queued before it's
+	// rejected with a 504; by default requests are never rejected.
+	MaxAge Duration `json:"max_age"`
+	// ClockSkew is how far apart the clocks of the proxy setting the
+	// header and of the app may be; it defaults to none.
+	ClockSkew Duration `json:"clock_skew"`
+}
+
+// AuthConfig configures the authentication of requests by bearer
+// token, see BearerAuth, and the roles routes require, see
+// Authorization.
+type AuthConfig struct {
+	// Tokens are the static bearer tokens, as "principal:token" entries.
+	Tokens []string `json:"tokens" secretfile:"true"`
+	// Roles lists the roles of each principal.
+	Roles map[string][]string `json:"roles"`
+	// RouteRoles overrides the roles routes require, by pattern; an empty
+	// list requires none. Admin and debug routes not listed require the
+	// "admin" role.
+	RouteRoles map[string][]string `json:"route_roles"`
+	// APIKeys are the API keys accepted in the X-API-Key header, see
+	// APIKeyAuth. If APIKeysFile is set, they're read from that JSON file
+	// instead, as a list in the same form, which is re-read on SIGHUP.
+	APIKeys     []APIKeyConfig `json:"api_keys"`
+	APIKeysFile string         `json:"api_keys_file"`
+}
+
+// APIKeyConfig configures an API key.
+type APIKeyConfig struct {
+	Key string `json:"key" secretfile:"true"`
+	// Name identifies the key, and is the principal of its requests.
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+	// RateLimit is how many requests per second the key may make, in
+	// bursts of up to Burst, which defaults to the rate rounded up; by
+	// default there's no limit.
+	RateLimit float64 `json:"rate_limit"`
+	Burst     int     `json:"burst"`
+	// Disabled turns the requests of the key away with a 403.
+	Disabled bool `json:"disabled"`
+}
+
+// ProxyProtocolConfig configures the PROXY protocol support of the
+// listener.
+type ProxyProtocolConfig struct {
+	// Enabled reads PROXY protocol headers, versions 1 and 2, taking the
+	// client address from them. Only enable it behind a load balancer
+	// sending them, since clients could otherwise claim any address.
+	Enabled bool `json:"enabled"`
+	// Required rejects connections without a header; otherwise they're
+	// served as they are.
+	Required bool `json:"required"`
+	// TrustedProxies lists the addresses, as CIDRs or IPs, of the load
+	// balancers whose headers are trusted; by default any peer's are.
+	// Headers from other peers are rejected, as are the peers themselves
+	// if headers are required.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// HeaderTimeout is how long a connection has to send its header; it
+	// defaults to 5s.
+	HeaderTimeout Duration `json:"header_timeout"`
+}
+
+// DeprecationConfig configures the handling of deprecated routes.
+type DeprecationConfig struct {
+	// LogPerClient is how many requests to deprecated routes are logged
+	// per client and day; it defaults to 10.
+	LogPerClient int `json:"log_per_client"`
+	// GoneAfterSunset answers requests to deprecated routes with 410
+	// Gone once their sunset has passed; by default they keep working.
+	GoneAfterSunset bool `json:"gone_after_sunset"`
+}
+
+// MaintenanceConfig configures maintenance mode, see Maintenance.
+type MaintenanceConfig struct {
+	// Enabled starts the app in maintenance mode, with the given Message
+	// and ETA.
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message"`
+	ETA     *time.Time `json:"eta"`
+	// MarkerFile is a file whose existence at startup turns maintenance
+	// mode on; its contents, if any, are the message.
+	MarkerFile string `json:"marker_file"`
+	// RetryAfter is the delay suggested to clients when there's no ETA,
+	// rounded up to whole seconds; it defaults to 30s.
+	RetryAfter Duration `json:"retry_after"`
+	// KeepReady keeps /readyz reporting the app ready during
+	// maintenance; by default it reports it not ready, so that load
+	// balancers drain it.
+	KeepReady bool `json:"keep_ready"`
+}
+
+// ConcurrencyConfig configures the per-route concurrency limits.
+type ConcurrencyConfig struct {
+	// Limits caps the requests served at once, by route pattern. It
+	// overrides the limit routes declare themselves.
+	Limits map[string]int `json:"limits"`
+	// MaxWait is how long a request waits for a busy route; by default
+	// it's turned away at once.
+	MaxWait Duration `json:"max_wait"`
+	// RetryAfter is the delay suggested to turned away clients, rounded
+	// up to whole seconds; it defaults to 1s.
+	RetryAfter Duration `json:"retry_after"`
+}
+
+// BodyLimit returns the effective request body limit.
+func (c ServerConfig) BodyLimit() int64 {
+	if c.MaxBodyBytes <= 0 {
+		return 1 << 20
+	}
+	return c.MaxBodyBytes
+}
+
+// RouteBodyLimit returns the request body limit of the route with the
+// given pattern, declaring the given limit itself, or -1 if it has none.
+func (c ServerConfig) RouteBodyLimit(pattern string, declared int64) int64 {
+	limit, ok := c.BodyLimits[pattern]
+	if !ok {
+		limit = declared
+	}
+	switch {
+	case limit == 0:
+		return c.BodyLimit()
+	case limit < 0:
+		return -1
+	default:
+		return limit
+	}
+}
+
+// ClientConfig configures the shared outbound HTTP client.
+type ClientConfig struct {
+	// Timeout bounds each outbound request; it defaults to 10s.
+	Timeout Duration `json:"timeout"`
+	// DialTimeout bounds the connect of each outbound connection; it
+	// defaults to 5s.
+	DialTimeout Duration `json:"dial_timeout"`
+	// Resolve maps host names, or host:port pairs, to the addresses they
+	// are dialed at instead of the ones DNS has for them, as curl's
+	// --resolve does. An address without a port keeps the requested one.
+	Resolve map[string]string `json:"resolve"`
+	Breaker BreakerConfig     `json:"breaker"`
+	Retry   RetryConfig       `json:"retry"`
+	Hedge   HedgeConfig       `json:"hedge"`
+	// PropagateHeaders lists the inbound request headers copied onto
+	// outbound requests; it defaults to X-Request-ID, Traceparent and
+	// Tracestate. A "*" entry propagates all headers but sensitive ones
+	// such as Authorization, which must be listed by name.
+	PropagateHeaders []PropagatedHeader `json:"propagate_headers"`
+	// CallBudget is how many outbound requests each inbound request may
+	// make, unless its route declares otherwise; it defaults to 10.
+	CallBudget int `json:"call_budget"`
+	// MaxResponseBytes caps the body of each outbound response; it
+	// defaults to 32 MiB, and a negative cap lifts it. Requests may set
+	// their own with WithResponseLimit.
+	MaxResponseBytes int64 `json:"max_response_bytes"`
+}
+
+// PropagatedHeader is an inbound header propagated to outbound requests.
+type PropagatedHeader struct {
+	Name string `json:"name"`
+	// As renames the header on outbound requests.
+	As string `json:"as"`
+}
+
+// BreakerConfig configures the per-host circuit breaker of the
+// outbound HTTP client. Zero values select the defaults.
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens
+	// the circuit; it defaults to 5.
+	FailureThreshold int `json:"failure_threshold"`
+	// OpenDuration is how long the circuit stays open before probing the
+	// host again; it defaults to 30s.
+	OpenDuration Duration `json:"open_duration"`
+	// HalfOpenProbes is the number of successful probes needed to close
+	// the circuit again; it defaults to 1.
+	HalfOpenProbes int `json:"half_open_probes"`
+}
+
+// RetryConfig configures retries of idempotent outbound requests. Zero
+// values select the defaults.
+type RetryConfig struct {
+	// MaxAttempts is the total number of attempts, including the first;
+	// it defaults to 3. Set it to 1 to disable retries.
+	MaxAttempts int `json:"max_attempts"`
+	// MaxElapsed bounds the total time spent on a request including
+	// backoff; it defaults to 10s.
+	MaxElapsed Duration `json:"max_elapsed"`
+	// BaseDelay is the backoff before the first retry, doubled on each
+	// further attempt; it defaults to 100ms.
+	BaseDelay Duration `json:"base_delay"`
+	// MaxDelay caps the backoff between attempts; it defaults to 2s.
+	MaxDelay Duration `json:"max_delay"`
+}
+
+// HedgeConfig configures the hedging of idempotent outbound requests,
+// see Hedger.
+type HedgeConfig struct {
+	// Delay is how long an attempt may go without a response before a
+	// second one is sent; hedging is off unless it's set.
+	Delay Duration `json:"delay"`
+	// MaxInFlight caps the hedges outstanding at once; it defaults to
+	// 10.
+	MaxInFlight int `json:"max_in_flight"`
+}
+
+// EchoConfig configures the echo endpoints.
+type EchoConfig struct {
+	// MaxMultipartBytes caps the combined size of the parts accepted by
+	// /echo/multipart; it defaults to the body limit of the route.
+	MaxMultipartBytes int64 `json:"max_multipart_bytes"`
+	// MaxPartBytes caps the size of each part; it defaults to
+	// MaxMultipartBytes.
+	MaxPartBytes int64 `json:"max_part_bytes"`
+	// MaxTransformBytes caps the bodies that transformers needing the
+	// whole body, such as reverse, accept; it defaults to 1 MiB.
+	MaxTransformBytes int64 `json:"max_transform_bytes"`
+	// FlushInterval is how long echoed data may be held before it's
+	// flushed to the client; it defaults to 100ms.
+	FlushInterval Duration `json:"flush_interval"`
+	// EncodingWidth is the width of the lines /echo?encoding= wraps its
+	// output at; it defaults to 76, and a negative width doesn't wrap.
+	EncodingWidth int `json:"encoding_width"`
+	// MaxLineBytes caps the lines /echo/ndjson validates; longer ones are
+	// reported as such. It defaults to 1 MiB.
+	MaxLineBytes int `json:"max_line_bytes"`
+}
+
+// APIConfig configures the shape of the JSON responses, see
+// APIResponses.
+type APIConfig struct {
+	// Envelope wraps responses as {"data": ..., "meta": {...}}, and
+	// errors as {"error": ..., "meta": {...}}.
+	Envelope bool `json:"envelope"`
+	// FieldCase names the fields of responses without a json tag naming
+	// them: "snake" for snake_case, or by default their Go names.
+	FieldCase string `json:"field_case"`
+	// PlainRoutes lists the routes whose responses stay plain, as
+	// path.Match patterns of their pattern or path.
+	PlainRoutes []string `json:"plain_routes"`
+}
+
+// RedirectConfig configures a redirect.
+type RedirectConfig struct {
+	// From is the path redirected, for any method.
+	From string `json:"from"`
+	// To is the URL redirected to, relative to the request's or absolute.
+	To string `json:"to"`
+	// Status is the status of the redirect; it defaults to 301.
+	Status int `json:"status"`
+	// Query says whether the query string of the request is carried over
+	// to the target, "preserve", or not, "drop". It defaults to preserve
+	// for relative targets and drop for absolute ones.
+	Query string `json:"query"`
+}
+
+// RouteTogglesConfig configures the route toggles.
+type RouteTogglesConfig struct {
+	// Disabled lists the routes off from the start, by pattern, as in
+	// "POST /upload", or by path, for every method.
+	Disabled []string `json:"disabled"`
+	// Message is the detail of the responses of disabled routes; it
+	// defaults to a message translated to the client's language.
+	Message string `json:"message"`
+}
+
+// WorkersConfig configures the worker pool of CPU-heavy work, see
+// WorkerPool.
+type WorkersConfig struct {
+	// Size is the number of workers; it defaults to GOMAXPROCS.
+	Size int `json:"size"`
+	// QueueSize is how much work may wait for a worker; it defaults to 4
+	// times the size.
+	QueueSize int `json:"queue_size"`
+	// Routes lists the route patterns, or path.Match patterns of them,
+	// whose work goes through the pool; by default none does.
+	Routes []string `json:"routes"`
+	// RetryAfter is the delay suggested to clients turned away; it
+	// defaults to 1s.
+	RetryAfter Duration `json:"retry_after"`
+}
+
+// SelfTestConfig caps the runs of POST /admin/selftest, see
+// SelfTestHandler.
+type SelfTestConfig struct {
+	// MaxConcurrency caps the requests in flight at once; it defaults to
+	// 16.
+	MaxConcurrency int `json:"max_concurrency"`
+	// MaxDuration caps how long a run lasts; it defaults to 30s. Runs
+	// are also cut short by the deadline of their request, see
+	// Config.Server.HandlerTimeout.
+	MaxDuration Duration `json:"max_duration"`
+	// MaxRequests caps the requests a run sends; it defaults to 100000.
+	MaxRequests int `json:"max_requests"`
+	// MaxPayloadBytes caps the body of each request; it defaults to 1
+	// MiB.
+	MaxPayloadBytes int64 `json:"max_payload_bytes"`
+}
+
+// HealthConfig configures the health checks, see HealthProber.
+type HealthConfig struct {
+	// Interval is how often each check runs, unless it sets its own; it
+	// defaults to 30s, and may not be under 100ms.
+	Interval Duration `json:"interval" min:"100ms"`
+	// Jitter is the share of the interval added at random to each wait
+	// between runs; it defaults to 0.1, and a negative jitter adds none.
+	Jitter float64 `json:"jitter"`
+	// Timeout bounds each run of a check; it defaults to 5s.
+	Timeout Duration `json:"timeout" min:"1ms"`
+	// Upstreams are the upstream services checked over HTTP.
+	Upstreams []UpstreamCheckConfig `json:"upstreams"`
+	// RouteDependencies lists, by route pattern, the health checks of
+	// what the routes depend on, overriding what they declare, see
+	// DependencyGuard.
+	RouteDependencies map[string][]string `json:"route_dependencies"`
+	// DependencyPolicy decides what happens to requests to routes whose
+	// dependencies are failing: "reject" (the default) answers them with
+	// a 503, "warn" serves them with an X-Degraded-Dependencies header.
+	DependencyPolicy string `json:"dependency_policy"`
+}
+
+// UpstreamCheckConfig configures an HTTPHealthChecker.
+type UpstreamCheckConfig struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// ExpectStatus is the status the upstream answers when healthy; it
+	// defaults to 200.
+	ExpectStatus int `json:"expect_status"`
+	// Interval overrides Config.Health.Interval for this check, with the
+	// same minimum.
+	Interval Duration `json:"interval" min:"100ms"`
+	// Informational makes failures of the check reported but not fatal
+	// to the health of the app.
+	Informational bool `json:"informational"`
+}
+
+// AlertsConfig configures the alerts on the rate of server errors, see
+// ErrorRateMonitor.
+type AlertsConfig struct {
+	// Threshold is the share of requests answered with a 5xx from which
+	// an alert fires; it defaults to 0.05. MinRequests is the least
+	// requests in the window for it to, which defaults to 20.
+	Threshold   float64 `json:"threshold"`
+	MinRequests int     `json:"min_requests"`
+	// Window is how far back the error rate is measured; it defaults to
+	// 1m. Interval is how often it's measured; it defaults to 10s.
+	Window   Duration `json:"window" min:"1s"`
+	Interval Duration `json:"interval" min:"100ms"`
+	// Cooldown is the least time between two alerts of a type; it
+	// defaults to 5m.
+	Cooldown Duration `json:"cooldown"`
+	// Webhook is where alerts are posted, if anywhere.
+	Webhook AlertWebhookConfig `json:"webhook"`
+}
+
+// AlertWebhookConfig configures a WebhookAlerter.
+type AlertWebhookConfig struct {
+	URL string `json:"url"`
+	// Timeout bounds each post; it defaults to 5s.
+	Timeout Duration `json:"timeout" min:"1ms"`
+}
+
+// HelloConfig configures the greeting routes.
+type HelloConfig struct {
+	// UpstreamURL is where /proxy-hello forwards requests; it defaults to
+	// this server's own /hello route.
+	UpstreamURL string `json:"upstream_url"`
+	// Backend selects what makes the greetings, see NewGreeter:
+	// "static", the default, "time" or "remote".
+	Backend string `json:"backend"`
+	// Template is the greeting of the static backend, "{name}" being
+	// replaced with the name greeted; it defaults to "Hello, {name}".
+	Template string `json:"template"`
+	// RemoteURL is where the remote backend gets its greetings; failing
+	// that, it falls back to the static one.
+	RemoteURL string `json:"remote_url"`
+}
+
+// AuditConfig configures the audit log of state-changing requests.
+type AuditConfig struct {
+	// Path is the file audit entries are appended to as JSON lines; they
+	// go to stderr if it's empty. The "audit" logger of Config.Log.Loggers
+	// overrides it.
+	Path string `json:"path"`
+}
+
+// SessionConfig configures cookie-based sessions.
+type SessionConfig struct {
+	// Keys sign session cookies. The first key signs new cookies and all
+	// of them are accepted, so keys can be rotated.
+	Keys []string `json:"keys" secretfile:"true"`
+	// TTL is how long an unused session lives; it defaults to 24h, and
+	// may not be under 1m.
+	TTL Duration `json:"ttl" min:"1m"`
+	// CookieName defaults to "session".
+	CookieName string `json:"cookie_name"`
+}
+
+// IdempotencyConfig configures Idempotency-Key handling.
+type IdempotencyConfig struct {
+	// TTL is how long stored responses are replayed; it defaults to 24h,
+	// and may not be under 1s.
+	TTL Duration `json:"ttl" min:"1s"`
+	// MaxResponseBytes caps the size of stored responses; larger ones are
+	// not stored. It defaults to 1 MiB.
+	MaxResponseBytes int `json:"max_response_bytes"`
+}
+
+// StaticConfig configures the static file route.
+type StaticConfig struct {
+	// Dir is the directory files are served from; nothing is served if
+	// it's empty.
+	Dir string `json:"dir"`
+	// Prefix is the URL path files are served under; it defaults to
+	// "/static/".
+	Prefix string `json:"prefix"`
+	// SPA serves the index page for paths that don't match a file.
+	SPA bool `json:"spa"`
+	// Index is the index page; it defaults to "index.html".
+	Index string `json:"index"`
+}
+
+// CoalesceConfig configures the coalescing of identical concurrent
+// requests.
+type CoalesceConfig struct {
+	// MaxResponseBytes caps the size of responses shared between
+	// coalesced requests; larger ones aren't shared. It defaults to 1 MiB.
+	MaxResponseBytes int `json:"max_response_bytes"`
+}
+
+// WarmupConfig configures the warm-up run before the app reports ready.
+type WarmupConfig struct {
+	// Timeout bounds each warmer; it defaults to 30s.
+	Timeout Duration `json:"timeout"`
+	// Policy decides what a failed warm-up does: "fail" (the default)
+	// shuts the app down, "degrade" keeps it running but never ready.
+	Policy string `json:"policy"`
+}
+
+// EventsConfig configures the event bus.
+type EventsConfig struct {
+	// QueueSize bounds the events waiting to be dispatched; it defaults
+	// to 1024.
+	QueueSize int `json:"queue_size"`
+	// Workers is the number of goroutines dispatching events; it
+	// defaults to 4.
+	Workers int `json:"workers"`
+	// Policy decides what publishing to a full queue does: "drop" (the
+	// default) drops the event, "block" waits for room.
+	Policy string `json:"policy"`
+}
+
+// UploadConfig configures the upload endpoint.
+type UploadConfig struct {
+	// DailyQuotaBytes is how many bytes each client may upload per UTC
+	// day; it defaults to 100 MiB.
+	DailyQuotaBytes int64 `json:"daily_quota_bytes"`
+	// Quotas overrides the daily quota of the principals it lists.
+	Quotas map[string]int64 `json:"quotas"`
+	// MaxFileBytes caps the size of each part of an upload; zero leaves
+	// parts bounded by the quota alone.
+	MaxFileBytes int64 `json:"max_file_bytes"`
+}
+
+// DebugConfig configures the debugging aids.
+type DebugConfig struct {
+	// DumpRoutes lists the route patterns, or path.Match patterns of
+	// them, whose requests and responses are logged in full. Dumping is
+	// never done in production.
+	DumpRoutes []string `json:"dump_routes"`
+	// MaxDumpBytes caps the body bytes logged for each request and
+	// response; it defaults to 4 KiB.
+	MaxDumpBytes int `json:"max_dump_bytes"`
+	// RecordRoutes lists the route patterns, or path.Match patterns of
+	// them, whose requests are recorded for replay, see ReplayRecorder.
+	// Recording is never done in production.
+	RecordRoutes []string `json:"record_routes"`
+	// RecordDir is the directory recordings are written to; it defaults
+	// to uberfx-recordings in the temporary directory.
+	RecordDir string `json:"record_dir"`
+	// MaxRecordBytes is the largest request body recorded; it defaults
+	// to 1 MiB. MaxRecordings is how many recordings are kept; it
+	// defaults to 100.
+	MaxRecordBytes int64 `json:"max_record_bytes"`
+	MaxRecordings  int   `json:"max_recordings"`
+	// RecordSecrets keeps credential headers in recordings; by default
+	// they're redacted.
+	RecordSecrets bool `json:"record_secrets"`
+	// CaptureEntries is how many requests the capture ring of a route
+	// keeps, see Captures; it defaults to 50. CaptureBodyBytes caps the
+	// body bytes kept for each request and response; it defaults to 4
+	// KiB.
+	CaptureEntries   int `json:"capture_entries"`
+	CaptureBodyBytes int `json:"capture_body_bytes"`
+	// CaptureDuration is how long a capture lasts unless it's given its
+	// own duration; it defaults to 10m. MaxCaptureDuration caps the
+	// durations given; it defaults to 1h.
+	CaptureDuration    Duration `json:"capture_duration" min:"1s"`
+	MaxCaptureDuration Duration `json:"max_capture_duration" min:"1s"`
+}
+
+// DiscoveryConfig configures the announcement of the instance to a
+// service registry.
+type DiscoveryConfig struct {
+	// Kind selects the registry: "file", "webhook", or none (the
+	// default).
+	Kind string `json:"kind"`
+	// File is the file the instance is written to by file discovery.
+	File string `json:"file"`
+	// URL is the registry endpoint of webhook discovery.
+	URL string `json:"url"`
+	// Required fails startup if the instance can't be announced;
+	// otherwise the app starts unannounced.
+	Required bool `json:"required"`
+	// Attempts is the number of announcement attempts; it defaults to
+	// 5. BaseDelay is the backoff before the first retry, doubled on
+	// each further one up to MaxDelay; they default to 200ms and 5s.
+	Attempts  int      `json:"attempts"`
+	BaseDelay Duration `json:"base_delay"`
+	MaxDelay  Duration `json:"max_delay"`
+}
+
+// ShadowConfig configures the mirroring of live requests to a shadow
+// upstream.
+type ShadowConfig struct {
+	// URL is the base URL of the shadow upstream. Without one, nothing
+	// is mirrored.
+	URL string `json:"url"`
+	// Routes maps the patterns of the routes to mirror to the
+	// percentage of their requests that is mirrored.
+	Routes map[string]float64 `json:"routes"`
+	// MaxBodyBytes caps the request bodies mirrored; larger requests
+	// aren't mirrored. It defaults to 64 KiB.
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// QueueSize is how many mirrors may wait to be sent before further
+	// ones are dropped; it defaults to 100. Workers is how many are sent
+	// at once; it defaults to 2.
+	QueueSize int `json:"queue_size"`
+	Workers   int `json:"workers"`
+}
+
+// HeaderRule sets response headers on the routes matching Route, a
+// path.Match pattern matched against the route pattern or its path, so
+// "/static/*" matches "GET /static/". Headers fill in the response
+// headers the handler didn't set; a "!remove" value strips the header.
+type HeaderRule struct {
+	Route   string            `json:"route"`
+	Headers map[string]string `json:"headers"`
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a duration of the configuration. It's written as a string
+// in the syntax of time.ParseDuration, such as "500ms" or "2m30s", and
+// marshaled back the same way, so the config shown at /debug/config
+// reads as it's written. A bare integer is taken, for compatibility, as
+// milliseconds; it's deprecated, and reported as such when the config is
+// loaded, see LogConfigSources.
+//
+// Fields may bound their durations with min and max struct tags, such as
+// `min:"100ms"`, checked by validateDurations. Zero durations, which
+// select the defaults, aren't checked.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText parses a duration given as text, as in environment
+// variables.
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := parseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		b = []byte(s)
+	}
+	return d.UnmarshalText(b)
+}
+
+// parseDuration parses s as a duration with a unit, or a bare integer
+// of milliseconds.
+func parseDuration(s string) (Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Duration(time.Duration(ms) * time.Millisecond), nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, want a number with a unit such as 500ms or 2m30s", s)
+	}
+	return Duration(v), nil
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// walkDurations calls f with each Duration of v, a config value, its
+// JSON path and the struct field holding it, which is that of the
+// enclosing list or map for their elements.
+func walkDurations(v reflect.Value, path string, field reflect.StructField, f func(string, reflect.StructField, Duration) error) error {
+	if v.Type() == durationType {
+		return f(path, field, Duration(v.Int()))
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return walkDurations(v.Elem(), path, field, f)
+		}
+	case reflect.Struct:
+		for i := range v.NumField() {
+			sf := v.Type().Field(i)
+			name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+			if !sf.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			if err := walkDurations(v.Field(i), joinConfigPath(path, name), sf, f); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := range v.Len() {
+			if err := walkDurations(v.Index(i), fmt.Sprintf("%s[%d]", path, i), field, f); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := walkDurations(iter.Value(), fmt.Sprintf("%s[%v]", path, iter.Key()), field, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func joinConfigPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// validateDurations checks the durations of cfg against the bounds set
+// by the min and max tags of their fields.
+func validateDurations(cfg *Config) error {
+	return walkDurations(reflect.ValueOf(cfg).Elem(), "", reflect.StructField{}, func(path string, field reflect.StructField, d Duration) error {
+		if d == 0 {
+			return nil
+		}
+		if tag, ok := field.Tag.Lookup("min"); ok {
+			limit, err := time.ParseDuration(tag)
+			if err != nil {
+				return fmt.Errorf("config %s: invalid min %q: %w", path, tag, err)
+			}
+			if time.Duration(d) < limit {
+				return fmt.Errorf("config %s: %s is under the minimum of %s", path, d, limit)
+			}
+		}
+		if tag, ok := field.Tag.Lookup("max"); ok {
+			limit, err := time.ParseDuration(tag)
+			if err != nil {
+				return fmt.Errorf("config %s: invalid max %q: %w", path, tag, err)
+			}
+			if time.Duration(d) > limit {
+				return fmt.Errorf("config %s: %s is over the maximum of %s", path, d, limit)
+			}
+		}
+		return nil
+	})
+}
+
+// bareDurations returns the JSON paths of the durations given as bare
+// integers in the JSON config b, which unmarshals into a value of type
+// t.
+func bareDurations(b []byte, t reflect.Type) ([]string, error) {
+	var raw any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	var paths []string
+	var walk func(raw any, t reflect.Type, path string)
+	walk = func(raw any, t reflect.Type, path string) {
+		if t == durationType {
+			if n, ok := raw.(json.Number); ok && n.String() != "0" {
+				paths = append(paths, path)
+			}
+			return
+		}
+		switch t.Kind() {
+		case reflect.Pointer:
+			walk(raw, t.Elem(), path)
+		case reflect.Struct:
+			obj, _ := raw.(map[string]any)
+			for i := range t.NumField() {
+				sf := t.Field(i)
+				name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+				if v, ok := obj[name]; ok && sf.IsExported() {
+					walk(v, sf.Type, joinConfigPath(path, name))
+				}
+			}
+		case reflect.Slice:
+			list, _ := raw.([]any)
+			for i, v := range list {
+				walk(v, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+			}
+		case reflect.Map:
+			obj, _ := raw.(map[string]any)
+			for k, v := range obj {
+				walk(v, t.Elem(), fmt.Sprintf("%s[%s]", path, k))
+			}
+		}
+	}
+	walk(raw, t, "")
+	return paths, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDurationSyntax(t *testing.T) {
+	for _, tc := range []struct {
+		json string
+		want time.Duration
+		err  bool
+	}{
+		{`"500ms"`, 500 * time.Millisecond, false},
+		{`"2m30s"`, 150 * time.Second, false},
+		{`" 1h "`, time.Hour, false},
+		{`""`, 0, false},
+		{`null`, 0, false},
+		{`250`, 250 * time.Millisecond, false},
+		{`"250"`, 250 * time.Millisecond, false},
+		{`"soon"`, 0, true},
+		{`"5 minutes"`, 0, true},
+		{`1.5`, 0, true},
+	} {
+		var d Duration
+		err := json.Unmarshal([]byte(tc.json), &d)
+		if (err != nil) != tc.err || time.Duration(d) != tc.want {
+			t.Errorf("%s: got %s, %v; want %s, error %t", tc.json, time.Duration(d), err, tc.want, tc.err)
+		}
+	}
+
+	var d Duration
+	if err := d.UnmarshalText([]byte("90s")); err != nil || time.Duration(d) != 90*time.Second {
+		t.Errorf("as text: got %s, %v; want 1m30s", time.Duration(d), err)
+	}
+}
+
+func TestDurationRoundTrip(t *testing.T) {
+	in := ServerConfig{HandlerTimeout: Duration(2*time.Minute + 30*time.Second)}
+	b, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"handler_timeout":"2m30s"`)) {
+		t.Errorf("marshaled %s, want the handler timeout as \"2m30s\"", b)
+	}
+	var out ServerConfig
+	if err := json.Unmarshal(b, &out); err != nil || out.HandlerTimeout != in.HandlerTimeout {
+		t.Errorf("round trip: got %s, %v; want %s", out.HandlerTimeout, err, in.HandlerTimeout)
+	}
+}
+
+// loadTestConfig loads the config with the JSON file content as its
+// base layer.
+func loadTestConfig(t *testing.T, content string) (*Config, error) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", file)
+	return NewConfig("")
+}
+
+func TestDurationBounds(t *testing.T) {
+	for _, tc := range []struct {
+		content string
+		err     string
+	}{
+		{`{"server": {"handler_timeout": "500us"}}`, "config server.handler_timeout: 500µs is under the minimum of 1ms"},
+		{`{"server": {"handler_timeout": "1ms"}}`, ""},
+		// Zero selects the default, and isn't checked.
+		{`{"server": {"handler_timeout": "0s"}}`, ""},
+	} {
+		_, err := loadTestConfig(t, tc.content)
+		if got := errString(err); got != tc.err {
+			t.Errorf("%s: got error %q, want %q", tc.content, got, tc.err)
+		}
+	}
+}
+
+func TestBareDurations(t *testing.T) {
+	cfg, err := loadTestConfig(t, `{"server": {"handler_timeout": 2500, "min_request_timeout": "20ms"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := time.Duration(cfg.Server.HandlerTimeout); got != 2500*time.Millisecond {
+		t.Errorf("handler timeout = %s, want 2.5s", got)
+	}
+
+	var buf bytes.Buffer
+	LogConfigSources(cfg, slog.New(slog.NewTextHandler(&buf, nil)))
+	var warning string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(line, "bare milliseconds") {
+			warning = line
+		}
+	}
+	if !strings.Contains(warning, "server.handler_timeout") || strings.Contains(warning, "min_request_timeout") {
+		t.Errorf("got deprecation warning %q, want one naming server.handler_timeout alone", warning)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.uber.org/fx"
+)
+
+// ConfigEnv is the environment an app is run in, as selected with
+// WithEnv, from the --env flag. It takes precedence over the env set in
+// the config files; empty, it leaves it be.
+type ConfigEnv string
+
+// envOption is the fx.Option of WithEnv, which NewApp also reads before
+// building the app, for the options taken from the config.
+type envOption struct {
+	fx.Option
+	env ConfigEnv
+}
+
+// WithEnv runs the app in the given environment, see ConfigEnv.
+func WithEnv(env string) fx.Option {
+	return envOption{Option: fx.Supply(ConfigEnv(env)), env: ConfigEnv(env)}
+}
+
+// configLayer is one source of configuration values.
+type configLayer struct {
+	// name identifies the layer in the logs: "defaults" or a file path.
+	name string
+	cfg  *Config
+}
+
+// configLoad records how a Config was put together, to be logged once
+// the logger is available.
+type configLoad struct {
+	// sources lists, by top-level section, the layers that set values
+	// in that section, lowest precedence first.
+	sources map[string][]string
+	// missing is the overlay file that was looked for but not found.
+	missing string
+	// bare lists, by layer, the durations given as bare integers, see
+	// Duration.
+	bare map[string][]string
+}
+
+// parseConfigLayer parses the JSON config b of the named layer,
+// recording in load the durations it gives as bare integers.
+func parseConfigLayer(name string, b []byte, load *configLoad) (*Config, error) {
+	cfg := &Config{}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, err
+	}
+	bare, err := bareDurations(b, reflect.TypeOf(cfg).Elem())
+	if err != nil {
+		return nil, err
+	}
+	if len(bare) > 0 {
+		sort.Strings(bare)
+		if load.bare == nil {
+			load.bare = make(map[string][]string)
+		}
+		load.bare[name] = bare
+	}
+	return cfg, nil
+}
+
+// loadConfigLayers reads the configuration layers in order of
+// precedence: the embedded defaults, the base file named by CONFIG_FILE
+// and the overlay of the selected environment. The overlay is the file
+// named after the environment next to the base file, so with
+// CONFIG_FILE=config/base.json in production it's
+// config/production.json. A missing overlay is an error in production
+// and only reported otherwise. The environment is env, or else the one
+// set in the config.
+func loadConfigLayers(env ConfigEnv) ([]configLayer, *configLoad, error) {
+	b, err := embeddedFile("defaults.json")
+	if err != nil {
+		return nil, nil, fmt.Errorf("read default config: %w", err)
+	}
+	load := &configLoad{}
+	defaults, err := parseConfigLayer("defaults", b, load)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse default config: %w", err)
+	}
+	layers := []configLayer{{name: "defaults", cfg: defaults}}
+
+	base := os.Getenv("CONFIG_FILE")
+	if base == "" {
+		return layers, load, nil
+	}
+	cfg, err := readConfigFile(base, load)
+	if err != nil {
+		return nil, nil, err
+	}
+	layers = append(layers, configLayer{name: base, cfg: cfg})
+
+	selected := cmp.Or(string(env), cfg.Env, defaults.Env)
+	overlay := filepath.Join(filepath.Dir(base), selected+".json")
+	if filepath.Clean(base) == overlay {
+		return layers, load, nil
+	}
+	cfg, err = readConfigFile(overlay, load)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && selected != "production":
+		load.missing = overlay
+	case err != nil:
+		return nil, nil, err
+	default:
+		layers = append(layers, configLayer{name: overlay, cfg: cfg})
+	}
+	return layers, load, nil
+}
+
+func readConfigFile(path string, load *configLoad) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	cfg, err := parseConfigLayer(path, b, load)
+	if err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// mergeConfigs merges the layers into a single Config, later layers
+// winning, and records in load which layers each section came from.
+func mergeConfigs(layers []configLayer, load *configLoad) *Config {
+	cfg := &Config{}
+	dst := reflect.ValueOf(cfg).Elem()
+	load.sources = make(map[string][]string)
+	for _, l := range layers {
+		src := reflect.ValueOf(l.cfg).Elem()
+		for i := range src.NumField() {
+			field := src.Type().Field(i)
+			if !field.IsExported() || src.Field(i).IsZero() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			load.sources[name] = append(load.sources[name], l.name)
+		}
+		mergeValue(dst, src)
+	}
+	return cfg
+}
+
+// mergeValue merges src into dst. Structs are merged field by field and
+// maps key by key, while slices are replaced whole. Zero scalars are
+// taken as unset and don't override, so a layer can't reset a value set
+// by a lower one to zero.
+func mergeValue(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Struct:
+		for i := range src.NumField() {
+			if src.Type().Field(i).IsExported() {
+				mergeValue(dst.Field(i), src.Field(i))
+			}
+		}
+	case reflect.Map:
+		if src.Len() == 0 {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(src.Type(), src.Len()))
+		}
+		iter := src.MapRange()
+		for iter.Next() {
+			// Map elements aren't addressable, so they're merged into a
+			// copy that is stored back.
+			v := reflect.New(src.Type().Elem()).Elem()
+			if cur := dst.MapIndex(iter.Key()); cur.IsValid() {
+				v.Set(cur)
+			}
+			mergeValue(v, iter.Value())
+			dst.SetMapIndex(iter.Key(), v)
+		}
+	case reflect.Slice:
+		if !src.IsNil() {
+			dst.Set(src)
+		}
+	default:
+		if !src.IsZero() {
+			dst.Set(src)
+		}
+	}
+}
+
+// LogConfigSources logs which files the configuration was loaded from.
+func LogConfigSources(cfg *Config, log *slog.Logger) {
+	if cfg.load == nil {
+		return
+	}
+	if cfg.load.missing != "" {
+		log.Warn("No config overlay for the environment", slog.String("env", cfg.Env), slog.String("path", cfg.load.missing))
+	}
+	for name, paths := range cfg.load.bare {
+		log.Warn("Config durations given as bare milliseconds, which is deprecated; give them with a unit, as in \"500ms\"",
+			slog.String("source", name), slog.Any("fields", paths))
+	}
+	sections := make([]string, 0, len(cfg.load.sources))
+	for name := range cfg.load.sources {
+		sections = append(sections, name)
+	}
+	sort.Strings(sections)
+	attrs := make([]any, 0, len(sections))
+	for _, name := range sections {
+		attrs = append(attrs, slog.String(name, strings.Join(cfg.load.sources[name], ", ")))
+	}
+	log.Info("Loaded config", slog.String("env", cfg.Env), slog.Group("sources", attrs...))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+
+	"example.com/uberfx/httpjson"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ConnStats is a snapshot of the server's connections by state.
+type ConnStats struct {
+	New      int    `json:"new"`
+	Active   int    `json:"active"`
+	Idle     int    `json:"idle"`
+	Hijacked uint64 `json:"hijacked_total"`
+	// Peak is the most connections open at once.
+	Peak int `json:"peak"`
+}
+
+// ConnTracker keeps track of the state of the server's connections
+// through http.Server.ConnState, to spot keep-alive leaks. Hijacked
+// connections, such as WebSocket upgrades, are no longer tracked by the
+// server once handed over, so they're only counted as they happen. A
+// warning is logged whenever the number of active connections rises
+// above Config.Server.ActiveConnsWarning.
+type ConnTracker struct {
+	warnAt int
+	log    *slog.Logger
+
+	mu     sync.Mutex
+	states map[net.Conn]http.ConnState
+	stats  ConnStats
+	warned bool
+}
+
+// NewConnTracker builds a new ConnTracker.
+func NewConnTracker(cfg *Config, log *slog.Logger, reg *prometheus.Registry) *ConnTracker {
+	t := &ConnTracker{
+		warnAt: cfg.Server.ActiveConnsWarning,
+		log:    log,
+		states: make(map[net.Conn]http.ConnState),
+	}
+	for _, state := range []http.ConnState{http.StateNew, http.StateActive, http.StateIdle} {
+		reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "http_server_connections",
+			Help:        "Connections of the HTTP server, by state.",
+			ConstLabels: prometheus.Labels{"state": state.String()},
+		}, func() float64 {
+			return float64(t.count(state))
+		}))
+	}
+	reg.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "http_server_hijacked_connections_total",
+		Help: "Connections of the HTTP server hijacked by handlers.",
+	}, func() float64 {
+		return float64(t.Stats().Hijacked)
+	}))
+	return t
+}
+
+// ConnState is the http.Server.ConnState hook.
+func (t *ConnTracker) ConnState(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if prev, ok := t.states[c]; ok {
+		t.add(prev, -1)
+	}
+	switch state {
+	case http.StateClosed:
+		delete(t.states, c)
+	case http.StateHijacked:
+		delete(t.states, c)
+		t.stats.Hijacked++
+	default:
+		t.states[c] = state
+		t.add(state, 1)
+		t.stats.Peak = max(t.stats.Peak, len(t.states))
+	}
+
+	switch {
+	case t.warnAt > 0 && t.stats.Active > t.warnAt && !t.warned:
+		t.warned = true
+		t.log.Warn("Active connections above threshold", slog.Int("active", t.stats.Active), slog.Int("threshold", t.warnAt))
+	case t.stats.Active <= t.warnAt:
+		t.warned = false
+	}
+}
+
+func (t *ConnTracker) add(state http.ConnState, n int) {
+	switch state {
+	case http.StateNew:
+		t.stats.New += n
+	case http.StateActive:
+		t.stats.Active += n
+	case http.StateIdle:
+		t.stats.Idle += n
+	}
+}
+
+func (t *ConnTracker) count(state http.ConnState) int {
+	s := t.Stats()
+	switch state {
+	case http.StateNew:
+		return s.New
+	case http.StateActive:
+		return s.Active
+	case http.StateIdle:
+		return s.Idle
+	}
+	return 0
+}
+
+// Stats returns the current connection counts.
+func (t *ConnTracker) Stats() ConnStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+// DebugStatsHandler is an HTTP handler that reports runtime statistics
+// of the server as JSON.
+type DebugStatsHandler struct {
+	conns *ConnTracker
+}
+
+// NewDebugStatsHandler builds a new DebugStatsHandler.
+func NewDebugStatsHandler(conns *ConnTracker) *DebugStatsHandler {
+	return &DebugStatsHandler{conns: conns}
+}
+
+func (*DebugStatsHandler) Pattern() string {
+	return "GET /debug/stats"
+}
+
+func (h *DebugStatsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	_ = httpjson.Respond(w, r, http.StatusOK, map[string]any{
+		"connections": h.conns.Stats(),
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"example.com/uberfx/httpjson"
+)
+
+// ContentTypedRoute is implemented by routes that only accept request
+// bodies of some media types, such as "application/json". A subtype of
+// "*" matches any subtype, and one of "*+json" any with that suffix, as
+// in "application/*+json".
+type ContentTypedRoute interface {
+	AcceptedContentTypes() []string
+}
+
+// ContentTypes is route middleware that answers requests with a body of
+// a media type their route doesn't accept with a 415, listing the types
+// it does, rather than leave the handler to fail parsing it. The
+// accepted types are taken from Config.Server.ContentTypes.Accepted by
+// pattern, or else the route's ContentTypedRoute method; other routes
+// accept anything. Requests with a body but no Content-Type are let
+// through, unless Config.Server.ContentTypes.Strict is set.
+type ContentTypes struct {
+	accepted map[string][]string
+	strict   bool
+	catalog  *Catalog
+}
+
+// NewContentTypes builds a new ContentTypes.
+func NewContentTypes(cfg *Config, catalog *Catalog) *ContentTypes {
+	return &ContentTypes{
+		accepted: cfg.Server.ContentTypes.Accepted,
+		strict:   cfg.Server.ContentTypes.Strict,
+		catalog:  catalog,
+	}
+}
+
+func (*ContentTypes) Order() int {
+	return orderContentType
+}
+
+func (m *ContentTypes) WrapRoute(route Route, next http.Handler) http.Handler {
+	accepted, ok := m.accepted[route.Pattern()]
+	if ctr, typed := route.(ContentTypedRoute); !ok && typed {
+		accepted = ctr.AcceptedContentTypes()
+	}
+	if len(accepted) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength == 0 && len(r.TransferEncoding) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ct := r.Header.Get("Content-Type")
+		if ct == "" && !m.strict {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if mt, _, err := mime.ParseMediaType(ct); err == nil && acceptsMediaType(accepted, mt) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		m.reject(w, r, accepted)
+	})
+}
+
+// acceptsMediaType reports whether mt, a lowercase media type without
+// parameters, matches one of the accepted patterns.
+func acceptsMediaType(accepted []string, mt string) bool {
+	typ, sub, ok := strings.Cut(mt, "/")
+	if !ok {
+		return false
+	}
+	for _, a := range accepted {
+		atyp, asub, _ := strings.Cut(strings.ToLower(a), "/")
+		if atyp != "*" && atyp != typ {
+			continue
+		}
+		switch {
+		case asub == "*", asub == sub:
+			return true
+		case strings.HasPrefix(asub, "*+") && strings.HasSuffix(sub, asub[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// contentTypeProblem is the problem+json body of requests rejected for
+// their media type.
+type contentTypeProblem struct {
+	Problem
+	Accepted []string `json:"accepted"`
+}
+
+func (m *ContentTypes) reject(w http.ResponseWriter, r *http.Request, accepted []string) {
+	locale := m.catalog.Negotiate(r.Header.Get("Accept-Language"))
+	title, ok := m.catalog.Message(locale, "title.415", nil)
+	if !ok {
+		title = http.StatusText(http.StatusUnsupportedMediaType)
+	}
+	list := strings.Join(accepted, ", ")
+	detail, _ := m.catalog.Message(locale, "detail.unsupported_media_type", map[string]string{"accepted": list})
+	h := w.Header()
+	h.Set("Content-Language", locale)
+	h.Add("Vary", "Accept-Language")
+	if r.Method == http.MethodPost {
+		h.Set("Accept-Post", list)
+	}
+	h.Set("Content-Type", "application/problem+json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	_ = httpjson.RespondError(w, r, http.StatusUnsupportedMediaType, contentTypeProblem{
+		Problem: Problem{
+			Title:    title,
+			Status:   http.StatusUnsupportedMediaType,
+			Detail:   detail,
+			Instance: r.URL.Path,
+		},
+		Accepted: accepted,
+	})
+}
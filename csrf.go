@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"mime"
+	"net/http"
+
+	"example.com/uberfx/httpjson"
+	"example.com/uberfx/reqctx"
+)
+
+const (
+	csrfSessionKey = "csrf_token"
+	csrfHeader     = "X-CSRF-Token"
+	csrfFormField  = "csrf_token"
+)
+
+// BrowserFacing is implemented by routes that are used from browsers
+// with cookie-based sessions and so need CSRF protection.
+type BrowserFacing interface {
+	BrowserFacing() bool
+}
+
+var (
+	errCSRFMissing = errors.New("missing CSRF token")
+	errCSRFInvalid = errors.New("invalid CSRF token")
+)
+
+// CSRFTokens mints and checks the per-session synchronizer tokens.
+type CSRFTokens struct {
+	sessions *SessionManager
+}
+
+// NewCSRFTokens builds a new CSRFTokens.
+func NewCSRFTokens(sessions *SessionManager) *CSRFTokens {
+	return &CSRFTokens{sessions: sessions}
+}
+
+// Token returns the CSRF token of the current session, minting one if
+// the session has none yet.
+func (t *CSRFTokens) Token(r *http.Request) (string, error) {
+	if v, ok := t.sessions.Get(r.Context(), csrfSessionKey); ok {
+		if token, ok := v.(string); ok && token != "" {
+			return token, nil
+		}
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	t.sessions.Put(r.Context(), csrfSessionKey, token)
+	return token, nil
+}
+
+// check verifies the token submitted with r against the session's.
+func (t *CSRFTokens) check(r *http.Request) error {
+	got := r.Header.Get(csrfHeader)
+	if got == "" {
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/x-www-form-urlencoded" || ct == "multipart/form-data" {
+			got = r.PostFormValue(csrfFormField)
+		}
+	}
+	if got == "" {
+		return errCSRFMissing
+	}
+	v, _ := t.sessions.Get(r.Context(), csrfSessionKey)
+	want, _ := v.(string)
+	if want == "" || subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		return errCSRFInvalid
+	}
+	return nil
+}
+
+// CSRFMiddleware requires a valid CSRF token, in the X-CSRF-Token header
+// or the csrf_token form field, on unsafe requests to browser-facing
+// routes. Requests authenticated with a bearer token or an API key don't
+// carry ambient credentials and are exempt: those BearerAuth or
+// APIKeyAuth set the principal of, not any with an Authorization header.
+type CSRFMiddleware struct {
+	tokens *CSRFTokens
+	errs   *ErrorWriter
+}
+
+// NewCSRFMiddleware builds a new CSRFMiddleware.
+func NewCSRFMiddleware(tokens *CSRFTokens, errs *ErrorWriter) *CSRFMiddleware {
+	return &CSRFMiddleware{tokens: tokens, errs: errs}
+}
+
+func (m *CSRFMiddleware) WrapRoute(route Route, next http.Handler) http.Handler {
+	if bf, ok := route.(BrowserFacing); !ok || !bf.BrowserFacing() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if reqctx.Principal(r.Context()) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := m.tokens.check(r); err != nil {
+			m.errs.Write(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CSRFHandler is an HTTP handler that returns the CSRF token of the
+// caller's session.
+type CSRFHandler struct {
+	tokens *CSRFTokens
+	errs   *ErrorWriter
+}
+
+// NewCSRFHandler builds a new CSRFHandler.
+func NewCSRFHandler(tokens *CSRFTokens, errs *ErrorWriter) *CSRFHandler {
+	return &CSRFHandler{tokens: tokens, errs: errs}
+}
+
+func (*CSRFHandler) Pattern() string {
+	return "GET /csrf"
+}
+
+func (h *CSRFHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, err := h.tokens.Token(r)
+	if err != nil {
+		h.errs.Write(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	_ = httpjson.Respond(w, r, http.StatusOK, map[string]string{"token": token})
+}
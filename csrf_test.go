@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"testing"
+
+	"go.uber.org/fx"
+)
+
+// browserRoute is a browser-facing route.
+type browserRoute struct{ *funcRoute }
+
+func (browserRoute) BrowserFacing() bool { return true }
+
+func TestCSRF(t *testing.T) {
+	base := startTestApp(t, func(cfg *Config) {
+		withTokens(cfg)
+		cfg.Auth.APIKeys = []APIKeyConfig{{Key: "ops-key", Name: "ops"}}
+	}, fx.Provide(AsRoute(func() Route {
+		return browserRoute{newFuncRoute("/form", http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "posted")
+		})}
+	})))
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Jar: jar}
+	post := func(header map[string]string) (int, string) {
+		req, err := http.NewRequest(http.MethodPost, base+"/form", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return resp.StatusCode, ""
+		}
+		var p Problem
+		_ = json.NewDecoder(resp.Body).Decode(&p)
+		return resp.StatusCode, p.Detail
+	}
+
+	resp, err := client.Get(base + "/csrf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if err != nil || body.Token == "" {
+		t.Fatalf("get token: %v %+v", err, body)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		header map[string]string
+		status int
+		detail string
+	}{
+		{"no token", nil, http.StatusForbidden, "missing CSRF token"},
+		{"forged token", map[string]string{csrfHeader: "forged"}, http.StatusForbidden, "invalid CSRF token"},
+		{"session token", map[string]string{csrfHeader: body.Token}, http.StatusOK, ""},
+		// Neither authenticates the request, which goes on anonymously.
+		{"bare bearer scheme", map[string]string{"Authorization": "Bearer"}, http.StatusForbidden, "missing CSRF token"},
+		{"other scheme", map[string]string{"Authorization": "Basic Ym9iOnB3"}, http.StatusForbidden, "missing CSRF token"},
+		{"bearer token", map[string]string{"Authorization": "Bearer user-token"}, http.StatusOK, ""},
+		{"API key", map[string]string{"X-API-Key": "ops-key"}, http.StatusOK, ""},
+	} {
+		if status, detail := post(tc.header); status != tc.status || detail != tc.detail {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, status, detail, tc.status, tc.detail)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Package ctxslog enriches log records with the request-scoped values
+// of the context they're logged with, see package reqctx, so that the
+// records handlers log carry the request ID, trace ID and principal
+// without each call site adding them.
+package ctxslog
+
+import (
+	"context"
+	"log/slog"
+
+	"example.com/uberfx/reqctx"
+)
+
+// Handler is a slog.Handler adding the values of reqctx.Snapshot to the
+// records logged with a context carrying them, as with InfoContext, and
+// passing them to the next handler. Records logged without a context
+// carry no values, so they're passed as they are, as are the values of
+// a key the record already has. The values are added in the group the
+// logger is in, if any.
+type Handler struct {
+	next slog.Handler
+}
+
+// New returns a Handler passing records to next.
+func New(next slog.Handler) *Handler {
+	return &Handler{next: next}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx == nil {
+		return h.next.Handle(ctx, r)
+	}
+	attrs := reqctx.Snapshot(ctx).Attrs()
+	if len(attrs) == 0 {
+		return h.next.Handle(ctx, r)
+	}
+	r = r.Clone()
+	r.Attrs(func(a slog.Attr) bool {
+		for i := 0; i < len(attrs); i++ {
+			if attrs[i].Key == a.Key {
+				attrs = append(attrs[:i], attrs[i+1:]...)
+				i--
+			}
+		}
+		return len(attrs) > 0
+	})
+	r.AddAttrs(attrs...)
+	return h.next.Handle(ctx, r)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{next: h.next.WithAttrs(attrs)}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name)}
+}
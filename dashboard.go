@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// dashboardRefresh is how often the dashboard page reloads itself.
+const dashboardRefresh = 10 * time.Second
+
+// dashboardTopRoutes and dashboardErrors bound the routes and errors
+// the dashboard lists.
+const (
+	dashboardTopRoutes = 10
+	dashboardErrors    = 10
+)
+
+// DashboardHandler serves at GET /admin/dashboard an HTML page showing
+// the state of the app at a glance: its readiness, the results of its
+// health checks, its build, uptime and log level, the routes serving
+// the most requests and the last errors rendered. The figures are those
+// of the components keeping them, the page only lays them out; it
+// reloads itself every 10s. Only principals with the "admin" role may
+// see it, unless Config.Auth.RouteRoles says otherwise.
+type DashboardHandler struct {
+	name        string
+	readiness   *Readiness
+	maintenance *Maintenance
+	prober      *HealthProber
+	build       *BuildInfo
+	stats       *LifetimeStats
+	errors      *ErrorWriter
+	log         *slog.Logger
+	tmpl        *template.Template
+}
+
+// NewDashboardHandler builds a new DashboardHandler.
+func NewDashboardHandler(
+	cfg *Config,
+	readiness *Readiness,
+	maintenance *Maintenance,
+	prober *HealthProber,
+	build *BuildInfo,
+	stats *LifetimeStats,
+	errors *ErrorWriter,
+	log *slog.Logger,
+) (*DashboardHandler, error) {
+	tmpl, err := template.ParseFS(assets, "assets/templates/dashboard.html")
+	if err != nil {
+		return nil, err
+	}
+	name := cfg.App.Name
+	if name == "" {
+		name = "uberfx"
+	}
+	return &DashboardHandler{
+		name:        name,
+		readiness:   readiness,
+		maintenance: maintenance,
+		prober:      prober,
+		build:       build,
+		stats:       stats,
+		errors:      errors,
+		log:         log,
+		tmpl:        tmpl,
+	}, nil
+}
+
+func (*DashboardHandler) Pattern() string {
+	return "GET /admin/dashboard"
+}
+
+func (*DashboardHandler) RequiredRoles() []string {
+	return []string{"admin"}
+}
+
+func (*DashboardHandler) BrowserFacing() bool {
+	return true
+}
+
+// dashboardData is what the dashboard template renders.
+type dashboardData struct {
+	Name      string
+	Refresh   int
+	Readiness string
+	Healthy   bool
+	Checks    []HealthResult
+	Build     *BuildInfo
+	Uptime    time.Duration
+	LogLevel  slog.Level
+	Routes    []dashboardRoute
+	Errors    []RecordedError
+}
+
+// dashboardRoute is a route and the requests it served.
+type dashboardRoute struct {
+	Route    string
+	Requests int64
+}
+
+func (h *DashboardHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	summary, err := h.stats.Summary()
+	if err != nil {
+		h.errors.Write(w, r, err)
+		return
+	}
+	checks, healthy := h.prober.Results()
+	data := dashboardData{
+		Name:      h.name,
+		Refresh:   int(dashboardRefresh / time.Second),
+		Readiness: "ready",
+		Healthy:   healthy,
+		Checks:    checks,
+		Build:     h.build,
+		Uptime:    summary.Uptime.Round(time.Second),
+		LogLevel:  logLevel(r.Context(), h.log),
+		Routes:    topRoutes(summary.Requests, dashboardTopRoutes),
+		Errors:    h.errors.Recent(),
+	}
+	switch {
+	case !h.readiness.Ready():
+		data.Readiness = "not ready"
+	case !h.maintenance.Ready():
+		data.Readiness = "maintenance"
+	}
+	if len(data.Errors) > dashboardErrors {
+		data.Errors = data.Errors[:dashboardErrors]
+	}
+
+	// Rendered in full first so that a template error is reported as
+	// such rather than as a page cut short.
+	var buf bytes.Buffer
+	if err := h.tmpl.Execute(&buf, data); err != nil {
+		h.errors.Write(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	_, _ = buf.WriteTo(w)
+}
+
+// topRoutes returns the k routes serving the most requests, most first.
+func topRoutes(requests map[string]int64, k int) []dashboardRoute {
+	routes := make([]dashboardRoute, 0, len(requests))
+	for route, n := range requests {
+		routes = append(routes, dashboardRoute{Route: route, Requests: n})
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Requests != routes[j].Requests {
+			return routes[i].Requests > routes[j].Requests
+		}
+		return routes[i].Route < routes[j].Route
+	})
+	if len(routes) > k {
+		routes = routes[:k]
+	}
+	return routes
+}
+
+// logLevel returns the lowest level log records are written at.
+func logLevel(ctx context.Context, log *slog.Logger) slog.Level {
+	for _, l := range []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn} {
+		if log.Enabled(ctx, l) {
+			return l
+		}
+	}
+	return slog.LevelError
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/uberfx/clock"
+	"example.com/uberfx/reqctx"
+)
+
+const requestTimeoutHeader = "X-Request-Timeout"
+
+// RequestDeadline is middleware that bounds each request with a
+// deadline: the server's default handler timeout, tightened by the
+// budget a caller passes in the X-Request-Timeout header. The header
+// holds either a Go duration or an integer number of milliseconds, and
+// is clamped to the configured bounds. The effective deadline is echoed
+// in the X-Request-Deadline response header.
+type RequestDeadline struct {
+	def   time.Duration
+	min   time.Duration
+	max   time.Duration
+	log   *slog.Logger
+	clock clock.Clock
+}
+
+// NewRequestDeadline builds a new RequestDeadline.
+func NewRequestDeadline(cfg *Config, clk clock.Clock, log *slog.Logger) *RequestDeadline {
+	d := &RequestDeadline{
+		def:   time.Duration(cfg.Server.HandlerTimeout),
+		min:   time.Duration(cfg.Server.MinRequestTimeout),
+		max:   time.Duration(cfg.Server.MaxRequestTimeout),
+		log:   log,
+		clock: clk,
+	}
+	if d.def <= 0 {
+		d.def = 30 * time.Second
+	}
+	if d.min <= 0 {
+		d.min = 10 * time.Millisecond
+	}
+	if d.max <= 0 || d.max > d.def {
+		d.max = d.def
+	}
+	return d
+}
+
+func (*RequestDeadline) Order() int {
+	return orderDeadline
+}
+
+func (d *RequestDeadline) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := d.def
+		if v := r.Header.Get(requestTimeoutHeader); v != "" {
+			if t, ok := parseRequestTimeout(v); ok {
+				timeout = min(max(t, d.min), d.max)
+			} else {
+				d.log.Debug("Ignoring invalid request timeout", slog.String("value", v), slog.String("path", r.URL.Path))
+			}
+		}
+
+		deadline := d.clock.Now().Add(timeout)
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+		if dl, ok := ctx.Deadline(); ok {
+			deadline = dl
+		}
+
+		log := reqctx.Logger(ctx, d.log).With(slog.Time("deadline", deadline))
+		w.Header().Set("X-Request-Deadline", deadline.UTC().Format(time.RFC3339Nano))
+		next.ServeHTTP(w, r.WithContext(reqctx.WithLogger(ctx, log)))
+	})
+}
+
+// parseRequestTimeout parses a request timeout given as a duration or as
+// an integer number of milliseconds.
+func parseRequestTimeout(v string) (time.Duration, bool) {
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if ms <= 0 {
+			return 0, false
+		}
+		return time.Duration(ms) * time.Millisecond, true
+	}
+	t, err := time.ParseDuration(v)
+	if err != nil || t <= 0 {
+		return 0, false
+	}
+	return t, true
+}
+
+// deadlineTransport is an http.RoundTripper that passes the remaining
+// budget of the request context on to upstreams in X-Request-Timeout.
+type deadlineTransport struct {
+	next  http.RoundTripper
+	clock clock.Clock
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if deadline, ok := req.Context().Deadline(); ok && req.Header.Get(requestTimeoutHeader) == "" {
+		remaining := deadline.Sub(t.clock.Now()).Milliseconds()
+		if remaining > 0 {
+			req = req.Clone(req.Context())
+			req.Header.Set(requestTimeoutHeader, strconv.FormatInt(remaining, 10))
+		}
+	}
+	return t.next.RoundTrip(req)
+}
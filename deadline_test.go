@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+)
+
+func TestRequestDeadline(t *testing.T) {
+	// Context deadlines are kept in real time, so the clock starts now.
+	start := time.Now().Truncate(time.Millisecond)
+	clk := testsupport.NewFakeClock(start)
+	cfg := &Config{}
+	cfg.Server.HandlerTimeout = Duration(30 * time.Second)
+	d := NewRequestDeadline(cfg, clk, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var forwarded string
+	upstream := &deadlineTransport{
+		next: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			forwarded = req.Header.Get(requestTimeoutHeader)
+			return respond(req, http.StatusOK), nil
+		}),
+		clock: clk,
+	}
+	h := d.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clk.Advance(500 * time.Millisecond)
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, "http://upstream/hello", nil)
+		resp, err := upstream.RoundTrip(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+	}))
+
+	for _, tc := range []struct {
+		name, timeout     string
+		deadline, forward time.Duration
+	}{
+		{"default", "", 30 * time.Second, 29500 * time.Millisecond},
+		{"requested", "2000", 2 * time.Second, 1500 * time.Millisecond},
+		{"clamped", "5m", 30 * time.Second, 29500 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			now := clk.Now()
+			req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+			if tc.timeout != "" {
+				req.Header.Set(requestTimeoutHeader, tc.timeout)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			want := now.Add(tc.deadline).UTC().Format(time.RFC3339Nano)
+			if got := rec.Header().Get("X-Request-Deadline"); got != want {
+				t.Errorf("X-Request-Deadline: got %s, want %s", got, want)
+			}
+			if want := tc.forward.Milliseconds(); forwarded != strconv.FormatInt(want, 10) {
+				t.Errorf("%s: got %s, want %d", requestTimeoutHeader, forwarded, want)
+			}
+		})
+	}
+}
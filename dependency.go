@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDependencyUnavailable is reported, wrapped in a *DependencyError,
+// for requests to routes whose dependencies are failing.
+var ErrDependencyUnavailable = errors.New("a dependency of this route is unavailable")
+
+// degradedHeader lists the failing dependencies of the route answering,
+// when they're only warned about.
+const degradedHeader = "X-Degraded-Dependencies"
+
+// DependentRoute is implemented by routes that can't be served without
+// some of the things the app depends on, named after the health
+// checkers checking them, such as an upstream of Config.Health.Upstreams.
+type DependentRoute interface {
+	DependsOn() []string
+}
+
+// DependencyError is the error of a request turned away for the
+// dependencies of its route failing.
+type DependencyError struct {
+	// Checks are the health checks of the failing dependencies.
+	Checks []string
+	// Wait is how long the client is advised to wait before retrying.
+	Wait time.Duration
+}
+
+func (e *DependencyError) Error() string {
+	return fmt.Sprintf("dependencies unavailable: %s", strings.Join(e.Checks, ", "))
+}
+
+func (e *DependencyError) Is(target error) bool {
+	return target == ErrDependencyUnavailable
+}
+
+// RetryAfter is sent to the client as the Retry-After header, see
+// ErrorWriter.Write.
+func (e *DependencyError) RetryAfter() time.Duration {
+	return e.Wait
+}
+
+// DependencyGuard is route middleware failing requests fast, with a
+// 503, while a dependency of their route is failing, rather than let
+// them time out on it; routes not depending on it are left alone. A
+// route's dependencies are taken from Config.Health.RouteDependencies
+// by pattern, or else its DependentRoute method, and are critical to
+// it whether or not their checkers are critical to the app. Their state
+// is the cached one of the HealthProber: dependencies not checked yet
+// aren't failing.
+//
+// With Config.Health.DependencyPolicy set to "warn", requests are served
+// anyway, and the failing dependencies listed in their
+// X-Degraded-Dependencies header.
+type DependencyGuard struct {
+	routeDeps map[string][]string
+	warn      bool
+	prober    *HealthProber
+	errs      *ErrorWriter
+	log       *slog.Logger
+
+	mu     sync.Mutex // guards routes against the wrapping of routes
+	routes map[string][]string
+}
+
+// NewDependencyGuard builds a new DependencyGuard.
+func NewDependencyGuard(cfg *Config, prober *HealthProber, errs *ErrorWriter, log *slog.Logger) (*DependencyGuard, error) {
+	g := &DependencyGuard{
+		routeDeps: cfg.Health.RouteDependencies,
+		prober:    prober,
+		errs:      errs,
+		log:       log,
+		routes:    make(map[string][]string),
+	}
+	switch cfg.Health.DependencyPolicy {
+	case "", "reject":
+	case "warn":
+		g.warn = true
+	default:
+		return nil, fmt.Errorf("unknown dependency policy %q, want reject or warn", cfg.Health.DependencyPolicy)
+	}
+	return g, nil
+}
+
+func (*DependencyGuard) Order() int {
+	return orderDependencies
+}
+
+func (g *DependencyGuard) WrapRoute(route Route, next http.Handler) http.Handler {
+	pattern := route.Pattern()
+	deps, ok := g.routeDeps[pattern]
+	if dr, dependent := route.(DependentRoute); !ok && dependent {
+		deps = dr.DependsOn()
+	}
+	if len(deps) == 0 {
+		return next
+	}
+	for _, name := range deps {
+		if !g.prober.Checks(name) {
+			g.log.Warn("Route depends on an unknown health check", slog.String("route", pattern), slog.String("check", name))
+		}
+	}
+	g.mu.Lock()
+	g.routes[pattern] = deps
+	g.mu.Unlock()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failing := g.prober.Failing(deps)
+		switch {
+		case len(failing) == 0:
+		case g.warn:
+			w.Header().Set(degradedHeader, strings.Join(failing, ", "))
+		default:
+			g.errs.Write(w, r, &DependencyError{Checks: failing, Wait: g.prober.interval})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// DegradedDependency is a failing dependency, and the routes depending
+// on it.
+type DegradedDependency struct {
+	Check  string   `json:"check"`
+	Error  string   `json:"error,omitempty"`
+	Routes []string `json:"routes"`
+}
+
+// Degraded returns the failing dependencies of routes, by name.
+func (g *DependencyGuard) Degraded() []DegradedDependency {
+	g.mu.Lock()
+	byCheck := make(map[string][]string)
+	for pattern, deps := range g.routes {
+		for _, name := range deps {
+			byCheck[name] = append(byCheck[name], pattern)
+		}
+	}
+	g.mu.Unlock()
+
+	results, _ := g.prober.Results()
+	var degraded []DegradedDependency
+	for _, res := range results {
+		routes, ok := byCheck[res.Name]
+		if !ok || res.Status != "failing" {
+			continue
+		}
+		sort.Strings(routes)
+		degraded = append(degraded, DegradedDependency{Check: res.Name, Error: res.Error, Routes: routes})
+	}
+	return degraded
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fakeChecker is a health checker whose failure is set by the test.
+type fakeChecker struct {
+	name string
+	err  error
+}
+
+func (c *fakeChecker) Name() string                { return c.name }
+func (c *fakeChecker) Check(context.Context) error { return c.err }
+func (c *fakeChecker) Informational() bool         { return true }
+
+// dependentRoute is a route depending on the named checks.
+type dependentRoute struct {
+	*funcRoute
+	deps []string
+}
+
+func (r dependentRoute) DependsOn() []string { return r.deps }
+
+func TestDependencyGuard(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db := &fakeChecker{name: "db"}
+	cfg := &Config{}
+	cfg.Health.Interval = Duration(10 * time.Second)
+	prober, err := NewHealthProber([]HealthChecker{db}, cfg, testsupport.NewFakeClock(time.Now()), log, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := func(err error) {
+		db.err = err
+		prober.run(context.Background(), db)
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "ok") }
+	orders := dependentRoute{funcRoute: newFuncRoute("/orders", http.MethodGet, ok), deps: []string{"db"}}
+	ping := newFuncRoute("/ping", http.MethodGet, ok)
+
+	serveWith := func(policy string) (http.Handler, http.Handler, *DependencyGuard) {
+		cfg := &Config{}
+		cfg.Health.DependencyPolicy = policy
+		guard, err := NewDependencyGuard(cfg, prober, newTestErrorWriter(t), log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return guard.WrapRoute(orders, orders), guard.WrapRoute(ping, ping), guard
+	}
+	get := func(h http.Handler) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec
+	}
+
+	rejecting, independent, guard := serveWith("reject")
+	// Dependencies not checked yet aren't failing.
+	if rec := get(rejecting); rec.Code != http.StatusOK {
+		t.Errorf("before the first check: got %d, want 200", rec.Code)
+	}
+
+	probe(errors.New("connection refused"))
+	rec := get(rejecting)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "10" {
+		t.Errorf("failing dependency: got %d with Retry-After %q, want 503 with 10", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := get(independent); rec.Code != http.StatusOK {
+		t.Errorf("independent route: got %d, want 200", rec.Code)
+	}
+	want := []DegradedDependency{{Check: "db", Error: "connection refused", Routes: []string{"GET /orders"}}}
+	if got := guard.Degraded(); len(got) != 1 || got[0].Check != want[0].Check || got[0].Error != want[0].Error || strings.Join(got[0].Routes, ",") != "GET /orders" {
+		t.Errorf("degraded: got %+v, want %+v", got, want)
+	}
+
+	warning, _, _ := serveWith("warn")
+	rec = get(warning)
+	if rec.Code != http.StatusOK || rec.Header().Get(degradedHeader) != "db" {
+		t.Errorf("warn policy: got %d with %s %q, want 200 with db", rec.Code, degradedHeader, rec.Header().Get(degradedHeader))
+	}
+
+	// The app stays ready, naming the degraded dependency.
+	catalog, err := NewCatalog(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maintenance, err := NewMaintenance(&Config{}, log, catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readiness := NewReadiness()
+	readiness.SetReady(true)
+	if rec := get(NewReadyzHandler(readiness, maintenance, guard)); rec.Code != http.StatusOK || rec.Body.String() != "ok, degraded: db\n" {
+		t.Errorf("readyz: got %d %q, want 200 naming db", rec.Code, rec.Body)
+	}
+
+	probe(nil)
+	if rec := get(rejecting); rec.Code != http.StatusOK {
+		t.Errorf("recovered dependency: got %d, want 200", rec.Code)
+	}
+	if rec := get(warning); rec.Header().Get(degradedHeader) != "" {
+		t.Errorf("recovered dependency: got %s %q, want none", degradedHeader, rec.Header().Get(degradedHeader))
+	}
+	if got := guard.Degraded(); len(got) != 0 {
+		t.Errorf("recovered dependency: got degraded %+v, want none", got)
+	}
+}
+
+func TestDependencyGuardPolicy(t *testing.T) {
+	cfg := &Config{}
+	cfg.Health.DependencyPolicy = "ignore"
+	if _, err := NewDependencyGuard(cfg, nil, nil, nil); err == nil {
+		t.Error("got no error for an unknown policy")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"example.com/uberfx/clock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrRouteGone is reported by deprecated routes once their sunset has
+// passed, if Config.Deprecation.GoneAfterSunset is set.
+var ErrRouteGone = errors.New("this route has been retired")
+
+// DeprecatedRoute is implemented by routes that are deprecated. The
+// successor is the URL of the route replacing it, if any, and the
+// sunset the time after which it may stop working, if it's known.
+type DeprecatedRoute interface {
+	Deprecated() (successor string, sunset time.Time)
+}
+
+// Deprecations is route middleware announcing the deprecation of the
+// routes implementing DeprecatedRoute: their responses carry the
+// Deprecation header, the Sunset header (RFC 8594) if there's a sunset,
+// and a Link to the successor-version if there's a successor. Requests
+// to them are counted, and the first Config.Deprecation.LogPerClient of
+// each client every day are logged, so the callers can be chased up
+// without flooding the logs. Clients are identified as by the upload
+// quotas. After the sunset, the route answers 410 Gone if
+// Config.Deprecation.GoneAfterSunset is set.
+type Deprecations struct {
+	log    *slog.Logger
+	errs   *ErrorWriter
+	clock  clock.Clock
+	gone   bool
+	logMax int
+	total  *prometheus.CounterVec
+	hits   *clientHits
+}
+
+// NewDeprecations builds a new Deprecations.
+func NewDeprecations(cfg *Config, clk clock.Clock, log *slog.Logger, errs *ErrorWriter, reg *prometheus.Registry) *Deprecations {
+	m := &Deprecations{
+		log:    log,
+		errs:   errs,
+		clock:  clk,
+		gone:   cfg.Deprecation.GoneAfterSunset,
+		logMax: cfg.Deprecation.LogPerClient,
+		total: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_deprecated_requests_total",
+			Help: "Requests to deprecated routes, by route.",
+		}, []string{"route"}),
+		hits: newClientHits(),
+	}
+	if m.logMax <= 0 {
+		m.logMax = 10
+	}
+	reg.MustRegister(m.total)
+	return m
+}
+
+func (*Deprecations) Order() int {
+	return orderDeprecation
+}
+
+func (m *Deprecations) WrapRoute(route Route, next http.Handler) http.Handler {
+	dr, ok := route.(DeprecatedRoute)
+	if !ok {
+		return next
+	}
+	successor, sunset := dr.Deprecated()
+	pattern := route.Pattern()
+	total := m.total.WithLabelValues(pattern)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Deprecation", "true")
+		if !sunset.IsZero() {
+			h.Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+		if successor != "" {
+			h.Add("Link", "<"+successor+`>; rel="successor-version"`)
+		}
+		total.Inc()
+
+		now := m.clock.Now()
+		client := clientKey(r)
+		if m.hits.add(client, now.UTC().Format(time.DateOnly)) <= m.logMax {
+			m.log.Warn("Deprecated route called",
+				slog.String("route", pattern),
+				slog.String("client", client),
+				slog.String("successor", successor),
+				slog.Time("sunset", sunset),
+			)
+		}
+		if m.gone && !sunset.IsZero() && now.After(sunset) {
+			m.errs.Write(w, r, ErrRouteGone)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientHits counts requests by client, for the current day only.
+type clientHits struct {
+	mu   sync.Mutex
+	day  string
+	hits map[string]int
+}
+
+func newClientHits() *clientHits {
+	return &clientHits{hits: make(map[string]int)}
+}
+
+// add counts a request of client on day and returns the client's count
+// for the day.
+func (c *clientHits) add(client, day string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if day > c.day {
+		c.day = day
+		clear(c.hits)
+	}
+	c.hits[client]++
+	return c.hits[client]
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"example.com/uberfx/clock"
+)
+
+// ServiceInstance describes a running instance of the app to a service
+// registry.
+type ServiceInstance struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Addr    string `json:"addr"`
+	Env     string `json:"env"`
+	Version string `json:"version"`
+}
+
+// Announcer registers instances with a service registry. The returned
+// func deregisters the instance.
+type Announcer interface {
+	Announce(ctx context.Context, inst ServiceInstance) (deregister func(ctx context.Context) error, err error)
+}
+
+// NewAnnouncer builds the Announcer selected by Config.Discovery.Kind:
+// "file" or "webhook". Without one, instances aren't announced and nil
+// is returned.
+func NewAnnouncer(cfg *Config, client *http.Client) (Announcer, error) {
+	switch d := cfg.Discovery; d.Kind {
+	case "":
+		return nil, nil
+	case "file":
+		if d.File == "" {
+			return nil, errors.New("discovery.file must be set for file discovery")
+		}
+		return &FileAnnouncer{path: d.File}, nil
+	case "webhook":
+		if d.URL == "" {
+			return nil, errors.New("discovery.url must be set for webhook discovery")
+		}
+		return &WebhookAnnouncer{url: d.URL, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown discovery kind %q", d.Kind)
+	}
+}
+
+// FileAnnouncer announces the instance by writing it as JSON to a file,
+// removed on deregistration, for file-based service discovery.
+type FileAnnouncer struct {
+	path string
+}
+
+func (a *FileAnnouncer) Announce(_ context.Context, inst ServiceInstance) (func(context.Context) error, error) {
+	b, err := json.MarshalIndent(inst, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// Written aside and renamed, so readers never see a partial file.
+	tmp := a.path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, a.path); err != nil {
+		return nil, err
+	}
+	return func(context.Context) error {
+		return os.Remove(a.path)
+	}, nil
+}
+
+// WebhookAnnouncer announces the instance by POSTing it as JSON to a
+// registry URL, and deregisters it with a DELETE of the URL followed by
+// the instance ID.
+type WebhookAnnouncer struct {
+	url    string
+	client *http.Client
+}
+
+func (a *WebhookAnnouncer) Announce(ctx context.Context, inst ServiceInstance) (func(context.Context) error, error) {
+	b, err := json.Marshal(inst)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.do(ctx, http.MethodPost, a.url, b); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		u, err := url.JoinPath(a.url, url.PathEscape(inst.ID))
+		if err != nil {
+			return err
+		}
+		return a.do(ctx, http.MethodDelete, u, nil)
+	}, nil
+}
+
+func (a *WebhookAnnouncer) do(ctx context.Context, method, u string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s", method, u, resp.Status)
+	}
+	return nil
+}
+
+// DiscoveryComponent announces the instance once the server listens,
+// using the address it's actually bound to, and deregisters it before
+// the server shuts down so that traffic moves away while requests
+// drain. Failed announcements are retried with exponential backoff;
+// if they keep failing, startup only fails if
+// Config.Discovery.Required is set.
+type DiscoveryComponent struct {
+	announcer Announcer
+	cfg       DiscoveryConfig
+	inst      func() ServiceInstance
+	clock     clock.Clock
+	log       *slog.Logger
+
+	deregister func(context.Context) error
+}
+
+// NewDiscoveryComponent builds a new DiscoveryComponent.
+func NewDiscoveryComponent(announcer Announcer, cfg *Config, server *ServerInfo, build *BuildInfo, clk clock.Clock, log *slog.Logger) *DiscoveryComponent {
+	c := &DiscoveryComponent{announcer: announcer, cfg: cfg.Discovery, clock: clk, log: log}
+	if c.cfg.Attempts <= 0 {
+		c.cfg.Attempts = 5
+	}
+	if c.cfg.BaseDelay <= 0 {
+		c.cfg.BaseDelay = Duration(200 * time.Millisecond)
+	}
+	if c.cfg.MaxDelay <= 0 {
+		c.cfg.MaxDelay = Duration(5 * time.Second)
+	}
+	c.inst = func() ServiceInstance {
+		name := cfg.App.Name
+		if name == "" {
+			name = "uberfx"
+		}
+		host, _ := os.Hostname()
+		return ServiceInstance{
+			ID:      fmt.Sprintf("%s-%s-%d", name, host, os.Getpid()),
+			Name:    name,
+			Addr:    server.Addr().String(),
+			Env:     cfg.Env,
+			Version: build.Version,
+		}
+	}
+	return c
+}
+
+func (*DiscoveryComponent) Name() string {
+	return "discovery"
+}
+
+func (*DiscoveryComponent) Priority() int {
+	return priorityDiscovery
+}
+
+func (c *DiscoveryComponent) Start(ctx context.Context) error {
+	if c.announcer == nil {
+		return nil
+	}
+	inst := c.inst()
+	delay := c.cfg.BaseDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		c.deregister, err = c.announcer.Announce(ctx, inst)
+		if err == nil {
+			c.log.Info("Announced instance", slog.String("id", inst.ID), slog.String("addr", inst.Addr))
+			return nil
+		}
+		if attempt == c.cfg.Attempts {
+			break
+		}
+		c.log.Warn("Failed to announce instance, retrying", slog.Int("attempt", attempt), slog.String("err", err.Error()))
+		if c.clock.Sleep(ctx, time.Duration(delay)) != nil {
+			break
+		}
+		delay = min(2*delay, c.cfg.MaxDelay)
+	}
+	if c.cfg.Required {
+		return fmt.Errorf("announce instance: %w", err)
+	}
+	c.log.Error("Failed to announce instance, starting unannounced", slog.String("err", err.Error()))
+	return nil
+}
+
+func (c *DiscoveryComponent) Stop(ctx context.Context) error {
+	if c.deregister == nil {
+		return nil
+	}
+	defer func() { c.deregister = nil }()
+	if err := c.deregister(ctx); err != nil {
+		return fmt.Errorf("deregister instance: %w", err)
+	}
+	c.log.Info("Deregistered instance")
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+)
+
+// flakyAnnouncer is an Announcer failing its first failures attempts.
+type flakyAnnouncer struct {
+	failures int32
+	attempts atomic.Int32
+}
+
+func (a *flakyAnnouncer) Announce(context.Context, ServiceInstance) (func(context.Context) error, error) {
+	if a.attempts.Add(1) <= a.failures {
+		return nil, errors.New("registry unavailable")
+	}
+	return func(context.Context) error { return nil }, nil
+}
+
+func TestDiscoveryBackoff(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	server := NewServerInfo()
+	server.setListener(ln)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for _, tc := range []struct {
+		name     string
+		failures int32
+		attempts int
+		required bool
+		// delays are the backoffs expected between the attempts.
+		delays []time.Duration
+		err    bool
+	}{
+		{"recovers", 4, 5, true, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 250 * time.Millisecond, 250 * time.Millisecond}, false},
+		{"required", 10, 3, true, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, true},
+		{"optional", 10, 3, false, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := testsupport.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			cfg := &Config{}
+			cfg.Discovery.Required = tc.required
+			cfg.Discovery.Attempts = tc.attempts
+			cfg.Discovery.BaseDelay = Duration(100 * time.Millisecond)
+			cfg.Discovery.MaxDelay = Duration(250 * time.Millisecond)
+			announcer := &flakyAnnouncer{failures: tc.failures}
+			c := NewDiscoveryComponent(announcer, cfg, server, &BuildInfo{}, clk, log)
+
+			errs := make(chan error, 1)
+			go func() { errs <- c.Start(context.Background()) }()
+			for i, delay := range tc.delays {
+				clk.BlockUntil(1)
+				clk.Advance(delay - time.Nanosecond)
+				if clk.Pending() != 1 {
+					t.Fatalf("retry %d sent before its %s backoff", i+1, delay)
+				}
+				clk.Advance(time.Nanosecond)
+			}
+			if err := <-errs; (err != nil) != tc.err {
+				t.Errorf("got error %v, want one: %v", err, tc.err)
+			}
+			if n := announcer.attempts.Load(); n != int32(len(tc.delays)+1) {
+				t.Errorf("got %d attempts, want %d", n, len(tc.delays)+1)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"net/http"
+)
+
+// BodyDrainer is middleware that finishes reading the request body once
+// the handler returns, so that the connection can be reused by
+// keep-alive clients even when the handler failed or didn't need the
+// body. The net/http server closes the connection when a handler closes
+// a body it hasn't read to the end, so closing is deferred until then.
+// Up to Config.Server.MaxDrainBytes of leftover body are read and
+// discarded; when more is left, the connection is closed after the
+// response instead, with a Connection: close header if the response
+// hasn't been sent yet.
+type BodyDrainer struct {
+	max int64
+}
+
+// NewBodyDrainer builds a new BodyDrainer.
+func NewBodyDrainer(cfg *Config) *BodyDrainer {
+	m := &BodyDrainer{max: cfg.Server.MaxDrainBytes}
+	if m.max <= 0 {
+		m.max = 256 << 10
+	}
+	return m
+}
+
+func (*BodyDrainer) Order() int {
+	return orderDrain
+}
+
+func (m *BodyDrainer) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Inner middleware replaces r.Body with readers of its own; the
+		// original one is what's left on the connection.
+		body := r.Body
+		r.Body = drainingBody{body}
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		n, _ := io.CopyN(io.Discard, body, m.max+1)
+		if n > m.max && rec.Status() == 0 {
+			w.Header().Set("Connection", "close")
+		}
+		_ = body.Close()
+	})
+}
+
+// drainingBody is a request body whose Close is left to the BodyDrainer.
+type drainingBody struct {
+	io.ReadCloser
+}
+
+func (drainingBody) Close() error {
+	return nil
+}
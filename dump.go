@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"example.com/uberfx/httpjson"
+	"example.com/uberfx/reqctx"
+)
+
+// DebugDump is route middleware that logs the full requests and
+// responses of the routes matching Config.Debug.DumpRoutes, to debug
+// clients locally: headers, with credentials redacted, and the first
+// Config.Debug.MaxDumpBytes of bodies, with binary ones summarized.
+// Bodies are copied as they stream through, so streaming handlers keep
+// streaming. Dumping can be switched off and on at runtime through
+// /admin/debug/dump; in production, routes are never wrapped.
+type DebugDump struct {
+	patterns []string
+	max      int
+	log      *slog.Logger
+	enabled  atomic.Bool
+}
+
+// NewDebugDump builds a new DebugDump, enabled if any routes are to be
+// dumped.
+func NewDebugDump(cfg *Config, log *slog.Logger) *DebugDump {
+	m := &DebugDump{max: cfg.Debug.MaxDumpBytes, log: log}
+	if cfg.Env != "production" {
+		m.patterns = cfg.Debug.DumpRoutes
+	}
+	if m.max <= 0 {
+		m.max = 4 << 10
+	}
+	m.enabled.Store(len(m.patterns) > 0)
+	return m
+}
+
+func (*DebugDump) Order() int {
+	return orderDebugDump
+}
+
+// Enabled reports whether dumping is switched on.
+func (m *DebugDump) Enabled() bool {
+	return m.enabled.Load()
+}
+
+// SetEnabled switches dumping on or off.
+func (m *DebugDump) SetEnabled(enabled bool) {
+	m.enabled.Store(enabled)
+}
+
+func (m *DebugDump) WrapRoute(route Route, next http.Handler) http.Handler {
+	pattern := route.Pattern()
+	if !m.matches(pattern) {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.enabled.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		reqBody := &cappedBuffer{max: m.max}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, reqBody), r.Body}
+		}
+		dw := &dumpWriter{responseRecorder: newResponseRecorder(w), body: &cappedBuffer{max: m.max}}
+		next.ServeHTTP(dw, r)
+
+		m.log.Debug("Request dump",
+			slog.String("route", pattern),
+			slog.Any("context", reqctx.Snapshot(r.Context())),
+			slog.Group("request",
+				slog.String("method", r.Method),
+				slog.String("uri", r.RequestURI),
+				slog.Any("header", redactHeader(r.Header)),
+				reqBody.attr(),
+			),
+			slog.Group("response",
+				slog.Int("status", dw.Status()),
+				slog.Any("header", redactHeader(w.Header())),
+				dw.body.attr(),
+			),
+		)
+	})
+}
+
+// matches reports whether the route pattern is one to dump.
+func (m *DebugDump) matches(pattern string) bool {
+	for _, want := range m.patterns {
+		if matchRoute(want, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHeader returns a copy of h with the values of credential
+// headers redacted.
+func redactHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for name := range out {
+		if sensitiveHeaders[name] || name == "Set-Cookie" {
+			out[name] = []string{redacted}
+		}
+	}
+	return out
+}
+
+// cappedBuffer keeps the first max bytes written to it and counts the
+// rest.
+type cappedBuffer struct {
+	buf   bytes.Buffer
+	max   int
+	total int64
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.total += int64(len(p))
+	if room := b.max - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+// attr describes the captured body as a log attribute.
+func (b *cappedBuffer) attr() slog.Attr {
+	data := b.buf.Bytes()
+	body := string(data)
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		body = fmt.Sprintf("<binary, %d bytes>", b.total)
+	}
+	return slog.Group("body",
+		slog.String("data", body),
+		slog.Int64("bytes", b.total),
+		slog.Bool("truncated", b.total > int64(b.buf.Len())),
+	)
+}
+
+// dumpWriter copies the response body into a cappedBuffer.
+type dumpWriter struct {
+	*responseRecorder
+	body *cappedBuffer
+}
+
+func (w *dumpWriter) Write(b []byte) (int, error) {
+	n, err := w.responseRecorder.Write(b)
+	_, _ = w.body.Write(b[:n])
+	return n, err
+}
+
+// Switch is a feature that can be switched on and off at runtime.
+type Switch interface {
+	Enabled() bool
+	SetEnabled(enabled bool)
+}
+
+// SwitchHandler shows at GET on its path whether a Switch is on, and
+// switches it with a PUT of {"enabled": bool}. Both require the admin
+// role. A switch that may only be turned on in development is refused
+// with a 403 elsewhere.
+type SwitchHandler struct {
+	path    string
+	sw      Switch
+	errs    *ErrorWriter
+	devOnly bool
+	env     string
+}
+
+// NewDebugDumpHandler builds the SwitchHandler of request dumping, at
+// /admin/debug/dump. Dumping may only be switched on in development.
+func NewDebugDumpHandler(dump *DebugDump, cfg *Config, errs *ErrorWriter) *SwitchHandler {
+	return &SwitchHandler{path: "/admin/debug/dump", sw: dump, errs: errs, devOnly: true, env: cfg.Env}
+}
+
+func (h *SwitchHandler) RegisterRoutes(r Router) {
+	r.Handle(http.MethodGet, h.path, WithRoles(http.HandlerFunc(h.show), "admin"))
+	r.Handle(http.MethodPut, h.path, WithRoles(http.HandlerFunc(h.set), "admin"))
+}
+
+func (h *SwitchHandler) show(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	_ = httpjson.Respond(w, r, http.StatusOK, map[string]bool{"enabled": h.sw.Enabled()})
+}
+
+func (h *SwitchHandler) set(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, err))
+		return
+	}
+	if body.Enabled == nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, fmt.Errorf("missing %q", "enabled")))
+		return
+	}
+	if *body.Enabled && h.devOnly && h.env != "development" {
+		h.errs.Write(w, r, NewStatusError(http.StatusForbidden, fmt.Errorf("%s can only be switched on in development, not %s", h.path, h.env)))
+		return
+	}
+	h.sw.SetEnabled(*body.Enabled)
+	h.show(w, r)
+}
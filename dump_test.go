@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDebugSwitchHandlers(t *testing.T) {
+	paths := []string{"/admin/debug/dump", "/admin/debug/record"}
+	t.Run("development", func(t *testing.T) {
+		base := startTestApp(t, withTokens)
+		for _, path := range paths {
+			if status, _ := do(t, http.MethodPut, base+path, "", `{"enabled": true}`); status != http.StatusUnauthorized {
+				t.Errorf("%s: anonymous switch: got %d, want 401", path, status)
+			}
+			if status, body := do(t, http.MethodPut, base+path, "admin-token", `{"enabled": true}`); status != http.StatusOK {
+				t.Errorf("%s: admin switch: got %d %s, want 200", path, status, body)
+			}
+		}
+	})
+	t.Run("production", func(t *testing.T) {
+		base := startTestApp(t, func(cfg *Config) {
+			withTokens(cfg)
+			cfg.Env = "production"
+		})
+		for _, path := range paths {
+			if status, body := do(t, http.MethodPut, base+path, "admin-token", `{"enabled": true}`); status != http.StatusForbidden {
+				t.Errorf("%s: switch on: got %d %s, want 403", path, status, body)
+			}
+			if status, body := do(t, http.MethodPut, base+path, "admin-token", `{"enabled": false}`); status != http.StatusOK {
+				t.Errorf("%s: switch off: got %d %s, want 200", path, status, body)
+			}
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+)
+
+// echoEncodings are the encodings /echo?encoding= supports, each
+// returning a writer encoding into w. Writers are closed to flush any
+// partial block.
+var echoEncodings = map[string]func(w io.Writer) io.WriteCloser{
+	"hex": func(w io.Writer) io.WriteCloser {
+		return nopWriteCloser{hex.NewEncoder(w)}
+	},
+	"base64": func(w io.Writer) io.WriteCloser {
+		return base64.NewEncoder(base64.StdEncoding, w)
+	},
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// lineWrapper is a writer breaking the text written to it into lines of
+// width bytes, ending the last one on Close. A width of zero or less
+// leaves the text on one line.
+type lineWrapper struct {
+	w     io.Writer
+	width int
+	// col is the length of the current line.
+	col int
+	// any is set once anything was written.
+	any bool
+}
+
+func (l *lineWrapper) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		l.any = true
+	}
+	if l.width <= 0 {
+		return l.w.Write(b)
+	}
+	n := 0
+	for len(b) > 0 {
+		if l.col == l.width {
+			if _, err := io.WriteString(l.w, "\n"); err != nil {
+				return n, err
+			}
+			l.col = 0
+		}
+		chunk := b[:min(l.width-l.col, len(b))]
+		m, err := l.w.Write(chunk)
+		n += m
+		l.col += m
+		if err != nil {
+			return n, err
+		}
+		b = b[m:]
+	}
+	return n, nil
+}
+
+func (l *lineWrapper) Close() error {
+	if !l.any {
+		return nil
+	}
+	_, err := io.WriteString(l.w, "\n")
+	return err
+}
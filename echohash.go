@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+
+	"example.com/uberfx/httpjson"
+	"golang.org/x/crypto/blake2b"
+)
+
+// maxHashHead bounds the number of leading bytes /echo/hash dumps.
+const maxHashHead = 4096
+
+// hashAlgorithms are the digests /echo/hash supports, by ?alg= name.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake2b": func() hash.Hash {
+		h, _ := blake2b.New512(nil)
+		return h
+	},
+}
+
+// HashResult is the response of /echo/hash.
+type HashResult struct {
+	Algorithm string `json:"algorithm"`
+	Digest    string `json:"digest"`
+	Bytes     int64  `json:"bytes"`
+	Head      string `json:"head,omitempty"`
+}
+
+// EchoHashHandler is an HTTP handler that reports the digest and size of
+// its request body, streaming it rather than buffering it. The ?alg=
+// parameter selects sha256 (the default), sha512 or blake2b, and ?head=N
+// adds a hex dump of the first N bytes. Hashing goes through the
+// WorkerPool if the route opts in.
+type EchoHashHandler struct {
+	errs *ErrorWriter
+	pool *WorkerPool
+}
+
+// NewEchoHashHandler builds a new EchoHashHandler.
+func NewEchoHashHandler(errs *ErrorWriter, pool *WorkerPool) *EchoHashHandler {
+	return &EchoHashHandler{errs: errs, pool: pool}
+}
+
+func (*EchoHashHandler) Pattern() string {
+	return "POST /echo/hash"
+}
+
+func (h *EchoHashHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	alg := q.Get("alg")
+	if alg == "" {
+		alg = "sha256"
+	}
+	newHash, ok := hashAlgorithms[alg]
+	if !ok {
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, fmt.Errorf("unknown hash algorithm %q", alg)))
+		return
+	}
+	var headLen int
+	if s := q.Get("head"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > maxHashHead {
+			h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, fmt.Errorf("head must be between 0 and %d", maxHashHead)))
+			return
+		}
+		headLen = n
+	}
+
+	sum := newHash()
+	head := &headWriter{max: headLen}
+	var n int64
+	err := h.pool.Run(r.Context(), h.Pattern(), func() error {
+		var err error
+		n, err = io.Copy(io.MultiWriter(sum, head), r.Body)
+		return err
+	})
+	if err != nil {
+		h.errs.Write(w, r, err)
+		return
+	}
+
+	_ = httpjson.Respond(w, r, http.StatusOK, HashResult{
+		Algorithm: alg,
+		Digest:    hex.EncodeToString(sum.Sum(nil)),
+		Bytes:     n,
+		Head:      hex.EncodeToString(head.buf),
+	})
+}
+
+// headWriter keeps the first max bytes written to it.
+type headWriter struct {
+	buf []byte
+	max int
+}
+
+func (w *headWriter) Write(b []byte) (int, error) {
+	if room := w.max - len(w.buf); room > 0 {
+		w.buf = append(w.buf, b[:min(room, len(b))]...)
+	}
+	return len(b), nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestEchoHash(t *testing.T) {
+	base := startTestApp(t, func(cfg *Config) { cfg.Server.MaxBodyBytes = 64 })
+	const body = "the quick brown fox"
+	sha256Sum := sha256.Sum256([]byte(body))
+	sha512Sum := sha512.Sum512([]byte(body))
+	blake2bSum := blake2b.Sum512([]byte(body))
+
+	for _, tc := range []struct {
+		query  string
+		body   string
+		status int
+		want   HashResult
+	}{
+		{"", body, http.StatusOK, HashResult{Algorithm: "sha256", Digest: hex.EncodeToString(sha256Sum[:]), Bytes: 19}},
+		{"?alg=sha512", body, http.StatusOK, HashResult{Algorithm: "sha512", Digest: hex.EncodeToString(sha512Sum[:]), Bytes: 19}},
+		{"?alg=blake2b&head=3", body, http.StatusOK, HashResult{Algorithm: "blake2b", Digest: hex.EncodeToString(blake2bSum[:]), Bytes: 19, Head: "746865"}},
+		{"?alg=md5", body, http.StatusBadRequest, HashResult{}},
+		{"?head=4097", body, http.StatusBadRequest, HashResult{}},
+		{"", strings.Repeat("x", 65), http.StatusRequestEntityTooLarge, HashResult{}},
+	} {
+		status, resp := do(t, http.MethodPost, base+"/echo/hash"+tc.query, "", tc.body)
+		if status != tc.status {
+			t.Errorf("%q: got %d %s, want %d", tc.query, status, resp, tc.status)
+			continue
+		}
+		if status != http.StatusOK {
+			continue
+		}
+		var got HashResult
+		if err := json.Unmarshal([]byte(resp), &got); err != nil || got != tc.want {
+			t.Errorf("%q: got %s, want %+v", tc.query, resp, tc.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+
+	"example.com/uberfx/httpjson"
+)
+
+// PartInfo describes a part of a multipart/form-data request.
+type PartInfo struct {
+	Field       string `json:"field"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// EchoMultipartHandler is an HTTP handler that describes how a
+// multipart/form-data request body was parsed. Parts are streamed
+// through a hash rather than kept in memory, and are capped in size by
+// Config.Echo.MaxPartBytes and, all together, MaxMultipartBytes, which
+// defaults to the body limit of the route.
+type EchoMultipartHandler struct {
+	maxPart  int64
+	maxTotal int64
+	errs     *ErrorWriter
+}
+
+// NewEchoMultipartHandler builds a new EchoMultipartHandler.
+func NewEchoMultipartHandler(cfg *Config, errs *ErrorWriter) *EchoMultipartHandler {
+	h := &EchoMultipartHandler{
+		maxPart:  cfg.Echo.MaxPartBytes,
+		maxTotal: cfg.Echo.MaxMultipartBytes,
+		errs:     errs,
+	}
+	if h.maxTotal <= 0 {
+		h.maxTotal = cfg.Server.RouteBodyLimit(h.Pattern(), 0)
+	}
+	if h.maxTotal < 0 {
+		h.maxTotal = math.MaxInt64
+	}
+	if h.maxPart <= 0 {
+		h.maxPart = h.maxTotal
+	}
+	return h
+}
+
+func (*EchoMultipartHandler) Pattern() string {
+	return "POST /echo/multipart"
+}
+
+// AcceptedContentTypes lists the types the multipart reader handles.
+func (*EchoMultipartHandler) AcceptedContentTypes() []string {
+	return []string{"multipart/form-data", "multipart/mixed"}
+}
+
+func (h *EchoMultipartHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, err))
+		return
+	}
+
+	parts, err := readParts(mr, h.maxPart, h.maxTotal)
+	if err != nil {
+		h.errs.Write(w, r, err)
+		return
+	}
+	_ = httpjson.Respond(w, r, http.StatusOK, map[string]any{"parts": parts})
+}
+
+// readParts streams the parts of mr through a hash, describing each,
+// and fails with a 413 once a part exceeds maxPart bytes or all of them
+// together maxTotal.
+func readParts(mr *multipart.Reader, maxPart, maxTotal int64) ([]PartInfo, error) {
+	parts := []PartInfo{}
+	var total int64
+	for {
+		p, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return parts, nil
+		}
+		if err != nil {
+			return nil, multipartError(err)
+		}
+
+		sum := sha256.New()
+		n, err := io.Copy(sum, io.LimitReader(p, maxPart+1))
+		p.Close()
+		if err != nil {
+			return nil, multipartError(err)
+		}
+		if n > maxPart {
+			return nil, NewStatusError(http.StatusRequestEntityTooLarge,
+				fmt.Errorf("part %q exceeds the limit of %d bytes", p.FormName(), maxPart))
+		}
+		if total += n; total > maxTotal {
+			return nil, NewStatusError(http.StatusRequestEntityTooLarge,
+				fmt.Errorf("parts exceed the total limit of %d bytes", maxTotal))
+		}
+		parts = append(parts, PartInfo{
+			Field:       p.FormName(),
+			Filename:    p.FileName(),
+			ContentType: p.Header.Get("Content-Type"),
+			Size:        n,
+			SHA256:      hex.EncodeToString(sum.Sum(nil)),
+		})
+	}
+}
+
+// multipartError reports a malformed body as a 400, leaving other
+// errors, such as exceeding the body limit or the upload quota, as they
+// are.
+func multipartError(err error) error {
+	var (
+		mbe *http.MaxBytesError
+		se  *StatusError
+	)
+	if errors.As(err, &mbe) || errors.As(err, &se) || errors.Is(err, ErrQuotaExceeded) {
+		return err
+	}
+	return NewStatusError(http.StatusBadRequest, fmt.Errorf("malformed multipart body: %w", err))
+}
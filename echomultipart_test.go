@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// multipartBody builds a multipart/form-data body with the given text
+// fields and files, both as name and content pairs, returning it with
+// its content type.
+func multipartBody(t *testing.T, fields, files []string) ([]byte, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for i := 0; i+1 < len(fields); i += 2 {
+		if err := mw.WriteField(fields[i], fields[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i+1 < len(files); i += 2 {
+		fw, err := mw.CreateFormFile("file", files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, files[i+1])
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), mw.FormDataContentType()
+}
+
+func postMultipart(t *testing.T, url string, body []byte, contentType string) (int, string) {
+	t.Helper()
+	resp, err := http.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestEchoMultipart(t *testing.T) {
+	base := startTestApp(t, func(cfg *Config) { cfg.Echo.MaxPartBytes = 16 })
+	url := base + "/echo/multipart"
+
+	body, contentType := multipartBody(t, []string{"name", "ann"}, []string{"notes.txt", "hello"})
+	status, resp := postMultipart(t, url, body, contentType)
+	var got struct {
+		Parts []PartInfo `json:"parts"`
+	}
+	if err := json.Unmarshal([]byte(resp), &got); status != http.StatusOK || err != nil {
+		t.Fatalf("got %d %s", status, resp)
+	}
+	want := []PartInfo{
+		{Field: "name", Size: 3, SHA256: sha256Hex("ann")},
+		{Field: "file", Filename: "notes.txt", ContentType: "application/octet-stream", Size: 5, SHA256: sha256Hex("hello")},
+	}
+	if len(got.Parts) != len(want) {
+		t.Fatalf("got parts %+v, want %+v", got.Parts, want)
+	}
+	for i := range want {
+		if got.Parts[i] != want[i] {
+			t.Errorf("part %d: got %+v, want %+v", i, got.Parts[i], want[i])
+		}
+	}
+
+	body, contentType = multipartBody(t, nil, []string{"big.bin", strings.Repeat("x", 17)})
+	if status, resp := postMultipart(t, url, body, contentType); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized part: got %d %s, want 413", status, resp)
+	}
+
+	body, contentType = multipartBody(t, []string{"name", "ann"}, []string{"notes.txt", "hello"})
+	truncated := body[:len(body)-10]
+	if status, resp := postMultipart(t, url, truncated, contentType); status != http.StatusBadRequest || !strings.Contains(resp, "malformed multipart body") {
+		t.Errorf("truncated body: got %d %s, want a 400 with the parse error", status, resp)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"example.com/uberfx/stream"
+)
+
+// NDJSONResult is the result of a line of the body of /echo/ndjson.
+type NDJSONResult struct {
+	Line int  `json:"line"`
+	OK   bool `json:"ok"`
+	// Error says why the line isn't valid JSON, and Offset at which of
+	// its bytes the error was found, if it's a syntax error.
+	Error  string `json:"error,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+}
+
+// EchoNDJSONHandler is an HTTP handler that validates newline-delimited
+// JSON, streaming back an NDJSONResult per line of its request body as
+// the lines are read, rather than once it's all in. Blank lines are
+// skipped. Lines longer than Config.Echo.MaxLineBytes are reported as
+// such, without being read in full. Results are flushed at most every
+// Config.Echo.FlushInterval while lines keep coming, and whenever
+// reading would wait on the client. Reading stops as soon as the client
+// goes away.
+type EchoNDJSONHandler struct {
+	log           *slog.Logger
+	errs          *ErrorWriter
+	maxLine       int
+	flushInterval time.Duration
+}
+
+// NewEchoNDJSONHandler builds a new EchoNDJSONHandler.
+func NewEchoNDJSONHandler(log *slog.Logger, errs *ErrorWriter, cfg *Config) *EchoNDJSONHandler {
+	h := &EchoNDJSONHandler{
+		log:           log,
+		errs:          errs,
+		maxLine:       cfg.Echo.MaxLineBytes,
+		flushInterval: time.Duration(cfg.Echo.FlushInterval),
+	}
+	if h.maxLine <= 0 {
+		h.maxLine = 1 << 20
+	}
+	if h.flushInterval <= 0 {
+		h.flushInterval = 100 * time.Millisecond
+	}
+	return h
+}
+
+func (*EchoNDJSONHandler) Pattern() string {
+	return "POST /echo/ndjson"
+}
+
+// MaxBodyBytes lifts the body limit: streams are bounded by their lines
+// instead, which are never held more than one at a time.
+func (*EchoNDJSONHandler) MaxBodyBytes() int64 {
+	return -1
+}
+
+// AcceptedContentTypes limits bodies to the usual names of NDJSON, and
+// JSON itself.
+func (*EchoNDJSONHandler) AcceptedContentTypes() []string {
+	return []string{"application/x-ndjson", "application/jsonl", "application/json"}
+}
+
+func (h *EchoNDJSONHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	// Over HTTP/1.1 the server stops reading the request body once the
+	// response starts going out, which would cut the stream short.
+	_ = http.NewResponseController(w).EnableFullDuplex()
+	sw := stream.New(w, r, stream.Options{FlushInterval: h.flushInterval, Log: h.log})
+	enc := json.NewEncoder(sw)
+
+	br := bufio.NewReaderSize(r.Body, min(h.maxLine+1, 64<<10))
+	var err error
+	for n := 1; ; n++ {
+		var (
+			line    []byte
+			tooLong bool
+		)
+		line, tooLong, err = h.readLine(br)
+		if len(line) == 0 && !tooLong && err != nil {
+			break
+		}
+		if line = bytes.TrimSpace(line); len(line) == 0 && !tooLong {
+			continue
+		}
+		if werr := enc.Encode(h.validate(n, line, tooLong)); werr != nil {
+			err = werr
+			break
+		}
+		if err != nil {
+			break
+		}
+		// Results held for the flush interval would otherwise wait on the
+		// client sending more.
+		if br.Buffered() == 0 {
+			if werr := sw.Flush(); werr != nil {
+				err = werr
+				break
+			}
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		err = sw.Flush()
+	}
+	if errors.Is(err, stream.ErrDisconnected) || r.Context().Err() != nil {
+		h.log.DebugContext(r.Context(), "Client left during NDJSON echo", slog.Int64("bytes", sw.Bytes()))
+		return
+	}
+	if err != nil {
+		h.errs.SafeError(w, r, err)
+	}
+}
+
+// readLine reads the next line of br, without its newline. A line
+// longer than the max is read no further than the max; the rest of it
+// is discarded, and tooLong reported.
+func (h *EchoNDJSONHandler) readLine(br *bufio.Reader) (line []byte, tooLong bool, err error) {
+	for {
+		chunk, err := br.ReadSlice('\n')
+		content := bytes.TrimSuffix(chunk, []byte("\n"))
+		if !tooLong {
+			if len(line)+len(content) > h.maxLine {
+				tooLong, line = true, nil
+			} else {
+				line = append(line, content...)
+			}
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		return line, tooLong, err
+	}
+}
+
+// validate returns the result of the nth line.
+func (h *EchoNDJSONHandler) validate(n int, line []byte, tooLong bool) NDJSONResult {
+	if tooLong {
+		return NDJSONResult{Line: n, Error: fmt.Sprintf("line longer than %d bytes", h.maxLine)}
+	}
+	var v json.RawMessage
+	err := json.Unmarshal(line, &v)
+	if err == nil {
+		return NDJSONResult{Line: n, OK: true}
+	}
+	res := NDJSONResult{Line: n, Error: err.Error()}
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		res.Offset = syntax.Offset
+	}
+	return res
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// readResults decodes the NDJSON results of body.
+func readResults(t *testing.T, body io.Reader) []NDJSONResult {
+	t.Helper()
+	var results []NDJSONResult
+	dec := json.NewDecoder(body)
+	for dec.More() {
+		var res NDJSONResult
+		if err := dec.Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+func TestEchoNDJSON(t *testing.T) {
+	base := startTestApp(t, func(cfg *Config) { cfg.Echo.MaxLineBytes = 16 })
+	body := strings.Join([]string{
+		`{"a": 1}`,
+		`[1, 2`,
+		``,
+		`"` + strings.Repeat("x", 20) + `"`,
+		`true`,
+	}, "\n")
+	resp, err := http.Post(base+"/echo/ndjson", "application/x-ndjson", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("got %d with content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	want := []NDJSONResult{
+		{Line: 1, OK: true},
+		{Line: 2, Error: "unexpected end of JSON input", Offset: 5},
+		{Line: 4, Error: "line longer than 16 bytes"},
+		{Line: 5, OK: true},
+	}
+	got := readResults(t, resp.Body)
+	if len(got) != len(want) {
+		t.Fatalf("got results %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("result %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestEchoNDJSONStreams(t *testing.T) {
+	base := startTestApp(t, nil)
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, base+"/echo/ndjson", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	// The pipe only takes the first line once the client reads it.
+	go io.WriteString(pw, "{}\n")
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			pw.CloseWithError(err)
+			close(responses)
+			return
+		}
+		responses <- resp
+	}()
+	resp, ok := <-responses
+	if !ok {
+		t.Fatal("request failed")
+	}
+	defer resp.Body.Close()
+
+	// Each result arrives while the rest of the body is still to be
+	// sent.
+	lines := bufio.NewScanner(resp.Body)
+	for i, line := range []string{"{}", "nope", `{"b": 2}`} {
+		if i > 0 {
+			io.WriteString(pw, line+"\n")
+		}
+		if !lines.Scan() {
+			t.Fatalf("line %d: no result: %v", i+1, lines.Err())
+		}
+		var res NDJSONResult
+		if err := json.Unmarshal(lines.Bytes(), &res); err != nil || res.Line != i+1 || res.OK != (line != "nope") {
+			t.Fatalf("line %d: got result %s", i+1, lines.Bytes())
+		}
+	}
+	pw.Close()
+	if lines.Scan() {
+		t.Errorf("got result %s after the end of the body", lines.Bytes())
+	}
+}
+
+// endlessLines is a request body of valid NDJSON lines that never ends,
+// canceling the request once `after` bytes are read from it.
+type endlessLines struct {
+	read   atomic.Int64
+	after  int64
+	cancel context.CancelFunc
+}
+
+func (b *endlessLines) Read(p []byte) (int, error) {
+	n := copy(p, strings.Repeat("{}\n", len(p)/3+1)[:len(p)])
+	if b.read.Add(int64(n)) >= b.after {
+		b.cancel()
+	}
+	return n, nil
+}
+
+func TestEchoNDJSONDisconnect(t *testing.T) {
+	cfg := &Config{}
+	h := NewEchoNDJSONHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), newTestErrorWriter(t), cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	body := &endlessLines{after: 1 << 20, cancel: cancel}
+	req := httptest.NewRequest(http.MethodPost, "/echo/ndjson", io.NopCloser(body)).WithContext(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler kept reading after the client left")
+	}
+	if n := body.read.Load(); n > 2<<20 {
+		t.Errorf("read %d bytes, want reading to stop soon after the client left at 1 MiB", n)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"example.com/uberfx/clock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Event is a domain event, such as "greeting_sent", published on the
+// EventBus.
+type Event struct {
+	Topic   string
+	Time    time.Time
+	Payload any
+}
+
+// Subscriber consumes the events of the topics it lists; "*" stands for
+// every topic.
+type Subscriber interface {
+	Topics() []string
+	Handle(ctx context.Context, e Event) error
+}
+
+// AsSubscriber annotates the given constructor to state that it provides
+// a subscriber to the "subscribers" group.
+func AsSubscriber(f any) any {
+	return AsGroupMember[Subscriber]("subscribers", f)
+}
+
+// EventBus lets handlers publish events without knowing who consumes
+// them. Events are queued in a bounded queue and dispatched to the
+// subscribers by worker goroutines, so slow or failing subscribers don't
+// hold up publishers. When the queue is full, Config.Events.Policy
+// decides whether events are dropped ("drop", the default) or publishers
+// wait ("block"). On stop, queued events are still dispatched until the
+// stop deadline.
+type EventBus struct {
+	subscribers []Subscriber
+	workers     int
+	block       bool
+	log         *slog.Logger
+	clock       clock.Clock
+
+	queue chan Event
+	quit  chan struct{}
+	wg    sync.WaitGroup
+	// ctx is passed to subscribers, and canceled if draining runs past
+	// the stop deadline.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.RWMutex
+	stopped bool
+
+	published *prometheus.CounterVec
+	dropped   *prometheus.CounterVec
+	failures  *prometheus.CounterVec
+}
+
+// NewEventBus builds a new EventBus.
+func NewEventBus(subscribers []Subscriber, cfg *Config, clk clock.Clock, log *slog.Logger, reg *prometheus.Registry) (*EventBus, error) {
+	b := &EventBus{
+		subscribers: subscribers,
+		workers:     cfg.Events.Workers,
+		log:         log,
+		clock:       clk,
+		quit:        make(chan struct{}),
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "events_published_total",
+			Help: "Events published on the event bus, by topic.",
+		}, []string{"topic"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "events_dropped_total",
+			Help: "Events dropped because the event bus queue was full or stopped, by topic.",
+		}, []string{"topic"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "event_subscriber_failures_total",
+			Help: "Events that a subscriber failed to handle, by subscriber.",
+		}, []string{"subscriber"}),
+	}
+	if b.workers <= 0 {
+		b.workers = 4
+	}
+	size := cfg.Events.QueueSize
+	if size <= 0 {
+		size = 1024
+	}
+	b.queue = make(chan Event, size)
+	switch cfg.Events.Policy {
+	case "", "drop":
+	case "block":
+		b.block = true
+	default:
+		return nil, fmt.Errorf("unknown event queue policy %q", cfg.Events.Policy)
+	}
+	reg.MustRegister(b.published, b.dropped, b.failures)
+	return b, nil
+}
+
+// Publish queues e for the subscribers of its topic. In block mode, it
+// waits for room in the queue until ctx is done.
+func (b *EventBus) Publish(ctx context.Context, e Event) {
+	if e.Time.IsZero() {
+		e.Time = b.clock.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.stopped {
+		b.drop(e, "event bus stopped")
+		return
+	}
+
+	select {
+	case b.queue <- e:
+		b.published.WithLabelValues(e.Topic).Inc()
+		return
+	default:
+	}
+	if !b.block {
+		b.drop(e, "event queue full")
+		return
+	}
+	select {
+	case b.queue <- e:
+		b.published.WithLabelValues(e.Topic).Inc()
+	case <-ctx.Done():
+		b.drop(e, ctx.Err().Error())
+	case <-b.quit:
+		b.drop(e, "event bus stopped")
+	}
+}
+
+func (b *EventBus) drop(e Event, reason string) {
+	b.dropped.WithLabelValues(e.Topic).Inc()
+	b.log.Warn("Dropped event", slog.String("topic", e.Topic), slog.String("reason", reason))
+}
+
+func (*EventBus) Name() string {
+	return "event-bus"
+}
+
+func (*EventBus) Priority() int {
+	return priorityEventBus
+}
+
+// Start starts the workers.
+func (b *EventBus) Start(context.Context) error {
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	for range b.workers {
+		b.wg.Add(1)
+		go b.work()
+	}
+	return nil
+}
+
+// Stop stops accepting events and waits for the queued ones to be
+// dispatched, giving up once ctx is done.
+func (b *EventBus) Stop(ctx context.Context) error {
+	close(b.quit)
+	b.mu.Lock()
+	b.stopped = true
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		b.cancel()
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		b.log.Warn("Stopped the event bus before draining its queue", slog.Int("queued", len(b.queue)))
+		return ctx.Err()
+	}
+}
+
+func (b *EventBus) work() {
+	defer b.wg.Done()
+	for {
+		select {
+		case e := <-b.queue:
+			b.dispatch(e)
+		case <-b.quit:
+			for {
+				select {
+				case e := <-b.queue:
+					if b.ctx.Err() != nil {
+						return
+					}
+					b.dispatch(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// dispatch hands e to each of its subscribers in turn.
+func (b *EventBus) dispatch(e Event) {
+	for _, s := range b.subscribers {
+		topics := s.Topics()
+		if !slices.Contains(topics, e.Topic) && !slices.Contains(topics, "*") {
+			continue
+		}
+		if err := b.handle(s, e); err != nil {
+			name := fmt.Sprintf("%T", s)
+			b.failures.WithLabelValues(name).Inc()
+			b.log.Error("Subscriber failed to handle event", slog.String("subscriber", name), slog.String("topic", e.Topic), slog.String("err", err.Error()))
+		}
+	}
+}
+
+// handle calls the subscriber, turning a panic into an error.
+func (b *EventBus) handle(s Subscriber, e Event) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return s.Handle(b.ctx, e)
+}
+
+// LogSubscriber is a Subscriber that logs every event.
+type LogSubscriber struct {
+	log *slog.Logger
+}
+
+// NewLogSubscriber builds a new LogSubscriber.
+func NewLogSubscriber(log *slog.Logger) *LogSubscriber {
+	return &LogSubscriber{log: log}
+}
+
+func (*LogSubscriber) Topics() []string {
+	return []string{"*"}
+}
+
+func (s *LogSubscriber) Handle(ctx context.Context, e Event) error {
+	s.log.InfoContext(ctx, "Event", slog.String("topic", e.Topic), slog.Time("time", e.Time), slog.Any("payload", e.Payload))
+	return nil
+}
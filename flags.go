@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"maps"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"example.com/uberfx/httpjson"
+	"example.com/uberfx/reqctx"
+)
+
+// FeatureFlags tells whether a feature is enabled for the request or
+// other work ctx belongs to.
+type FeatureFlags interface {
+	Enabled(ctx context.Context, name string) bool
+}
+
+// Flag is the setting of a feature flag.
+type Flag struct {
+	Enabled bool `json:"enabled"`
+	// Percent, if between 1 and 99, enables an enabled flag only for that
+	// share of flag keys. A key always gets the same answer.
+	Percent int `json:"percent,omitempty"`
+}
+
+// ConfigFlags is the FeatureFlags implementation backed by Config.Flags.
+// Flags can be replaced at runtime through /admin/flags; readers never
+// take a lock, the whole set being swapped atomically.
+type ConfigFlags struct {
+	flags atomic.Pointer[map[string]Flag]
+	mu    sync.Mutex // serializes writers
+}
+
+// NewConfigFlags builds a new ConfigFlags.
+func NewConfigFlags(cfg *Config) *ConfigFlags {
+	f := &ConfigFlags{}
+	flags := maps.Clone(cfg.Flags)
+	if flags == nil {
+		flags = make(map[string]Flag)
+	}
+	f.flags.Store(&flags)
+	return f
+}
+
+func (f *ConfigFlags) Enabled(ctx context.Context, name string) bool {
+	flag, ok := (*f.flags.Load())[name]
+	if !ok || !flag.Enabled {
+		return false
+	}
+	if flag.Percent <= 0 || flag.Percent >= 100 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(flagKey(ctx)))
+	return int(h.Sum32()%100) < flag.Percent
+}
+
+// All returns every flag.
+func (f *ConfigFlags) All() map[string]Flag {
+	return *f.flags.Load()
+}
+
+// Set replaces the given flags, leaving the others as they are.
+func (f *ConfigFlags) Set(updates map[string]Flag) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	flags := maps.Clone(*f.flags.Load())
+	maps.Copy(flags, updates)
+	f.flags.Store(&flags)
+}
+
+// flagKey returns the key percentage rollouts are decided by: the
+// request ID, or failing that the client IP, so that a client gets
+// consistent answers.
+func flagKey(ctx context.Context) string {
+	if id := reqctx.RequestID(ctx); id != "" {
+		return id
+	}
+	return reqctx.ClientIP(ctx)
+}
+
+// FlagsHandler lists the feature flags at GET /admin/flags and updates
+// them with a PUT of a JSON object of flags by name. Both require the
+// admin role.
+type FlagsHandler struct {
+	flags *ConfigFlags
+	errs  *ErrorWriter
+}
+
+// NewFlagsHandler builds a new FlagsHandler.
+func NewFlagsHandler(flags *ConfigFlags, errs *ErrorWriter) *FlagsHandler {
+	return &FlagsHandler{flags: flags, errs: errs}
+}
+
+func (h *FlagsHandler) RegisterRoutes(r Router) {
+	r.Handle(http.MethodGet, "/admin/flags", WithRoles(http.HandlerFunc(h.list), "admin"))
+	r.Handle(http.MethodPut, "/admin/flags", WithRoles(http.HandlerFunc(h.set), "admin"))
+}
+
+func (h *FlagsHandler) list(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	_ = httpjson.Respond(w, r, http.StatusOK, h.flags.All())
+}
+
+func (h *FlagsHandler) set(w http.ResponseWriter, r *http.Request) {
+	var updates map[string]Flag
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, err))
+		return
+	}
+	h.flags.Set(updates)
+	h.list(w, r)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"example.com/uberfx/reqctx"
+)
+
+func TestConfigFlags(t *testing.T) {
+	flags := NewConfigFlags(&Config{Flags: map[string]Flag{
+		"on":      {Enabled: true},
+		"off":     {Enabled: false},
+		"partial": {Enabled: true, Percent: 30},
+		// Percent only narrows an enabled flag.
+		"partial-off": {Enabled: false, Percent: 30},
+	}})
+	ctx := reqctx.WithRequestID(context.Background(), "req-1")
+	for name, want := range map[string]bool{"on": true, "off": false, "partial-off": false, "missing": false} {
+		if got := flags.Enabled(ctx, name); got != want {
+			t.Errorf("Enabled(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	t.Run("rollout", func(t *testing.T) {
+		enabled := 0
+		for i := range 1000 {
+			ctx := reqctx.WithRequestID(context.Background(), fmt.Sprintf("req-%d", i))
+			got := flags.Enabled(ctx, "partial")
+			for range 3 {
+				if flags.Enabled(ctx, "partial") != got {
+					t.Fatalf("req-%d got different answers", i)
+				}
+			}
+			if got {
+				enabled++
+			}
+		}
+		if enabled < 250 || enabled > 350 {
+			t.Errorf("30%% rollout enabled %d of 1000 keys", enabled)
+		}
+		// Without a request ID, the client IP decides.
+		ctx := reqctx.WithClientIP(context.Background(), "192.0.2.1")
+		got := flags.Enabled(ctx, "partial")
+		for range 3 {
+			if flags.Enabled(ctx, "partial") != got {
+				t.Fatal("client 192.0.2.1 got different answers")
+			}
+		}
+	})
+
+	t.Run("set", func(t *testing.T) {
+		flags.Set(map[string]Flag{"on": {Enabled: false}, "new": {Enabled: true}})
+		for name, want := range map[string]bool{"on": false, "new": true, "off": false} {
+			if got := flags.Enabled(ctx, name); got != want {
+				t.Errorf("after Set, Enabled(%q) = %v, want %v", name, got, want)
+			}
+		}
+		if _, ok := flags.All()["partial"]; !ok {
+			t.Error("Set dropped the flags it didn't update")
+		}
+	})
+}
+
+func TestFlagsHandler(t *testing.T) {
+	base := startTestApp(t, withTokens)
+	for _, tc := range []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"user-token", http.StatusForbidden},
+	} {
+		if status, _ := do(t, http.MethodPut, base+"/admin/flags", tc.token, `{"beta": {"enabled": true}}`); status != tc.want {
+			t.Errorf("PUT /admin/flags as %q: got %d, want %d", tc.token, status, tc.want)
+		}
+	}
+	if status, body := do(t, http.MethodPut, base+"/admin/flags", "admin-token", `{"beta": {"enabled": true}}`); status != http.StatusOK {
+		t.Fatalf("PUT /admin/flags as admin: got %d %s, want 200", status, body)
+	}
+	status, body := do(t, http.MethodGet, base+"/admin/flags", "admin-token", "")
+	if status != http.StatusOK || !strings.Contains(body, `"beta"`) {
+		t.Errorf("GET /admin/flags: got %d %s, want the beta flag", status, body)
+	}
+
+	// Toggling json_greeting changes /hello from the next request on.
+	for _, tc := range []struct {
+		enabled bool
+		want    string
+	}{
+		{true, `{"greeting":`},
+		{false, "Hello, ann"},
+	} {
+		update := fmt.Sprintf(`{"json_greeting": {"enabled": %v}}`, tc.enabled)
+		if status, body := do(t, http.MethodPut, base+"/admin/flags", "admin-token", update); status != http.StatusOK {
+			t.Fatalf("PUT /admin/flags %s: got %d %s", update, status, body)
+		}
+		if _, body := do(t, http.MethodPost, base+"/hello", "", "ann"); !strings.HasPrefix(body, tc.want) {
+			t.Errorf("POST /hello with json_greeting %v: got %q, want it to start with %q", tc.enabled, body, tc.want)
+		}
+	}
+}
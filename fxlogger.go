@@ -0,0 +1,185 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.uber.org/fx/fxevent"
+)
+
+// NewFxLogger builds the logger for Fx's own events as selected by
+// Config.Log.FxEvents: every event by default, or with "on-error" only
+// the events leading up to a failure.
+func NewFxLogger(log *slog.Logger, cfg *Config) fxevent.Logger {
+	base := &fxevent.SlogLogger{Logger: log}
+	if cfg.Log.FxEvents == "on-error" {
+		return NewBufferedFxLogger(base, 0)
+	}
+	return base
+}
+
+// BufferedFxLogger is an fxevent.Logger that logs nothing while the app
+// is healthy. It keeps the most recent events in a bounded ring, and on
+// the first event reporting an error it logs the buffered events in
+// order, passing every later event straight through.
+type BufferedFxLogger struct {
+	next fxevent.Logger
+
+	mu      sync.Mutex
+	ring    []fxevent.Event
+	start   int
+	size    int
+	flushed bool
+}
+
+// NewBufferedFxLogger builds a BufferedFxLogger that keeps up to
+// capacity events for next. A capacity of zero selects 256.
+func NewBufferedFxLogger(next fxevent.Logger, capacity int) *BufferedFxLogger {
+	if capacity <= 0 {
+		capacity = 256
+	}
+	return &BufferedFxLogger{next: next, ring: make([]fxevent.Event, capacity)}
+}
+
+func (l *BufferedFxLogger) LogEvent(e fxevent.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.flushed {
+		l.next.LogEvent(e)
+		return
+	}
+	if eventErr(e) == nil {
+		l.push(e)
+		return
+	}
+
+	l.flushed = true
+	for i := 0; i < l.size; i++ {
+		l.next.LogEvent(l.ring[(l.start+i)%len(l.ring)])
+	}
+	l.ring, l.size = nil, 0
+	l.next.LogEvent(e)
+}
+
+// push appends e to the ring, dropping the oldest event if it's full.
+func (l *BufferedFxLogger) push(e fxevent.Event) {
+	if l.size < len(l.ring) {
+		l.ring[(l.start+l.size)%len(l.ring)] = e
+		l.size++
+		return
+	}
+	l.ring[l.start] = e
+	l.start = (l.start + 1) % len(l.ring)
+}
+
+// eventErr returns the error reported by e, if any.
+func eventErr(e fxevent.Event) error {
+	switch e := e.(type) {
+	case *fxevent.OnStartExecuted:
+		return e.Err
+	case *fxevent.OnStopExecuted:
+		return e.Err
+	case *fxevent.Supplied:
+		return e.Err
+	case *fxevent.Provided:
+		return e.Err
+	case *fxevent.Replaced:
+		return e.Err
+	case *fxevent.Decorated:
+		return e.Err
+	case *fxevent.Run:
+		return e.Err
+	case *fxevent.Invoked:
+		return e.Err
+	case *fxevent.Stopped:
+		return e.Err
+	case *fxevent.RollingBack:
+		return e.StartErr
+	case *fxevent.RolledBack:
+		return e.Err
+	case *fxevent.Started:
+		return e.Err
+	case *fxevent.LoggerInitialized:
+		return e.Err
+	}
+	return nil
+}
+
+// Provisions records, from Fx's events, the constructors providing each
+// type to the container, for the dependency graph to name them: the
+// graph only has the reflect stubs fx.Annotate builds.
+type Provisions struct {
+	mu       sync.Mutex
+	byOutput map[string][]string
+}
+
+func newProvisions() *Provisions {
+	return &Provisions{byOutput: make(map[string][]string)}
+}
+
+// Logger returns an fxevent.Logger recording the provided constructors
+// before passing events on to next.
+func (p *Provisions) Logger(next fxevent.Logger) fxevent.Logger {
+	return &provisionsLogger{next: next, p: p}
+}
+
+type provisionsLogger struct {
+	next fxevent.Logger
+	p    *Provisions
+}
+
+func (l *provisionsLogger) LogEvent(e fxevent.Event) {
+	switch e := e.(type) {
+	case *fxevent.Provided:
+		if e.Err == nil {
+			for _, out := range e.OutputTypeNames {
+				l.p.add(out, constructorName(e.ConstructorName))
+			}
+		}
+	case *fxevent.Supplied:
+		if e.Err == nil {
+			l.p.add(e.TypeName, "fx.Supply")
+		}
+	}
+	l.next.LogEvent(e)
+}
+
+func (p *Provisions) add(typeName, constructor string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := graphTypeKey(typeName)
+	p.byOutput[key] = append(p.byOutput[key], constructor)
+}
+
+// Constructors returns the constructors providing each type, in the
+// order they were provided. Types are keyed as in the DOT graph,
+// without the indexes of group members: "main.Route[group=routes]".
+func (p *Provisions) Constructors() map[string][]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make(map[string][]string, len(p.byOutput))
+	for k, v := range p.byOutput {
+		out[k] = append([]string(nil), v...)
+	}
+	return out
+}
+
+// graphTypeKey turns a type name as Fx reports it, such as
+// `main.Route[group = "routes"]`, into the form the DOT graph uses.
+func graphTypeKey(name string) string {
+	return strings.NewReplacer(" ", "", `"`, "").Replace(name)
+}
+
+var annotatedConstructor = regexp.MustCompile(`^fx\.Annotate\(([^(),]+)\(`)
+
+// constructorName returns the name of the function Fx reports as a
+// constructor, unwrapping fx.Annotate and dropping the parentheses.
+func constructorName(name string) string {
+	if m := annotatedConstructor.FindStringSubmatch(name); m != nil {
+		return m[1]
+	}
+	return strings.TrimSuffix(name, "()")
+}
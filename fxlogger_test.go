@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"go.uber.org/fx/fxevent"
+)
+
+// eventRecorder is an fxevent.Logger recording the events it's given.
+type eventRecorder struct {
+	events []fxevent.Event
+}
+
+func (r *eventRecorder) LogEvent(e fxevent.Event) { r.events = append(r.events, e) }
+
+// invokedNames returns the function names of the Invoked events logged to r.
+func (r *eventRecorder) invokedNames() []string {
+	var names []string
+	for _, e := range r.events {
+		if e, ok := e.(*fxevent.Invoked); ok {
+			names = append(names, e.FunctionName)
+		}
+	}
+	return names
+}
+
+func TestBufferedFxLogger(t *testing.T) {
+	rec := &eventRecorder{}
+	l := NewBufferedFxLogger(rec, 3)
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		l.LogEvent(&fxevent.Invoked{FunctionName: name})
+	}
+	if len(rec.events) != 0 {
+		t.Fatalf("logged %d events while healthy, want none", len(rec.events))
+	}
+
+	// The failure flushes the ring, which has dropped the oldest event,
+	// in order and before the failure itself.
+	l.LogEvent(&fxevent.Invoked{FunctionName: "e", Err: errors.New("boom")})
+	if got, want := rec.invokedNames(), []string{"b", "c", "d", "e"}; !slices.Equal(got, want) {
+		t.Errorf("after the failure, logged %v, want %v", got, want)
+	}
+
+	// Later events pass straight through.
+	l.LogEvent(&fxevent.Invoked{FunctionName: "f"})
+	if got, want := rec.invokedNames(), []string{"b", "c", "d", "e", "f"}; !slices.Equal(got, want) {
+		t.Errorf("after a later event, logged %v, want %v", got, want)
+	}
+}
+
+func TestNewFxLogger(t *testing.T) {
+	cfg := &Config{}
+	if _, ok := NewFxLogger(nil, cfg).(*BufferedFxLogger); ok {
+		t.Error("by default, got a BufferedFxLogger, want every event logged")
+	}
+	cfg.Log.FxEvents = "on-error"
+	if _, ok := NewFxLogger(nil, cfg).(*BufferedFxLogger); !ok {
+		t.Error(`with "on-error", want a BufferedFxLogger`)
+	}
+}
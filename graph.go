@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.uber.org/fx"
+)
+
+// GraphNode is a value in the dependency graph.
+type GraphNode struct {
+	// ID is the node's name in the DOT graph: the type, and for group
+	// members the group and an index, as in "main.Route[group=routes]0".
+	ID string `json:"id"`
+	// Constructor names the function providing the value.
+	Constructor string `json:"constructor"`
+	// Group is the value group the value is a member of, if any.
+	Group string `json:"group,omitempty"`
+	// DependsOn lists the IDs of the values the constructor takes.
+	DependsOn []string `json:"depends_on"`
+}
+
+// GraphGroup is a value group in the dependency graph.
+type GraphGroup struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Members []string `json:"members"`
+}
+
+// DependencyGraph is the adjacency list of the dependency graph.
+type DependencyGraph struct {
+	Nodes  []GraphNode  `json:"nodes"`
+	Groups []GraphGroup `json:"groups"`
+}
+
+// The lines of the DOT graph generated by dig that parseDotGraph reads.
+var (
+	dotCluster     = regexp.MustCompile(`^subgraph (cluster_\d+) \{$`)
+	dotLabel       = regexp.MustCompile(`^label = "(.*)";$`)
+	dotConstructor = regexp.MustCompile(`^(constructor_\d+) \[shape=plaintext label="(.*)"\];$`)
+	dotNode        = regexp.MustCompile(`^"(.+)" \[label=<.*>\];$`)
+	dotDependency  = regexp.MustCompile(`^(constructor_\d+) -> "(.+)" \[ltail=cluster_\d+\];$`)
+	dotGroup       = regexp.MustCompile(`^"\[type=(.+) group=(.+)\]" \[shape=diamond .*\];$`)
+	dotMember      = regexp.MustCompile(`^"\[type=.+ group=(.+)\]" -> "(.+)";$`)
+	dotGroupMember = regexp.MustCompile(`\[group=([^\]]+)\]\d+$`)
+)
+
+// parseDotGraph builds the adjacency list of the DOT graph generated by
+// dig: each constructor is a cluster of the values it provides,
+// followed by edges to the values it depends on, and each value group
+// is a node with edges to its members. The graph names constructors
+// wrapped by fx.Annotate after the reflect stub running them, so the
+// names recorded in provisions are used when they're known, matching
+// each group's members in the order they were provided.
+func parseDotGraph(dot fx.DotGraph, provisions map[string][]string) (*DependencyGraph, error) {
+	type constructor struct {
+		name, pkg string
+		provides  []string
+		deps      []string
+	}
+	var (
+		ctors   = make(map[string]*constructor)
+		order   []string
+		groups  = make(map[string]*GraphGroup)
+		cluster *constructor
+		pkg     string
+	)
+	sc := bufio.NewScanner(strings.NewReader(string(dot)))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "}":
+			cluster = nil
+		case dotCluster.MatchString(line):
+			cluster, pkg = &constructor{}, ""
+		case cluster != nil && dotLabel.MatchString(line):
+			pkg = dotLabel.FindStringSubmatch(line)[1]
+		case cluster != nil && dotConstructor.MatchString(line):
+			m := dotConstructor.FindStringSubmatch(line)
+			cluster.name, cluster.pkg = m[2], pkg
+			ctors[m[1]] = cluster
+			order = append(order, m[1])
+		case cluster != nil && dotNode.MatchString(line):
+			cluster.provides = append(cluster.provides, dotNode.FindStringSubmatch(line)[1])
+		case dotDependency.MatchString(line):
+			m := dotDependency.FindStringSubmatch(line)
+			if c, ok := ctors[m[1]]; ok {
+				c.deps = append(c.deps, m[2])
+			}
+		case dotGroup.MatchString(line):
+			m := dotGroup.FindStringSubmatch(line)
+			groups[m[2]] = &GraphGroup{Name: m[2], Type: m[1], Members: []string{}}
+		case dotMember.MatchString(line):
+			m := dotMember.FindStringSubmatch(line)
+			if g, ok := groups[m[1]]; ok {
+				g.Members = append(g.Members, m[2])
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(ctors) == 0 {
+		return nil, fmt.Errorf("no constructors in the dependency graph")
+	}
+
+	g := &DependencyGraph{Nodes: []GraphNode{}, Groups: make([]GraphGroup, 0, len(groups))}
+	for _, id := range order {
+		c := ctors[id]
+		deps := c.deps
+		if deps == nil {
+			deps = []string{}
+		}
+		for _, p := range c.provides {
+			n := GraphNode{ID: p, Constructor: c.pkg + "." + c.name, DependsOn: deps}
+			key := p
+			if m := dotGroupMember.FindStringSubmatch(p); m != nil {
+				n.Group = m[1]
+				key = strings.TrimRight(p, "0123456789")
+			}
+			if names := provisions[key]; len(names) > 0 {
+				n.Constructor = names[0]
+				provisions[key] = names[1:]
+			}
+			g.Nodes = append(g.Nodes, n)
+		}
+	}
+	for _, grp := range groups {
+		g.Groups = append(g.Groups, *grp)
+	}
+	sort.Slice(g.Groups, func(i, j int) bool { return g.Groups[i].Name < g.Groups[j].Name })
+	return g, nil
+}
+
+// GraphHandler serves the app's dependency graph at GET /admin/graph,
+// as generated by dig in the DOT language, or with ?format=json as an
+// adjacency list, see DependencyGraph. The graph doesn't change once
+// the app is built, so both are rendered once.
+type GraphHandler struct {
+	dot  []byte
+	json []byte
+	errs *ErrorWriter
+}
+
+// NewGraphHandler builds a new GraphHandler.
+func NewGraphHandler(dot fx.DotGraph, provisions *Provisions, errs *ErrorWriter) (*GraphHandler, error) {
+	g, err := parseDotGraph(dot, provisions.Constructors())
+	if err != nil {
+		return nil, fmt.Errorf("parse dependency graph: %w", err)
+	}
+	b, err := json.Marshal(g)
+	if err != nil {
+		return nil, err
+	}
+	return &GraphHandler{dot: []byte(dot), json: b, errs: errs}, nil
+}
+
+func (*GraphHandler) Pattern() string {
+	return "GET /admin/graph"
+}
+
+// PlainJSON keeps the graph in the format tools read.
+func (*GraphHandler) PlainJSON() bool {
+	return true
+}
+
+func (h *GraphHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		_, _ = w.Write(h.dot)
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(h.json)
+	default:
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, fmt.Errorf("unknown format %q, want dot or json", format)))
+	}
+}
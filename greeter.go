@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Greeter makes the greetings of HelloHandler.
+type Greeter interface {
+	Greet(ctx context.Context, name string) (string, error)
+}
+
+// NewGreeter builds the Greeter selected by Config.Hello.Backend:
+// "static", the default, "time" or "remote", see StaticGreeter,
+// TimeGreeter and RemoteGreeter.
+func NewGreeter(cfg *Config, client *http.Client, log *slog.Logger) (Greeter, error) {
+	static := NewStaticGreeter(cfg)
+	switch b := cfg.Hello.Backend; b {
+	case "", "static":
+		return static, nil
+	case "time":
+		return NewTimeGreeter(), nil
+	case "remote":
+		return NewRemoteGreeter(cfg, client, static, log)
+	default:
+		return nil, fmt.Errorf("unknown greeting backend %q, want static, time or remote", b)
+	}
+}
+
+// StaticGreeter greets everyone with the same template,
+// Config.Hello.Template, whose "{name}" is replaced with the name
+// greeted.
+type StaticGreeter struct {
+	template string
+}
+
+// NewStaticGreeter builds a new StaticGreeter.
+func NewStaticGreeter(cfg *Config) *StaticGreeter {
+	g := &StaticGreeter{template: cfg.Hello.Template}
+	if g.template == "" {
+		g.template = "Hello, {name}"
+	}
+	return g
+}
+
+func (g *StaticGreeter) Greet(_ context.Context, name string) (string, error) {
+	return strings.ReplaceAll(g.template, "{name}", name), nil
+}
+
+// TimeGreeter greets according to the time of day of the server: good
+// morning until noon, good afternoon until 6pm, and good evening
+// otherwise.
+type TimeGreeter struct {
+	now func() time.Time
+}
+
+// NewTimeGreeter builds a new TimeGreeter.
+func NewTimeGreeter() *TimeGreeter {
+	return &TimeGreeter{now: time.Now}
+}
+
+func (g *TimeGreeter) Greet(_ context.Context, name string) (string, error) {
+	switch h := g.now().Hour(); {
+	case h < 12:
+		return "Good morning, " + name, nil
+	case h < 18:
+		return "Good afternoon, " + name, nil
+	default:
+		return "Good evening, " + name, nil
+	}
+}
+
+// RemoteGreeter has an upstream service make the greetings: it POSTs
+// the name to Config.Hello.RemoteURL through the shared client, and
+// takes the body of a 200 as the greeting. Should the upstream fail,
+// the greeting is made by the fallback instead, so that /hello keeps
+// answering.
+type RemoteGreeter struct {
+	client   *http.Client
+	url      string
+	fallback Greeter
+	log      *slog.Logger
+}
+
+// NewRemoteGreeter builds a new RemoteGreeter.
+func NewRemoteGreeter(cfg *Config, client *http.Client, fallback Greeter, log *slog.Logger) (*RemoteGreeter, error) {
+	if cfg.Hello.RemoteURL == "" {
+		return nil, fmt.Errorf("the remote greeting backend requires hello.remote_url")
+	}
+	return &RemoteGreeter{client: client, url: cfg.Hello.RemoteURL, fallback: fallback, log: log}, nil
+}
+
+func (g *RemoteGreeter) Greet(ctx context.Context, name string) (string, error) {
+	greeting, err := g.fetch(ctx, name)
+	if err == nil {
+		return greeting, nil
+	}
+	if ctx.Err() != nil {
+		return "", err
+	}
+	g.log.WarnContext(ctx, "Remote greeting failed, falling back", slog.String("url", g.url), slog.String("err", err.Error()))
+	return g.fallback.Greet(ctx, name)
+}
+
+func (g *RemoteGreeter) fetch(ctx context.Context, name string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.url, strings.NewReader(name))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ReadAllLimited(resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("got status %d, want 200", resp.StatusCode)
+	}
+	return string(bytes.TrimSpace(body)), nil
+}
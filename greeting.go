@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"example.com/uberfx/httpjson"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// greetingCapacity bounds the number of distinct names tracked.
+	greetingCapacity = 1000
+	// greetingNameLimit bounds the length of a tracked name.
+	greetingNameLimit = 64
+	// greetingBuckets is the number of hashed label values the greetings
+	// counter is spread over.
+	greetingBuckets = 16
+)
+
+// GreetingCount is the number of greetings for a name.
+type GreetingCount struct {
+	Name  string `json:"name"`
+	Count uint64 `json:"count"`
+}
+
+// GreetingStats counts the names greeted by HelloHandler. At most
+// greetingCapacity names are tracked: once full, a new name evicts the
+// least greeted one and inherits its count, so that unbounded distinct
+// names can't exhaust memory while frequent names stay on top. Counts of
+// newcomers are therefore upper bounds.
+type GreetingStats struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+
+	greetings *prometheus.CounterVec
+}
+
+// NewGreetingStats builds a new GreetingStats.
+func NewGreetingStats(reg *prometheus.Registry) *GreetingStats {
+	s := &GreetingStats{
+		counts: make(map[string]uint64),
+		greetings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "hello_greetings_total",
+			Help: "Greetings served, by hashed name bucket.",
+		}, []string{"bucket"}),
+	}
+	reg.MustRegister(s.greetings)
+	return s
+}
+
+// Add counts a greeting for name.
+func (s *GreetingStats) Add(name string) {
+	if len(name) > greetingNameLimit {
+		name = strings.ToValidUTF8(name[:greetingNameLimit], "")
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	s.greetings.WithLabelValues(fmt.Sprintf("%02d", h.Sum32()%greetingBuckets)).Inc()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n, ok := s.counts[name]; ok || len(s.counts) < greetingCapacity {
+		s.counts[name] = n + 1
+		return
+	}
+	var (
+		minName  string
+		minCount uint64
+	)
+	for n, c := range s.counts {
+		if minName == "" || c < minCount {
+			minName, minCount = n, c
+		}
+	}
+	delete(s.counts, minName)
+	s.counts[name] = minCount + 1
+}
+
+// Top returns the k most greeted names, most greeted first.
+func (s *GreetingStats) Top(k int) []GreetingCount {
+	s.mu.Lock()
+	top := make([]GreetingCount, 0, len(s.counts))
+	for n, c := range s.counts {
+		top = append(top, GreetingCount{Name: n, Count: c})
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(top, func(a, b GreetingCount) int {
+		if a.Count != b.Count {
+			if a.Count > b.Count {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	if len(top) > k {
+		top = top[:k]
+	}
+	return top
+}
+
+// Reset forgets all counted names.
+func (s *GreetingStats) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.counts)
+}
+
+// GreetingStatsHandler serves the most greeted names at GET /hello/stats
+// and resets the stats on DELETE, which requires the admin role, and a
+// CSRF token from browsers.
+type GreetingStatsHandler struct {
+	stats *GreetingStats
+}
+
+// NewGreetingStatsHandler builds a new GreetingStatsHandler.
+func NewGreetingStatsHandler(stats *GreetingStats) *GreetingStatsHandler {
+	return &GreetingStatsHandler{stats: stats}
+}
+
+func (h *GreetingStatsHandler) RegisterRoutes(r Router) {
+	r.Handle(http.MethodGet, "/hello/stats", http.HandlerFunc(h.top))
+	r.Handle(http.MethodDelete, "/hello/stats", WithBrowserFacing(WithRoles(http.HandlerFunc(h.reset), "admin")))
+}
+
+func (h *GreetingStatsHandler) top(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	_ = httpjson.Respond(w, r, http.StatusOK, h.stats.Top(20))
+}
+
+func (h *GreetingStatsHandler) reset(w http.ResponseWriter, r *http.Request) {
+	h.stats.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
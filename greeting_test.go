@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGreetingStatsReset(t *testing.T) {
+	base := startTestApp(t, withTokens)
+	for _, tc := range []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"user-token", http.StatusForbidden},
+		{"admin-token", http.StatusNoContent},
+	} {
+		if status, body := do(t, http.MethodDelete, base+"/hello/stats", tc.token, ""); status != tc.want {
+			t.Errorf("reset with %q: got %d %s, want %d", tc.token, status, body, tc.want)
+		}
+	}
+}
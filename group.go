@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"go.uber.org/fx"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// AsGroupMember annotates the given constructor to state that it
+// provides a T to the named value group. Any extra annotations are
+// applied after the group wiring.
+//
+// It panics if f is not a function, or if its first result does not
+// implement T, so that wiring mistakes surface when the option is built
+// rather than as a dig error at app construction.
+func AsGroupMember[T any](group string, f any, anns ...fx.Annotation) any {
+	target := reflect.TypeOf((*T)(nil)).Elem()
+	if target.Kind() != reflect.Interface {
+		panic(fmt.Sprintf("AsGroupMember: group %q: %v is not an interface type", group, target))
+	}
+
+	ft := reflect.TypeOf(f)
+	if ft == nil || ft.Kind() != reflect.Func {
+		panic(fmt.Sprintf("AsGroupMember[%v]: group %q: constructor must be a function, got %T", target, group, f))
+	}
+	switch {
+	case ft.NumOut() == 0:
+		panic(fmt.Sprintf("AsGroupMember[%v]: group %q: constructor %s has no results", target, group, funcDescription(f)))
+	case ft.NumOut() > 2, ft.NumOut() == 2 && ft.Out(1) != errorType:
+		panic(fmt.Sprintf("AsGroupMember[%v]: group %q: constructor %s must return (T) or (T, error), got %v", target, group, funcDescription(f), ft))
+	}
+	if out := ft.Out(0); !out.Implements(target) {
+		panic(fmt.Sprintf("AsGroupMember[%v]: group %q: constructor %s returns %v, which does not implement %v: %s",
+			target, group, funcDescription(f), out, target, missingMethods(out, target)))
+	}
+
+	return fx.Annotate(
+		f,
+		append([]fx.Annotation{
+			fx.As(new(T)),
+			fx.ResultTags(`group:"` + group + `"`),
+		}, anns...)...,
+	)
+}
+
+// funcDescription names the given function along with the file and line
+// it was defined at.
+func funcDescription(f any) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return "<unknown>"
+	}
+	file, line := fn.FileLine(fn.Entry())
+	return fmt.Sprintf("%s (%s:%d)", fn.Name(), file, line)
+}
+
+// missingMethods describes the methods of iface that t lacks or
+// declares with a different signature.
+func missingMethods(t, iface reflect.Type) string {
+	var problems []string
+	for i := 0; i < iface.NumMethod(); i++ {
+		want := iface.Method(i)
+		got, ok := t.MethodByName(want.Name)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("missing method %s%s", want.Name, strings.TrimPrefix(want.Type.String(), "func")))
+			continue
+		}
+		if !sameSignature(t, got.Type, want.Type) {
+			problems = append(problems, fmt.Sprintf("method %s has signature %v, want %v", want.Name, got.Type, want.Type))
+		}
+	}
+	return strings.Join(problems, "; ")
+}
+
+// sameSignature reports whether the method type got, taken from the
+// method set of t, matches the interface method type want. For
+// non-interface types reflect includes the receiver as the first
+// parameter, which is skipped here.
+func sameSignature(t, got, want reflect.Type) bool {
+	skip := 0
+	if t.Kind() != reflect.Interface {
+		skip = 1
+	}
+	if got.NumIn()-skip != want.NumIn() || got.NumOut() != want.NumOut() || got.IsVariadic() != want.IsVariadic() {
+		return false
+	}
+	for i := 0; i < want.NumIn(); i++ {
+		if got.In(i+skip) != want.In(i) {
+			return false
+		}
+	}
+	for i := 0; i < want.NumOut(); i++ {
+		if got.Out(i) != want.Out(i) {
+			return false
+		}
+	}
+	return true
+}
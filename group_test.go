@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.uber.org/fx"
+)
+
+// namer is the group member type of the tests.
+type namer interface {
+	Name() string
+}
+
+type fixedName string
+
+func (n fixedName) Name() string { return string(n) }
+
+// mustPanic calls f and returns the message it panics with.
+func mustPanic(t *testing.T, f func()) (msg string) {
+	t.Helper()
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatal("no panic")
+		}
+		msg = fmt.Sprint(r)
+	}()
+	f()
+	return ""
+}
+
+func TestAsGroupMember(t *testing.T) {
+	var names []string
+	app := fx.New(
+		fx.NopLogger,
+		fx.Provide(
+			AsGroupMember[namer]("names", func() fixedName { return "a" }),
+			AsGroupMember[namer]("names", func() (fixedName, error) { return "b", nil }),
+			AsGroupMember[namer]("names", func(s string) *fixedName { n := fixedName(s); return &n }),
+			func() string { return "c" },
+		),
+		fx.Invoke(fx.Annotate(func(ns []namer) {
+			for _, n := range ns {
+				names = append(names, n.Name())
+			}
+		}, fx.ParamTags(`group:"names"`))),
+	)
+	if err := app.Err(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, ","); got != "a,b,c" {
+		t.Errorf("got members %s, want a,b,c", got)
+	}
+}
+
+func TestAsGroupMemberError(t *testing.T) {
+	errBroken := errors.New("broken")
+	app := fx.New(
+		fx.NopLogger,
+		fx.Provide(AsGroupMember[namer]("names", func() (fixedName, error) { return "", errBroken })),
+		fx.Invoke(fx.Annotate(func([]namer) {}, fx.ParamTags(`group:"names"`))),
+	)
+	if err := app.Err(); !errors.Is(err, errBroken) {
+		t.Errorf("got %v, want the error of the constructor", err)
+	}
+}
+
+func TestAsGroupMemberRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		f    any
+		want string
+	}{
+		{"not a function", fixedName("a"), "constructor must be a function, got main.fixedName"},
+		{"nil", nil, "constructor must be a function, got <nil>"},
+		{"no results", func() {}, "has no results"},
+		{"three results", func() (fixedName, int, error) { return "", 0, nil }, "must return (T) or (T, error)"},
+		{"second result not an error", func() (fixedName, int) { return "", 0 }, "must return (T) or (T, error)"},
+		{"wrong type", func() int { return 0 }, "returns int, which does not implement main.namer"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := mustPanic(t, func() { AsGroupMember[namer]("names", tc.f) })
+			if !strings.Contains(msg, tc.want) || !strings.Contains(msg, `group "names"`) {
+				t.Errorf("got %q, want it to name the group and contain %q", msg, tc.want)
+			}
+		})
+	}
+	msg := mustPanic(t, func() { AsGroupMember[fixedName]("names", func() fixedName { return "" }) })
+	if !strings.Contains(msg, "is not an interface type") {
+		t.Errorf("got %q for a non-interface T", msg)
+	}
+}
+
+// patternless is a handler that forgot its Pattern method.
+type patternless struct{}
+
+func (patternless) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+// misnamedPattern declares Pattern with the wrong signature.
+type misnamedPattern struct{ patternless }
+
+func (misnamedPattern) Pattern() []byte { return nil }
+
+func newPatternless() *patternless { return &patternless{} }
+
+func TestAsRouteRejectsNonRoutes(t *testing.T) {
+	msg := mustPanic(t, func() { AsRoute(newPatternless) })
+	for _, want := range []string{
+		"example.com/uberfx.newPatternless",
+		"group_test.go:",
+		"returns *main.patternless, which does not implement main.Route",
+		"missing method Pattern() string",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("got %q, want it to contain %q", msg, want)
+		}
+	}
+
+	msg = mustPanic(t, func() { AsRoute(func() misnamedPattern { return misnamedPattern{} }) })
+	if want := "method Pattern has signature func(main.misnamedPattern) []uint8, want func() string"; !strings.Contains(msg, want) {
+		t.Errorf("got %q, want it to contain %q", msg, want)
+	}
+}
+
+func TestAsRouteAcceptsRoutes(t *testing.T) {
+	var patterns []string
+	app := fx.New(
+		fx.NopLogger,
+		fx.Provide(
+			AsRoute(func() Route { return newFuncRoute("/a", "GET", nil) }),
+			AsRoute(func() (*funcRoute, error) { return newFuncRoute("/b", "", nil), nil }),
+		),
+		fx.Invoke(fx.Annotate(func(routes []Route) {
+			for _, r := range routes {
+				patterns = append(patterns, r.Pattern())
+			}
+		}, fx.ParamTags(`group:"routes"`))),
+	)
+	if err := app.Err(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(patterns)
+	if got := strings.Join(patterns, ","); got != "/b,GET /a" {
+		t.Errorf("got routes %s, want /b,GET /a", got)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// GzipDecoder is middleware that transparently decompresses request
+// bodies sent with "Content-Encoding: gzip". The wire size is still
+// capped by BodyLimit, which wraps it; the decompressed size is capped
+// separately by Config.Server.MaxDecodedBodyBytes so that small
+// compressed bodies can't expand without bound. Corrupt streams are
+// reported as 400s.
+type GzipDecoder struct {
+	limit int64
+	errs  *ErrorWriter
+	pool  sync.Pool
+}
+
+// NewGzipDecoder builds a new GzipDecoder.
+func NewGzipDecoder(cfg *Config, errs *ErrorWriter) *GzipDecoder {
+	limit := cfg.Server.MaxDecodedBodyBytes
+	if limit <= 0 {
+		limit = 8 * cfg.Server.BodyLimit()
+	}
+	return &GzipDecoder{limit: limit, errs: errs}
+}
+
+func (*GzipDecoder) Order() int {
+	return orderGzip
+}
+
+func (m *GzipDecoder) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(strings.TrimSpace(r.Header.Get("Content-Encoding")), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		zr, _ := m.pool.Get().(*gzip.Reader)
+		var err error
+		if zr == nil {
+			zr, err = gzip.NewReader(r.Body)
+		} else {
+			err = zr.Reset(r.Body)
+		}
+		if err != nil {
+			m.errs.Write(w, r, gzipError(err))
+			return
+		}
+		body := &gzipBody{zr: zr, wire: r.Body, remaining: m.limit, limit: m.limit}
+		defer func() {
+			body.release()
+			m.pool.Put(zr)
+		}()
+
+		r.Body = body
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		next.ServeHTTP(w, r)
+	})
+}
+
+// gzipError maps an error reading a gzip stream to the status it's
+// reported with: a 400 for corrupt input, otherwise itself, so that for
+// instance exceeding the wire-size limit is still a 413.
+func gzipError(err error) error {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return err
+	}
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return NewStatusError(http.StatusBadRequest, fmt.Errorf("invalid gzip request body: %w", err))
+}
+
+// gzipBody is a request body decompressing the wire body, failing once
+// more than limit bytes have been decompressed.
+type gzipBody struct {
+	mu        sync.Mutex
+	zr        *gzip.Reader
+	wire      io.ReadCloser
+	remaining int64
+	limit     int64
+	released  bool
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.released {
+		return 0, errors.New("gzip request body read after the handler returned")
+	}
+	if b.remaining <= 0 {
+		// Only fail once a byte beyond the limit has actually been read.
+		var one [1]byte
+		n, err := b.zr.Read(one[:])
+		switch {
+		case n > 0:
+		case err == nil || err == io.EOF:
+			return 0, err
+		default:
+			return 0, gzipError(err)
+		}
+		return 0, NewStatusError(http.StatusRequestEntityTooLarge, fmt.Errorf("decompressed request body exceeds the limit of %d bytes", b.limit))
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.zr.Read(p)
+	b.remaining -= int64(n)
+	if err != nil && err != io.EOF {
+		err = gzipError(err)
+	}
+	return n, err
+}
+
+func (b *gzipBody) Close() error {
+	return b.wire.Close()
+}
+
+// release stops the body from being read any further, so that the gzip
+// reader can be reused.
+func (b *gzipBody) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.released = true
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestGzipBodyLimit(t *testing.T) {
+	for _, tc := range []struct {
+		body     string
+		tooLarge bool
+	}{
+		{"hello", false},
+		{"hello!", true},
+	} {
+		var wire bytes.Buffer
+		zw := gzip.NewWriter(&wire)
+		_, _ = io.WriteString(zw, tc.body)
+		_ = zw.Close()
+		zr, err := gzip.NewReader(&wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := &gzipBody{zr: zr, wire: io.NopCloser(&wire), remaining: 5, limit: 5}
+		got, err := io.ReadAll(body)
+		var se *StatusError
+		switch {
+		case tc.tooLarge && (!errors.As(err, &se) || se.Status != http.StatusRequestEntityTooLarge):
+			t.Errorf("%q: got %v, want a 413", tc.body, err)
+		case !tc.tooLarge && (err != nil || string(got) != tc.body):
+			t.Errorf("%q: got %q, %v, want the body read whole", tc.body, got, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import "net/http"
+
+// removeHeader is the header rule value stripping a header from the
+// response.
+const removeHeader = "!remove"
+
+// HeaderPolicy is route middleware that applies the response header
+// rules of Config.HeaderPolicy, so that cache headers and the like are
+// set per route without each handler setting them. A rule applies to
+// the routes its pattern matches, as a path.Match pattern against the
+// route pattern or its path; only the first rule matching a route is
+// applied. The rule's headers fill in those the handler didn't set, and
+// those with the value "!remove" are stripped whatever the handler set.
+type HeaderPolicy struct {
+	rules []HeaderRule
+}
+
+// NewHeaderPolicy builds a new HeaderPolicy.
+func NewHeaderPolicy(cfg *Config) *HeaderPolicy {
+	return &HeaderPolicy{rules: cfg.HeaderPolicy}
+}
+
+func (*HeaderPolicy) Order() int {
+	return orderHeaderPolicy
+}
+
+// rule returns the headers of the first rule matching the route
+// pattern, or nil.
+func (p *HeaderPolicy) rule(pattern string) map[string]string {
+	for _, r := range p.rules {
+		if matchRoute(r.Route, pattern) {
+			return r.Headers
+		}
+	}
+	return nil
+}
+
+func (p *HeaderPolicy) WrapRoute(route Route, next http.Handler) http.Handler {
+	headers := p.rule(route.Pattern())
+	if len(headers) == 0 {
+		return next
+	}
+	apply := func(h http.Header) {
+		for name, value := range headers {
+			switch {
+			case value == removeHeader:
+				h.Del(name)
+			case len(h.Values(name)) == 0:
+				h.Set(name, value)
+			}
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &headerPolicyWriter{ResponseWriter: w, apply: apply}
+		next.ServeHTTP(hw, r)
+		hw.applyOnce()
+	})
+}
+
+// headerPolicyWriter applies the header rule once the handler is done
+// setting headers, just before they're written.
+type headerPolicyWriter struct {
+	http.ResponseWriter
+	apply   func(http.Header)
+	applied bool
+}
+
+func (w *headerPolicyWriter) applyOnce() {
+	if !w.applied {
+		w.applied = true
+		w.apply(w.Header())
+	}
+}
+
+func (w *headerPolicyWriter) WriteHeader(status int) {
+	w.applyOnce()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerPolicyWriter) Write(b []byte) (int, error) {
+	w.applyOnce()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headerPolicyWriter) Flush() {
+	w.applyOnce()
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *headerPolicyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
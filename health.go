@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/uberfx/clock"
+	"example.com/uberfx/httpjson"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HealthChecker checks the health of something the app depends on, such
+// as a database or an upstream service. Checkers are run in the
+// background by the HealthProber, never by the health endpoint itself.
+type HealthChecker interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+// InformationalChecker is implemented by health checkers whose failures
+// are reported but don't make the app unhealthy. Other checkers are
+// critical.
+type InformationalChecker interface {
+	Informational() bool
+}
+
+// IntervalChecker is implemented by health checkers run on an interval
+// of their own, rather than Config.Health.Interval.
+type IntervalChecker interface {
+	CheckInterval() time.Duration
+}
+
+// AsHealthChecker annotates the given constructor to state that it
+// provides a health checker to the "health_checkers" group.
+func AsHealthChecker(f any) any {
+	return AsGroupMember[HealthChecker]("health_checkers", f)
+}
+
+// HealthResult is the last result of a health checker.
+type HealthResult struct {
+	Name     string `json:"name"`
+	Critical bool   `json:"critical"`
+	// Status is "pending" until the checker first ran, then "ok" or
+	// "failing".
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+	// CheckedAt is when the checker last ran.
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+// HealthProber is the component running each health checker on its
+// interval, plus up to Config.Health.Jitter of it at random so that
+// checkers don't all run at once, with a timeout of
+// Config.Health.Timeout. It keeps the last result of each, for the
+// health endpoint to report, and exports it as the health_check_up
+// gauge. The checkers are those of the "health_checkers" group and one
+// HTTPHealthChecker per Config.Health.Upstreams.
+type HealthProber struct {
+	checkers []HealthChecker
+	interval time.Duration
+	jitter   float64
+	timeout  time.Duration
+	log      *slog.Logger
+	up       *prometheus.GaugeVec
+	clock    clock.Clock
+	random   func() float64
+
+	mu      sync.Mutex
+	results map[string]HealthResult
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewHealthProber builds a new HealthProber.
+func NewHealthProber(checkers []HealthChecker, cfg *Config, clk clock.Clock, log *slog.Logger, reg *prometheus.Registry) (*HealthProber, error) {
+	c := cfg.Health
+	p := &HealthProber{
+		interval: time.Duration(c.Interval),
+		jitter:   c.Jitter,
+		timeout:  time.Duration(c.Timeout),
+		log:      log,
+		up: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "health_check_up",
+			Help: "Whether the last run of each health check succeeded.",
+		}, []string{"check"}),
+		clock:   clk,
+		random:  rand.Float64,
+		results: make(map[string]HealthResult),
+	}
+	if p.interval <= 0 {
+		p.interval = 30 * time.Second
+	}
+	switch {
+	case p.jitter == 0:
+		p.jitter = 0.1
+	case p.jitter < 0:
+		p.jitter = 0
+	}
+	if p.timeout <= 0 {
+		p.timeout = 5 * time.Second
+	}
+	for _, u := range c.Upstreams {
+		hc, err := NewHTTPHealthChecker(u)
+		if err != nil {
+			return nil, err
+		}
+		checkers = append(checkers, hc)
+	}
+	for _, hc := range checkers {
+		if _, ok := p.results[hc.Name()]; ok {
+			return nil, fmt.Errorf("health check %q registered twice", hc.Name())
+		}
+		p.results[hc.Name()] = HealthResult{Name: hc.Name(), Critical: isCritical(hc), Status: "pending"}
+	}
+	p.checkers = checkers
+	reg.MustRegister(p.up)
+	return p, nil
+}
+
+func isCritical(hc HealthChecker) bool {
+	i, ok := hc.(InformationalChecker)
+	return !ok || !i.Informational()
+}
+
+func (*HealthProber) Name() string {
+	return "health-prober"
+}
+
+func (*HealthProber) Priority() int {
+	return priorityHealth
+}
+
+// Start runs each checker right away, then on its interval, in the
+// background.
+func (p *HealthProber) Start(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	var wg sync.WaitGroup
+	for _, hc := range p.checkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.loop(ctx, hc)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(p.done)
+	}()
+	return nil
+}
+
+func (p *HealthProber) Stop(ctx context.Context) error {
+	p.cancel()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *HealthProber) loop(ctx context.Context, hc HealthChecker) {
+	interval := p.interval
+	if i, ok := hc.(IntervalChecker); ok && i.CheckInterval() > 0 {
+		interval = i.CheckInterval()
+	}
+	p.run(ctx, hc)
+	t := p.clock.NewTimer(p.delay(interval))
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			return
+		}
+		p.run(ctx, hc)
+		t.Reset(p.delay(interval))
+	}
+}
+
+// delay returns how long to wait for the next run of a checker: the
+// interval, plus up to the jitter of it.
+func (p *HealthProber) delay(interval time.Duration) time.Duration {
+	return interval + time.Duration(p.random()*p.jitter*float64(interval))
+}
+
+// run runs a checker once and records its result.
+func (p *HealthProber) run(ctx context.Context, hc HealthChecker) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	start := p.clock.Now()
+	err := hc.Check(ctx)
+	if ctx.Err() != nil && err == nil {
+		err = ctx.Err()
+	}
+	end := p.clock.Now()
+
+	res := HealthResult{
+		Name:      hc.Name(),
+		Critical:  isCritical(hc),
+		Status:    "ok",
+		Duration:  end.Sub(start),
+		CheckedAt: &end,
+	}
+	up := 1.0
+	if err != nil {
+		res.Status, res.Error, up = "failing", err.Error(), 0
+	}
+	p.mu.Lock()
+	prev := p.results[hc.Name()]
+	p.results[hc.Name()] = res
+	p.mu.Unlock()
+	p.up.WithLabelValues(hc.Name()).Set(up)
+
+	// Checkers passing their first run aren't worth a record.
+	if res.Status != prev.Status && !(prev.Status == "pending" && err == nil) {
+		p.log.Warn("Health check changed status",
+			slog.String("check", res.Name),
+			slog.String("status", res.Status),
+			slog.Bool("critical", res.Critical),
+			slog.String("error", res.Error),
+		)
+	}
+}
+
+// Results returns the last result of each checker, by name, and whether
+// the app is healthy: whether no critical checker is failing. Checkers
+// that haven't run yet don't count.
+func (p *HealthProber) Results() ([]HealthResult, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	results := make([]HealthResult, 0, len(p.results))
+	healthy := true
+	for _, res := range p.results {
+		results = append(results, res)
+		if res.Critical && res.Status == "failing" {
+			healthy = false
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results, healthy
+}
+
+// Checks reports whether a checker of the given name is run.
+func (p *HealthProber) Checks(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.results[name]
+	return ok
+}
+
+// Failing returns those of the named checkers whose last run failed,
+// critical or not.
+func (p *HealthProber) Failing(names []string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var failing []string
+	for _, name := range names {
+		if p.results[name].Status == "failing" {
+			failing = append(failing, name)
+		}
+	}
+	return failing
+}
+
+// HealthzHandler reports at GET /healthz the health of the app from the
+// cached results of the HealthProber: 200 "ok", or 503 "unhealthy" if a
+// critical check is failing. With ?verbose, the results of all checks
+// are reported as JSON.
+type HealthzHandler struct {
+	prober *HealthProber
+}
+
+// NewHealthzHandler builds a new HealthzHandler.
+func NewHealthzHandler(prober *HealthProber) *HealthzHandler {
+	return &HealthzHandler{prober: prober}
+}
+
+func (*HealthzHandler) Pattern() string {
+	return "GET /healthz"
+}
+
+func (h *HealthzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	results, healthy := h.prober.Results()
+	status, text := http.StatusOK, "ok"
+	if !healthy {
+		status, text = http.StatusServiceUnavailable, "unhealthy"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if _, verbose := r.URL.Query()["verbose"]; verbose {
+		_ = httpjson.Respond(w, r, status, struct {
+			Status string         `json:"status"`
+			Checks []HealthResult `json:"checks"`
+		}{text, results})
+		return
+	}
+	w.WriteHeader(status)
+	fmt.Fprintln(w, text)
+}
+
+// HTTPHealthChecker checks an upstream service by requesting a URL and
+// expecting a status, see UpstreamCheckConfig.
+type HTTPHealthChecker struct {
+	name          string
+	url           string
+	want          int
+	interval      time.Duration
+	informational bool
+	client        *http.Client
+}
+
+// NewHTTPHealthChecker builds a new HTTPHealthChecker.
+func NewHTTPHealthChecker(cfg UpstreamCheckConfig) (*HTTPHealthChecker, error) {
+	if cfg.Name == "" || cfg.URL == "" {
+		return nil, fmt.Errorf("upstream health check: name and url are required")
+	}
+	c := &HTTPHealthChecker{
+		name:          cfg.Name,
+		url:           cfg.URL,
+		want:          cfg.ExpectStatus,
+		interval:      time.Duration(cfg.Interval),
+		informational: cfg.Informational,
+		// The probe's context bounds it; it doesn't go through the
+		// retries and breaker of the app's client, so that it sees the
+		// upstream as it is.
+		client: &http.Client{},
+	}
+	if c.want == 0 {
+		c.want = http.StatusOK
+	}
+	return c, nil
+}
+
+func (c *HTTPHealthChecker) Name() string {
+	return c.name
+}
+
+func (c *HTTPHealthChecker) Informational() bool {
+	return c.informational
+}
+
+func (c *HTTPHealthChecker) CheckInterval() time.Duration {
+	return c.interval
+}
+
+func (c *HTTPHealthChecker) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != c.want {
+		return fmt.Errorf("got status %d, want %d", resp.StatusCode, c.want)
+	}
+	return nil
+}
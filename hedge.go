@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"example.com/uberfx/clock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Hedger is an http.RoundTripper that hedges idempotent requests
+// against a slow upstream: if no response has arrived after
+// Config.Client.Hedge.Delay, it sends the request a second time and
+// uses whichever response arrives first, canceling the other attempt.
+// An attempt failing doesn't decide the race while the other may still
+// succeed.
+//
+// Requests with a body are only hedged if it can be rewound through
+// Request.GetBody. No more than Config.Client.Hedge.MaxInFlight hedges
+// are outstanding at once, so that an upstream slowing down across the
+// board doesn't see its load doubled.
+type Hedger struct {
+	next     http.RoundTripper
+	delay    time.Duration
+	max      int64
+	inFlight atomic.Int64
+	clock    clock.Clock
+	log      *slog.Logger
+	hedged   *prometheus.CounterVec
+}
+
+// NewHedger builds a Hedger in front of next. Hedging is off, and next
+// is returned as is, unless Config.Client.Hedge.Delay is set.
+func NewHedger(next http.RoundTripper, cfg HedgeConfig, clk clock.Clock, log *slog.Logger, reg prometheus.Registerer) http.RoundTripper {
+	if cfg.Delay <= 0 {
+		return next
+	}
+	h := &Hedger{
+		next:  next,
+		delay: time.Duration(cfg.Delay),
+		max:   int64(cfg.MaxInFlight),
+		clock: clk,
+		log:   log,
+		hedged: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_hedged_requests_total",
+			Help: "Outbound requests sent a second time for being slow, by which attempt won.",
+		}, []string{"winner"}),
+	}
+	if h.max <= 0 {
+		h.max = 10
+	}
+	reg.MustRegister(h.hedged)
+	return h
+}
+
+// hedgeAttempt is the outcome of an attempt at a hedged request.
+type hedgeAttempt struct {
+	resp   *http.Response
+	err    error
+	hedge  bool
+	cancel context.CancelFunc
+}
+
+func (h *Hedger) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isIdempotent(req) || req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return h.next.RoundTrip(req)
+	}
+
+	// Room for both attempts, so that the loser never blocks.
+	results := make(chan hedgeAttempt, 2)
+	var cancels [2]context.CancelFunc
+	send := func(req *http.Request, hedge bool, done func()) {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels[btoi(hedge)] = cancel
+		go func() {
+			if done != nil {
+				defer done()
+			}
+			resp, err := h.next.RoundTrip(req.WithContext(ctx))
+			results <- hedgeAttempt{resp: resp, err: err, hedge: hedge, cancel: cancel}
+		}()
+	}
+	send(req, false, nil)
+
+	t := h.clock.NewTimer(h.delay)
+	defer t.Stop()
+	select {
+	case a := <-results:
+		return a.response()
+	case <-t.C():
+	}
+	if !h.hedge(req, send) {
+		return (<-results).response()
+	}
+
+	first := <-results
+	if first.err != nil {
+		// The other attempt may yet succeed.
+		first.cancel()
+		first = <-results
+		h.count(first.hedge)
+		return first.response()
+	}
+	cancels[btoi(!first.hedge)]()
+	go func() {
+		if loser := <-results; loser.resp != nil {
+			loser.resp.Body.Close()
+		}
+	}()
+	h.count(first.hedge)
+	return first.response()
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// hedge sends the second attempt at req, unless there are too many
+// hedges outstanding or the body can't be rewound, and reports whether
+// it did.
+func (h *Hedger) hedge(req *http.Request, send func(*http.Request, bool, func())) bool {
+	if h.inFlight.Add(1) > h.max {
+		h.inFlight.Add(-1)
+		return false
+	}
+	hreq := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			h.inFlight.Add(-1)
+			return false
+		}
+		hreq.Body = body
+	}
+	h.log.Debug("Hedging outbound request",
+		slog.String("method", req.Method),
+		slog.String("url", req.URL.Redacted()),
+		slog.Duration("delay", h.delay))
+	send(hreq, true, func() { h.inFlight.Add(-1) })
+	return true
+}
+
+func (h *Hedger) count(hedge bool) {
+	winner := "original"
+	if hedge {
+		winner = "hedge"
+	}
+	h.hedged.WithLabelValues(winner).Inc()
+}
+
+// response returns the outcome of the attempt. The attempt's context is
+// canceled once its body is closed, or right away if it failed.
+func (a hedgeAttempt) response() (*http.Response, error) {
+	if a.err != nil {
+		a.cancel()
+		return nil, a.err
+	}
+	a.resp.Body = &cancelBody{ReadCloser: a.resp.Body, cancel: a.cancel}
+	return a.resp, nil
+}
+
+// cancelBody cancels the context of the request it's the response body
+// of when closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
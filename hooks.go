@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"example.com/uberfx/httpjson"
+)
+
+// HookRecord describes a lifecycle hook and how its runs went.
+type HookRecord struct {
+	// Name is the function that appended the hook, and Caller the
+	// file and line it did so from.
+	Name          string        `json:"name"`
+	Caller        string        `json:"caller"`
+	HasStart      bool          `json:"has_start"`
+	HasStop       bool          `json:"has_stop"`
+	StartDuration time.Duration `json:"start_duration,omitempty"`
+	StopDuration  time.Duration `json:"stop_duration,omitempty"`
+	StartError    string        `json:"start_error,omitempty"`
+	StopError     string        `json:"stop_error,omitempty"`
+}
+
+// HookRegistry lists the lifecycle hooks appended through the decorated
+// fx.Lifecycle, in the order they were appended.
+type HookRegistry struct {
+	mu      sync.Mutex
+	records []*HookRecord
+}
+
+// NewHookRegistry builds a new, empty HookRegistry.
+func NewHookRegistry() *HookRegistry {
+	return &HookRegistry{}
+}
+
+// Records returns a copy of the records.
+func (r *HookRegistry) Records() []HookRecord {
+	r.mu.Lock()
+	records := r.records
+	r.mu.Unlock()
+	return r.snapshot(records)
+}
+
+func (r *HookRegistry) add(rec *HookRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, rec)
+}
+
+// snapshot returns copies of the given records.
+func (r *HookRegistry) snapshot(records []*HookRecord) []HookRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]HookRecord, len(records))
+	for i, rec := range records {
+		out[i] = *rec
+	}
+	return out
+}
+
+// update calls f with rec under the registry's lock.
+func (r *HookRegistry) update(rec *HookRecord, f func(*HookRecord)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f(rec)
+}
+
+// DebugHooksHandler is an HTTP handler that lists the lifecycle hooks.
+type DebugHooksHandler struct {
+	hooks *HookRegistry
+}
+
+// NewDebugHooksHandler builds a new DebugHooksHandler.
+func NewDebugHooksHandler(hooks *HookRegistry) *DebugHooksHandler {
+	return &DebugHooksHandler{hooks: hooks}
+}
+
+func (*DebugHooksHandler) Pattern() string {
+	return "GET /debug/hooks"
+}
+
+func (h *DebugHooksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	_ = httpjson.Respond(w, r, http.StatusOK, h.hooks.Records())
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"example.com/uberfx/reqctx"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/fx"
+)
+
+// VirtualHost is a set of routes served only to the hosts mapped to its
+// name in Config.Server.Hosts.
+type VirtualHost interface {
+	http.Handler
+	VirtualHostName() string
+}
+
+// virtualHost is a VirtualHost routing requests through its own Router.
+type virtualHost struct {
+	name string
+	mux  Router
+}
+
+func (h *virtualHost) VirtualHostName() string {
+	return h.name
+}
+
+func (h *virtualHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// AsVirtualHost builds a constructor for a virtual host with the given
+// name and provides it to the "virtual_hosts" group. The virtual host is
+// fed by the given group of routes, which are wrapped with the route
+// middleware like the default host's. Use AsSubRoute to add routes to
+// the group.
+//
+//	fx.Provide(
+//		AsVirtualHost("admin", "admin_routes"),
+//		AsSubRoute("admin_routes", NewUsersHandler),
+//	)
+func AsVirtualHost(name, group string) any {
+	return AsGroupMember[VirtualHost](
+		"virtual_hosts",
+		func(cfg *Config, table *RouteTable, routes []Route, mws []RouteMiddleware) (*virtualHost, error) {
+			mux, err := NewRouter(cfg.Server.Router)
+			if err != nil {
+				return nil, err
+			}
+			rec := newRecordingRouter(mux, sortByOrder(mws), table)
+			for _, route := range routes {
+				rec.source = fmt.Sprintf("route %T in virtual host %q", route, name)
+				method, pattern := splitPattern(route.Pattern())
+				rec.Handle(method, pattern, route)
+			}
+			if rec.err != nil {
+				return nil, rec.err
+			}
+			return &virtualHost{name: name, mux: mux}, nil
+		},
+		fx.ParamTags("", "", `group:"`+group+`"`, `group:"route_middleware"`),
+	)
+}
+
+// hostRule maps a host pattern to a virtual host.
+type hostRule struct {
+	pattern string
+	// suffix is set for "*." wildcard patterns, which match any
+	// subdomain of it.
+	suffix string
+	host   VirtualHost
+}
+
+// HostRouter is middleware that routes requests to the virtual host
+// their Host header maps to in Config.Server.Hosts, letting one listener
+// serve several hostnames. Patterns are exact hostnames or "*." followed
+// by a domain, matching any subdomain of it; exact patterns win over
+// wildcards, and longer wildcards over shorter ones. Requests for other
+// hosts fall through to the default routes. The matched pattern labels
+// the request metrics and the request logger.
+type HostRouter struct {
+	exact    map[string]hostRule
+	wildcard []hostRule
+	log      *slog.Logger
+	requests *prometheus.CounterVec
+}
+
+// NewHostRouter builds a new HostRouter.
+func NewHostRouter(cfg *Config, hosts []VirtualHost, log *slog.Logger, reg *prometheus.Registry) (*HostRouter, error) {
+	byName := make(map[string]VirtualHost, len(hosts))
+	for _, h := range hosts {
+		byName[h.VirtualHostName()] = h
+	}
+	hr := &HostRouter{
+		exact: make(map[string]hostRule),
+		log:   log,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_host_requests_total",
+			Help: "Requests by the host pattern they were routed by.",
+		}, []string{"host"}),
+	}
+	for pattern, name := range cfg.Server.Hosts {
+		h, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("host %q is mapped to unknown virtual host %q", pattern, name)
+		}
+		pattern = strings.ToLower(pattern)
+		rule := hostRule{pattern: pattern, host: h}
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			rule.suffix = "." + suffix
+			hr.wildcard = append(hr.wildcard, rule)
+		} else {
+			hr.exact[pattern] = rule
+		}
+	}
+	sort.Slice(hr.wildcard, func(i, j int) bool {
+		return len(hr.wildcard[i].suffix) > len(hr.wildcard[j].suffix)
+	})
+	reg.MustRegister(hr.requests)
+	return hr, nil
+}
+
+func (*HostRouter) Order() int {
+	return orderHostRouter
+}
+
+func (hr *HostRouter) Wrap(next http.Handler) http.Handler {
+	if len(hr.exact) == 0 && len(hr.wildcard) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := hr.match(r.Host)
+		var h http.Handler = next
+		pattern := "default"
+		if ok {
+			h, pattern = rule.host, rule.pattern
+		}
+		hr.requests.WithLabelValues(pattern).Inc()
+		ctx := reqctx.WithLogger(r.Context(), reqctx.Logger(r.Context(), hr.log).With(slog.String("host", pattern)))
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// match returns the rule for the host of a Host header.
+func (hr *HostRouter) match(host string) (hostRule, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if rule, ok := hr.exact[host]; ok {
+		return rule, true
+	}
+	for _, rule := range hr.wildcard {
+		if strings.HasSuffix(host, rule.suffix) {
+			return rule, true
+		}
+	}
+	return hostRule{}, false
+}
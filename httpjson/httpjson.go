@@ -0,0 +1,98 @@
+// Package httpjson writes the JSON responses of the server's routes,
+// shaped as set by the Options in the request context: plain, as
+// encoding/json encodes them, or wrapped in an envelope, and with the
+// fields of structs named in a given case.
+//
+// With the envelope, responses read
+//
+//	{"data": ..., "meta": {"request_id": "..."}}
+//
+// and errors carry the problem under "error" instead of "data".
+package httpjson
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"example.com/uberfx/reqctx"
+)
+
+// FieldCase names how the fields of structs without a name in their
+// json tag are named.
+type FieldCase string
+
+const (
+	// GoCase keeps the Go names of fields, as encoding/json does.
+	GoCase FieldCase = ""
+	// SnakeCase names fields in snake_case, as in "dns_names".
+	SnakeCase FieldCase = "snake"
+)
+
+// ParseFieldCase returns the FieldCase of the given name.
+func ParseFieldCase(name string) (FieldCase, error) {
+	switch c := FieldCase(name); c {
+	case GoCase, SnakeCase:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown field case %q, want snake", name)
+	}
+}
+
+// Options shape the JSON responses to a request.
+type Options struct {
+	Envelope  bool
+	FieldCase FieldCase
+}
+
+type optionsKey struct{}
+
+// WithOptions returns a copy of ctx carrying the options of the
+// responses to the request.
+func WithOptions(ctx context.Context, opts Options) context.Context {
+	return context.WithValue(ctx, optionsKey{}, opts)
+}
+
+// OptionsOf returns the options of the responses to the request, or
+// the zero Options, for plain responses, if they aren't set.
+func OptionsOf(ctx context.Context) Options {
+	opts, _ := ctx.Value(optionsKey{}).(Options)
+	return opts
+}
+
+// Meta is the meta of an envelope.
+type Meta struct {
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Respond writes v as the JSON response to r with the given status,
+// returning the error writing it. The Content-Type is application/json
+// unless already set.
+func Respond(w http.ResponseWriter, r *http.Request, status int, v any) error {
+	return respond(w, r, status, "data", v)
+}
+
+// RespondError is like Respond for error responses, such as problems,
+// which are put under "error" in the envelope.
+func RespondError(w http.ResponseWriter, r *http.Request, status int, v any) error {
+	return respond(w, r, status, "error", v)
+}
+
+func respond(w http.ResponseWriter, r *http.Request, status int, key string, v any) error {
+	opts := OptionsOf(r.Context())
+	if opts.FieldCase != GoCase {
+		v = Rename(v, opts.FieldCase)
+	}
+	if opts.Envelope {
+		v = object{
+			{key, v},
+			{"meta", Meta{RequestID: reqctx.RequestID(r.Context())}},
+		}
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,289 @@
+package httpjson
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+var (
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Rename returns v with the fields of its structs, at any depth, named
+// in the given case, as a value encoding/json encodes as it would v
+// otherwise. Fields named by their json tag keep that name; tag options
+// such as omitempty and "-" are honored. Values encoding themselves,
+// as json.Marshalers or encoding.TextMarshalers do, are left as they
+// are.
+func Rename(v any, c FieldCase) any {
+	if c == GoCase || v == nil {
+		return v
+	}
+	return rename(reflect.ValueOf(v), c)
+}
+
+func rename(v reflect.Value, c FieldCase) any {
+	if !v.IsValid() {
+		return nil
+	}
+	t := v.Type()
+	if t.Implements(marshalerType) || t.Implements(textMarshalerType) ||
+		v.CanAddr() && (reflect.PointerTo(t).Implements(marshalerType) || reflect.PointerTo(t).Implements(textMarshalerType)) {
+		return v.Interface()
+	}
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return rename(v.Elem(), c)
+	case reflect.Struct:
+		obj := object{}
+		for _, f := range structFields(v, c) {
+			obj = append(obj, member{f.name, rename(f.value, c)})
+		}
+		return obj
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		// Maps keep their keys, and encoding/json sorts them.
+		m := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[mapKey(iter.Key())] = rename(iter.Value(), c)
+		}
+		return m
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Encoded as base64.
+			return v.Interface()
+		}
+		fallthrough
+	case reflect.Array:
+		s := make([]any, v.Len())
+		for i := range s {
+			s[i] = rename(v.Index(i), c)
+		}
+		return s
+	default:
+		return v.Interface()
+	}
+}
+
+// mapKey returns the key of a map as encoding/json names it.
+func mapKey(k reflect.Value) string {
+	if k.Kind() == reflect.String {
+		return k.String()
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		b, _ := tm.MarshalText()
+		return string(b)
+	}
+	b, _ := json.Marshal(k.Interface())
+	return string(bytes.Trim(b, `"`))
+}
+
+// field is a field of a struct to encode.
+type field struct {
+	name   string
+	value  reflect.Value
+	depth  int
+	tagged bool
+}
+
+// structFields returns the fields of the struct v to encode, in order,
+// with those of embedded structs promoted the way encoding/json does:
+// of fields sharing a name, the shallowest wins, and if several are
+// the shallowest, the tagged one, or none.
+func structFields(v reflect.Value, c FieldCase) []field {
+	var all []field
+	collectFields(v, c, 0, &all)
+
+	byName := make(map[string][]int)
+	for i, f := range all {
+		byName[f.name] = append(byName[f.name], i)
+	}
+	var out []field
+	for i, f := range all {
+		idx := byName[f.name]
+		if len(idx) == 1 {
+			out = append(out, f)
+			continue
+		}
+		if dominant(all, idx) == i {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// dominant returns the index of the field winning among those at idx,
+// or -1 if none does.
+func dominant(all []field, idx []int) int {
+	best, n := -1, 0
+	for _, i := range idx {
+		switch {
+		case best == -1 || all[i].depth < all[best].depth:
+			best, n = i, 1
+		case all[i].depth == all[best].depth:
+			switch {
+			case all[i].tagged && !all[best].tagged:
+				best, n = i, 1
+			case all[i].tagged == all[best].tagged:
+				n++
+			}
+		}
+	}
+	if n > 1 {
+		return -1
+	}
+	return best
+}
+
+func collectFields(v reflect.Value, c FieldCase, depth int, out *[]field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if fv.Kind() == reflect.Pointer {
+					if fv.IsNil() {
+						continue
+					}
+					fv = fv.Elem()
+				}
+				collectFields(fv, c, depth+1, out)
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if hasOption(opts, "omitempty") && isEmpty(fv) {
+			continue
+		}
+		f := field{name: name, value: fv, depth: depth, tagged: name != ""}
+		if name == "" {
+			f.name = convert(sf.Name, c)
+		}
+		if hasOption(opts, "string") {
+			// Left to encoding/json, which only quotes the field of a
+			// struct.
+			b, err := json.Marshal(fv.Interface())
+			if err == nil {
+				f.value = reflect.ValueOf(string(b))
+			}
+		}
+		*out = append(*out, f)
+	}
+}
+
+func hasOption(opts, want string) bool {
+	for opts != "" {
+		var o string
+		o, opts, _ = strings.Cut(opts, ",")
+		if o == want {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmpty reports whether v is empty as encoding/json's omitempty
+// understands it.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
+
+// convert names a Go field in the given case.
+func convert(name string, c FieldCase) string {
+	if c != SnakeCase {
+		return name
+	}
+	return snake(name)
+}
+
+// snake returns name in snake_case, keeping initialisms together:
+// "DNSNames" is "dns_names" and "SHA256" is "sha256".
+func snake(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				prev := runes[i-1]
+				nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || unicode.IsUpper(prev) && nextLower {
+					b.WriteByte('_')
+				}
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// object is a JSON object keeping the order of its members.
+type object []member
+
+type member struct {
+	name  string
+	value any
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(m.name)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLocale is the locale of the catalog's reference messages, used
+// when no other locale matches.
+const defaultLocale = "en"
+
+// Catalog holds the messages of the user-facing errors in each locale,
+// loaded from the files embedded under assets/i18n, one JSON object of
+// messages by key per locale. Titles are keyed "title.<status>" and the
+// details of well-known errors "detail.<code>"; details may contain
+// {name} placeholders.
+type Catalog struct {
+	locales  map[string]map[string]string
+	fallback map[string]string
+}
+
+// NewCatalog loads the embedded catalog. A malformed file fails the
+// load; keys missing from a locale are reported and fall back to
+// English.
+func NewCatalog(log *slog.Logger) (*Catalog, error) {
+	return loadCatalog(embeddedDir("i18n"), log)
+}
+
+func loadCatalog(files fs.FS, log *slog.Logger) (*Catalog, error) {
+	c := &Catalog{locales: make(map[string]map[string]string)}
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read message catalog: %w", err)
+	}
+	for _, e := range entries {
+		locale, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		b, err := fs.ReadFile(files, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read message catalog %s: %w", e.Name(), err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(b, &messages); err != nil {
+			return nil, fmt.Errorf("parse message catalog %s: %w", e.Name(), err)
+		}
+		c.locales[strings.ToLower(locale)] = messages
+	}
+	c.fallback = c.locales[defaultLocale]
+	if c.fallback == nil {
+		return nil, fmt.Errorf("message catalog has no %s messages", path.Join("i18n", defaultLocale+".json"))
+	}
+	for locale, messages := range c.locales {
+		var missing []string
+		for key := range c.fallback {
+			if _, ok := messages[key]; !ok {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			log.Warn("Message catalog is missing keys", slog.String("locale", locale), slog.Any("keys", missing))
+		}
+	}
+	return c, nil
+}
+
+// Negotiate returns the locale of the catalog best matching the
+// Accept-Language header value, falling back to English. A regional
+// tag such as de-CH matches de when there are no de-CH messages.
+func (c *Catalog) Negotiate(header string) string {
+	type tag struct {
+		name string
+		q    float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if v, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			tags = append(tags, tag{name: name, q: q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+	for _, t := range tags {
+		if _, ok := c.locales[t.name]; ok {
+			return t.name
+		}
+		if base, _, ok := strings.Cut(t.name, "-"); ok {
+			if _, ok := c.locales[base]; ok {
+				return base
+			}
+		}
+	}
+	return defaultLocale
+}
+
+// Message returns the message stored under key in locale, with its
+// placeholders replaced by args, or false if neither locale nor English
+// has it.
+func (c *Catalog) Message(locale, key string, args map[string]string) (string, bool) {
+	msg, ok := c.locales[locale][key]
+	if !ok {
+		msg, ok = c.fallback[key]
+	}
+	if !ok {
+		return "", false
+	}
+	for name, v := range args {
+		msg = strings.ReplaceAll(msg, "{"+name+"}", v)
+	}
+	return msg, true
+}
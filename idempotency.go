@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"example.com/uberfx/reqctx"
+)
+
+const idempotencyHeader = "Idempotency-Key"
+
+// IdempotentRoute is implemented by routes that accept an
+// Idempotency-Key header to make retried requests safe.
+type IdempotentRoute interface {
+	AcceptsIdempotencyKey() bool
+}
+
+// idempotencyRecord is the stored outcome of the first request made
+// with an idempotency key.
+type idempotencyRecord struct {
+	BodyHash string
+	Status   int
+	Header   http.Header
+	Body     []byte
+}
+
+// Idempotency is route middleware that, for routes opting in through
+// IdempotentRoute, stores the response to the first request made with a
+// given Idempotency-Key and replays it for duplicates. Keys are those of
+// the principal making the request, so that one can't be replayed the
+// response to another's. A duplicate with a different body, or one
+// arriving while the first is still in progress, gets a 409. Records are
+// kept in the same kind of store as sessions.
+//
+// The body is read, to be hashed, before the route is served, up to the
+// route's body limit, see BodyLimit; a longer one gets a 413 without
+// being read any further. Routes without a body limit refuse keys with
+// a 400, since their bodies can't be read ahead.
+type Idempotency struct {
+	server   ServerConfig
+	store    SessionStore
+	ttl      time.Duration
+	maxBytes int
+	errs     *ErrorWriter
+	log      *slog.Logger
+
+	mu       sync.Mutex
+	inFlight map[string]struct{}
+}
+
+// NewIdempotency builds a new Idempotency.
+func NewIdempotency(store SessionStore, cfg *Config, errs *ErrorWriter, log *slog.Logger) *Idempotency {
+	m := &Idempotency{
+		server:   cfg.Server,
+		store:    store,
+		ttl:      time.Duration(cfg.Idempotency.TTL),
+		maxBytes: cfg.Idempotency.MaxResponseBytes,
+		errs:     errs,
+		log:      log,
+		inFlight: make(map[string]struct{}),
+	}
+	if m.ttl <= 0 {
+		m.ttl = 24 * time.Hour
+	}
+	if m.maxBytes <= 0 {
+		m.maxBytes = 1 << 20
+	}
+	return m
+}
+
+func (*Idempotency) Order() int {
+	return orderIdempotency
+}
+
+func (m *Idempotency) WrapRoute(route Route, next http.Handler) http.Handler {
+	if ir, ok := route.(IdempotentRoute); !ok || !ir.AcceptsIdempotencyKey() {
+		return next
+	}
+	pattern := route.Pattern()
+	var declared int64
+	if bl, ok := route.(BodyLimitedRoute); ok {
+		declared = bl.MaxBodyBytes()
+	}
+	limit := m.server.RouteBodyLimit(pattern, declared)
+	if limit < 0 {
+		m.log.Warn("Route accepting idempotency keys has no body limit, so keys are refused", slog.String("route", pattern))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if limit < 0 {
+			m.errs.Write(w, r, NewStatusError(http.StatusBadRequest, fmt.Errorf("%s isn't accepted by %s, which has no body limit", idempotencyHeader, pattern)))
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+		if err == nil && int64(len(body)) > limit {
+			err = &http.MaxBytesError{Limit: limit}
+		}
+		if err != nil {
+			m.errs.Write(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		bodyHash := hex.EncodeToString(sum[:])
+		storeKey := fmt.Sprintf("idempotency:%q:%s:%s", reqctx.Principal(r.Context()), pattern, key)
+
+		if !m.acquire(storeKey) {
+			m.conflict(w, r, "a request with this idempotency key is already in progress")
+			return
+		}
+		defer m.release(storeKey)
+
+		values, found, err := m.store.Load(r.Context(), storeKey)
+		if err != nil {
+			m.errs.Write(w, r, err)
+			return
+		}
+		if rec, ok := values["record"].(*idempotencyRecord); found && ok {
+			if rec.BodyHash != bodyHash {
+				m.conflict(w, r, "the idempotency key was already used with a different request body")
+				return
+			}
+			for k, v := range rec.Header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(rec.Status)
+			_, _ = w.Write(rec.Body)
+			return
+		}
+
+		cw := &capturingWriter{responseRecorder: newResponseRecorder(w), max: m.maxBytes}
+		next.ServeHTTP(cw, r)
+
+		status := cw.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		switch {
+		case status >= http.StatusInternalServerError:
+			return
+		case cw.overflow:
+			m.log.Warn("Response too large to store for idempotency key", slog.String("route", pattern), slog.Int("limit", m.maxBytes))
+			return
+		}
+		header := w.Header().Clone()
+		header.Del("Set-Cookie")
+		header.Del("Date")
+		rec := &idempotencyRecord{BodyHash: bodyHash, Status: status, Header: header, Body: cw.buf.Bytes()}
+		if err := m.store.Save(r.Context(), storeKey, map[string]any{"record": rec}, m.ttl); err != nil {
+			m.log.Error("Failed to store idempotent response", slog.String("route", pattern), slog.String("err", err.Error()))
+		}
+	})
+}
+
+func (m *Idempotency) acquire(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, busy := m.inFlight[key]; busy {
+		return false
+	}
+	m.inFlight[key] = struct{}{}
+	return true
+}
+
+func (m *Idempotency) release(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.inFlight, key)
+}
+
+func (m *Idempotency) conflict(w http.ResponseWriter, r *http.Request, detail string) {
+	m.errs.Write(w, r, NewStatusError(http.StatusConflict, fmt.Errorf("%s: %q", detail, r.Header.Get(idempotencyHeader))))
+}
+
+// capturingWriter copies up to max bytes of the response body it passes
+// through.
+type capturingWriter struct {
+	*responseRecorder
+	buf      bytes.Buffer
+	max      int
+	overflow bool
+}
+
+func (w *capturingWriter) Write(b []byte) (int, error) {
+	n, err := w.responseRecorder.Write(b)
+	if !w.overflow {
+		if w.buf.Len()+n > w.max {
+			w.overflow = true
+			w.buf.Reset()
+		} else {
+			w.buf.Write(b[:n])
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+)
+
+// idempotentRoute is a route accepting idempotency keys, with the given
+// body limit.
+type idempotentRoute struct {
+	*funcRoute
+	limit int64
+}
+
+func (idempotentRoute) AcceptsIdempotencyKey() bool { return true }
+
+func (r idempotentRoute) MaxBodyBytes() int64 { return r.limit }
+
+func TestIdempotency(t *testing.T) {
+	var calls atomic.Int32
+	base := startTestApp(t, withTokens, fx.Provide(AsRoute(func() Route {
+		return idempotentRoute{funcRoute: newFuncRoute("/orders", http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			fmt.Fprintf(w, "order %d: %s", calls.Add(1), body)
+		})}
+	})))
+	order := func(token, key, content string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodPost, base+"/orders", strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(idempotencyHeader, key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp, string(b)
+	}
+
+	first, body := order("admin-token", "k1", "hello")
+	if first.StatusCode != http.StatusOK || body != "order 1: hello" {
+		t.Fatalf("first order: got %d %s", first.StatusCode, body)
+	}
+	again, againBody := order("admin-token", "k1", "hello")
+	if again.Header.Get("Idempotent-Replayed") != "true" || againBody != body {
+		t.Errorf("retried order: got %d %s, want the first response replayed", again.StatusCode, againBody)
+	}
+
+	// Another principal with the same key gets a response of its own.
+	other, otherBody := order("user-token", "k1", "hello")
+	if other.StatusCode != http.StatusOK || other.Header.Get("Idempotent-Replayed") != "" || otherBody != "order 2: hello" {
+		t.Errorf("other principal: got %d %s replayed %q, want a fresh 200", other.StatusCode, otherBody, other.Header.Get("Idempotent-Replayed"))
+	}
+
+	conflict, conflictBody := order("admin-token", "k1", "changed")
+	var p Problem
+	_ = json.Unmarshal([]byte(conflictBody), &p)
+	if conflict.StatusCode != http.StatusConflict || p.Status != http.StatusConflict || conflict.Header.Get("Content-Language") == "" {
+		t.Errorf("changed body: got %d %s, want a 409 problem from the ErrorWriter", conflict.StatusCode, conflictBody)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("route served %d times, want 2", n)
+	}
+}
+
+// endlessBody is a request body of zeros that never ends, counting the
+// bytes read from it.
+type endlessBody struct {
+	read atomic.Int64
+}
+
+func (b *endlessBody) Read(p []byte) (int, error) {
+	clear(p)
+	b.read.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func (*endlessBody) Close() error { return nil }
+
+func TestIdempotencyBodyLimit(t *testing.T) {
+	lc := fxtest.NewLifecycle(t)
+	store := NewMemorySessionStore(lc, testsupport.NewFakeClock(time.Now()))
+	cfg := &Config{}
+	m := NewIdempotency(store, cfg, newTestErrorWriter(t), slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, tc := range []struct {
+		name   string
+		limit  int64
+		status int
+		read   int64
+	}{
+		// The body is read one byte past the limit, and no further.
+		{"over the limit", 4096, http.StatusRequestEntityTooLarge, 4097},
+		// The body isn't read at all.
+		{"no limit", -1, http.StatusBadRequest, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			served := false
+			route := idempotentRoute{funcRoute: newFuncRoute("/orders", http.MethodPost, func(http.ResponseWriter, *http.Request) {
+				served = true
+			}), limit: tc.limit}
+			h := m.WrapRoute(route, route)
+
+			body := &endlessBody{}
+			req := httptest.NewRequest(http.MethodPost, "/orders", body)
+			req.ContentLength = -1
+			req.Header.Set(idempotencyHeader, "k1")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.status || served {
+				t.Errorf("got %d, served %t, want %d without serving the route", rec.Code, served, tc.status)
+			}
+			if n := body.read.Load(); n > tc.read {
+				t.Errorf("read %d bytes of the body, want at most %d", n, tc.read)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/uberfx/clock"
+)
+
+// drainMargin is the time kept from the stop timeout to report on the
+// requests left, and close them: Fx gives up waiting on a hook right at
+// its deadline.
+const drainMargin = 100 * time.Millisecond
+
+// InFlightRequests keeps the requests being served, with their route
+// and start time, to report on them while the server drains on
+// shutdown: the requests still in flight by route are logged every
+// Config.Server.DrainReportInterval, and those cut off by the stop
+// timeout with their age. Requests are tracked by the RequestLogger,
+// so only those that matched a route are.
+type InFlightRequests struct {
+	interval time.Duration
+	clock    clock.Clock
+	log      *slog.Logger
+
+	mu   sync.Mutex
+	seq  uint64
+	reqs map[uint64]inFlightRequest
+}
+
+type inFlightRequest struct {
+	route     string
+	requestID string
+	start     time.Time
+}
+
+// NewInFlightRequests builds a new InFlightRequests.
+func NewInFlightRequests(cfg *Config, clk clock.Clock, log *slog.Logger) *InFlightRequests {
+	t := &InFlightRequests{
+		interval: time.Duration(cfg.Server.DrainReportInterval),
+		clock:    clk,
+		log:      log,
+		reqs:     make(map[uint64]inFlightRequest),
+	}
+	if t.interval <= 0 {
+		t.interval = time.Second
+	}
+	return t
+}
+
+// track records a request to route started at start, until done is
+// called.
+func (t *InFlightRequests) track(route, requestID string, start time.Time) (done func()) {
+	t.mu.Lock()
+	t.seq++
+	id := t.seq
+	t.reqs[id] = inFlightRequest{route: route, requestID: requestID, start: start}
+	t.mu.Unlock()
+	return func() {
+		t.mu.Lock()
+		delete(t.reqs, id)
+		t.mu.Unlock()
+	}
+}
+
+// byRoute returns how many requests are in flight, in all and by route.
+func (t *InFlightRequests) byRoute() (int, map[string]int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	routes := make(map[string]int)
+	for _, req := range t.reqs {
+		routes[req.route]++
+	}
+	return len(t.reqs), routes
+}
+
+// Drain runs shutdown, logging the requests still in flight while it
+// waits for them. If it fails, as it does drainMargin before the
+// deadline of ctx, the requests left are logged as terminated.
+func (t *InFlightRequests) Drain(ctx context.Context, shutdown func(context.Context) error) error {
+	start := t.clock.Now()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tk := t.clock.NewTicker(t.interval)
+		defer tk.Stop()
+		for {
+			t.logProgress(start)
+			select {
+			case <-tk.C():
+			case <-stop:
+				return
+			}
+		}
+	}()
+	sctx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		sctx, cancel = context.WithDeadline(ctx, deadline.Add(-drainMargin))
+		defer cancel()
+	}
+	err := shutdown(sctx)
+	close(stop)
+	<-done
+	if err != nil {
+		t.logTerminated()
+	}
+	return err
+}
+
+// logProgress logs the requests in flight, if there are any.
+func (t *InFlightRequests) logProgress(start time.Time) {
+	n, routes := t.byRoute()
+	if n == 0 {
+		return
+	}
+	t.log.Info("Draining requests",
+		slog.Int("in_flight", n),
+		slog.Any("routes", routes),
+		slog.Duration("elapsed", t.clock.Now().Sub(start)),
+	)
+}
+
+// agedRequest is a request in flight as reported, with its age.
+type agedRequest struct {
+	Route     string `json:"route"`
+	RequestID string `json:"request_id,omitempty"`
+	Age       string `json:"age"`
+}
+
+// olderThan returns the requests in flight for at least age, oldest
+// first.
+func (t *InFlightRequests) olderThan(age time.Duration) []agedRequest {
+	now := t.clock.Now()
+	t.mu.Lock()
+	var reqs []inFlightRequest
+	for _, req := range t.reqs {
+		if now.Sub(req.start) >= age {
+			reqs = append(reqs, req)
+		}
+	}
+	t.mu.Unlock()
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].start.Before(reqs[j].start) })
+	aged := make([]agedRequest, len(reqs))
+	for i, req := range reqs {
+		aged[i] = agedRequest{
+			Route:     req.route,
+			RequestID: req.requestID,
+			Age:       now.Sub(req.start).Round(time.Millisecond).String(),
+		}
+	}
+	return aged
+}
+
+// logTerminated logs the requests in flight, oldest first, as cut off.
+func (t *InFlightRequests) logTerminated() {
+	terminated := t.olderThan(0)
+	if len(terminated) == 0 {
+		return
+	}
+	t.log.Warn("Requests terminated at the stop timeout",
+		slog.Int("count", len(terminated)),
+		slog.Any("requests", terminated),
+	)
+}
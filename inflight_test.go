@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+)
+
+// recordHandler is a slog.Handler keeping the records logged, as their
+// message and attributes.
+type recordHandler struct {
+	mu      sync.Mutex
+	records []map[string]any
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	rec := map[string]any{"msg": r.Message}
+	r.Attrs(func(a slog.Attr) bool {
+		rec[a.Key] = a.Value.Any()
+		return true
+	})
+	h.mu.Lock()
+	h.records = append(h.records, rec)
+	h.mu.Unlock()
+	return nil
+}
+
+// named returns the records logged with the given message.
+func (h *recordHandler) named(msg string) []map[string]any {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []map[string]any
+	for _, rec := range h.records {
+		if rec["msg"] == msg {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// waitRecords waits for n records with the given message to be logged
+// to h, and returns the last.
+func waitRecords(t *testing.T, h *recordHandler, msg string, n int) map[string]any {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if recs := h.named(msg); len(recs) >= n {
+			return recs[n-1]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d %q records, want %d", len(h.named(msg)), msg, n)
+		}
+	}
+}
+
+func TestInFlightDrain(t *testing.T) {
+	clk := testsupport.NewFakeClock(time.Now())
+	logs := &recordHandler{}
+	cfg := &Config{}
+	cfg.Server.DrainReportInterval = Duration(time.Second)
+	inFlight := NewInFlightRequests(cfg, clk, slog.New(logs))
+
+	// Two slow requests are held open.
+	doneSlow := inFlight.track("GET /slow", "req-1", clk.Now())
+	clk.Advance(500 * time.Millisecond)
+	inFlight.track("POST /upload", "req-2", clk.Now())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	parentDeadline, _ := ctx.Deadline()
+	err := inFlight.Drain(ctx, func(ctx context.Context) error {
+		if deadline, _ := ctx.Deadline(); !deadline.Equal(parentDeadline.Add(-drainMargin)) {
+			t.Errorf("shutdown deadline is %s before the stop timeout, want %s", parentDeadline.Sub(deadline), drainMargin)
+		}
+		rec := waitRecords(t, logs, "Draining requests", 1)
+		if rec["in_flight"] != int64(2) || fmt.Sprint(rec["routes"]) != "map[GET /slow:1 POST /upload:1]" {
+			t.Errorf("first progress record %v, want the two requests by route", rec)
+		}
+
+		clk.BlockUntil(1) // the report's ticker
+		doneSlow()
+		clk.Advance(time.Second)
+		rec = waitRecords(t, logs, "Draining requests", 2)
+		if rec["in_flight"] != int64(1) || rec["elapsed"] != time.Second {
+			t.Errorf("second progress record %v, want one request a second in", rec)
+		}
+		clk.Advance(time.Second)
+		waitRecords(t, logs, "Draining requests", 3)
+		return context.DeadlineExceeded
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want the error of the shutdown", err)
+	}
+
+	terminated := logs.named("Requests terminated at the stop timeout")
+	if len(terminated) != 1 {
+		t.Fatalf("got %d termination records, want 1", len(terminated))
+	}
+	want := "[{POST /upload req-2 2s}]"
+	if rec := terminated[0]; rec["count"] != int64(1) || fmt.Sprint(rec["requests"]) != want {
+		t.Errorf("termination record %v, want the upload, 2s old", rec)
+	}
+}
+
+func TestInFlightDrainQuiet(t *testing.T) {
+	logs := &recordHandler{}
+	inFlight := NewInFlightRequests(&Config{}, testsupport.NewFakeClock(time.Now()), slog.New(logs))
+	done := inFlight.track("GET /fast", "", time.Now())
+	done()
+	if err := inFlight.Drain(context.Background(), func(context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if len(logs.records) != 0 {
+		t.Errorf("got records %v with nothing in flight, want none", logs.records)
+	}
+}
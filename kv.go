@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"go.uber.org/fx"
+)
+
+// kvModule serves a KVStore under /kv/ from a sub-router, as a feature
+// module contributing a whole subtree of routes would.
+var kvModule = fx.Module("kv",
+	fx.Provide(
+		NewKVStore,
+		AsSubRouter("/kv/", "kv_routes"),
+		AsSubRoute("kv_routes", NewKVGetHandler),
+		AsSubRoute("kv_routes", NewKVPutHandler),
+	),
+)
+
+// KVStore is an in-memory store of values by key.
+type KVStore struct {
+	mu     sync.RWMutex
+	values map[string][]byte
+}
+
+// NewKVStore builds a new, empty KVStore.
+func NewKVStore() *KVStore {
+	return &KVStore{values: make(map[string][]byte)}
+}
+
+// KVGetHandler is an HTTP handler that returns the value of a key of
+// the KVStore, at GET /kv/{key}.
+type KVGetHandler struct {
+	store *KVStore
+	errs  *ErrorWriter
+}
+
+// NewKVGetHandler builds a new KVGetHandler.
+func NewKVGetHandler(store *KVStore, errs *ErrorWriter) *KVGetHandler {
+	return &KVGetHandler{store: store, errs: errs}
+}
+
+func (*KVGetHandler) Pattern() string {
+	return "GET /{key}"
+}
+
+func (h *KVGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := Params(r.Context())["key"]
+	h.store.mu.RLock()
+	value, ok := h.store.values[key]
+	h.store.mu.RUnlock()
+	if !ok {
+		h.errs.Write(w, r, NewStatusError(http.StatusNotFound, fmt.Errorf("no key %q", key)))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(value)
+}
+
+// KVPutHandler is an HTTP handler that sets the value of a key of the
+// KVStore to the request body, at PUT /kv/{key}.
+type KVPutHandler struct {
+	store *KVStore
+	errs  *ErrorWriter
+}
+
+// NewKVPutHandler builds a new KVPutHandler.
+func NewKVPutHandler(store *KVStore, errs *ErrorWriter) *KVPutHandler {
+	return &KVPutHandler{store: store, errs: errs}
+}
+
+func (*KVPutHandler) Pattern() string {
+	return "PUT /{key}"
+}
+
+func (h *KVPutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	value, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.errs.Write(w, r, err)
+		return
+	}
+	h.store.mu.Lock()
+	h.store.values[Params(r.Context())["key"]] = value
+	h.store.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
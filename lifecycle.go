@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"go.uber.org/fx"
+)
+
+// Component is a part of the app whose start and stop order relative to
+// other components matters. Rather than relying on the order in which
+// constructors append lifecycle hooks, components are started and
+// stopped by a single coordinator hook in priority order.
+type Component interface {
+	Name() string
+	// Priority orders components: lower priorities start first and stop
+	// last.
+	Priority() int
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+// AsComponent annotates the given constructor to state that it provides
+// a component to the "components" group.
+func AsComponent(f any) any {
+	return AsGroupMember[Component]("components", f)
+}
+
+// Priorities of the built-in components. The server stops listening and
+// drains in-flight requests before the router is torn down, and the
+// event bus outlives both so that handlers can publish until the end.
+// Mirroring to the shadow upstream stops once no more requests come in,
+// and so does the summary of sampled out access logs, and the watch of
+// the error rate.
+// The instance is announced once the server listens and deregistered
+// before it stops. A process started to take over the listener reports
+// that it's ready once everything else has started.
+const (
+	prioritySecrets    = -100
+	priorityEventBus   = -50
+	priorityRouter     = 0
+	priorityRequestLog = 25
+	priorityShadow     = 50
+	priorityHealth     = 75
+	priorityWorkers    = 80
+	priorityAlerts     = 90
+	priorityServer     = 100
+	priorityDiscovery  = 150
+	priorityRestart    = 200
+)
+
+// ComponentCoordinator starts components in ascending priority order and
+// stops them in reverse. If a component fails to start, the ones already
+// started are stopped in reverse order before the error is returned.
+type ComponentCoordinator struct {
+	components []Component
+	log        *slog.Logger
+	started    []Component
+}
+
+// NewComponentCoordinator builds a new ComponentCoordinator.
+func NewComponentCoordinator(components []Component, log *slog.Logger) *ComponentCoordinator {
+	sorted := append([]Component(nil), components...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority() < sorted[j].Priority()
+	})
+	return &ComponentCoordinator{components: sorted, log: log}
+}
+
+// Start starts every component.
+func (c *ComponentCoordinator) Start(ctx context.Context) error {
+	for _, comp := range c.components {
+		c.log.Debug("Starting component", slog.String("component", comp.Name()), slog.Int("priority", comp.Priority()))
+		if err := comp.Start(ctx); err != nil {
+			err = fmt.Errorf("start %s: %w", comp.Name(), err)
+			if stopErr := c.Stop(ctx); stopErr != nil {
+				err = errors.Join(err, stopErr)
+			}
+			return err
+		}
+		c.started = append(c.started, comp)
+	}
+	return nil
+}
+
+// Stop stops the started components in reverse order. Every component
+// is stopped even if some fail; their errors are joined.
+func (c *ComponentCoordinator) Stop(ctx context.Context) error {
+	var errs []error
+	for i := len(c.started) - 1; i >= 0; i-- {
+		comp := c.started[i]
+		c.log.Debug("Stopping component", slog.String("component", comp.Name()))
+		if err := comp.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("stop %s: %w", comp.Name(), err))
+		}
+	}
+	c.started = nil
+	return errors.Join(errs...)
+}
+
+// RegisterComponents drives the coordinator from the app lifecycle.
+func RegisterComponents(lc fx.Lifecycle, c *ComponentCoordinator) {
+	lc.Append(fx.Hook{
+		OnStart: c.Start,
+		OnStop:  c.Stop,
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/fx"
+)
+
+// recordedComponent is a Component recording its starts and stops in
+// events.
+type recordedComponent struct {
+	name     string
+	priority int
+	startErr error
+	stopErr  error
+	events   *[]string
+}
+
+func (c *recordedComponent) Name() string  { return c.name }
+func (c *recordedComponent) Priority() int { return c.priority }
+
+func (c *recordedComponent) Start(context.Context) error {
+	*c.events = append(*c.events, "start "+c.name)
+	return c.startErr
+}
+
+func (c *recordedComponent) Stop(context.Context) error {
+	*c.events = append(*c.events, "stop "+c.name)
+	return c.stopErr
+}
+
+func TestComponentCoordinator(t *testing.T) {
+	var events []string
+	c := NewComponentCoordinator([]Component{
+		&recordedComponent{name: "server", priority: priorityServer, events: &events},
+		&recordedComponent{name: "router", priority: priorityRouter, events: &events},
+		&recordedComponent{name: "bus", priority: priorityEventBus, events: &events},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start bus", "start router", "start server", "stop server", "stop router", "stop bus"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got %v, want %v", events, want)
+	}
+}
+
+func TestComponentCoordinatorUnwinds(t *testing.T) {
+	var events []string
+	errStart, errStop := errors.New("no port"), errors.New("stuck")
+	c := NewComponentCoordinator([]Component{
+		&recordedComponent{name: "a", priority: 0, events: &events},
+		&recordedComponent{name: "b", priority: 10, stopErr: errStop, events: &events},
+		&recordedComponent{name: "c", priority: 20, startErr: errStart, events: &events},
+		&recordedComponent{name: "d", priority: 30, events: &events},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	err := c.Start(context.Background())
+	if !errors.Is(err, errStart) || !errors.Is(err, errStop) {
+		t.Errorf("got %v, want the start error of c joined with the stop error of b", err)
+	}
+	// The components started before c are stopped in reverse, d is
+	// never started, and a is stopped even though b fails to.
+	want := []string{"start a", "start b", "start c", "stop b", "stop a"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got %v, want %v", events, want)
+	}
+	if err := c.Stop(context.Background()); err != nil {
+		t.Errorf("stopping again: %v", err)
+	}
+	if len(events) != len(want) {
+		t.Errorf("components stopped twice: %v", events)
+	}
+}
+
+// stopProbe is a Component calling probe before stopping.
+type stopProbe struct {
+	Component
+	probe func(name string)
+}
+
+func (c stopProbe) Stop(ctx context.Context) error {
+	c.probe(c.Name())
+	return c.Component.Stop(ctx)
+}
+
+func TestAppStopsListenerBeforeRouter(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		addr     string
+		stopped  []string
+		accepted bool
+	)
+	probe := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = append(stopped, name)
+		if name != "router" {
+			return
+		}
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			accepted = true
+		}
+	}
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		server, router := -1, -1
+		for i, name := range stopped {
+			switch name {
+			case "http-server":
+				server = i
+			case "router":
+				router = i
+			}
+		}
+		if server < 0 || router < 0 || server > router {
+			t.Errorf("got stop order %v, want http-server before router", stopped)
+		}
+		if accepted {
+			t.Error("server still accepting connections when the router stopped")
+		}
+	})
+
+	base := startTestApp(t, nil, fx.Decorate(fx.Annotate(
+		func(comps []Component) []Component {
+			probed := make([]Component, len(comps))
+			for i, c := range comps {
+				probed[i] = stopProbe{Component: c, probe: probe}
+			}
+			return probed
+		},
+		fx.ParamTags(`group:"components"`),
+		fx.ResultTags(`group:"components"`),
+	)))
+	u, err := url.Parse(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	addr = u.Host
+	mu.Unlock()
+	if status, body := do(t, "GET", base+"/ping", "", ""); status != 200 || !strings.Contains(body, "pong") {
+		t.Fatalf("GET /ping: got %d %q", status, body)
+	}
+}
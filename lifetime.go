@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/fx"
+)
+
+// ShutdownRecorder records why the app is shutting down. Code stopping
+// the app goes through its Shutdown rather than the fx.Shutdowner, and
+// the signals stopping it are recorded by the LifetimeStats. The first
+// reason recorded is kept.
+type ShutdownRecorder struct {
+	shutdowner fx.Shutdowner
+
+	mu     sync.Mutex
+	reason string
+}
+
+// NewShutdownRecorder builds a new ShutdownRecorder.
+func NewShutdownRecorder(shutdowner fx.Shutdowner) *ShutdownRecorder {
+	return &ShutdownRecorder{shutdowner: shutdowner}
+}
+
+// Shutdown records reason and shuts the app down.
+func (s *ShutdownRecorder) Shutdown(reason string, opts ...fx.ShutdownOption) error {
+	s.Record(reason)
+	return s.shutdowner.Shutdown(opts...)
+}
+
+// Record records reason, unless another one already was.
+func (s *ShutdownRecorder) Record(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reason == "" {
+		s.reason = reason
+	}
+}
+
+// Reason returns the recorded reason, or "stopped" if the app was
+// stopped without one, as fxtest apps are.
+func (s *ShutdownRecorder) Reason() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reason == "" {
+		return "stopped"
+	}
+	return s.reason
+}
+
+// LifetimeSummary is what the app served over its lifetime.
+type LifetimeSummary struct {
+	Uptime   time.Duration
+	Reason   string
+	Requests map[string]int64
+	// ClientErrors and ServerErrors count the 4xx and 5xx responses.
+	ClientErrors    int64
+	ServerErrors    int64
+	BytesIn         int64
+	BytesOut        int64
+	PeakConnections int
+}
+
+// LogValue represents the summary as a group with the requests by
+// route in a subgroup.
+func (s LifetimeSummary) LogValue() slog.Value {
+	routes := make([]string, 0, len(s.Requests))
+	var total int64
+	for route, n := range s.Requests {
+		routes = append(routes, route)
+		total += n
+	}
+	sort.Strings(routes)
+	byRoute := make([]slog.Attr, 0, len(routes))
+	for _, route := range routes {
+		byRoute = append(byRoute, slog.Int64(route, s.Requests[route]))
+	}
+	return slog.GroupValue(
+		slog.Duration("uptime", s.Uptime),
+		slog.String("reason", s.Reason),
+		slog.Int64("requests", total),
+		slog.Attr{Key: "routes", Value: slog.GroupValue(byRoute...)},
+		slog.Int64("client_errors", s.ClientErrors),
+		slog.Int64("server_errors", s.ServerErrors),
+		slog.Int64("bytes_in", s.BytesIn),
+		slog.Int64("bytes_out", s.BytesOut),
+		slog.Int("peak_connections", s.PeakConnections),
+	)
+}
+
+// LifetimeStats logs a summary of what the app served once it has
+// stopped: the requests by route, the errors, the body bytes read and
+// written, the peak number of connections, the uptime and why it shut
+// down. The figures are those of the metrics the route middleware and
+// the ConnTracker keep, so they cover the routes those see.
+type LifetimeStats struct {
+	reg      *prometheus.Registry
+	conns    *ConnTracker
+	shutdown *ShutdownRecorder
+	log      *slog.Logger
+	now      func() time.Time
+
+	started time.Time
+	signals chan os.Signal
+}
+
+// NewLifetimeStats builds a new LifetimeStats.
+func NewLifetimeStats(reg *prometheus.Registry, conns *ConnTracker, shutdown *ShutdownRecorder, log *slog.Logger) *LifetimeStats {
+	return &LifetimeStats{reg: reg, conns: conns, shutdown: shutdown, log: log, now: time.Now}
+}
+
+// RegisterLifetimeStats hooks s into the lifecycle. It must be invoked
+// before anything else appends hooks for its OnStop hook to run last.
+func RegisterLifetimeStats(lc fx.Lifecycle, s *LifetimeStats) {
+	lc.Append(fx.Hook{OnStart: s.start, OnStop: s.stop})
+}
+
+func (s *LifetimeStats) start(context.Context) error {
+	s.started = s.now()
+	// Fx is notified of the same signals and stops the app on them.
+	s.signals = make(chan os.Signal, 1)
+	signal.Notify(s.signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range s.signals {
+			s.shutdown.Record("signal: " + sig.String())
+		}
+	}()
+	return nil
+}
+
+func (s *LifetimeStats) stop(context.Context) error {
+	signal.Stop(s.signals)
+	close(s.signals)
+	summary, err := s.Summary()
+	if err != nil {
+		return err
+	}
+	s.log.Info("Lifetime summary", slog.Any("lifetime", summary))
+	return nil
+}
+
+// Summary returns the figures so far.
+func (s *LifetimeStats) Summary() (LifetimeSummary, error) {
+	families, err := s.reg.Gather()
+	if err != nil {
+		return LifetimeSummary{}, err
+	}
+	summary := LifetimeSummary{
+		Uptime:          s.now().Sub(s.started),
+		Reason:          s.shutdown.Reason(),
+		Requests:        make(map[string]int64),
+		PeakConnections: s.conns.Stats().Peak,
+	}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			n := int64(m.GetCounter().GetValue())
+			switch f.GetName() {
+			case "http_requests_total":
+				var route, status string
+				for _, l := range m.GetLabel() {
+					switch l.GetName() {
+					case "route":
+						route = l.GetValue()
+					case "status":
+						status = l.GetValue()
+					}
+				}
+				summary.Requests[route] += n
+				switch code, _ := strconv.Atoi(status); {
+				case code >= 500:
+					summary.ServerErrors += n
+				case code >= 400:
+					summary.ClientErrors += n
+				}
+			case "http_request_body_bytes_total":
+				summary.BytesIn += n
+			case "http_response_body_bytes_total":
+				summary.BytesOut += n
+			}
+		}
+	}
+	return summary, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+
+	"example.com/uberfx/ctxslog"
+)
+
+// The names of the loggers of the app's components, see LoggerFactory.
+const (
+	loggerAccess = "http.access"
+	loggerAudit  = "audit"
+	loggerFx     = "fx"
+)
+
+// LoggerFactory hands out the loggers of the components noisy or
+// important enough to be tuned apart from the rest of the app, by name:
+// "http.access" for the access log, "audit" for the audit log and "fx"
+// for Fx's own events. Config.Log.Loggers sets, by name, the least level
+// of their records and where they're written, as a separate output to
+// stdout, stderr or a file. Loggers not configured, including those of
+// unknown names, are the app's logger itself.
+//
+// Separate outputs write JSON lines, or key=value pairs with the "text"
+// format, carrying the same static and request-scoped fields as the
+// records of the app's logger. Files are rotated once they reach their
+// MaxSizeBytes, see rotatingFile, and closed by Close.
+type LoggerFactory struct {
+	root    *slog.Logger
+	loggers map[string]*slog.Logger
+	closers []io.Closer
+}
+
+// loggerSpec is the effective configuration of a named logger.
+type loggerSpec struct {
+	NamedLoggerConfig
+	// perm is the mode of the file output, if created.
+	perm os.FileMode
+}
+
+// NewLoggerFactory builds the loggers configured by Config.Log.Loggers
+// on top of root, the app's logger. The access and audit logs keep
+// their own settings, Config.Log.Access and Config.Audit, as the
+// defaults of theirs.
+func NewLoggerFactory(cfg *Config, root *slog.Logger) (*LoggerFactory, error) {
+	specs := map[string]loggerSpec{
+		loggerAccess: {NamedLoggerConfig: NamedLoggerConfig{
+			Output:       cfg.Log.Access.Output,
+			Format:       cfg.Log.Access.Format,
+			MaxSizeBytes: cfg.Log.Access.MaxSizeBytes,
+			MaxBackups:   cfg.Log.Access.MaxBackups,
+		}, perm: 0o644},
+		// Audit entries have always been kept apart, on stderr by
+		// default, and their file is never rotated away.
+		loggerAudit: {NamedLoggerConfig: NamedLoggerConfig{
+			Output:       cmp.Or(cfg.Audit.Path, "stderr"),
+			MaxSizeBytes: -1,
+		}, perm: 0o600},
+	}
+	for name, c := range cfg.Log.Loggers {
+		spec := specs[name]
+		mergeValue(reflect.ValueOf(&spec.NamedLoggerConfig).Elem(), reflect.ValueOf(c))
+		if spec.perm == 0 {
+			spec.perm = 0o644
+		}
+		specs[name] = spec
+	}
+
+	f := &LoggerFactory{root: root, loggers: make(map[string]*slog.Logger, len(specs))}
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log, err := f.build(cfg, specs[name])
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("logger %s: %w", name, err), f.Close())
+		}
+		if log != nil {
+			f.loggers[name] = log
+		}
+	}
+	return f, nil
+}
+
+// build builds the logger of spec, or returns nil if it's the root
+// logger unchanged.
+func (f *LoggerFactory) build(cfg *Config, spec loggerSpec) (*slog.Logger, error) {
+	var level slog.Leveler
+	if spec.Level != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(spec.Level)); err != nil {
+			return nil, fmt.Errorf("invalid level %q", spec.Level)
+		}
+		level = l
+	}
+
+	var w io.Writer
+	switch spec.Output {
+	case "":
+		if level == nil {
+			return nil, nil
+		}
+		return slog.New(&levelHandler{level: level, next: f.root.Handler()}), nil
+	case "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		var (
+			file io.WriteCloser
+			err  error
+		)
+		if spec.MaxSizeBytes < 0 {
+			file, err = os.OpenFile(spec.Output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, spec.perm)
+		} else {
+			file, err = newRotatingFile(spec.Output, spec.MaxSizeBytes, spec.MaxBackups, spec.perm)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("open log: %w", err)
+		}
+		f.closers = append(f.closers, file)
+		w = file
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch spec.Format {
+	case "", "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", spec.Format)
+	}
+	return slog.New(ctxslog.New(withStaticFields(h, cfg))), nil
+}
+
+// Named returns the logger of the given name, or the app's logger if
+// it isn't configured.
+func (f *LoggerFactory) Named(name string) *slog.Logger {
+	if log, ok := f.loggers[name]; ok {
+		return log
+	}
+	return f.root
+}
+
+// Close closes the files the loggers write to.
+func (f *LoggerFactory) Close() error {
+	var errs []error
+	for _, c := range f.closers {
+		errs = append(errs, c.Close())
+	}
+	f.closers = nil
+	return errors.Join(errs...)
+}
+
+// levelHandler is a slog.Handler dropping the records of next under a
+// level.
+type levelHandler struct {
+	level slog.Leveler
+	next  slog.Handler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return l >= h.level.Level() && h.next.Enabled(ctx, l)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{level: h.level, next: h.next.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{level: h.level, next: h.next.WithGroup(name)}
+}
@@ -2,126 +2,658 @@ package main
 
 import (
 	"context"
+	"errors"
+	"example.com/uberfx/clock"
+	"example.com/uberfx/ctxslog"
+	"example.com/uberfx/httpjson"
+	"example.com/uberfx/spanlog"
+	"example.com/uberfx/stream"
+	"flag"
 	"fmt"
 	"github.com/samber/slog-zap/v2"
 	"go.uber.org/fx"
 	"go.uber.org/fx/fxevent"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"io"
 	"log/slog"
-	"net"
 	"net/http"
 	"os"
+	"slices"
+	"sync"
+	"time"
 )
 
 func main() {
-	fx.New(
-		fx.Provide(NewLogger),
-		fx.WithLogger(func(log *slog.Logger) fxevent.Logger {
-			return &fxevent.SlogLogger{Logger: log}
+	smoke := flag.Bool("smoke", false, "run the smoke checks and exit")
+	target := flag.String("target", "", "base URL to run the smoke checks against; by default the app is started on an ephemeral port")
+	preflight := flag.Bool("preflight-only", false, "run the preflight checks of the app's prerequisites and exit")
+	replay := flag.String("replay", "", "replay the request recorded in the given file against the app started on an ephemeral port, print the response and exit")
+	env := flag.String("env", "", "environment to run in, selecting the config overlay; by default the env set in the config")
+	flag.Parse()
+
+	withEnv := WithEnv(*env)
+	if *smoke {
+		os.Exit(RunSmoke(*target, withEnv))
+	}
+	if *preflight {
+		os.Exit(RunPreflight(withEnv))
+	}
+	if *replay != "" {
+		os.Exit(RunReplay(*replay, withEnv))
+	}
+	NewApp(withEnv).Run()
+}
+
+// NewApp builds the Fx application with the given extra options.
+func NewApp(opts ...fx.Option) *fx.App {
+	logger := &onceLogger{}
+	provisions := newProvisions()
+	var env ConfigEnv
+	for _, opt := range opts {
+		if e, ok := opt.(envOption); ok {
+			env = e.env
+		}
+	}
+	all := append(append(bootstrapOptions(env), []fx.Option{
+		fx.Module("logging",
+			fx.Provide(
+				logger.build,
+				logger.factory,
+				fx.Annotate(NewAccessLogger, fx.ResultTags(`name:"access"`)),
+			),
+		),
+		// The Fx event logger can't depend on the logger like other
+		// components do: if building it fails, the failure must still be
+		// reported, so a bootstrap logger is used instead.
+		fx.WithLogger(func(cfg *Config) fxevent.Logger {
+			log := newBootstrapLogger()
+			if loggers, err := logger.buildFactory(cfg); err == nil {
+				log = loggers.Named(loggerFx)
+			}
+			return provisions.Logger(NewFxLogger(log, cfg))
 		}),
-		fx.Supply(&Config{Env: "development"}),
+		fx.Supply(provisions),
+		fx.Provide(
+			fx.Annotate(NewConfig, fx.ParamTags(`optional:"true"`)),
+			clock.Real,
+		),
 		fx.Provide(
-			NewHTTPServer,
+			fx.Annotate(
+				NewHTTPServer,
+				fx.ParamTags("", "", "", `group:"middleware"`),
+			),
+			NewServerInfo,
+			NewConnTracker,
+			AsRoute(NewDebugStatsHandler),
+			NewInFlightRequests,
+			AsComponent(NewServerComponent),
+			NewProxyProtocol,
+			AsComponent(NewRouterComponent),
+			NewAnnouncer,
+			AsComponent(NewDiscoveryComponent),
+			AsComponent(NewRestarter),
+			fx.Annotate(
+				NewComponentCoordinator,
+				fx.ParamTags(`group:"components"`),
+			),
+			fx.Annotate(
+				NewEventBus,
+				fx.ParamTags(`group:"subscribers"`),
+			),
+			AsComponent(func(b *EventBus) *EventBus { return b }),
+			AsSubscriber(NewLogSubscriber),
+			fx.Annotate(NewConfigFlags, fx.As(fx.Self()), fx.As(new(FeatureFlags))),
+			AsMiddleware(NewRequestContext),
+			NewAPIResponses,
+			AsMiddleware(func(m *APIResponses) *APIResponses { return m }),
+			AsRouteMiddleware(func(m *APIResponses) *APIResponses { return m }),
+			NewServerTLS,
+			AsMiddleware(NewTLSContext),
+			AsRoute(NewTLSDebugHandler),
+			AsRegistrar(NewFlagsHandler),
+			NewReadiness,
+			AsRoute(NewReadyzHandler),
+			fx.Annotate(
+				NewHealthProber,
+				fx.ParamTags(`group:"health_checkers"`),
+			),
+			AsComponent(func(p *HealthProber) *HealthProber { return p }),
+			AsRoute(NewHealthzHandler),
+			fx.Annotate(
+				NewErrorRateMonitor,
+				fx.ParamTags(`group:"alerters"`),
+			),
+			AsComponent(func(m *ErrorRateMonitor) *ErrorRateMonitor { return m }),
+			AsAlerter(NewLogAlerter),
+			NewDependencyGuard,
+			AsRouteMiddleware(func(g *DependencyGuard) *DependencyGuard { return g }),
+			AsRoute(NewDashboardHandler),
+			AsRoute(NewSelfTestHandler),
+			NewMaintenance,
+			AsMiddleware(func(m *Maintenance) *Maintenance { return m }),
+			AsRegistrar(NewMaintenanceHandler),
+			NewRouteToggles,
+			AsRouteMiddleware(func(t *RouteToggles) *RouteToggles { return t }),
+			AsRegistrar(NewRouteTogglesHandler),
+			fx.Annotate(
+				NewWarmupCoordinator,
+				fx.ParamTags(`group:"warmers"`),
+			),
+			NewMetricsRegistry,
+			NewCatalog,
+			NewErrorWriter,
+			fx.Annotate(
+				NewHTTPClient,
+				fx.ParamTags("", "", "", "", "", `optional:"true"`),
+			),
+			NewHeaderPropagator,
+			AsMiddleware(NewInboundHeaders),
+			fx.Annotate(NewSlogAuditSink, fx.As(new(AuditSink))),
+			NewAuditLogger,
+			AsGroupMember[Middleware](
+				"middleware",
+				NewPathNormalizer,
+				fx.ParamTags("", `group:"routes"`),
+			),
+			NewBodyLimit,
+			AsMiddleware(func(m *BodyLimit) *BodyLimit { return m }),
+			AsRouteMiddleware(func(m *BodyLimit) *BodyLimit { return m }),
+			AsMiddleware(NewBodyDrainer),
+			AsMiddleware(NewGzipDecoder),
+			AsMiddleware(NewRequestDeadline),
+			AsMiddleware(NewQueueTimer),
+			AsMiddleware(NewAuditMiddleware),
+			fx.Annotate(NewMemorySessionStore, fx.As(new(SessionStore))),
+			NewSessionManager,
+			AsComponent(NewSecretReloader),
+			AsRoute(NewDebugConfigHandler),
+			AsMiddleware(NewSessionMiddleware),
+			AsMiddleware(NewBearerAuth),
+			fx.Annotate(NewStaticTokens, fx.As(new(TokenValidator))),
+			NewAPIKeyStore,
+			NewAPIKeyAuth,
+			AsMiddleware(func(m *APIKeyAuth) *APIKeyAuth { return m }),
+			AsRoute(NewAPIKeyUsageHandler),
+			AsRouteMiddleware(NewAuthorization),
+			NewCSRFTokens,
+			AsRouteMiddleware(NewCSRFMiddleware),
+			AsRouteMiddleware(NewIdempotency),
+			AsRouteMiddleware(NewCoalescer),
+			AsRouteMiddleware(NewHeaderPolicy),
+			fx.Annotate(NewRequestLogger, fx.ParamTags("", "", `name:"access"`)),
+			AsRouteMiddleware(func(m *RequestLogger) *RequestLogger { return m }),
+			AsComponent(func(m *RequestLogger) *RequestLogger { return m }),
+			AsRouteMiddleware(NewDeprecations),
+			AsRouteMiddleware(NewByteCounter),
+			AsRouteMiddleware(NewConcurrencyLimiter),
+			AsRouteMiddleware(NewCallBudgets),
+			AsRouteMiddleware(NewContentTypes),
+			NewDebugDump,
+			AsRouteMiddleware(func(d *DebugDump) *DebugDump { return d }),
+			AsRegistrar(NewDebugDumpHandler),
+			NewCaptures,
+			AsRouteMiddleware(func(c *Captures) *Captures { return c }),
+			AsRegistrar(NewCapturesHandler),
+			NewReplayRecorder,
+			AsRouteMiddleware(func(m *ReplayRecorder) *ReplayRecorder { return m }),
+			AsRegistrar(NewReplayRecorderHandler),
+			NewShadowMirror,
+			AsRouteMiddleware(func(m *ShadowMirror) *ShadowMirror { return m }),
+			AsComponent(func(m *ShadowMirror) *ShadowMirror { return m }),
+			AsRoute(NewCSRFHandler),
+			NewWorkerPool,
+			AsComponent(func(p *WorkerPool) *WorkerPool { return p }),
 			AsRoute(NewEchoHandler),
+			fx.Annotate(NewTransformers, fx.ParamTags("", `group:"transformers"`)),
+			AsTransformer(NewUpperTransformer),
+			AsTransformer(NewLowerTransformer),
+			AsTransformer(NewReverseTransformer),
+			AsTransformer(NewBase64Transformer),
+			AsTransformer(NewROT13Transformer),
+			AsRoute(NewEchoHashHandler),
+			AsRoute(NewEchoMultipartHandler),
+			AsRoute(NewEchoNDJSONHandler),
+			fx.Annotate(NewMemoryQuotaStore, fx.As(new(QuotaStore))),
+			NewQuotaTracker,
+			AsRoute(NewUploadHandler),
+			NewGreeter,
 			AsRoute(NewHelloHandler),
+			NewGreetingStats,
+			AsRegistrar(NewGreetingStatsHandler),
+			AsRoute(NewProxyHelloHandler),
+			AsRoute(NewMetricsHandler),
+			NewDebugVars,
+			AsRoute(NewDebugVarsHandler),
+			AsRoute(NewErrorsHandler),
+			AsRoute(NewGraphHandler),
+			NewStaticHandler,
+			AsRoute(func(h *StaticHandler) *StaticHandler { return h }),
+			NewRootHandler,
+			fx.Annotate(NewRedirects, fx.ResultTags(`group:"routes,flatten"`)),
+			AsGroupMember[Middleware](
+				"middleware",
+				NewHostRouter,
+				fx.ParamTags("", `group:"virtual_hosts"`),
+			),
 			fx.Annotate(
 				NewServeMux,
-				fx.ParamTags("", `group:"routes"`),
+				fx.ParamTags("", "", "", "", "", `group:"routes"`, `group:"registrars"`, `group:"route_middleware"`),
 			),
+			NewRouteTable,
+			NewHookRegistry,
+			AsRoute(NewDebugHooksHandler),
+			NewBuildInfo,
+			NewShutdownRecorder,
+			NewLifetimeStats,
 		),
-		fx.Invoke(func(server *http.Server) {}),
-		fx.Decorate(func(l *slog.Logger, c *Config) *slog.Logger {
-			return l.With(slog.String("app", c.Env))
+		kvModule,
+		ProvideRoute("/ping", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "pong")
 		}),
-	).Run()
+		fx.Provide(DefaultSmokeChecks...),
+		fx.Provide(
+			AsPreflightCheck(NewListenAddrCheck),
+			AsPreflightCheck(NewTLSFilesCheck),
+			AsPreflightCheck(NewStaticDirCheck),
+			AsPreflightCheck(NewRecordDirCheck),
+		),
+		// Invoked before anything else is built, so that missing
+		// prerequisites are all reported at once.
+		fx.Invoke(fx.Annotate(CheckPreflight, fx.ParamTags(`group:"preflight"`))),
+		// Invoked first so that its OnStop hook runs once all the others
+		// have stopped.
+		fx.Invoke(RegisterLifetimeStats),
+		fx.Invoke(checkValueGroups),
+		fx.Invoke(LogConfigSources),
+		fx.Invoke(CheckRedirects),
+		fx.Invoke(RegisterComponents),
+		fx.Invoke(RegisterWarmup),
+		fx.Decorate(NewShutdownSupervisor),
+	}...), append(opts,
+		// Appended last so that its hook runs once all the others have
+		// started.
+		fx.Invoke(RegisterReadyBanner),
+	)...)
+
+	// dig's errors for missing providers don't say where to add them, so
+	// the app is validated first and the common wiring mistakes are
+	// reported with a hint, by an app that only fails.
+	if err := explainAppError(fx.ValidateApp(append(all, fx.NopLogger)...)); err != nil {
+		var hint *HintError
+		if errors.As(err, &hint) {
+			return fx.New(
+				fx.WithLogger(func() fxevent.Logger {
+					return &fxevent.SlogLogger{Logger: newBootstrapLogger()}
+				}),
+				fx.Error(err),
+			)
+		}
+	}
+	return fx.New(all...)
 }
 
-type Config struct {
-	Env string `json:"env"`
+// bootstrapOptions returns the app options that must be known before the
+// container is built, and so can't be taken from the Config it
+// provides. The config is loaded an extra time up front for them; load
+// errors are left to be reported by the container.
+func bootstrapOptions(env ConfigEnv) []fx.Option {
+	cfg, err := NewConfig(env)
+	if err != nil {
+		return nil
+	}
+	var opts []fx.Option
+	if t := cfg.App.StartTimeout; t > 0 {
+		opts = append(opts, fx.StartTimeout(time.Duration(t)))
+	}
+	if t := cfg.App.StopTimeout; t > 0 {
+		opts = append(opts, fx.StopTimeout(time.Duration(t)))
+	}
+	return opts
 }
 
-// NewHTTPServer builds an HTTP server that will begin serving requests
-// when the Fx application starts.
-func NewHTTPServer(lc fx.Lifecycle, cfg *Config, mux *http.ServeMux) *http.Server {
-	srv := &http.Server{Addr: ":8098", Handler: mux}
-	lc.Append(fx.Hook{
-		OnStart: func(ctx context.Context) error {
-			ln, err := net.Listen("tcp", srv.Addr)
-			if err != nil {
-				return err
-			}
-			fmt.Println("Starting HTTP server at", srv.Addr, "in", cfg.Env, "mode")
-			go func() {
-				err := srv.Serve(ln)
-				if err != nil {
-					fmt.Println("HTTP server error:", err)
-				}
-			}()
-			return nil
-		},
-		OnStop: func(ctx context.Context) error {
-			return srv.Shutdown(ctx)
-		},
-	})
-	return srv
+// NewHTTPServer builds an HTTP server that routes requests through the
+// server-wide middleware to mux. It's started by the ServerComponent.
+func NewHTTPServer(cfg *Config, mux Router, conns *ConnTracker, mws []Middleware, tls *ServerTLS) *http.Server {
+	return &http.Server{
+		Addr:      serverAddr(cfg),
+		Handler:   Chain(mux, sortByOrder(mws)...),
+		ConnState: conns.ConnState,
+		TLSConfig: tls.Config(),
+	}
+}
+
+// serverAddr returns the address the server listens on.
+func serverAddr(cfg *Config) string {
+	if cfg.Server.Addr == "" {
+		return ":8098"
+	}
+	return cfg.Server.Addr
+}
+
+// ServerComponent is the component that begins serving requests when
+// the Fx application starts.
+type ServerComponent struct {
+	srv      *http.Server
+	cfg      *Config
+	info     *ServerInfo
+	proxy    *ProxyProtocol
+	inFlight *InFlightRequests
+	log      *slog.Logger
+}
+
+// NewServerComponent builds a new ServerComponent.
+func NewServerComponent(srv *http.Server, cfg *Config, info *ServerInfo, proxy *ProxyProtocol, inFlight *InFlightRequests, log *slog.Logger) *ServerComponent {
+	return &ServerComponent{srv: srv, cfg: cfg, info: info, proxy: proxy, inFlight: inFlight, log: log}
+}
+
+func (*ServerComponent) Name() string {
+	return "http-server"
+}
+
+func (*ServerComponent) Priority() int {
+	return priorityServer
+}
+
+func (c *ServerComponent) Start(ctx context.Context) error {
+	ln, err := listen(publicSocket, c.srv.Addr, c.log)
+	if err != nil {
+		return err
+	}
+	c.info.setListener(ln)
+	warnUnusedSockets(c.log, publicSocket)
+	fmt.Println("Starting HTTP server at", ln.Addr(), "in", c.cfg.Env, "mode")
+	go func() {
+		var err error
+		if c.srv.TLSConfig != nil {
+			err = c.srv.ServeTLS(c.proxy.Listener(ln), "", "")
+		} else {
+			err = c.srv.Serve(c.proxy.Listener(ln))
+		}
+		if err != nil {
+			fmt.Println("HTTP server error:", err)
+		}
+	}()
+	return nil
+}
+
+// Stop drains the requests in flight, reporting on them as it goes, and
+// closes the connections of those left once ctx is done.
+func (c *ServerComponent) Stop(ctx context.Context) error {
+	err := c.inFlight.Drain(ctx, c.srv.Shutdown)
+	if err != nil {
+		_ = c.srv.Close()
+	}
+	return err
 }
 
 // EchoHandler is an http.Handler that copies its request body
-// back to the response.
+// back to the response, passed through the transformers listed in the
+// transform query parameter, if any. With ?encoding=hex or base64 the
+// body is echoed encoded, as text wrapped at Config.Echo.EncodingWidth,
+// for binary payloads to be read in a terminal. Transformed echoes go
+// through the WorkerPool if the route opts in.
 type EchoHandler struct {
-	log *slog.Logger
+	log           *slog.Logger
+	errs          *ErrorWriter
+	transformers  *Transformers
+	pool          *WorkerPool
+	flushInterval time.Duration
+	encodingWidth int
 }
 
 // NewEchoHandler builds a new EchoHandler.
-func NewEchoHandler(l *slog.Logger) *EchoHandler {
-	return &EchoHandler{
-		log: l,
+func NewEchoHandler(l *slog.Logger, errs *ErrorWriter, transformers *Transformers, pool *WorkerPool, cfg *Config) *EchoHandler {
+	h := &EchoHandler{
+		log:           l,
+		errs:          errs,
+		transformers:  transformers,
+		pool:          pool,
+		flushInterval: time.Duration(cfg.Echo.FlushInterval),
+		encodingWidth: cfg.Echo.EncodingWidth,
 	}
+	if h.flushInterval <= 0 {
+		h.flushInterval = 100 * time.Millisecond
+	}
+	if h.encodingWidth == 0 {
+		h.encodingWidth = 76
+	}
+	return h
 }
 
 // ServeHTTP handles an HTTP request to the /echo endpoint.
 func (h *EchoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.log.Info("Handling request", slog.String("path", r.URL.Path))
-	if _, err := io.Copy(w, r.Body); err != nil {
-		_, err := fmt.Fprintln(os.Stderr, "Failed to handle request:", err)
+	h.log.InfoContext(r.Context(), "Handling request", slog.String("path", r.URL.Path))
+	var body io.Reader = r.Body
+	transformed := false
+	if list := r.URL.Query().Get("transform"); list != "" {
+		pipeline, err := h.transformers.Pipeline(list)
 		if err != nil {
-			fmt.Println("Failed to write error:", err)
+			h.errs.Write(w, r, err)
+			return
+		}
+		body = pipeline(body)
+		transformed = true
+	}
+	var newEncoder func(io.Writer) io.WriteCloser
+	if name := r.URL.Query().Get("encoding"); name != "" {
+		var ok bool
+		if newEncoder, ok = echoEncodings[name]; !ok {
+			h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, fmt.Errorf("unknown encoding %q, want hex or base64", name)))
+			return
 		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	// Over HTTP/1.1 the server stops reading the request body once the
+	// response starts going out, which would cut long echoes short.
+	_ = http.NewResponseController(w).EnableFullDuplex()
+	sw := stream.New(w, r, stream.Options{FlushInterval: h.flushInterval, Log: h.log})
+	echo := func() error {
+		if newEncoder == nil {
+			_, err := io.Copy(sw, body)
+			return err
+		}
+		return h.copyEncoded(sw, body, newEncoder)
+	}
+	var err error
+	if transformed {
+		err = h.pool.Run(r.Context(), h.Pattern(), echo)
+	} else {
+		err = echo()
+	}
+	if err == nil {
+		err = sw.Flush()
+	}
+	if errors.Is(err, stream.ErrDisconnected) {
+		h.log.DebugContext(r.Context(), "Client left during echo", slog.Int64("bytes", sw.Bytes()))
+		return
+	}
+	if err != nil {
+		// Errors found before anything was echoed, such as a body too
+		// large to transform, can still be reported.
+		h.errs.SafeError(w, r, err)
+	}
+}
 
+// copyEncoded copies body to w encoded with the given encoder, in lines
+// of the configured width.
+func (h *EchoHandler) copyEncoded(w io.Writer, body io.Reader, newEncoder func(io.Writer) io.WriteCloser) error {
+	lines := &lineWrapper{w: w, width: h.encodingWidth}
+	enc := newEncoder(lines)
+	if _, err := io.Copy(enc, body); err != nil {
+		return err
 	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return lines.Close()
 }
 
 func (h *EchoHandler) Pattern() string {
 	return "/echo"
 }
 
-// NewServeMux builds a ServeMux that will route requests
-// to the given EchoHandler.
-func NewServeMux(lc fx.Lifecycle, routes []Route) *http.ServeMux {
-	lc.Append(fx.Hook{
-		OnStart: func(ctx context.Context) error {
-			fmt.Println("starting mux")
-			return nil
-		},
-		OnStop: func(ctx context.Context) error {
-			fmt.Println("stopping mux")
-			return nil
-		},
-	})
-	mux := http.NewServeMux()
+// NewServeMux builds a Router, using the backend selected in the
+// config, that will route requests to the given routes and to those the
+// registrars register, each wrapped with the route middleware. Two
+// registrations of the same pattern are reported as an error, and so is
+// a routes group of built-in routes only unless Config.Server.EmptyRoutes
+// says otherwise, see checkRoutes. GET / is answered by root unless a route handles it.
+// The registered patterns are listed in table.
+func NewServeMux(cfg *Config, log *slog.Logger, errs *ErrorWriter, table *RouteTable, root *RootHandler, routes []Route, registrars []RouteRegistrar, mws []RouteMiddleware) (Router, error) {
+	mux, err := NewRouter(cfg.Server.Router)
+	if err != nil {
+		return nil, err
+	}
+	rec := newRecordingRouter(mux, sortByOrder(mws), table)
 	for _, route := range routes {
-		mux.Handle(route.Pattern(), route)
+		rec.source = fmt.Sprintf("route %T", route)
+		method, pattern := splitPattern(route.Pattern())
+		rec.Handle(method, pattern, route)
+	}
+	for _, reg := range registrars {
+		rec.source = fmt.Sprintf("registrar %T", reg)
+		reg.RegisterRoutes(rec)
+	}
+	if appRoutes(routes) == 0 {
+		if err := checkRoutes(cfg, rec, table, errs, log); err != nil {
+			return nil, err
+		}
+	} else {
+		root.register(rec)
+	}
+	if rec.err != nil {
+		return nil, rec.err
+	}
+	return mux, nil
+}
+
+// RouterComponent is the component owning the router. It starts before
+// and stops after the server, so the server never routes requests to a
+// stopped router.
+type RouterComponent struct {
+	mux Router
+}
+
+// NewRouterComponent builds a new RouterComponent.
+func NewRouterComponent(mux Router) *RouterComponent {
+	return &RouterComponent{mux: mux}
+}
+
+func (*RouterComponent) Name() string {
+	return "router"
+}
+
+func (*RouterComponent) Priority() int {
+	return priorityRouter
+}
+
+func (*RouterComponent) Start(context.Context) error {
+	fmt.Println("starting mux")
+	return nil
+}
+
+func (*RouterComponent) Stop(context.Context) error {
+	fmt.Println("stopping mux")
+	return nil
+}
+
+// NewLogger builds the app's logger: human-readable by default, or
+// with Config.Log.Format set to "json" one JSON object per record,
+// named as Config.Log.Keys says. Every record carries the app's env as
+// "app" and the static fields of Config.Log.Fields, and those logged
+// with a request's context its request-scoped values, see ctxslog.
+func NewLogger(cfg *Config) (*slog.Logger, error) {
+	zc := zap.NewDevelopmentConfig()
+	switch cfg.Log.Format {
+	case "", "console":
+	case "json":
+		zc.Encoding = "json"
+		zc.EncoderConfig = zap.NewProductionEncoderConfig()
+		zc.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Log.Format)
+	}
+	keys := cfg.Log.Keys
+	for _, k := range []struct {
+		to   *string
+		name string
+	}{
+		{&zc.EncoderConfig.TimeKey, keys.Time},
+		{&zc.EncoderConfig.LevelKey, keys.Level},
+		{&zc.EncoderConfig.MessageKey, keys.Message},
+	} {
+		if k.name != "" {
+			*k.to = k.name
+		}
+	}
+	z, err := zc.Build()
+	if err != nil {
+		return nil, fmt.Errorf("build logger: %w", err)
+	}
+	return slog.New(ctxslog.New(withStaticFields(slogzap.Option{Logger: z}.NewZapHandler(), cfg))), nil
+}
+
+// withStaticFields returns h adding the app's env and the static fields
+// of the config to every record. The fields are added with WithAttrs,
+// so they stay at the top level of records logged in a group, whatever
+// the handler.
+func withStaticFields(h slog.Handler, cfg *Config) slog.Handler {
+	attrs := make([]slog.Attr, 0, 1+len(cfg.Log.Fields))
+	attrs = append(attrs, slog.String("app", cfg.Env))
+	names := make([]string, 0, len(cfg.Log.Fields))
+	for name := range cfg.Log.Fields {
+		names = append(names, name)
 	}
-	return mux
+	slices.Sort(names)
+	for _, name := range names {
+		attrs = append(attrs, slog.String(name, cfg.Log.Fields[name]))
+	}
+	return h.WithAttrs(attrs)
+}
+
+// onceLogger builds the app's logger and its LoggerFactory at most
+// once, so that the Fx event logger and the container share them.
+type onceLogger struct {
+	once    sync.Once
+	log     *slog.Logger
+	loggers *LoggerFactory
+	err     error
+}
+
+func (l *onceLogger) init(cfg *Config) {
+	l.once.Do(func() {
+		if l.log, l.err = NewLogger(cfg); l.err == nil {
+			l.loggers, l.err = NewLoggerFactory(cfg, l.log)
+		}
+	})
+}
+
+func (l *onceLogger) build(cfg *Config) (*slog.Logger, error) {
+	l.init(cfg)
+	return l.log, l.err
+}
+
+func (l *onceLogger) buildFactory(cfg *Config) (*LoggerFactory, error) {
+	l.init(cfg)
+	return l.loggers, l.err
 }
 
-func NewLogger() *slog.Logger {
-	z, err := zap.NewDevelopment()
+// factory provides the LoggerFactory, closing its files when the app
+// stops.
+func (l *onceLogger) factory(lc fx.Lifecycle, cfg *Config) (*LoggerFactory, error) {
+	loggers, err := l.buildFactory(cfg)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return slog.New(slogzap.Option{Logger: z}.NewZapHandler())
+	lc.Append(fx.StopHook(loggers.Close))
+	return loggers, nil
+}
+
+// newBootstrapLogger builds the minimal logger used to report failures
+// when the app's logger can't be built.
+func newBootstrapLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, nil))
 }
 
 type Route interface {
@@ -130,14 +662,19 @@ type Route interface {
 }
 
 // HelloHandler is an HTTP handler that
-// prints a greeting to the user.
+// prints a greeting to the user, made by its Greeter.
 type HelloHandler struct {
-	log *slog.Logger
+	log     *slog.Logger
+	errs    *ErrorWriter
+	stats   *GreetingStats
+	events  *EventBus
+	flags   FeatureFlags
+	greeter Greeter
 }
 
 // NewHelloHandler builds a new HelloHandler.
-func NewHelloHandler(log *slog.Logger) *HelloHandler {
-	return &HelloHandler{log: log}
+func NewHelloHandler(log *slog.Logger, errs *ErrorWriter, stats *GreetingStats, events *EventBus, flags FeatureFlags, greeter Greeter) *HelloHandler {
+	return &HelloHandler{log: log, errs: errs, stats: stats, events: events, flags: flags, greeter: greeter}
 }
 
 func (*HelloHandler) Pattern() string {
@@ -145,26 +682,42 @@ func (*HelloHandler) Pattern() string {
 }
 
 func (h *HelloHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	span, ctx := spanlog.Start(r.Context(), "read-body")
 	body, err := io.ReadAll(r.Body)
+	span.End()
+	if err != nil {
+		h.errs.SafeError(w, r, fmt.Errorf("read request: %w", err))
+		return
+	}
+
+	span, ctx = spanlog.Start(r.Context(), "greet")
+	greeting, err := h.greeter.Greet(ctx, string(body))
+	span.End()
 	if err != nil {
-		h.log.Error("Failed to read request", slog.String("err", err.Error()))
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		h.errs.SafeError(w, r, fmt.Errorf("greet: %w", err))
 		return
 	}
 
-	if _, err := fmt.Fprintf(w, "Hello, %s\n", body); err != nil {
-		h.log.Error("Failed to write response", slog.String("err", err.Error()))
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	span, ctx = spanlog.Start(r.Context(), "record")
+	h.stats.Add(string(body))
+	h.events.Publish(ctx, Event{Topic: "greeting_sent", Payload: map[string]string{"name": string(body)}})
+	span.End()
+
+	span, ctx = spanlog.Start(r.Context(), "render")
+	defer span.End()
+	if h.flags.Enabled(ctx, "json_greeting") {
+		if err := httpjson.Respond(w, r, http.StatusOK, map[string]string{"greeting": greeting}); err != nil {
+			h.errs.SafeError(w, r, fmt.Errorf("write response: %w", err))
+		}
 		return
 	}
+	if _, err := fmt.Fprintln(w, greeting); err != nil {
+		h.errs.SafeError(w, r, fmt.Errorf("write response: %w", err))
+	}
 }
 
 // AsRoute annotates the given constructor to state that
 // it provides a route to the "routes" group.
 func AsRoute(f any) any {
-	return fx.Annotate(
-		f,
-		fx.As(new(Route)),
-		fx.ResultTags(`group:"routes"`),
-	)
+	return AsGroupMember[Route]("routes", f)
 }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"example.com/uberfx/httpjson"
+)
+
+// maintenanceExempt are the paths still served in maintenance mode: the
+// health checks, and the switch itself.
+var maintenanceExempt = map[string]bool{
+	"/healthz":           true,
+	"/readyz":            true,
+	"/admin/maintenance": true,
+}
+
+// MaintenanceState describes the maintenance in progress.
+type MaintenanceState struct {
+	// Message tells clients what's going on.
+	Message string `json:"message,omitempty"`
+	// ETA is when the maintenance is expected to be over, if known.
+	ETA   *time.Time `json:"eta,omitempty"`
+	Since time.Time  `json:"since"`
+}
+
+// Maintenance is the outermost middleware, answering every request but
+// those to the health checks with a 503 and a Retry-After header while
+// maintenance mode is on, as during database migrations. The body is a
+// problem+json object carrying the message and ETA of the maintenance.
+// Unless Config.Maintenance.KeepReady is set, /readyz reports the app
+// not ready meanwhile, so that load balancers drain it.
+//
+// Maintenance mode is switched at runtime with PUT /admin/maintenance,
+// see MaintenanceHandler. It's on from the start if
+// Config.Maintenance.Enabled is set or the file named by
+// Config.Maintenance.MarkerFile exists, its contents being the message.
+type Maintenance struct {
+	state      atomic.Pointer[MaintenanceState]
+	log        *slog.Logger
+	catalog    *Catalog
+	now        func() time.Time
+	retryAfter time.Duration
+	keepReady  bool
+}
+
+// NewMaintenance builds a new Maintenance.
+func NewMaintenance(cfg *Config, log *slog.Logger, catalog *Catalog) (*Maintenance, error) {
+	c := cfg.Maintenance
+	m := &Maintenance{
+		log:        log,
+		catalog:    catalog,
+		now:        time.Now,
+		retryAfter: time.Duration(c.RetryAfter),
+		keepReady:  c.KeepReady,
+	}
+	if m.retryAfter <= 0 {
+		m.retryAfter = 30 * time.Second
+	}
+	msg := c.Message
+	on := c.Enabled
+	if c.MarkerFile != "" {
+		b, err := os.ReadFile(c.MarkerFile)
+		switch {
+		case err == nil:
+			on = true
+			if s := strings.TrimSpace(string(b)); s != "" {
+				msg = s
+			}
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, fmt.Errorf("maintenance marker file: %w", err)
+		}
+	}
+	if on {
+		m.Set(&MaintenanceState{Message: msg, ETA: c.ETA})
+	}
+	return m, nil
+}
+
+// State returns the maintenance in progress, or nil if there's none.
+func (m *Maintenance) State() *MaintenanceState {
+	return m.state.Load()
+}
+
+// Set switches maintenance mode on with the given state, or off if it's
+// nil.
+func (m *Maintenance) Set(s *MaintenanceState) {
+	if s != nil {
+		s.Since = m.now()
+		m.log.Warn("Maintenance mode on", slog.String("message", s.Message), slog.Any("eta", s.ETA))
+	} else if m.state.Load() != nil {
+		m.log.Info("Maintenance mode off")
+	}
+	m.state.Store(s)
+}
+
+// Ready reports whether the app should be reported ready as far as
+// maintenance goes.
+func (m *Maintenance) Ready() bool {
+	return m.keepReady || m.state.Load() == nil
+}
+
+func (*Maintenance) Order() int {
+	return orderMaintenance
+}
+
+func (m *Maintenance) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := m.state.Load()
+		if s == nil || maintenanceExempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		m.reject(w, r, s)
+	})
+}
+
+// maintenanceProblem is the problem+json body of requests turned away
+// during maintenance.
+type maintenanceProblem struct {
+	Problem
+	ETA *time.Time `json:"eta,omitempty"`
+}
+
+func (m *Maintenance) reject(w http.ResponseWriter, r *http.Request, s *MaintenanceState) {
+	retryAfter := m.retryAfter
+	if s.ETA != nil {
+		if wait := s.ETA.Sub(m.now()); wait > 0 {
+			retryAfter = wait
+		}
+	}
+	locale := m.catalog.Negotiate(r.Header.Get("Accept-Language"))
+	title, ok := m.catalog.Message(locale, "title.503", nil)
+	if !ok {
+		title = http.StatusText(http.StatusServiceUnavailable)
+	}
+	detail := s.Message
+	if detail == "" {
+		detail, _ = m.catalog.Message(locale, "detail.maintenance", nil)
+	}
+	h := w.Header()
+	h.Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+	h.Set("Content-Language", locale)
+	h.Add("Vary", "Accept-Language")
+	h.Set("Content-Type", "application/problem+json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	_ = httpjson.RespondError(w, r, http.StatusServiceUnavailable, maintenanceProblem{
+		Problem: Problem{
+			Title:    title,
+			Status:   http.StatusServiceUnavailable,
+			Detail:   detail,
+			Instance: r.URL.Path,
+		},
+		ETA: s.ETA,
+	})
+}
+
+// MaintenanceHandler shows at GET /admin/maintenance whether
+// maintenance mode is on, and switches it with a PUT of
+// {"enabled": bool, "message": string, "eta": time}. Both require the
+// admin role.
+type MaintenanceHandler struct {
+	maintenance *Maintenance
+	errs        *ErrorWriter
+}
+
+// NewMaintenanceHandler builds a new MaintenanceHandler.
+func NewMaintenanceHandler(maintenance *Maintenance, errs *ErrorWriter) *MaintenanceHandler {
+	return &MaintenanceHandler{maintenance: maintenance, errs: errs}
+}
+
+func (h *MaintenanceHandler) RegisterRoutes(r Router) {
+	r.Handle(http.MethodGet, "/admin/maintenance", WithRoles(http.HandlerFunc(h.show), "admin"))
+	r.Handle(http.MethodPut, "/admin/maintenance", WithRoles(http.HandlerFunc(h.set), "admin"))
+}
+
+func (h *MaintenanceHandler) show(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	s := h.maintenance.State()
+	_ = httpjson.Respond(w, r, http.StatusOK, struct {
+		Enabled bool `json:"enabled"`
+		*MaintenanceState
+	}{s != nil, s})
+}
+
+func (h *MaintenanceHandler) set(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Enabled *bool      `json:"enabled"`
+		Message string     `json:"message"`
+		ETA     *time.Time `json:"eta"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, err))
+		return
+	}
+	if body.Enabled == nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, fmt.Errorf("missing %q", "enabled")))
+		return
+	}
+	if *body.Enabled {
+		h.maintenance.Set(&MaintenanceState{Message: body.Message, ETA: body.ETA})
+	} else {
+		h.maintenance.Set(nil)
+	}
+	h.show(w, r)
+}
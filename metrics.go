@@ -0,0 +1,94 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// NewMetricsRegistry builds the Prometheus registry that components
+// register their metrics with. Each app gets its own registry rather
+// than sharing the global default one.
+func NewMetricsRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return reg
+}
+
+// MetricsHandler is an HTTP handler that exposes the registry in the
+// Prometheus text format.
+type MetricsHandler struct {
+	handler http.Handler
+}
+
+// NewMetricsHandler builds a new MetricsHandler.
+func NewMetricsHandler(reg *prometheus.Registry) *MetricsHandler {
+	return &MetricsHandler{handler: promhttp.HandlerFor(reg, promhttp.HandlerOpts{})}
+}
+
+func (*MetricsHandler) Pattern() string {
+	return "GET /metrics"
+}
+
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, r)
+}
+
+// DebugVars are the expvar variables of the app. They aren't published
+// with expvar.Publish, which is global to the process, but kept by the
+// app, so that apps running in the same process, as in tests, don't
+// add up their figures or panic publishing the same name twice.
+type DebugVars struct {
+	vars expvar.Map
+}
+
+// NewDebugVars builds a new, empty DebugVars.
+func NewDebugVars() *DebugVars {
+	return &DebugVars{}
+}
+
+// NewInt returns a new integer variable of the given name.
+func (v *DebugVars) NewInt(name string) *expvar.Int {
+	i := new(expvar.Int)
+	v.vars.Set(name, i)
+	return i
+}
+
+// DebugVarsHandler is an HTTP handler that exposes in JSON the expvar
+// variables of the app and those published for the whole process, such
+// as cmdline and memstats, in the format of expvar.Handler.
+type DebugVarsHandler struct {
+	vars *DebugVars
+}
+
+// NewDebugVarsHandler builds a new DebugVarsHandler.
+func NewDebugVarsHandler(vars *DebugVars) *DebugVarsHandler {
+	return &DebugVarsHandler{vars: vars}
+}
+
+func (*DebugVarsHandler) Pattern() string {
+	return "GET /debug/vars"
+}
+
+func (h *DebugVarsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n")
+	first := true
+	write := func(kv expvar.KeyValue) {
+		if !first {
+			fmt.Fprintf(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	}
+	expvar.Do(write)
+	h.vars.vars.Do(write)
+	fmt.Fprintf(w, "\n}\n")
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sort"
+
+	"example.com/uberfx/reqctx"
+)
+
+// Middleware wraps an http.Handler with additional behavior.
+type Middleware interface {
+	Wrap(next http.Handler) http.Handler
+}
+
+// MiddlewareFunc adapts an ordinary function to the Middleware interface.
+type MiddlewareFunc func(next http.Handler) http.Handler
+
+// Wrap calls f(next).
+func (f MiddlewareFunc) Wrap(next http.Handler) http.Handler {
+	return f(next)
+}
+
+// Chain wraps h with the given middleware so that the first one in the
+// list is the outermost.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i].Wrap(h)
+	}
+	return h
+}
+
+// AsMiddleware annotates the given constructor to state that it
+// provides middleware to the "middleware" group, which wraps every
+// route served by the HTTP server.
+func AsMiddleware(f any) any {
+	return AsGroupMember[Middleware]("middleware", f)
+}
+
+// Ordered is implemented by middleware that needs a fixed position in
+// the chain, since value groups are unordered. Lower orders wrap higher
+// ones; middleware that doesn't implement Ordered has order 0.
+type Ordered interface {
+	Order() int
+}
+
+// Orders of the built-in middleware.
+const (
+	orderRequestContext = -350
+	orderAPIResponses   = -345
+	orderTLSContext     = -340
+	orderQueueTime      = -320
+	orderMaintenance    = -300
+	orderDrain          = -250
+	orderPropagation    = -200
+	orderAudit          = -150
+	orderNormalize      = -140
+	orderDeadline       = -130
+	orderBodyLimit      = -100
+	orderGzip           = -90
+	orderAuth           = -60
+	orderAPIKeyAuth     = -55
+	orderSession        = -50
+	orderHostRouter     = 1000
+
+	orderHeaderPolicy  = -120
+	orderRequestLog    = -110
+	orderRouteToggle   = -108
+	orderDependencies  = -107
+	orderDeprecation   = -105
+	orderReplayRecord  = -102
+	orderByteCounter   = -100
+	orderAuthorization = -98
+	orderContentType   = -95
+	orderDebugDump     = -90
+	orderCapture       = -88
+	orderShadow        = -80
+	orderCoalesce      = 50
+	orderCallBudget    = 60
+	orderConcurrency   = 75
+	orderIdempotency   = 100
+)
+
+// sortByOrder sorts mws by their order, outermost first.
+func sortByOrder[T any](mws []T) []T {
+	sorted := append([]T(nil), mws...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return orderOf(sorted[i]) < orderOf(sorted[j])
+	})
+	return sorted
+}
+
+func orderOf(m any) int {
+	if o, ok := m.(Ordered); ok {
+		return o.Order()
+	}
+	return 0
+}
+
+// RouteMiddleware wraps individual routes as they're registered, so that
+// it can consult optional methods declared by the route. It runs inside
+// the server-wide middleware and is ordered the same way.
+type RouteMiddleware interface {
+	WrapRoute(route Route, next http.Handler) http.Handler
+}
+
+// AsRouteMiddleware annotates the given constructor to state that it
+// provides middleware to the "route_middleware" group.
+func AsRouteMiddleware(f any) any {
+	return AsGroupMember[RouteMiddleware]("route_middleware", f)
+}
+
+// wrapRoute wraps route with the given route middleware, the first one
+// in the list being the outermost. The route's pattern is made available
+// to the whole chain through reqctx.Route.
+func wrapRoute(route Route, mws []RouteMiddleware) http.Handler {
+	var h http.Handler = route
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i].WrapRoute(route, h)
+	}
+	pattern := route.Pattern()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(reqctx.WithRoute(r.Context(), pattern)))
+	})
+}
+
+// responseRecorder is an http.ResponseWriter that records the status
+// and size of the response it passes through. If wrapConn is set, it
+// wraps connections taken over with Hijack.
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	wrapConn func(net.Conn) net.Conn
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+// Status returns the status written so far, or 200 if the handler wrote
+// a body without an explicit status, or 0 if nothing has been written.
+func (w *responseRecorder) Status() int {
+	return w.status
+}
+
+// Written reports whether the response has been started: once it has,
+// its status can't be changed anymore.
+func (w *responseRecorder) Written() bool {
+	return w.status != 0
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *responseRecorder) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil || w.wrapConn == nil {
+		return conn, rw, err
+	}
+	// The buffered writer must write through the wrapped connection too.
+	conn = w.wrapConn(conn)
+	rw.Writer.Reset(conn)
+	return conn, rw, nil
+}
+
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// PathNormalizer is middleware that puts request paths in canonical
+// form before routing: duplicate slashes are collapsed, dot segments
+// resolved and trailing slashes removed, except within the subtrees
+// served by patterns ending in a slash, such as "/static/". What happens
+// to non-canonical paths depends on Config.Server.PathPolicy.
+type PathNormalizer struct {
+	policy   string
+	subtrees []string
+}
+
+// NewPathNormalizer builds a new PathNormalizer.
+func NewPathNormalizer(cfg *Config, routes []Route) (*PathNormalizer, error) {
+	n := &PathNormalizer{policy: cfg.Server.PathPolicy}
+	switch n.policy {
+	case "":
+		n.policy = "strict"
+	case "strict", "redirect", "strip":
+	default:
+		return nil, fmt.Errorf("unknown path policy %q", n.policy)
+	}
+	for _, route := range routes {
+		_, p := splitPattern(route.Pattern())
+		if p != "/" && strings.HasSuffix(p, "/") {
+			n.subtrees = append(n.subtrees, p)
+		}
+	}
+	return n, nil
+}
+
+func (*PathNormalizer) Order() int {
+	return orderNormalize
+}
+
+func (n *PathNormalizer) Wrap(next http.Handler) http.Handler {
+	if n.policy == "strict" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		canonical := n.canonical(r.URL.Path)
+		if canonical == r.URL.Path {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if n.policy == "redirect" {
+			u := *r.URL
+			u.Path, u.RawPath = canonical, ""
+			status := http.StatusMovedPermanently
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				// Unlike a 301, a 308 keeps the method and body.
+				status = http.StatusPermanentRedirect
+			}
+			http.Redirect(w, r, u.RequestURI(), status)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path, r2.URL.RawPath = canonical, ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// canonical returns the canonical form of the request path p.
+func (n *PathNormalizer) canonical(p string) string {
+	if p == "" {
+		return "/"
+	}
+	clean := path.Clean("/" + p)
+	if clean != "/" && strings.HasSuffix(p, "/") {
+		for _, subtree := range n.subtrees {
+			if strings.HasPrefix(clean+"/", subtree) {
+				return clean + "/"
+			}
+		}
+	}
+	return clean
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// routesGroup is the value group routes are provided into, see AsRoute.
+const routesGroup = "routes"
+
+// builtinRoute reports whether the route with the given path is one
+// NewApp provides to every app: ping, the health checks, metrics, the
+// CSRF token, and the admin and debug routes.
+func builtinRoute(path string) bool {
+	switch path {
+	case "/ping", "/healthz", "/readyz", "/metrics", "/csrf":
+		return true
+	}
+	for _, prefix := range []string{"/admin", "/debug"} {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// appRoutes returns how many of routes aren't built in.
+func appRoutes(routes []Route) int {
+	n := 0
+	for _, route := range routes {
+		if _, path := splitPattern(route.Pattern()); !builtinRoute(path) {
+			n++
+		}
+	}
+	return n
+}
+
+// checkRoutes applies Config.Server.EmptyRoutes when the routes group
+// holds no routes besides the built-in ones, which is most often the
+// mistake of a typo in a group tag rather than intended: "fail", the
+// default, fails startup with an error naming the group, while
+// "diagnostic" only warns and registers on r a page at GET / explaining
+// how to register routes, see NoRoutesHandler.
+func checkRoutes(cfg *Config, r *recordingRouter, table *RouteTable, errs *ErrorWriter, log *slog.Logger) error {
+	const hint = `provide routes with AsRoute, or annotated with fx.ResultTags(` + "`group:\"" + routesGroup + "\"`" + `), and check the tag of the group on both ends`
+	switch policy := cfg.Server.EmptyRoutes; policy {
+	case "", "fail":
+		return fmt.Errorf(`no routes in the group:"%s" value group besides the built-in ones: %s`, routesGroup, hint)
+	case "diagnostic":
+		h, err := NewNoRoutesHandler(cfg, table, errs)
+		if err != nil {
+			return err
+		}
+		log.Warn(fmt.Sprintf(`No routes in the group:"%s" value group besides the built-in ones, serving a diagnostic page at /`, routesGroup), slog.String("hint", hint))
+		r.source = "the empty routes policy"
+		r.Handle(http.MethodGet, "/", h)
+		return nil
+	default:
+		return fmt.Errorf("unknown empty routes policy %q, want fail or diagnostic", policy)
+	}
+}
+
+// NoRoutesHandler serves the diagnostic page of an app started without
+// routes, naming the group they're expected in and how to provide them,
+// and listing what the registrars registered. It answers with a 503,
+// so that the app isn't taken for healthy.
+type NoRoutesHandler struct {
+	name  string
+	table *RouteTable
+	errs  *ErrorWriter
+	tmpl  *template.Template
+}
+
+// NewNoRoutesHandler builds a new NoRoutesHandler.
+func NewNoRoutesHandler(cfg *Config, table *RouteTable, errs *ErrorWriter) (*NoRoutesHandler, error) {
+	tmpl, err := template.ParseFS(assets, "assets/templates/noroutes.html")
+	if err != nil {
+		return nil, err
+	}
+	name := cfg.App.Name
+	if name == "" {
+		name = "uberfx"
+	}
+	return &NoRoutesHandler{name: name, table: table, errs: errs, tmpl: tmpl}, nil
+}
+
+func (*NoRoutesHandler) Pattern() string {
+	return "GET /"
+}
+
+// noRoutesData is what the diagnostic page template renders.
+type noRoutesData struct {
+	Name  string
+	Group string
+	// Entries are the patterns registered by registrars.
+	Entries []RouteEntry
+}
+
+func (h *NoRoutesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data := noRoutesData{Name: h.name, Group: routesGroup}
+	for _, e := range h.table.Entries() {
+		if e.Pattern != h.Pattern() {
+			data.Entries = append(data.Entries, e)
+		}
+	}
+	var buf bytes.Buffer
+	if err := h.tmpl.Execute(&buf, data); err != nil {
+		h.errs.Write(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = buf.WriteTo(w)
+}
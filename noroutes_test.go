@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEmptyRoutes(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { fmt.Fprintln(w, "ok") }
+	builtin := []Route{
+		newFuncRoute("/ping", http.MethodGet, ok),
+		newFuncRoute("/healthz", http.MethodGet, ok),
+		newFuncRoute("/metrics", http.MethodGet, ok),
+		newFuncRoute("/debug/stats", http.MethodGet, ok),
+		newFuncRoute("/admin/errors", http.MethodGet, ok),
+	}
+	const warning = `No routes in the group:"routes" value group besides the built-in ones, serving a diagnostic page at /`
+	for _, tc := range []struct {
+		name   string
+		policy string
+		routes []string
+		err    string
+		// root is the status of GET /, if the mux is built.
+		root int
+	}{
+		{"fail by default", "", nil, `no routes in the group:"routes" value group besides the built-in ones`, 0},
+		{"fail", "fail", nil, `no routes in the group:"routes" value group besides the built-in ones`, 0},
+		{"diagnostic", "diagnostic", nil, "", http.StatusServiceUnavailable},
+		{"unknown policy", "ignore", nil, `unknown empty routes policy "ignore"`, 0},
+		{"app route", "fail", []string{"/hello"}, "", http.StatusOK},
+		{"app route under a built-in prefix", "fail", []string{"/administration"}, "", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Server.EmptyRoutes = tc.policy
+			logs := &recordHandler{}
+			table := NewRouteTable()
+			root, err := NewRootHandler(cfg, &BuildInfo{}, table, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			routes := builtin
+			for _, path := range tc.routes {
+				routes = append(routes, newFuncRoute(path, http.MethodGet, ok))
+			}
+			mux, err := NewServeMux(cfg, slog.New(logs), newTestErrorWriter(t), table, root, routes, nil, nil)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if warned := len(logs.named(warning)) == 1; warned != (tc.policy == "diagnostic") {
+				t.Errorf("got warned %v, want %v", warned, !warned)
+			}
+			for path, want := range map[string]int{"/": tc.root, "/ping": http.StatusOK} {
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				if rec.Code != want {
+					t.Errorf("GET %s: got %d, want %d", path, rec.Code, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// PreflightCheck is a check of a prerequisite of the app outside of it,
+// such as a directory it serves or a file it reads, run before the app
+// starts, see CheckPreflight. Checks are contributed by the parts of
+// the app owning the prerequisites, and pass when there's nothing to
+// check. A failure may advise how to remedy it, see PreflightFailure.
+type PreflightCheck interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+// AsPreflightCheck annotates the given constructor to state that it
+// provides a check to the "preflight" group.
+func AsPreflightCheck(f any) any {
+	return AsGroupMember[PreflightCheck]("preflight", f)
+}
+
+// PreflightFunc is a PreflightCheck calling a function.
+type PreflightFunc struct {
+	CheckName string
+	Fn        func(ctx context.Context) error
+}
+
+func (c *PreflightFunc) Name() string {
+	return c.CheckName
+}
+
+func (c *PreflightFunc) Check(ctx context.Context) error {
+	return c.Fn(ctx)
+}
+
+// preflightTimeout bounds each preflight check.
+const preflightTimeout = 5 * time.Second
+
+// PreflightFailure is the error of a failed PreflightCheck advising how
+// to remedy it.
+type PreflightFailure struct {
+	Err  error
+	Hint string
+}
+
+func (f *PreflightFailure) Error() string {
+	return f.Err.Error()
+}
+
+func (f *PreflightFailure) Unwrap() error {
+	return f.Err
+}
+
+// preflightFailure returns err, advising hint.
+func preflightFailure(err error, hint string) error {
+	return &PreflightFailure{Err: err, Hint: hint}
+}
+
+// PreflightError is the error of the preflight checks failing. It lists
+// all the failures, rather than the first, so they can be fixed at once.
+type PreflightError struct {
+	Failures []PreflightCheckError
+}
+
+// PreflightCheckError is the failure of a PreflightCheck.
+type PreflightCheckError struct {
+	Check string
+	Err   error
+}
+
+func (e *PreflightError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "preflight: %d checks failed:", len(e.Failures))
+	for _, f := range e.Failures {
+		fmt.Fprintf(&b, "\n  %s: %v", f.Check, f.Err)
+		var failure *PreflightFailure
+		if errors.As(f.Err, &failure) && failure.Hint != "" {
+			fmt.Fprintf(&b, "\n    hint: %s", failure.Hint)
+		}
+	}
+	return b.String()
+}
+
+func (e *PreflightError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// CheckPreflight runs the preflight checks, by name, and fails with a
+// *PreflightError if any fails. It's invoked before anything else, so
+// that a missing prerequisite is reported along with all the others,
+// and before the components owning them fail on it one by one.
+func CheckPreflight(checks []PreflightCheck, log *slog.Logger) error {
+	checks = sortedPreflightChecks(checks)
+	var failed PreflightError
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+		err := c.Check(ctx)
+		cancel()
+		if err != nil {
+			failed.Failures = append(failed.Failures, PreflightCheckError{Check: c.Name(), Err: err})
+		}
+	}
+	if len(failed.Failures) > 0 {
+		return &failed
+	}
+	log.Debug("Preflight checks passed", slog.Int("checks", len(checks)))
+	return nil
+}
+
+func sortedPreflightChecks(checks []PreflightCheck) []PreflightCheck {
+	checks = append([]PreflightCheck(nil), checks...)
+	sort.SliceStable(checks, func(i, j int) bool { return checks[i].Name() < checks[j].Name() })
+	return checks
+}
+
+// skipPreflight drops the preflight checks, for the modes that build
+// the app without starting it.
+var skipPreflight = fx.Decorate(fx.Annotate(
+	func([]PreflightCheck) []PreflightCheck { return nil },
+	fx.ParamTags(`group:"preflight"`),
+	fx.ResultTags(`group:"preflight"`),
+))
+
+// RunPreflight runs the preflight checks, without starting the app
+// built with the given extra options, and returns the process exit code.
+func RunPreflight(opts ...fx.Option) int {
+	var checks []PreflightCheck
+	app := NewApp(append(opts, fx.Invoke(fx.Annotate(func(c []PreflightCheck) {
+		checks = c
+	}, fx.ParamTags(`group:"preflight"`))))...)
+	if err := app.Err(); err != nil {
+		var failed *PreflightError
+		if errors.As(err, &failed) {
+			fmt.Println(failed)
+		} else {
+			fmt.Println("preflight: failed to build app:", err)
+		}
+		return 1
+	}
+	for _, c := range sortedPreflightChecks(checks) {
+		fmt.Printf("preflight: ok   %s\n", c.Name())
+	}
+	fmt.Printf("preflight: %d/%d checks passed\n", len(checks), len(checks))
+	return 0
+}
+
+// checkWritableDir checks that dir exists, or can be created, and that
+// files can be written to it.
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".preflight-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
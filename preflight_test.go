@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckPreflight(t *testing.T) {
+	errDisk := errors.New("disk full")
+	errPort := errors.New("port taken")
+	checks := []PreflightCheck{
+		&PreflightFunc{CheckName: "upload-dir", Fn: func(context.Context) error { return preflightFailure(errDisk, "free some space") }},
+		&PreflightFunc{CheckName: "clock", Fn: func(context.Context) error { return nil }},
+		&PreflightFunc{CheckName: "admin-port", Fn: func(context.Context) error { return errPort }},
+	}
+	err := CheckPreflight(checks, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var failed *PreflightError
+	if !errors.As(err, &failed) {
+		t.Fatalf("got %v, want a *PreflightError", err)
+	}
+	if len(failed.Failures) != 2 || failed.Failures[0].Check != "admin-port" || failed.Failures[1].Check != "upload-dir" {
+		t.Errorf("got failures %+v, want admin-port then upload-dir", failed.Failures)
+	}
+	if !errors.Is(err, errDisk) || !errors.Is(err, errPort) {
+		t.Errorf("got %v, want it to wrap both failures", err)
+	}
+	want := "preflight: 2 checks failed:\n  admin-port: port taken\n  upload-dir: disk full\n    hint: free some space"
+	if err.Error() != want {
+		t.Errorf("got message\n%s\nwant\n%s", err, want)
+	}
+
+	if err := CheckPreflight(checks[1:2], slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Errorf("passing checks: got %v", err)
+	}
+}
+
+// checkHint runs c and returns the hint of its failure, or "ok" if it
+// passes.
+func checkHint(t *testing.T, c PreflightCheck) string {
+	t.Helper()
+	err := c.Check(context.Background())
+	if err == nil {
+		return "ok"
+	}
+	var failure *PreflightFailure
+	if !errors.As(err, &failure) {
+		t.Fatalf("%s: got %v, want a *PreflightFailure", c.Name(), err)
+	}
+	return failure.Hint
+}
+
+func TestPreflightChecks(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cfg := func(edit func(*Config)) *Config {
+		cfg := &Config{}
+		edit(cfg)
+		return cfg
+	}
+	for _, tc := range []struct {
+		name  string
+		check PreflightCheck
+		hint  string
+	}{
+		{"static dir unset", NewStaticDirCheck(cfg(func(*Config) {})), "ok"},
+		{"static dir", NewStaticDirCheck(cfg(func(c *Config) { c.Static.Dir = dir })), "ok"},
+		{"static dir missing", NewStaticDirCheck(cfg(func(c *Config) { c.Static.Dir = filepath.Join(dir, "missing") })), "create the directory"},
+		{"static dir a file", NewStaticDirCheck(cfg(func(c *Config) { c.Static.Dir = file })), "set static.dir"},
+		{"listen addr free", NewListenAddrCheck(cfg(func(c *Config) { c.Server.Addr = "127.0.0.1:0" })), "ok"},
+		{"listen addr taken", NewListenAddrCheck(cfg(func(c *Config) { c.Server.Addr = ln.Addr().String() })), "stop the process listening there"},
+		{"tls files", NewTLSFilesCheck(cfg(func(c *Config) {
+			c.Server.TLS.Certificates = []TLSCertificateConfig{{CertFile: file, KeyFile: file}}
+		})), "set server.tls.certificates[0]"},
+	} {
+		if got := checkHint(t, tc.check); !strings.HasPrefix(got, tc.hint) {
+			t.Errorf("%s: got hint %q, want %q", tc.name, got, tc.hint)
+		}
+	}
+}
+
+func TestRunPreflight(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig := func(static string) {
+		file := filepath.Join(dir, "config.json")
+		content := fmt.Sprintf(`{"log": {"fx_events": "on-error"}, "server": {"addr": "127.0.0.1:0"}, "static": {"dir": %q}}`, static)
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("CONFIG_FILE", file)
+	}
+
+	writeConfig(dir)
+	if code := RunPreflight(); code != 0 {
+		t.Errorf("with the prerequisites met, got exit code %d, want 0", code)
+	}
+	writeConfig(filepath.Join(dir, "missing"))
+	if code := RunPreflight(); code != 1 {
+		t.Errorf("with the static dir missing, got exit code %d, want 1", code)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/uberfx/clock"
+	"example.com/uberfx/httpjson"
+	"example.com/uberfx/reqctx"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// StatusError is an error reported to the client with a specific status.
+type StatusError struct {
+	Status int
+	Err    error
+}
+
+// NewStatusError wraps err so that it's reported with the given status.
+func NewStatusError(status int, err error) *StatusError {
+	return &StatusError{Status: status, Err: err}
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// errorStatuses maps well-known errors to the status they're reported
+// with, and the code of their detail in the message catalog.
+var errorStatuses = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrCircuitOpen, http.StatusServiceUnavailable, "circuit_open"},
+	{ErrInvalidToken, http.StatusUnauthorized, "invalid_token"},
+	{ErrUnauthenticated, http.StatusUnauthorized, "unauthenticated"},
+	{errCSRFMissing, http.StatusForbidden, "csrf_missing"},
+	{errCSRFInvalid, http.StatusForbidden, "csrf_invalid"},
+	{ErrInvalidAPIKey, http.StatusUnauthorized, "invalid_api_key"},
+	{ErrAPIKeyDisabled, http.StatusForbidden, "api_key_disabled"},
+	{ErrRateLimited, http.StatusTooManyRequests, "rate_limited"},
+	{ErrQuotaExceeded, http.StatusRequestEntityTooLarge, "quota_exceeded"},
+	{ErrRouteBusy, http.StatusServiceUnavailable, "route_busy"},
+	{ErrRouteDisabled, http.StatusServiceUnavailable, "route_disabled"},
+	{ErrWorkersBusy, http.StatusServiceUnavailable, "workers_busy"},
+	{ErrSelfTestRunning, http.StatusConflict, "selftest_running"},
+	{ErrRouteGone, http.StatusGone, "route_gone"},
+}
+
+// ErrorWriter renders handler errors as problem+json responses. Every
+// error it renders is counted by route and status, and the most recent
+// ones are kept for GET /admin/errors. Titles, and the details of
+// well-known errors, are translated to the language the client accepts;
+// other details are diagnostics and are left as they are.
+type ErrorWriter struct {
+	log     *slog.Logger
+	catalog *Catalog
+	errors  *prometheus.CounterVec
+	recent  *errorRing
+	clock   clock.Clock
+}
+
+// NewErrorWriter builds a new ErrorWriter.
+func NewErrorWriter(log *slog.Logger, reg *prometheus.Registry, catalog *Catalog, clk clock.Clock) *ErrorWriter {
+	e := &ErrorWriter{
+		log:     log,
+		catalog: catalog,
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_handler_errors_total",
+			Help: "Errors rendered by HTTP handlers, by route pattern and status.",
+		}, []string{"route", "status"}),
+		recent: newErrorRing(100),
+		clock:  clk,
+	}
+	reg.MustRegister(e.errors)
+	return e
+}
+
+// Write reports err to the client. Errors that aren't StatusErrors or
+// well-known errors are reported as 500s without exposing their message.
+// Errors advising a delay with a RetryAfter method have it sent as the
+// Retry-After header.
+func (e *ErrorWriter) Write(w http.ResponseWriter, r *http.Request, err error) {
+	status, detail := http.StatusInternalServerError, ""
+	code, args := "", map[string]string(nil)
+	var (
+		se  *StatusError
+		mbe *http.MaxBytesError
+		cbe *CallBudgetError
+		rte *ResponseTooLargeError
+		de  *DependencyError
+	)
+	if errors.As(err, &se) {
+		status, detail = se.Status, se.Error()
+	} else if errors.As(err, &mbe) {
+		status, detail = http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds the limit of %d bytes", mbe.Limit)
+		code, args = "body_too_large", map[string]string{"limit": strconv.FormatInt(mbe.Limit, 10)}
+	} else if errors.As(err, &cbe) {
+		status, detail = http.StatusBadGateway, cbe.Error()
+		code, args = "call_budget_exceeded", map[string]string{"budget": strconv.Itoa(cbe.Budget)}
+	} else if errors.As(err, &rte) {
+		status, detail = http.StatusBadGateway, rte.Error()
+		code, args = "response_too_large", map[string]string{"limit": strconv.FormatInt(rte.Limit, 10)}
+	} else if errors.As(err, &de) {
+		status, detail = http.StatusServiceUnavailable, de.Error()
+		code, args = "dependency_unavailable", map[string]string{"checks": strings.Join(de.Checks, ", ")}
+	} else {
+		for _, es := range errorStatuses {
+			if errors.Is(err, es.err) {
+				status, detail, code = es.status, err.Error(), es.code
+				break
+			}
+		}
+	}
+
+	route := reqctx.Route(r.Context())
+	e.errors.WithLabelValues(route, strconv.Itoa(status)).Inc()
+	e.recent.add(RecordedError{
+		Time:      e.clock.Now(),
+		Route:     route,
+		Path:      r.URL.Path,
+		Status:    status,
+		Message:   err.Error(),
+		RequestID: reqctx.RequestID(r.Context()),
+	})
+
+	if status >= http.StatusInternalServerError {
+		e.log.Error("Request failed", slog.String("path", r.URL.Path), slog.Int("status", status), slog.String("err", err.Error()))
+	}
+	locale := e.catalog.Negotiate(r.Header.Get("Accept-Language"))
+	title, ok := e.catalog.Message(locale, "title."+strconv.Itoa(status), nil)
+	if !ok {
+		title = http.StatusText(status)
+	}
+	if code != "" {
+		if msg, ok := e.catalog.Message(locale, "detail."+code, args); ok {
+			detail = msg
+		}
+	}
+	var ra interface{ RetryAfter() time.Duration }
+	if errors.As(err, &ra) && ra.RetryAfter() > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((ra.RetryAfter()+time.Second-1)/time.Second)))
+	}
+	w.Header().Set("Content-Language", locale)
+	w.Header().Add("Vary", "Accept-Language")
+	WriteProblem(w, r, Problem{
+		Title:    title,
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	})
+}
+
+// SafeError reports err to the client like Write, unless the response
+// has already been started, in which case the status can't be changed
+// and another body would corrupt the one partly sent: err is logged
+// instead, and the handler aborted with http.ErrAbortHandler so that
+// the client sees the response cut short rather than complete. Whether
+// the response was started is known through the nearest writer wrapping
+// w that tracks it, such as the responseRecorder of the RequestLogger.
+func (e *ErrorWriter) SafeError(w http.ResponseWriter, r *http.Request, err error) {
+	sw := findStartedWriter(w)
+	if sw == nil || !sw.Written() {
+		e.Write(w, r, err)
+		return
+	}
+	e.log.ErrorContext(r.Context(), "Request failed after the response started",
+		slog.String("path", r.URL.Path),
+		slog.Int("status", sw.Status()),
+		slog.String("err", err.Error()))
+	panic(http.ErrAbortHandler)
+}
+
+// startedWriter is an http.ResponseWriter that tracks whether the
+// response was started, and with which status.
+type startedWriter interface {
+	http.ResponseWriter
+	Written() bool
+	Status() int
+}
+
+// findStartedWriter returns the startedWriter nearest to the handler in
+// the chain of writers wrapping w, or nil if there is none. Since all of
+// the handler's writes go through it, it knows whether the response was
+// started.
+func findStartedWriter(w http.ResponseWriter) startedWriter {
+	for {
+		switch ww := w.(type) {
+		case startedWriter:
+			return ww
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = ww.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// Recent returns the most recently rendered errors, newest first.
+func (e *ErrorWriter) Recent() []RecordedError {
+	return e.recent.list()
+}
+
+// RecordedError is an error rendered by the ErrorWriter.
+type RecordedError struct {
+	Time      time.Time `json:"time"`
+	Route     string    `json:"route,omitempty"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// errorRing keeps the last errors added to it.
+type errorRing struct {
+	mu      sync.Mutex
+	entries []RecordedError
+	next    int
+	full    bool
+}
+
+func newErrorRing(size int) *errorRing {
+	return &errorRing{entries: make([]RecordedError, size)}
+}
+
+func (b *errorRing) add(e RecordedError) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries[b.next] = e
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+func (b *errorRing) list() []RecordedError {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.next
+	if b.full {
+		n = len(b.entries)
+	}
+	out := make([]RecordedError, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, b.entries[(b.next-i+len(b.entries))%len(b.entries)])
+	}
+	return out
+}
+
+// ErrorsHandler is an HTTP handler that lists the most recent errors
+// rendered by the ErrorWriter, for quick triage.
+type ErrorsHandler struct {
+	errs *ErrorWriter
+}
+
+// NewErrorsHandler builds a new ErrorsHandler.
+func NewErrorsHandler(errs *ErrorWriter) *ErrorsHandler {
+	return &ErrorsHandler{errs: errs}
+}
+
+func (*ErrorsHandler) Pattern() string {
+	return "GET /admin/errors"
+}
+
+func (h *ErrorsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	_ = httpjson.Respond(w, r, http.StatusOK, h.errs.Recent())
+}
+
+// WriteProblem writes p as the application/problem+json response to r.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_ = httpjson.RespondError(w, r, p.Status, p)
+}
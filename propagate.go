@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// sensitiveHeaders carry credentials. They're never propagated by a
+// "*" rule, they must be listed by name, and never mirrored.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"X-Api-Key":           true,
+}
+
+// localHeaders describe the connection, the body or the inbound
+// request itself rather than the call chain, so a "*" rule doesn't
+// propagate them.
+var localHeaders = map[string]bool{
+	"Accept":            true,
+	"Accept-Encoding":   true,
+	"Connection":        true,
+	"Content-Encoding":  true,
+	"Content-Length":    true,
+	"Content-Type":      true,
+	"Expect":            true,
+	"Idempotency-Key":   true,
+	"If-Modified-Since": true,
+	"If-None-Match":     true,
+	"Keep-Alive":        true,
+	"Origin":            true,
+	"Proxy-Connection":  true,
+	"Range":             true,
+	"Referer":           true,
+	"Te":                true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+	"User-Agent":        true,
+	"X-Csrf-Token":      true,
+	"X-Request-Timeout": true,
+}
+
+// HeaderPropagator decides which headers of the inbound request, as
+// recorded by InboundHeaders, are copied onto the outbound requests made
+// while serving it.
+//
+// Only the headers listed in Config.Client.PropagateHeaders are
+// propagated, optionally under another name. A "*" entry propagates
+// every other end-to-end header too, except sensitive ones such as
+// Authorization, which are only ever propagated when listed by name.
+type HeaderPropagator struct {
+	renames  map[string]string
+	wildcard bool
+}
+
+// NewHeaderPropagator builds a new HeaderPropagator.
+func NewHeaderPropagator(cfg *Config) *HeaderPropagator {
+	p := &HeaderPropagator{renames: make(map[string]string)}
+	rules := cfg.Client.PropagateHeaders
+	if rules == nil {
+		rules = []PropagatedHeader{{Name: "X-Request-ID"}, {Name: "Traceparent"}, {Name: "Tracestate"}}
+	}
+	for _, h := range rules {
+		if h.Name == "*" {
+			p.wildcard = true
+			continue
+		}
+		as := h.As
+		if as == "" {
+			as = h.Name
+		}
+		p.renames[http.CanonicalHeaderKey(h.Name)] = http.CanonicalHeaderKey(as)
+	}
+	return p
+}
+
+// propagated returns the headers to add to outbound requests made with
+// ctx, or nil outside of an inbound request.
+func (p *HeaderPropagator) propagated(ctx context.Context) http.Header {
+	in, _ := ctx.Value(inboundHeaderKey{}).(http.Header)
+	if in == nil {
+		return nil
+	}
+	out := make(http.Header)
+	for name, values := range in {
+		as, listed := p.renames[name]
+		switch {
+		case listed:
+		case p.wildcard && !sensitiveHeaders[name] && !localHeaders[name]:
+			as = name
+		default:
+			continue
+		}
+		out[as] = append(out[as], values...)
+	}
+	return out
+}
+
+type inboundHeaderKey struct{}
+
+// InboundHeaders is middleware that records the headers of the inbound
+// request in its context, before other middleware can alter them, for
+// the HeaderPropagator.
+type InboundHeaders struct{}
+
+// NewInboundHeaders builds a new InboundHeaders.
+func NewInboundHeaders() *InboundHeaders {
+	return &InboundHeaders{}
+}
+
+func (*InboundHeaders) Order() int {
+	return orderPropagation
+}
+
+func (*InboundHeaders) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), inboundHeaderKey{}, r.Header.Clone())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Transport wraps next so that outbound requests carry the propagated
+// headers of the inbound request in their context. Headers the caller
+// set explicitly are left alone.
+func (p *HeaderPropagator) Transport(next http.RoundTripper) http.RoundTripper {
+	return &propagationTransport{next: next, p: p}
+}
+
+type propagationTransport struct {
+	next http.RoundTripper
+	p    *HeaderPropagator
+}
+
+func (t *propagationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if h := t.p.propagated(req.Context()); len(h) > 0 {
+		req = req.Clone(req.Context())
+		for name, values := range h {
+			if _, set := req.Header[name]; !set {
+				req.Header[name] = values
+			}
+		}
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// ProxyHelloHandler is an HTTP handler that forwards greetings to an
+// upstream hello service through the shared client.
+type ProxyHelloHandler struct {
+	log      *slog.Logger
+	client   *http.Client
+	errs     *ErrorWriter
+	upstream string
+	info     *ServerInfo
+}
+
+// NewProxyHelloHandler builds a new ProxyHelloHandler.
+func NewProxyHelloHandler(log *slog.Logger, client *http.Client, errs *ErrorWriter, cfg *Config, info *ServerInfo) *ProxyHelloHandler {
+	return &ProxyHelloHandler{
+		log:      log,
+		client:   client,
+		errs:     errs,
+		upstream: cfg.Hello.UpstreamURL,
+		info:     info,
+	}
+}
+
+func (*ProxyHelloHandler) Pattern() string {
+	return "/proxy-hello"
+}
+
+// CoalesceRequests lets concurrent identical GETs share one upstream
+// call.
+func (*ProxyHelloHandler) CoalesceRequests() bool {
+	return true
+}
+
+func (h *ProxyHelloHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upstream := h.upstream
+	if upstream == "" {
+		upstream = "http://" + h.info.Addr().String() + "/hello"
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, upstream, r.Body)
+	if err != nil {
+		h.errs.Write(w, r, err)
+		return
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		h.errs.Write(w, r, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		h.log.Error("Failed to copy upstream response", slog.String("err", err.Error()))
+	}
+}
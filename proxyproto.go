@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The signatures opening PROXY protocol headers.
+var (
+	proxyV1Signature = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+var (
+	// errNoProxyHeader is reported when a connection required to start
+	// with a PROXY protocol header doesn't.
+	errNoProxyHeader = errors.New("missing PROXY protocol header")
+	// errUntrustedProxy is reported when a connection from a peer that
+	// isn't a trusted proxy starts with a PROXY protocol header, or
+	// doesn't when headers are required.
+	errUntrustedProxy = errors.New("connection not from a trusted proxy")
+)
+
+// ProxyProtocol wraps the server's listener to read the PROXY protocol
+// header, version 1 or 2, that a load balancer in TCP mode such as
+// HAProxy sends ahead of each connection, so that the connection's
+// RemoteAddr is the client's rather than the load balancer's. The header
+// is read when the connection is first used, so a slow client can't
+// hold up accepting others, and must arrive within
+// Config.Server.ProxyProtocol.HeaderTimeout. Connections without a
+// header are rejected if it's required and served as they are
+// otherwise. Connections with a malformed header are closed. With
+// Config.Server.ProxyProtocol.TrustedProxies set, only the peers listed
+// may send a header: a header from any other peer is rejected, so that
+// clients reaching the server directly can't claim any address.
+type ProxyProtocol struct {
+	enabled  bool
+	required bool
+	trusted  []netip.Prefix
+	timeout  time.Duration
+	log      *slog.Logger
+	rejected *prometheus.CounterVec
+}
+
+// NewProxyProtocol builds a new ProxyProtocol.
+func NewProxyProtocol(cfg *Config, reg *prometheus.Registry, log *slog.Logger) (*ProxyProtocol, error) {
+	p := &ProxyProtocol{
+		enabled:  cfg.Server.ProxyProtocol.Enabled,
+		required: cfg.Server.ProxyProtocol.Required,
+		timeout:  time.Duration(cfg.Server.ProxyProtocol.HeaderTimeout),
+		log:      log,
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_server_proxy_protocol_rejected_total",
+			Help: "Connections closed for a missing, malformed or untrusted PROXY protocol header, by reason.",
+		}, []string{"reason"}),
+	}
+	for _, s := range cfg.Server.ProxyProtocol.TrustedProxies {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				return nil, fmt.Errorf("trusted proxy %q is neither a CIDR nor an IP", s)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		p.trusted = append(p.trusted, prefix.Masked())
+	}
+	if p.timeout <= 0 {
+		p.timeout = 5 * time.Second
+	}
+	reg.MustRegister(p.rejected)
+	return p, nil
+}
+
+// trusts reports whether the headers of the peer at addr are trusted.
+func (p *ProxyProtocol) trusts(addr net.Addr) bool {
+	if len(p.trusted) == 0 {
+		return true
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip := tcp.AddrPort().Addr().Unmap()
+	for _, prefix := range p.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Listener returns ln, wrapped to read PROXY protocol headers if they're
+// enabled.
+func (p *ProxyProtocol) Listener(ln net.Listener) net.Listener {
+	if !p.enabled {
+		return ln
+	}
+	return &proxyListener{Listener: ln, proto: p}
+}
+
+type proxyListener struct {
+	net.Listener
+	proto *ProxyProtocol
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: c, proto: l.proto, br: bufio.NewReader(c)}, nil
+}
+
+// proxyConn is a connection whose PROXY protocol header is read on
+// first use.
+type proxyConn struct {
+	net.Conn
+	proto *ProxyProtocol
+	br    *bufio.Reader
+
+	once   sync.Once
+	err    error
+	remote net.Addr
+}
+
+// init reads the header, once.
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.proto.timeout))
+		c.remote, c.err = readProxyHeader(c.br, c.proto.required, c.proto.trusts(c.Conn.RemoteAddr()))
+		_ = c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			reason := "malformed"
+			switch {
+			case errors.Is(c.err, errNoProxyHeader):
+				reason = "missing"
+			case errors.Is(c.err, errUntrustedProxy):
+				reason = "untrusted"
+			}
+			c.proto.rejected.WithLabelValues(reason).Inc()
+			c.proto.log.Warn("Rejected connection",
+				slog.String("remote", c.Conn.RemoteAddr().String()),
+				slog.String("err", c.err.Error()),
+			)
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads the PROXY protocol header from br and returns
+// the client address it advertises, or nil if it advertises none, as
+// with the LOCAL command health checks use. Without a header, it fails
+// if required and otherwise returns nil, leaving br untouched. Unless
+// the peer is trusted, a header, or the lack of a required one, fails.
+func readProxyHeader(br *bufio.Reader, required, trusted bool) (net.Addr, error) {
+	v1 := hasPrefix(br, proxyV1Signature)
+	v2 := !v1 && hasPrefix(br, proxyV2Signature)
+	switch {
+	case !trusted && (v1 || v2 || required):
+		return nil, errUntrustedProxy
+	case v1:
+		return readProxyV1(br)
+	case v2:
+		return readProxyV2(br)
+	case required:
+		return nil, errNoProxyHeader
+	default:
+		return nil, nil
+	}
+}
+
+// hasPrefix reports whether br starts with sig, peeking no further than
+// the first byte that differs so that short requests aren't waited on.
+func hasPrefix(br *bufio.Reader, sig []byte) bool {
+	for n := 1; n <= len(sig); n++ {
+		b, err := br.Peek(n)
+		if err != nil || !bytes.Equal(b, sig[:n]) {
+			return false
+		}
+	}
+	return true
+}
+
+// readProxyV1 reads a text header, such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyV1(br *bufio.Reader) (net.Addr, error) {
+	// A v1 header is at most 107 bytes long.
+	var line []byte
+	for len(line) < 107 {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("PROXY v1 header: %w", err)
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			return parseProxyV1(string(line[:len(line)-2]))
+		}
+	}
+	return nil, errors.New("PROXY v1 header too long")
+}
+
+func parseProxyV1(line string) (net.Addr, error) {
+	fields := strings.Split(line, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 reads a binary header.
+func readProxyV2(br *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, fmt.Errorf("PROXY v2 header: %w", err)
+	}
+	verCmd, family := hdr[12], hdr[13]
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, fmt.Errorf("PROXY v2 header: %w", err)
+	}
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", verCmd>>4)
+	}
+	switch verCmd & 0xf {
+	case 0:
+		// LOCAL: the connection is the proxy's own.
+		return nil, nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 command %d", verCmd&0xf)
+	}
+	// Only the address family matters: the transport is TCP, and
+	// addresses of other families, such as Unix sockets, aren't of use.
+	switch family >> 4 {
+	case 1:
+		if len(payload) < 12 {
+			return nil, errors.New("short PROXY v2 IPv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:]))}, nil
+	case 2:
+		if len(payload) < 36 {
+			return nil, errors.New("short PROXY v2 IPv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:]))}, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestProxyProtocolTrustedProxies(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		trusted  []string
+		required bool
+		header   string
+		remote   string // the client address seen, or "" if the connection is rejected
+	}{
+		{name: "trusted", trusted: []string{"127.0.0.0/8"}, header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", remote: "192.0.2.1:56324"},
+		{name: "trusted IP", trusted: []string{"127.0.0.1"}, header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", remote: "192.0.2.1:56324"},
+		{name: "untrusted header", trusted: []string{"10.0.0.0/8"}, header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"},
+		{name: "untrusted without header", trusted: []string{"10.0.0.0/8"}, remote: "127.0.0.1"},
+		{name: "untrusted without required header", trusted: []string{"10.0.0.0/8"}, required: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Server.ProxyProtocol = ProxyProtocolConfig{Enabled: true, Required: tc.required, TrustedProxies: tc.trusted}
+			p, err := NewProxyProtocol(cfg, prometheus.NewRegistry(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ln = p.Listener(ln)
+			defer ln.Close()
+
+			client, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			if _, err := io.WriteString(client, tc.header+"GET / HTTP/1.1\r\n"); err != nil {
+				t.Fatal(err)
+			}
+			c, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			var b [3]byte
+			_, rerr := io.ReadFull(c, b[:])
+			switch host, _, _ := net.SplitHostPort(c.RemoteAddr().String()); {
+			case tc.remote == "" && rerr == nil:
+				t.Errorf("connection served, want it rejected")
+			case tc.remote != "" && (rerr != nil || string(b[:]) != "GET"):
+				t.Errorf("read %q, %v, want the request", b, rerr)
+			case tc.remote != "" && c.RemoteAddr().String() != tc.remote && host != tc.remote:
+				t.Errorf("got remote %s, want %s", c.RemoteAddr(), tc.remote)
+			}
+		})
+	}
+}
+
+func TestProxyProtocolInvalidTrustedProxy(t *testing.T) {
+	cfg := &Config{}
+	cfg.Server.ProxyProtocol.TrustedProxies = []string{"not-an-ip"}
+	if _, err := NewProxyProtocol(cfg, prometheus.NewRegistry(), slog.Default()); err == nil {
+		t.Error("got no error, want the trusted proxy rejected")
+	}
+}
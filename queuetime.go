@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/uberfx/reqctx"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const requestStartHeader = "X-Request-Start"
+
+// QueueTimer is middleware measuring how long each request was queued
+// before the app saw it, from the time the proxy in front of it
+// received the request, passed in the X-Request-Start header. The
+// header holds the time in Unix milliseconds, optionally prefixed with
+// "t=", or in seconds with a fractional part as nginx's $msec. The
+// queue time is made available through reqctx.QueueTime, and so is part
+// of the access log, and observed in the http_request_queue_seconds
+// histogram.
+//
+// Requests queued for longer than Config.Server.QueueTime.MaxAge, such
+// as those a retry storm delivers late, are answered with a 504 rather
+// than served for a client that has likely given up. The clocks of the
+// proxy and of the app may be off by up to
+// Config.Server.QueueTime.ClockSkew: requests are only rejected once
+// queued for longer than the max age plus the skew, and requests
+// seemingly started in the future are taken to have not been queued.
+type QueueTimer struct {
+	maxAge   time.Duration
+	skew     time.Duration
+	errs     *ErrorWriter
+	log      *slog.Logger
+	now      func() time.Time
+	queued   prometheus.Histogram
+	rejected prometheus.Counter
+}
+
+// NewQueueTimer builds a new QueueTimer.
+func NewQueueTimer(cfg *Config, errs *ErrorWriter, log *slog.Logger, reg *prometheus.Registry) *QueueTimer {
+	c := cfg.Server.QueueTime
+	q := &QueueTimer{
+		maxAge: time.Duration(c.MaxAge),
+		skew:   time.Duration(max(c.ClockSkew, 0)),
+		errs:   errs,
+		log:    log,
+		now:    time.Now,
+		queued: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "http_request_queue_seconds",
+			Help:    "Time requests were queued before the app saw them, from X-Request-Start.",
+			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}),
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "http_requests_stale_total",
+			Help: "Requests rejected for having been queued for longer than the max age.",
+		}),
+	}
+	reg.MustRegister(q.queued, q.rejected)
+	return q
+}
+
+func (*QueueTimer) Order() int {
+	return orderQueueTime
+}
+
+func (q *QueueTimer) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.Header.Get(requestStartHeader)
+		if v == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start, ok := parseRequestStart(v)
+		if !ok {
+			q.log.Debug("Ignoring invalid request start", slog.String("value", v), slog.String("path", r.URL.Path))
+			next.ServeHTTP(w, r)
+			return
+		}
+		age := max(q.now().Sub(start), 0)
+		q.queued.Observe(age.Seconds())
+		if q.maxAge > 0 && age > q.maxAge+q.skew {
+			q.rejected.Inc()
+			q.errs.Write(w, r, NewStatusError(http.StatusGatewayTimeout,
+				fmt.Errorf("request queued for %s, longer than the limit of %s", age.Round(time.Millisecond), q.maxAge)))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(reqctx.WithQueueTime(r.Context(), age)))
+	})
+}
+
+// parseRequestStart parses the time a request started given in Unix
+// milliseconds, optionally prefixed with "t=", or in seconds with a
+// fractional part.
+func parseRequestStart(v string) (time.Time, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "t=")
+	if strings.Contains(v, ".") {
+		secs, err := strconv.ParseFloat(v, 64)
+		if err != nil || secs <= 0 {
+			return time.Time{}, false
+		}
+		return time.UnixMicro(int64(secs * 1e6)), true
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms), true
+}
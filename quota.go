@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"example.com/uberfx/clock"
+	"example.com/uberfx/reqctx"
+)
+
+// ErrQuotaExceeded is returned once a client has used up its quota.
+var ErrQuotaExceeded = errors.New("daily upload quota exceeded")
+
+// QuotaStore keeps the bytes used by each client, by day.
+type QuotaStore interface {
+	// Add adds n bytes to the usage of key on day and returns the new
+	// usage.
+	Add(ctx context.Context, key, day string, n int64) (int64, error)
+	// Used returns the usage of key on day.
+	Used(ctx context.Context, key, day string) (int64, error)
+}
+
+// MemoryQuotaStore is a QuotaStore that keeps usage in memory. Only the
+// current day's counters are kept: they're all dropped as soon as usage
+// for a later day is recorded.
+type MemoryQuotaStore struct {
+	mu   sync.Mutex
+	day  string
+	used map[string]int64
+}
+
+// NewMemoryQuotaStore builds a new MemoryQuotaStore.
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{used: make(map[string]int64)}
+}
+
+func (s *MemoryQuotaStore) Add(_ context.Context, key, day string, n int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollover(day)
+	if day != s.day {
+		// A late write for a day already rolled over.
+		return n, nil
+	}
+	s.used[key] += n
+	return s.used[key], nil
+}
+
+func (s *MemoryQuotaStore) Used(_ context.Context, key, day string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollover(day)
+	if day != s.day {
+		return 0, nil
+	}
+	return s.used[key], nil
+}
+
+// rollover drops the counters if day is later than the current one.
+func (s *MemoryQuotaStore) rollover(day string) {
+	if day > s.day {
+		s.day = day
+		clear(s.used)
+	}
+}
+
+// QuotaTracker enforces daily byte quotas per client. Clients are
+// identified by their authenticated principal, or else their IP
+// address. The quota is Config.Upload.DailyQuotaBytes, unless
+// Config.Upload.Quotas overrides it for the principal. Days are UTC
+// days of the tracker's clock.
+type QuotaTracker struct {
+	store     QuotaStore
+	clock     clock.Clock
+	limit     int64
+	overrides map[string]int64
+}
+
+// NewQuotaTracker builds a new QuotaTracker.
+func NewQuotaTracker(store QuotaStore, clk clock.Clock, cfg *Config) *QuotaTracker {
+	t := &QuotaTracker{
+		store:     store,
+		clock:     clk,
+		limit:     cfg.Upload.DailyQuotaBytes,
+		overrides: cfg.Upload.Quotas,
+	}
+	if t.limit <= 0 {
+		t.limit = 100 << 20
+	}
+	return t
+}
+
+// Client returns the key identifying the client making r, and its
+// quota.
+func (t *QuotaTracker) Client(r *http.Request) (string, int64) {
+	if p := reqctx.Principal(r.Context()); p != "" {
+		if limit, ok := t.overrides[p]; ok {
+			return "principal:" + p, limit
+		}
+	}
+	return clientKey(r), t.limit
+}
+
+// clientKey returns the key identifying the client making r: its
+// authenticated principal, or else its IP address.
+func clientKey(r *http.Request) string {
+	if p := reqctx.Principal(r.Context()); p != "" {
+		return "principal:" + p
+	}
+	return "ip:" + reqctx.ClientIP(r.Context())
+}
+
+// Remaining returns how many bytes the client with the given key and
+// quota may still use today.
+func (t *QuotaTracker) Remaining(ctx context.Context, key string, limit int64) (int64, error) {
+	used, err := t.store.Used(ctx, key, t.day())
+	if err != nil {
+		return 0, err
+	}
+	return max(limit-used, 0), nil
+}
+
+// Consume records n bytes used by the client and returns how many
+// remain. Once the quota is exceeded, it returns ErrQuotaExceeded.
+func (t *QuotaTracker) Consume(ctx context.Context, key string, limit, n int64) (int64, error) {
+	used, err := t.store.Add(ctx, key, t.day(), n)
+	if err != nil {
+		return 0, err
+	}
+	if used > limit {
+		return 0, ErrQuotaExceeded
+	}
+	return limit - used, nil
+}
+
+func (t *QuotaTracker) day() string {
+	return t.clock.Now().UTC().Format(time.DateOnly)
+}
+
+// quotaReader charges the bytes read through it to a client's quota,
+// failing the read that exceeds it.
+type quotaReader struct {
+	r         io.Reader
+	ctx       context.Context
+	tracker   *QuotaTracker
+	key       string
+	limit     int64
+	remaining int64
+}
+
+func (q *quotaReader) Read(b []byte) (int, error) {
+	n, err := q.r.Read(b)
+	if n > 0 {
+		remaining, qerr := q.tracker.Consume(q.ctx, q.key, q.limit, int64(n))
+		q.remaining = remaining
+		if qerr != nil {
+			return 0, qerr
+		}
+	}
+	return n, err
+}
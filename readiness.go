@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"example.com/uberfx/httpjson"
+)
+
+// Readiness tracks whether the app is ready to take traffic. It starts
+// out not ready.
+type Readiness struct {
+	ready atomic.Bool
+}
+
+// NewReadiness builds a new Readiness.
+func NewReadiness() *Readiness {
+	return &Readiness{}
+}
+
+// Ready reports whether the app is ready.
+func (r *Readiness) Ready() bool {
+	return r.ready.Load()
+}
+
+// SetReady marks the app ready or not.
+func (r *Readiness) SetReady(ready bool) {
+	r.ready.Store(ready)
+}
+
+// ReadyzHandler is an HTTP handler that reports the readiness of the
+// app, answering 503 until it's ready, and during maintenance unless
+// Config.Maintenance.KeepReady is set. The app stays ready while
+// dependencies of some routes fail, see DependencyGuard, serving its
+// other routes: those dependencies are listed as degraded instead, and
+// with ?verbose reported as JSON with the routes depending on them.
+type ReadyzHandler struct {
+	readiness   *Readiness
+	maintenance *Maintenance
+	deps        *DependencyGuard
+}
+
+// NewReadyzHandler builds a new ReadyzHandler.
+func NewReadyzHandler(readiness *Readiness, maintenance *Maintenance, deps *DependencyGuard) *ReadyzHandler {
+	return &ReadyzHandler{readiness: readiness, maintenance: maintenance, deps: deps}
+}
+
+func (*ReadyzHandler) Pattern() string {
+	return "GET /readyz"
+}
+
+func (h *ReadyzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status, text := http.StatusOK, "ok"
+	switch {
+	case !h.readiness.Ready():
+		status, text = http.StatusServiceUnavailable, "not ready"
+	case !h.maintenance.Ready():
+		status, text = http.StatusServiceUnavailable, "maintenance"
+	}
+	degraded := h.deps.Degraded()
+	if _, verbose := r.URL.Query()["verbose"]; verbose {
+		w.Header().Set("Cache-Control", "no-store")
+		_ = httpjson.Respond(w, r, status, struct {
+			Status   string               `json:"status"`
+			Degraded []DegradedDependency `json:"degraded"`
+		}{text, append([]DegradedDependency{}, degraded...)})
+		return
+	}
+	if status == http.StatusOK && len(degraded) > 0 {
+		checks := make([]string, len(degraded))
+		for i, d := range degraded {
+			checks[i] = d.Check
+		}
+		text = "ok, degraded: " + strings.Join(checks, ", ")
+	}
+	w.WriteHeader(status)
+	fmt.Fprintln(w, text)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime/debug"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// processStart approximates the time the process started, for the
+// startup duration.
+var processStart = time.Now()
+
+// BuildInfo describes the build of the running binary.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// NewBuildInfo reads the BuildInfo embedded in the binary by the Go
+// toolchain.
+func NewBuildInfo() *BuildInfo {
+	info := &BuildInfo{Version: "(devel)"}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = bi.GoVersion
+	if v := bi.Main.Version; v != "" {
+		info.Version = v
+	}
+	for _, s := range bi.Settings {
+		if s.Key == "vcs.revision" {
+			info.Revision = s.Value
+		}
+	}
+	return info
+}
+
+// ReadyBanner announces that the app has started, once all its OnStart
+// hooks have succeeded.
+type ReadyBanner struct {
+	cfg    *Config
+	build  *BuildInfo
+	server *ServerInfo
+	routes *RouteTable
+	log    *slog.Logger
+	out    io.Writer
+}
+
+// RegisterReadyBanner appends the hook announcing that the app is ready.
+// It must be invoked after everything else appending hooks.
+func RegisterReadyBanner(lc fx.Lifecycle, cfg *Config, build *BuildInfo, server *ServerInfo, routes *RouteTable, log *slog.Logger) {
+	b := &ReadyBanner{cfg: cfg, build: build, server: server, routes: routes, log: log, out: os.Stdout}
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			b.Announce()
+			return nil
+		},
+	})
+}
+
+// Announce logs the "Application ready" event. In development, the
+// route table is printed as well.
+func (b *ReadyBanner) Announce() {
+	name := b.cfg.App.Name
+	if name == "" {
+		name = "uberfx"
+	}
+	addr := ""
+	if a := b.server.Addr(); a != nil {
+		addr = a.String()
+	}
+	entries := b.routes.Entries()
+	b.log.Info("Application ready",
+		slog.String("name", name),
+		slog.String("version", b.build.Version),
+		slog.String("revision", b.build.Revision),
+		slog.String("env", b.cfg.Env),
+		slog.String("addr", addr),
+		slog.Int("routes", len(entries)),
+		slog.Duration("startup", time.Since(processStart)),
+	)
+	if b.cfg.Env != "development" {
+		return
+	}
+	tw := tabwriter.NewWriter(b.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROUTE\tSOURCE")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\n", e.Pattern, e.Source)
+	}
+	_ = tw.Flush()
+}
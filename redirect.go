@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RedirectRoute is a route redirecting requests for a path to another
+// URL, from Config.Redirects.
+type RedirectRoute struct {
+	from          string
+	to            *url.URL
+	status        int
+	preserveQuery bool
+}
+
+// NewRedirects builds the redirect routes of Config.Redirects, provided
+// to the "routes" group as a whole. Each must redirect from a path, with
+// a redirect status, to a URL; a path registered by another route is
+// reported by CheckRedirects.
+func NewRedirects(cfg *Config) ([]Route, error) {
+	routes := make([]Route, 0, len(cfg.Redirects))
+	for _, rc := range cfg.Redirects {
+		r, err := newRedirectRoute(rc)
+		if err != nil {
+			return nil, fmt.Errorf("redirect %q: %w", rc.From, err)
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+func newRedirectRoute(rc RedirectConfig) (*RedirectRoute, error) {
+	if !strings.HasPrefix(rc.From, "/") || strings.ContainsAny(rc.From, "{} ") {
+		return nil, fmt.Errorf("from must be a path without wildcards")
+	}
+	to, err := url.Parse(rc.To)
+	if err != nil || rc.To == "" {
+		return nil, fmt.Errorf("invalid target %q", rc.To)
+	}
+	r := &RedirectRoute{from: rc.From, to: to, status: rc.Status}
+	switch r.status {
+	case 0:
+		r.status = http.StatusMovedPermanently
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, fmt.Errorf("status %d isn't a redirect, want 301, 302, 303, 307 or 308", rc.Status)
+	}
+	switch rc.Query {
+	case "":
+		r.preserveQuery = !to.IsAbs()
+	case "preserve":
+		r.preserveQuery = true
+	case "drop":
+	default:
+		return nil, fmt.Errorf("unknown query handling %q, want preserve or drop", rc.Query)
+	}
+	return r, nil
+}
+
+func (r *RedirectRoute) Pattern() string {
+	return r.from
+}
+
+func (r *RedirectRoute) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	to := *r.to
+	if r.preserveQuery && req.URL.RawQuery != "" {
+		if to.RawQuery == "" {
+			to.RawQuery = req.URL.RawQuery
+		} else {
+			to.RawQuery += "&" + req.URL.RawQuery
+		}
+	}
+	http.Redirect(w, req, to.String(), r.status)
+}
+
+// CheckRedirects fails the app if a redirect of Config.Redirects
+// redirects from the path of another route. The router only rejects
+// patterns registered twice, while a redirect from "/hello" would take
+// the requests a "GET /hello" route doesn't. It takes the router to run
+// once the routes are registered.
+func CheckRedirects(cfg *Config, table *RouteTable, _ Router) error {
+	if len(cfg.Redirects) == 0 {
+		return nil
+	}
+	redirects := make(map[string]bool, len(cfg.Redirects))
+	for _, rc := range cfg.Redirects {
+		redirects[rc.From] = true
+	}
+	for _, e := range table.Entries() {
+		if e.Source == fmt.Sprintf("route %T", (*RedirectRoute)(nil)) {
+			continue
+		}
+		if _, path := splitPattern(e.Pattern); redirects[path] {
+			return fmt.Errorf("redirect %q conflicts with route %q registered by %s", path, e.Pattern, e.Source)
+		}
+	}
+	return nil
+}
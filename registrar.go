@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RouteRegistrar is implemented by handlers that register several
+// related patterns themselves, rather than providing one Route per
+// pattern.
+type RouteRegistrar interface {
+	RegisterRoutes(r Router)
+}
+
+// AsRegistrar annotates the given constructor to state that it provides
+// a route registrar to the "registrars" group.
+func AsRegistrar(f any) any {
+	return AsGroupMember[RouteRegistrar]("registrars", f)
+}
+
+// WithRoles returns h requiring the given roles of the principals using
+// it, for registrars to declare what the routes they register require,
+// as routes do with AuthorizedRoute.
+func WithRoles(h http.Handler, roles ...string) http.Handler {
+	rh := declared(h)
+	rh.roles = roles
+	return rh
+}
+
+// WithBrowserFacing returns h as used from browsers, for registrars to
+// declare the routes they register that need CSRF protection, as routes
+// do with BrowserFacing.
+func WithBrowserFacing(h http.Handler) http.Handler {
+	rh := declared(h)
+	rh.browserFacing = true
+	return rh
+}
+
+// declaredHandler is a handler registered with WithRoles or
+// WithBrowserFacing.
+type declaredHandler struct {
+	http.Handler
+	roles         []string
+	browserFacing bool
+}
+
+// declared returns a copy of h if it's a declaredHandler already, so
+// that declarations add up, or else h as one.
+func declared(h http.Handler) *declaredHandler {
+	if dh, ok := h.(*declaredHandler); ok {
+		c := *dh
+		return &c
+	}
+	return &declaredHandler{Handler: h}
+}
+
+// declaredFuncRoute is the route of a declaredHandler.
+type declaredFuncRoute struct {
+	*funcRoute
+	roles         []string
+	browserFacing bool
+}
+
+func (r *declaredFuncRoute) RequiredRoles() []string {
+	return r.roles
+}
+
+func (r *declaredFuncRoute) BrowserFacing() bool {
+	return r.browserFacing
+}
+
+// recordingRouter is a Router that records the patterns registered with
+// it so that conflicting registrations are reported as errors, whichever
+// way the routes are registered. Each handler is wrapped with the route
+// middleware, like the individual routes are.
+type recordingRouter struct {
+	Router
+	mws      []RouteMiddleware
+	table    *RouteTable
+	patterns map[string]string
+	source   string
+	// mount prefixes the paths recorded, for those of a SubRouter
+	// relative to its mount point.
+	mount string
+	err   error
+}
+
+func newRecordingRouter(next Router, mws []RouteMiddleware, table *RouteTable) *recordingRouter {
+	return &recordingRouter{Router: next, mws: mws, table: table, patterns: make(map[string]string)}
+}
+
+func (r *recordingRouter) Handle(method, pattern string, h http.Handler) {
+	full := r.mount + pattern
+	if method != "" {
+		full = method + " " + full
+	}
+	route, ok := h.(Route)
+	switch dh, isDeclared := h.(*declaredHandler); {
+	case ok:
+	case isDeclared:
+		route = &declaredFuncRoute{funcRoute: &funcRoute{pattern: full, handler: dh.ServeHTTP}, roles: dh.roles, browserFacing: dh.browserFacing}
+	default:
+		route = &funcRoute{pattern: full, handler: h.ServeHTTP}
+	}
+	if !r.claim(full) {
+		return
+	}
+	if sub, ok := h.(*SubRouter); ok {
+		// Its routes are wrapped already.
+		r.Router.Handle(method, pattern, sub)
+		return
+	}
+	r.Router.Handle(method, pattern, wrapRoute(route, r.mws))
+}
+
+// claim records pattern as registered by the current source, reporting
+// the first conflict with an earlier registration.
+func (r *recordingRouter) claim(pattern string) bool {
+	key := wildcardRe.ReplaceAllStringFunc(pattern, func(w string) string {
+		switch name := w[1 : len(w)-1]; {
+		case name == "$":
+			return w
+		case strings.HasSuffix(name, "..."):
+			return "{...}"
+		default:
+			return "{}"
+		}
+	})
+	if prev, ok := r.patterns[key]; ok {
+		if r.err == nil {
+			r.err = fmt.Errorf("route %q registered by %s conflicts with the one registered by %s", pattern, r.source, prev)
+		}
+		return false
+	}
+	r.patterns[key] = r.source
+	r.table.add(RouteEntry{Pattern: pattern, Source: r.source})
+	return true
+}
+
+// RouteEntry is a pattern registered with the router.
+type RouteEntry struct {
+	Pattern string `json:"pattern"`
+	// Source is the route or registrar that registered the pattern.
+	Source string `json:"source"`
+}
+
+// RouteTable lists the patterns registered with the app's router.
+type RouteTable struct {
+	mu      sync.RWMutex
+	entries []RouteEntry
+}
+
+// NewRouteTable builds a new, empty RouteTable.
+func NewRouteTable() *RouteTable {
+	return &RouteTable{}
+}
+
+// Entries returns the registered patterns, sorted.
+func (t *RouteTable) Entries() []RouteEntry {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	entries := append([]RouteEntry(nil), t.entries...)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Pattern < entries[j].Pattern
+	})
+	return entries
+}
+
+func (t *RouteTable) add(e RouteEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = append(t.entries, e)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRegistrarRoutesRequireTheirRoles(t *testing.T) {
+	base := startTestApp(t, withTokens)
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"anonymous", "", http.StatusUnauthorized},
+		{"without the role", "user-token", http.StatusForbidden},
+		{"admin", "admin-token", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := do(t, http.MethodPut, base+"/admin/maintenance", tc.token, `{"enabled": false}`)
+			if status != tc.want {
+				t.Errorf("PUT /admin/maintenance: got %d %s, want %d", status, body, tc.want)
+			}
+		})
+	}
+}
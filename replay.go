@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/uberfx/reqctx"
+	"go.uber.org/fx"
+)
+
+// ReplayRecorder is route middleware that writes the requests of the
+// routes matching Config.Debug.RecordRoutes to files in
+// Config.Debug.RecordDir, to replay them locally with the --replay mode
+// when a client reports a failing one. Each file holds a request as
+// sent over HTTP/1.1, headers and body, so it can also be sent as is
+// with a tool such as nc. Credential headers are redacted unless
+// Config.Debug.RecordSecrets is set.
+//
+// Requests are recorded before they're handled, with bodies of up to
+// Config.Debug.MaxRecordBytes; larger ones aren't recorded. Only the
+// Config.Debug.MaxRecordings latest recordings are kept. Recording can
+// be switched off and on at runtime through /admin/debug/record; in
+// production, routes are never wrapped.
+type ReplayRecorder struct {
+	patterns []string
+	dir      string
+	maxBody  int64
+	maxFiles int
+	secrets  bool
+	log      *slog.Logger
+	now      func() time.Time
+	enabled  atomic.Bool
+	seq      atomic.Uint64
+
+	// mu serializes the eviction of old recordings.
+	mu sync.Mutex
+}
+
+// NewReplayRecorder builds a new ReplayRecorder, enabled if any routes
+// are to be recorded.
+func NewReplayRecorder(cfg *Config, log *slog.Logger) *ReplayRecorder {
+	m := &ReplayRecorder{
+		dir:      cfg.Debug.RecordDir,
+		maxBody:  cfg.Debug.MaxRecordBytes,
+		maxFiles: cfg.Debug.MaxRecordings,
+		secrets:  cfg.Debug.RecordSecrets,
+		log:      log,
+		now:      time.Now,
+	}
+	if cfg.Env != "production" {
+		m.patterns = cfg.Debug.RecordRoutes
+	}
+	if m.dir == "" {
+		m.dir = filepath.Join(os.TempDir(), "uberfx-recordings")
+	}
+	if m.maxBody <= 0 {
+		m.maxBody = 1 << 20
+	}
+	if m.maxFiles <= 0 {
+		m.maxFiles = 100
+	}
+	m.enabled.Store(len(m.patterns) > 0)
+	return m
+}
+
+func (*ReplayRecorder) Order() int {
+	return orderReplayRecord
+}
+
+// Enabled reports whether recording is switched on.
+func (m *ReplayRecorder) Enabled() bool {
+	return m.enabled.Load()
+}
+
+// SetEnabled switches recording on or off.
+func (m *ReplayRecorder) SetEnabled(enabled bool) {
+	m.enabled.Store(enabled)
+}
+
+func (m *ReplayRecorder) WrapRoute(route Route, next http.Handler) http.Handler {
+	pattern := route.Pattern()
+	if !m.matches(pattern) {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.enabled.Load() {
+			m.record(r, pattern)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// matches reports whether the route pattern is one to record.
+func (m *ReplayRecorder) matches(pattern string) bool {
+	for _, want := range m.patterns {
+		if matchRoute(want, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// record writes r to a new recording. The body is read up front, and
+// put back for the handler to read.
+func (m *ReplayRecorder) record(r *http.Request, pattern string) {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		b, err := io.ReadAll(io.LimitReader(r.Body, m.maxBody+1))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+		if err != nil {
+			m.log.Warn("Failed to read request to record", slog.String("route", pattern), slog.String("err", err.Error()))
+			return
+		}
+		if int64(len(b)) > m.maxBody {
+			m.log.Warn("Request too large to record", slog.String("route", pattern), slog.Int64("limit", m.maxBody))
+			return
+		}
+		body = b
+	}
+
+	out := r.Clone(r.Context())
+	if !m.secrets {
+		out.Header = redactHeader(r.Header)
+	}
+	out.TransferEncoding = nil
+	out.ContentLength = int64(len(body))
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	name, err := m.write(out)
+	if err != nil {
+		m.log.Error("Failed to record request", slog.String("route", pattern), slog.String("err", err.Error()))
+		return
+	}
+	m.log.Info("Recorded request",
+		slog.String("route", pattern),
+		slog.String("file", name),
+		slog.String("request_id", reqctx.RequestID(r.Context())))
+	m.evict()
+}
+
+// write writes req to a new file named after the time, aside first so
+// that replays never see a partial recording.
+func (m *ReplayRecorder) write(req *http.Request) (string, error) {
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return "", err
+	}
+	name := filepath.Join(m.dir, fmt.Sprintf("%s-%06d.http", m.now().UTC().Format("20060102T150405.000"), m.seq.Add(1)))
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+	err = req.Write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return name, nil
+}
+
+// evict removes the oldest recordings beyond the maximum. Their names
+// sort in the order they were recorded.
+func (m *ReplayRecorder) evict() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names, err := filepath.Glob(filepath.Join(m.dir, "*.http"))
+	if err != nil || len(names) <= m.maxFiles {
+		return
+	}
+	sort.Strings(names)
+	for _, name := range names[:len(names)-m.maxFiles] {
+		if err := os.Remove(name); err != nil {
+			m.log.Warn("Failed to remove old recording", slog.String("file", name), slog.String("err", err.Error()))
+		}
+	}
+}
+
+// NewReplayRecorderHandler builds the SwitchHandler of request
+// recording, at /admin/debug/record. Recording may only be switched on
+// in development.
+func NewReplayRecorderHandler(rec *ReplayRecorder, cfg *Config, errs *ErrorWriter) *SwitchHandler {
+	return &SwitchHandler{path: "/admin/debug/record", sw: rec, errs: errs, devOnly: true, env: cfg.Env}
+}
+
+// RunReplay replays the request recorded in file, see ReplayRecorder,
+// against the app, built with the given extra options, started on an
+// ephemeral port, prints the response and returns the process exit
+// code.
+func RunReplay(file string, opts ...fx.Option) int {
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Println("replay:", err)
+		return 1
+	}
+	req, err := http.ReadRequest(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		fmt.Println("replay: failed to read recording:", err)
+		return 1
+	}
+	body, err := io.ReadAll(req.Body)
+	f.Close()
+	if err != nil {
+		fmt.Println("replay: failed to read recording:", err)
+		return 1
+	}
+
+	var info *ServerInfo
+	app := NewApp(append(opts, ephemeralAddr, fx.Populate(&info))...)
+	if err := app.Err(); err != nil {
+		fmt.Println("replay: failed to build app:", err)
+		return 1
+	}
+	stop, err := startApp(app)
+	if err != nil {
+		fmt.Println("replay: failed to start app:", err)
+		return 1
+	}
+	defer func() {
+		if err := stop(); err != nil {
+			fmt.Println("replay: failed to stop app:", err)
+		}
+	}()
+
+	// The recorded Host is kept, for routes served by host.
+	req.RequestURI = ""
+	req.URL.Scheme, req.URL.Host = "http", info.Addr().String()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if strings.Contains(req.Header.Get("Authorization"), redacted) {
+		fmt.Println("replay: credentials were redacted from the recording")
+	}
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Println("replay: request failed:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		fmt.Println("replay: failed to read response:", err)
+		return 1
+	}
+	os.Stdout.Write(dump)
+	return 0
+}
+
+// NewRecordDirCheck builds the preflight check that recordings can be
+// written to their directory, if routes are recorded.
+func NewRecordDirCheck(m *ReplayRecorder) *PreflightFunc {
+	return &PreflightFunc{CheckName: "record-dir", Fn: func(context.Context) error {
+		if len(m.patterns) == 0 {
+			return nil
+		}
+		if err := checkWritableDir(m.dir); err != nil {
+			return preflightFailure(err, "make debug.record_dir writable by the user running the app, or set it to another directory")
+		}
+		return nil
+	}}
+}
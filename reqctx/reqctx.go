@@ -0,0 +1,252 @@
+// Package reqctx stores the request-scoped values set by the server's
+// middleware in the request context, behind typed accessors.
+//
+// Each value has a With function returning a copy of the context
+// carrying it, and a getter returning it, or its zero value when the
+// context doesn't carry it. Snapshot collects them all for logging.
+//
+// A Stash in the context caches the values handlers derive from the
+// request, see GetOrCompute.
+package reqctx
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+type (
+	requestIDKey struct{}
+	traceIDKey   struct{}
+	clientIPKey  struct{}
+	principalKey struct{}
+	rolesKey     struct{}
+	sessionKey   struct{}
+	routeKey     struct{}
+	loggerKey    struct{}
+	tlsKey       struct{}
+	queueTimeKey struct{}
+)
+
+// WithRequestID returns a copy of ctx carrying the ID of the request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request, or "" if it has none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithTraceID returns a copy of ctx carrying the ID of the trace the
+// request is part of.
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, id)
+}
+
+// TraceID returns the ID of the trace of the request, or "" if it
+// isn't traced.
+func TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// WithClientIP returns a copy of ctx carrying the IP address of the
+// client making the request.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIP returns the IP address of the client, or "" if it's unknown.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// WithPrincipal returns a copy of ctx carrying the name of the
+// authenticated principal making the request.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// Principal returns the authenticated principal, or "" for anonymous
+// requests.
+func Principal(ctx context.Context) string {
+	p, _ := ctx.Value(principalKey{}).(string)
+	return p
+}
+
+// WithRoles returns a copy of ctx carrying the roles of the
+// authenticated principal.
+func WithRoles(ctx context.Context, roles []string) context.Context {
+	return context.WithValue(ctx, rolesKey{}, roles)
+}
+
+// Roles returns the roles of the authenticated principal, or nil if it
+// has none or the request is anonymous.
+func Roles(ctx context.Context) []string {
+	r, _ := ctx.Value(rolesKey{}).([]string)
+	return r
+}
+
+// WithSession returns a copy of ctx carrying the session of the
+// request.
+func WithSession(ctx context.Context, s any) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// Session returns the session of the request, or the zero value of T
+// if it has none or it isn't a T.
+func Session[T any](ctx context.Context) T {
+	s, _ := ctx.Value(sessionKey{}).(T)
+	return s
+}
+
+// WithRoute returns a copy of ctx carrying the pattern of the route
+// serving the request.
+func WithRoute(ctx context.Context, pattern string) context.Context {
+	return context.WithValue(ctx, routeKey{}, pattern)
+}
+
+// Route returns the pattern of the route serving the request, or ""
+// outside of a route.
+func Route(ctx context.Context) string {
+	p, _ := ctx.Value(routeKey{}).(string)
+	return p
+}
+
+// TLSInfo describes the TLS connection a request came over.
+type TLSInfo struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	// ServerName is the name the client asked for through SNI.
+	ServerName string `json:"server_name,omitempty"`
+	// ClientSubject is the subject of the client's certificate, with
+	// mutual TLS.
+	ClientSubject string `json:"client_subject,omitempty"`
+}
+
+// LogValue represents the connection as a group of the fields set.
+func (t TLSInfo) LogValue() slog.Value {
+	attrs := []slog.Attr{slog.String("version", t.Version), slog.String("cipher_suite", t.CipherSuite)}
+	if t.ServerName != "" {
+		attrs = append(attrs, slog.String("server_name", t.ServerName))
+	}
+	if t.ClientSubject != "" {
+		attrs = append(attrs, slog.String("client_subject", t.ClientSubject))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// WithTLS returns a copy of ctx carrying the TLS connection of the
+// request.
+func WithTLS(ctx context.Context, info TLSInfo) context.Context {
+	return context.WithValue(ctx, tlsKey{}, &info)
+}
+
+// TLS returns the TLS connection of the request, or nil if it didn't
+// come over TLS.
+func TLS(ctx context.Context) *TLSInfo {
+	t, _ := ctx.Value(tlsKey{}).(*TLSInfo)
+	return t
+}
+
+// WithQueueTime returns a copy of ctx carrying how long the request
+// was queued before the app saw it.
+func WithQueueTime(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, queueTimeKey{}, d)
+}
+
+// QueueTime returns how long the request was queued before the app saw
+// it, or zero if that isn't known.
+func QueueTime(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(queueTimeKey{}).(time.Duration)
+	return d
+}
+
+// WithLogger returns a copy of ctx carrying a request-scoped logger.
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// Logger returns the request-scoped logger, or fallback if there's
+// none.
+func Logger(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return fallback
+}
+
+// Deadline returns the deadline of the request, or the zero time if it
+// has none.
+func Deadline(ctx context.Context) time.Time {
+	d, _ := ctx.Deadline()
+	return d
+}
+
+// Values are the request-scoped values of a context. The session is
+// only reported as present, since its ID is a credential.
+type Values struct {
+	RequestID  string    `json:"request_id,omitempty"`
+	TraceID    string    `json:"trace_id,omitempty"`
+	ClientIP   string    `json:"client_ip,omitempty"`
+	Principal  string    `json:"principal,omitempty"`
+	HasSession bool      `json:"has_session,omitempty"`
+	Route      string    `json:"route,omitempty"`
+	Deadline   time.Time `json:"deadline"`
+	TLS        *TLSInfo  `json:"tls,omitempty"`
+	// QueueTime is how long the request was queued before the app saw
+	// it.
+	QueueTime time.Duration `json:"queue_time,omitempty"`
+}
+
+// Snapshot returns the request-scoped values of ctx.
+func Snapshot(ctx context.Context) Values {
+	return Values{
+		RequestID:  RequestID(ctx),
+		TraceID:    TraceID(ctx),
+		ClientIP:   ClientIP(ctx),
+		Principal:  Principal(ctx),
+		HasSession: ctx.Value(sessionKey{}) != nil,
+		Route:      Route(ctx),
+		Deadline:   Deadline(ctx),
+		TLS:        TLS(ctx),
+		QueueTime:  QueueTime(ctx),
+	}
+}
+
+// LogValue represents the values as a group of those present.
+func (v Values) LogValue() slog.Value {
+	return slog.GroupValue(v.Attrs()...)
+}
+
+// Attrs returns the values present as log attributes.
+func (v Values) Attrs() []slog.Attr {
+	var attrs []slog.Attr
+	for _, a := range []struct{ key, value string }{
+		{"request_id", v.RequestID},
+		{"trace_id", v.TraceID},
+		{"client_ip", v.ClientIP},
+		{"principal", v.Principal},
+		{"route", v.Route},
+	} {
+		if a.value != "" {
+			attrs = append(attrs, slog.String(a.key, a.value))
+		}
+	}
+	if v.HasSession {
+		attrs = append(attrs, slog.Bool("session", true))
+	}
+	if !v.Deadline.IsZero() {
+		attrs = append(attrs, slog.Time("deadline", v.Deadline))
+	}
+	if v.TLS != nil {
+		attrs = append(attrs, slog.Any("tls", *v.TLS))
+	}
+	if v.QueueTime > 0 {
+		attrs = append(attrs, slog.Duration("queue_time", v.QueueTime))
+	}
+	return attrs
+}
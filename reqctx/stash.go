@@ -0,0 +1,133 @@
+package reqctx
+
+import (
+	"context"
+	"sync"
+)
+
+type stashKey struct{}
+
+// Key identifies a value of type T in a Stash. Keys are compared by
+// identity, so each is made once, with NewKey, and kept in a package
+// variable:
+//
+//	var geoKey = reqctx.NewKey[*Location]("geo")
+//
+//	loc, err := reqctx.GetOrCompute(ctx, geoKey, func() (*Location, error) {
+//		return lookup(reqctx.ClientIP(ctx))
+//	})
+type Key[T any] struct {
+	name string
+}
+
+// NewKey returns a new key for values of type T. The name labels the
+// key's lookups in metrics.
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+// Name returns the name of the key.
+func (k *Key[T]) Name() string {
+	return k.name
+}
+
+// Stash caches the values derived from a request, such as a parsed
+// token or the location of the client, so that the middleware and
+// handlers needing them compute them once. The server places a stash in
+// the context of each request and discards it once the request is done.
+//
+// A stash isn't safe for concurrent use: it's meant for the goroutine
+// handling the request. Handlers spawning goroutines that use it, as
+// streaming handlers may, call Concurrent first.
+type Stash struct {
+	entries map[any]*stashEntry
+	// mu is set by Concurrent.
+	mu        *sync.Mutex
+	discarded bool
+	observe   func(key string, hit bool)
+}
+
+type stashEntry struct {
+	once  sync.Once
+	value any
+	err   error
+}
+
+// NewStash returns an empty stash. If observe isn't nil, it's called
+// with the name of the key of each lookup and whether it was a hit.
+func NewStash(observe func(key string, hit bool)) *Stash {
+	return &Stash{entries: make(map[any]*stashEntry), observe: observe}
+}
+
+// Concurrent makes the stash safe for concurrent use from then on. It
+// must be called before the goroutines sharing the stash are started.
+func (s *Stash) Concurrent() {
+	if s.mu == nil {
+		s.mu = new(sync.Mutex)
+	}
+}
+
+// Discard empties the stash. Lookups after it compute the value every
+// time, as without a stash.
+func (s *Stash) Discard() {
+	if s.mu != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	s.entries, s.discarded = nil, true
+}
+
+// entry returns the entry of key, or nil once the stash is discarded,
+// reporting whether it was already there.
+func (s *Stash) entry(key any) (e *stashEntry, hit bool) {
+	if s.mu != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	if s.discarded {
+		return nil, false
+	}
+	if e, ok := s.entries[key]; ok {
+		return e, true
+	}
+	e = new(stashEntry)
+	s.entries[key] = e
+	return e, false
+}
+
+// WithStash returns a copy of ctx carrying the stash of the request.
+func WithStash(ctx context.Context, s *Stash) context.Context {
+	return context.WithValue(ctx, stashKey{}, s)
+}
+
+// StashOf returns the stash of the request, or nil if it has none.
+func StashOf(ctx context.Context) *Stash {
+	s, _ := ctx.Value(stashKey{}).(*Stash)
+	return s
+}
+
+// GetOrCompute returns the value of key in the stash of the request,
+// computing it with compute on the first lookup. Errors are cached as
+// values are, so a failed computation isn't retried within the request.
+// Without a stash, or once it's discarded, the value is computed every
+// time.
+//
+// compute may look up other keys, but not key itself.
+func GetOrCompute[T any](ctx context.Context, key *Key[T], compute func() (T, error)) (T, error) {
+	s := StashOf(ctx)
+	if s == nil {
+		return compute()
+	}
+	e, hit := s.entry(key)
+	if s.observe != nil {
+		s.observe(key.name, hit)
+	}
+	if e == nil {
+		return compute()
+	}
+	e.once.Do(func() {
+		e.value, e.err = compute()
+	})
+	v, _ := e.value.(T)
+	return v, e.err
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/uberfx/clock"
+	"example.com/uberfx/reqctx"
+	"example.com/uberfx/spanlog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// slowStackFactor is how many times the slow request threshold a
+// request must run for the goroutine stacks to be logged.
+const slowStackFactor = 5
+
+// RequestLogger is route middleware logging each request once it's
+// done, with its route, status, size and duration, and counting it in
+// http_requests_total. With debug logging on, it opens a spanlog root
+// span under which handlers time their steps with spanlog.Start, and
+// the span tree is part of the record.
+//
+// Server errors, and requests aborted after the response started, are
+// logged as errors and client errors as warnings, always. Successful
+// requests are sampled at Config.Log.Access.SampleRate, by request ID
+// so that all the records of a request are kept or dropped together.
+// As a component, it logs how many records were dropped for each route
+// every Config.Log.Access.SummaryInterval, and once more when it stops.
+// These records go to the access logger, see NewAccessLogger; the
+// others to the app's logger.
+//
+// Requests taking longer than Config.Server.SlowRequestThreshold are
+// also logged as a warning and counted. As a component, it checks the
+// InFlightRequests, where requests are tracked while they're served,
+// every threshold: requests running for 5 times the threshold are
+// logged as stuck, with the stacks of all goroutines to show where; at
+// most one capture is made per Config.Server.SlowStackInterval.
+type RequestLogger struct {
+	log        *slog.Logger
+	access     *slog.Logger
+	slow       time.Duration
+	stackEvery time.Duration
+	clock      clock.Clock
+	inFlight   *InFlightRequests
+	slowTotal  *prometheus.CounterVec
+	requests   *prometheus.CounterVec
+	sampler    *accessSampler
+	summary    time.Duration
+	stop       chan struct{}
+	done       chan struct{}
+
+	// lastStack is the time of the last stack capture, in Unix
+	// nanoseconds.
+	lastStack atomic.Int64
+}
+
+// NewRequestLogger builds a new RequestLogger.
+func NewRequestLogger(cfg *Config, log, access *slog.Logger, clk clock.Clock, inFlight *InFlightRequests, reg *prometheus.Registry) *RequestLogger {
+	m := &RequestLogger{
+		log:        log,
+		access:     access,
+		slow:       time.Duration(cfg.Server.SlowRequestThreshold),
+		stackEvery: time.Duration(cfg.Server.SlowStackInterval),
+		clock:      clk,
+		inFlight:   inFlight,
+		slowTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_slow_requests_total",
+			Help: "Requests that took longer than the slow request threshold, by route.",
+		}, []string{"route"}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Requests handled, by route and status.",
+		}, []string{"route", "status"}),
+		sampler: newAccessSampler(cfg.Log.Access.SampleRate),
+		summary: time.Duration(cfg.Log.Access.SummaryInterval),
+	}
+	if m.summary <= 0 {
+		m.summary = time.Minute
+	}
+	if m.slow <= 0 {
+		m.slow = time.Second
+	}
+	if m.stackEvery <= 0 {
+		m.stackEvery = time.Minute
+	}
+	reg.MustRegister(m.slowTotal, m.requests)
+	return m
+}
+
+func (*RequestLogger) Order() int {
+	return orderRequestLog
+}
+
+func (*RequestLogger) Name() string {
+	return "request-logger"
+}
+
+func (*RequestLogger) Priority() int {
+	return priorityRequestLog
+}
+
+func (m *RequestLogger) Start(context.Context) error {
+	m.stop, m.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(m.done)
+		t := m.clock.NewTicker(m.summary)
+		defer t.Stop()
+		stuck := m.clock.NewTicker(m.slow)
+		defer stuck.Stop()
+		for {
+			select {
+			case <-t.C():
+				m.logSummary()
+			case <-stuck.C():
+				m.logStuck()
+			case <-m.stop:
+				m.logSummary()
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (m *RequestLogger) Stop(ctx context.Context) error {
+	close(m.stop)
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// logSummary logs how many records were dropped by route since the
+// last summary, if any were.
+func (m *RequestLogger) logSummary() {
+	counts := m.sampler.drain()
+	if len(counts) == 0 {
+		return
+	}
+	var total int64
+	attrs := make([]any, 0, len(counts))
+	for route, n := range counts {
+		total += n
+		attrs = append(attrs, slog.Int64(route, n))
+	}
+	m.access.Info("Sampled out access logs",
+		slog.Int64("total", total),
+		slog.Group("routes", attrs...),
+	)
+}
+
+func (m *RequestLogger) WrapRoute(route Route, next http.Handler) http.Handler {
+	pattern := route.Pattern()
+	slowTotal := m.slowTotal.WithLabelValues(pattern)
+	suppressed := m.sampler.route(pattern)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := m.clock.Now()
+		var root *spanlog.Span
+		if m.log.Enabled(r.Context(), slog.LevelDebug) {
+			var ctx context.Context
+			root, ctx = spanlog.New(r.Context(), pattern, m.clock.Now)
+			r = r.WithContext(ctx)
+		}
+		defer m.inFlight.track(pattern, reqctx.RequestID(r.Context()), start)()
+		rec := newResponseRecorder(w)
+		aborted := serveAbortable(next, rec, r)
+		took := m.clock.Now().Sub(start)
+
+		status := rec.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.requests.WithLabelValues(pattern, strconv.Itoa(status)).Inc()
+
+		requestID := reqctx.RequestID(r.Context())
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", pattern),
+			slog.Int("status", rec.Status()),
+			slog.Int64("bytes", rec.bytes),
+			slog.Duration("duration", took),
+			slog.String("request_id", requestID),
+		}
+		if root != nil {
+			root.End()
+			attrs = append(attrs, slog.Any("spans", root))
+		}
+		if aborted {
+			attrs = append(attrs, slog.Bool("aborted", true))
+			// The abort carries on up to the server once the request is
+			// logged.
+			defer panic(http.ErrAbortHandler)
+		}
+		switch {
+		case status >= 500 || aborted:
+			m.access.LogAttrs(r.Context(), slog.LevelError, "Handled request", attrs...)
+		case status >= 400:
+			m.access.LogAttrs(r.Context(), slog.LevelWarn, "Handled request", attrs...)
+		case m.sampler.keep(requestID):
+			m.access.LogAttrs(r.Context(), slog.LevelInfo, "Handled request", attrs...)
+		default:
+			suppressed.Add(1)
+		}
+
+		if took < m.slow {
+			return
+		}
+		slowTotal.Inc()
+		attrs = []slog.Attr{
+			slog.String("route", pattern),
+			slog.Duration("duration", took),
+			slog.Duration("threshold", m.slow),
+			slog.String("request_id", requestID),
+		}
+		m.log.LogAttrs(r.Context(), slog.LevelWarn, "Slow request", attrs...)
+	})
+}
+
+// serveAbortable serves r with h, reporting whether h aborted with
+// http.ErrAbortHandler, as ErrorWriter.SafeError does. Other panics go
+// on.
+func serveAbortable(h http.Handler, w http.ResponseWriter, r *http.Request) (aborted bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			if p != http.ErrAbortHandler {
+				panic(p)
+			}
+			aborted = true
+		}
+	}()
+	h.ServeHTTP(w, r)
+	return false
+}
+
+// takeStack reports whether a stack may be captured now, recording the
+// capture if so.
+func (m *RequestLogger) takeStack() bool {
+	now := m.clock.Now().UnixNano()
+	last := m.lastStack.Load()
+	if last != 0 && now-last < int64(m.stackEvery) {
+		return false
+	}
+	return m.lastStack.CompareAndSwap(last, now)
+}
+
+// logStuck logs the requests running for slowStackFactor times the
+// slow request threshold, with the stacks of all goroutines, unless
+// they were captured less than the stack interval ago.
+func (m *RequestLogger) logStuck() {
+	stuck := m.inFlight.olderThan(slowStackFactor * m.slow)
+	if len(stuck) == 0 || !m.takeStack() {
+		return
+	}
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	m.log.Warn("Stuck requests",
+		slog.Int("count", len(stuck)),
+		slog.Any("requests", stuck),
+		slog.String("stacks", string(buf)),
+	)
+}
+
+// accessSampler decides which records of successful requests are kept,
+// and counts the others by route. Deciding and counting are lock-free.
+type accessSampler struct {
+	// threshold is the rate scaled to the range of a uint64 hash; a
+	// request is kept if its hash falls below it.
+	threshold uint64
+	all       bool
+
+	mu     sync.Mutex
+	routes map[string]*atomic.Int64
+}
+
+// newAccessSampler returns a sampler keeping the given share of
+// records; zero or more than 1 keeps them all.
+func newAccessSampler(rate float64) *accessSampler {
+	s := &accessSampler{routes: make(map[string]*atomic.Int64)}
+	if rate <= 0 || rate >= 1 {
+		s.all = true
+	} else {
+		s.threshold = uint64(rate * math.MaxUint64)
+	}
+	return s
+}
+
+// route returns the counter of the records dropped for the route with
+// the given pattern.
+func (s *accessSampler) route(pattern string) *atomic.Int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.routes[pattern]
+	if !ok {
+		c = new(atomic.Int64)
+		s.routes[pattern] = c
+	}
+	return c
+}
+
+// keep reports whether the record of the request with the given ID is
+// kept. Requests without an ID are sampled at random.
+func (s *accessSampler) keep(requestID string) bool {
+	if s.all {
+		return true
+	}
+	var h uint64
+	if requestID == "" {
+		h = rand.Uint64()
+	} else {
+		f := fnv.New64a()
+		_, _ = f.Write([]byte(requestID))
+		h = mix64(f.Sum64())
+	}
+	return h < s.threshold
+}
+
+// mix64 spreads the bits of h, since FNV hashes of IDs differing only
+// in their last characters aren't uniform enough in their high bits.
+// It's the finalizer of SplitMix64.
+func mix64(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// drain returns the non-zero counts of dropped records by route, and
+// resets them.
+func (s *accessSampler) drain() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var counts map[string]int64
+	for route, c := range s.routes {
+		if n := c.Swap(0); n > 0 {
+			if counts == nil {
+				counts = make(map[string]int64)
+			}
+			counts[route] = n
+		}
+	}
+	return counts
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRequestLoggerStuck(t *testing.T) {
+	clk := testsupport.NewFakeClock(time.Now())
+	logs := &recordHandler{}
+	cfg := &Config{}
+	cfg.Server.SlowRequestThreshold = Duration(time.Second)
+	cfg.Server.SlowStackInterval = Duration(time.Minute)
+	log := slog.New(logs)
+	m := NewRequestLogger(cfg, log, log, clk, NewInFlightRequests(cfg, clk, log), prometheus.NewRegistry())
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(context.Background())
+	// Wait for the loop's summary and stuck tickers.
+	clk.BlockUntil(2)
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	route := newFuncRoute("/block", http.MethodGet, func(http.ResponseWriter, *http.Request) {
+		close(entered)
+		<-release
+	})
+	h := m.WrapRoute(route, route)
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/block", nil))
+	}()
+	<-entered
+
+	for range 5 {
+		clk.Advance(time.Second)
+	}
+	rec := waitRecords(t, logs, "Stuck requests", 1)
+	if got := fmt.Sprint(rec["requests"]); got != "[{GET /block  5s}]" {
+		t.Errorf("got stuck requests %s, want GET /block at 5s", got)
+	}
+	if stacks, _ := rec["stacks"].(string); !strings.Contains(stacks, "goroutine") {
+		t.Errorf("got stacks %q, want the goroutine stacks", stacks)
+	}
+
+	// Stacks are captured at most once per interval.
+	for range 10 {
+		clk.Advance(time.Second)
+	}
+	if n := len(logs.named("Stuck requests")); n != 1 {
+		t.Errorf("got %d captures within the interval, want 1", n)
+	}
+	clk.Advance(time.Minute)
+	waitRecords(t, logs, "Stuck requests", 2)
+
+	close(release)
+	<-served
+	rec = waitRecords(t, logs, "Slow request", 1)
+	if _, ok := rec["stack"]; ok {
+		t.Error("slow request logged with a stack")
+	}
+}
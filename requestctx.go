@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+
+	"example.com/uberfx/reqctx"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// RequestContext is the outermost middleware, storing the ID of each
+// request, from its X-Request-ID header or else generated, the ID of
+// its trace, from its Traceparent header, and the IP address of its
+// client in the request context, see package reqctx. The request ID is
+// echoed in the X-Request-ID header of the response. It also places a
+// reqctx.Stash there for handlers and middleware to cache the values
+// they derive from the request, and discards it once the request is
+// done; lookups are counted by key and whether they hit in
+// http_request_stash_lookups_total.
+type RequestContext struct {
+	lookups *prometheus.CounterVec
+}
+
+// NewRequestContext builds a new RequestContext.
+func NewRequestContext(reg *prometheus.Registry) *RequestContext {
+	m := &RequestContext{
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_request_stash_lookups_total",
+			Help: "Lookups in the request stash, by key and result: hit or miss.",
+		}, []string{"key", "result"}),
+	}
+	reg.MustRegister(m.lookups)
+	return m
+}
+
+func (*RequestContext) Order() int {
+	return orderRequestContext
+}
+
+func (m *RequestContext) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			// Set on the request too, so that it's propagated upstream.
+			id = newRequestID()
+			r.Header.Set(requestIDHeader, id)
+		}
+		ctx = reqctx.WithRequestID(ctx, id)
+		w.Header().Set(requestIDHeader, id)
+		if id := traceID(r.Header.Get("Traceparent")); id != "" {
+			ctx = reqctx.WithTraceID(ctx, id)
+		}
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		ctx = reqctx.WithClientIP(ctx, ip)
+		stash := reqctx.NewStash(m.observe)
+		defer stash.Discard()
+		ctx = reqctx.WithStash(ctx, stash)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newRequestID returns a random request ID of 32 hex digits.
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (m *RequestContext) observe(key string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.lookups.WithLabelValues(key, result).Inc()
+}
+
+// traceID returns the trace ID of a W3C traceparent header, such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", or "" if
+// it's malformed.
+func traceID(traceparent string) string {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || strings.Trim(parts[1], "0") == "" {
+		return ""
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return ""
+	}
+	return parts[1]
+}
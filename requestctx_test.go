@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	base := startTestApp(t, nil)
+	get := func(id string) string {
+		req, err := http.NewRequest(http.MethodGet, base+"/hello", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get(requestIDHeader)
+	}
+	if got := get("abc-123"); got != "abc-123" {
+		t.Errorf("with an ID: got %q echoed, want abc-123", got)
+	}
+	first, second := get(""), get("")
+	if len(first) != 32 || first == second {
+		t.Errorf("without an ID: got %q then %q, want distinct generated IDs", first, second)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// defaultMaxResponseBytes is the default cap of outbound response
+// bodies, see Config.Client.MaxResponseBytes.
+const defaultMaxResponseBytes = 32 << 20
+
+// ErrResponseTooLarge is reported, wrapped in a *ResponseTooLargeError,
+// when an outbound response body exceeds its cap.
+var ErrResponseTooLarge = errors.New("upstream response body too large")
+
+// ResponseTooLargeError is the error of an outbound response body over
+// its cap.
+type ResponseTooLargeError struct {
+	// Limit is the cap of the body, in bytes.
+	Limit int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("upstream response body exceeds the limit of %d bytes", e.Limit)
+}
+
+func (e *ResponseTooLargeError) Is(target error) bool {
+	return target == ErrResponseTooLarge
+}
+
+type responseLimitKey struct{}
+
+// WithResponseLimit returns a copy of ctx capping the bodies of the
+// responses to the outbound requests made with it at limit bytes,
+// rather than Config.Client.MaxResponseBytes. A negative limit lifts
+// the cap.
+func WithResponseLimit(ctx context.Context, limit int64) context.Context {
+	return context.WithValue(ctx, responseLimitKey{}, limit)
+}
+
+// responseLimitTransport is an http.RoundTripper capping the bodies of
+// responses, so that a misbehaving upstream can't have a handler
+// reading its response run out of memory. Responses declaring a length
+// over the cap fail at once; others fail with a *ResponseTooLargeError
+// once more than the cap has been read of them.
+type responseLimitTransport struct {
+	next  http.RoundTripper
+	limit int64
+}
+
+func newResponseLimitTransport(next http.RoundTripper, cfg ClientConfig) *responseLimitTransport {
+	t := &responseLimitTransport{next: next, limit: cfg.MaxResponseBytes}
+	if t.limit == 0 {
+		t.limit = defaultMaxResponseBytes
+	}
+	return t
+}
+
+func (t *responseLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	limit := responseLimit(req.Context(), t.limit)
+	if limit < 0 {
+		return resp, nil
+	}
+	if resp.ContentLength > limit {
+		resp.Body.Close()
+		return nil, &ResponseTooLargeError{Limit: limit}
+	}
+	resp.Body = &limitedResponseBody{r: resp.Body, limit: limit}
+	return resp, nil
+}
+
+// responseLimit returns the cap set on ctx with WithResponseLimit, or
+// else def.
+func responseLimit(ctx context.Context, def int64) int64 {
+	if limit, ok := ctx.Value(responseLimitKey{}).(int64); ok {
+		return limit
+	}
+	return def
+}
+
+// limitedResponseBody is a response body failing with a
+// *ResponseTooLargeError once more than limit bytes have been read from
+// it.
+type limitedResponseBody struct {
+	r     io.ReadCloser
+	limit int64
+	n     int64
+}
+
+func (b *limitedResponseBody) Read(p []byte) (int, error) {
+	if b.n > b.limit {
+		return 0, &ResponseTooLargeError{Limit: b.limit}
+	}
+	// Reading one byte past the limit tells a body of exactly the limit
+	// from a larger one.
+	if rest := b.limit - b.n + 1; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if b.n > b.limit {
+		return n - int(b.n-b.limit), &ResponseTooLargeError{Limit: b.limit}
+	}
+	return n, err
+}
+
+func (b *limitedResponseBody) Close() error {
+	return b.r.Close()
+}
+
+// ReadAllLimited reads the body of resp, failing with a
+// *ResponseTooLargeError if it exceeds its cap: that of the shared
+// client for its responses, or otherwise that set on the request's
+// context with WithResponseLimit, or 32 MiB. Handlers read upstream
+// responses with it rather than io.ReadAll.
+func ReadAllLimited(resp *http.Response) ([]byte, error) {
+	body := resp.Body
+	if _, ok := body.(*limitedResponseBody); !ok {
+		limit := int64(defaultMaxResponseBytes)
+		if resp.Request != nil {
+			limit = responseLimit(resp.Request.Context(), limit)
+		}
+		if limit >= 0 {
+			body = &limitedResponseBody{r: body, limit: limit}
+		}
+	}
+	return io.ReadAll(body)
+}
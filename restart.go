@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"example.com/uberfx/clock"
+)
+
+// The environment variables telling a process started by a restart
+// which inherited file descriptors are the listener and the pipe to
+// report readiness on.
+const (
+	listenerFDEnv = "UBERFX_LISTENER_FD"
+	readyFDEnv    = "UBERFX_READY_FD"
+)
+
+// readyMessage is written to the readiness pipe once the new process
+// has started.
+const readyMessage = "ready\n"
+
+// listen returns the listener the named server is to accept
+// connections from: the one inherited from the process that started
+// this one, if any, or else the socket of that name passed by systemd
+// socket activation, or else a new one on addr.
+func listen(name, addr string, log *slog.Logger) (net.Listener, error) {
+	fd, ok, err := inheritedFD(listenerFDEnv)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		f := os.NewFile(fd, "listener")
+		defer f.Close()
+		ln, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("inherited listener: %w", err)
+		}
+		return ln, nil
+	}
+	ln, fd, err := activatedListener(name)
+	if err != nil {
+		return nil, err
+	}
+	if ln != nil {
+		log.Info("Using socket from systemd activation", slog.String("server", name), slog.Int("fd", int(fd)), slog.String("addr", ln.Addr().String()))
+		return ln, nil
+	}
+	return net.Listen("tcp", addr)
+}
+
+// inheritedFD returns the file descriptor named by the environment
+// variable, which is then unset so it isn't passed on again.
+func inheritedFD(env string) (uintptr, bool, error) {
+	v, ok := os.LookupEnv(env)
+	if !ok {
+		return 0, false, nil
+	}
+	os.Unsetenv(env)
+	fd, err := strconv.ParseUint(v, 10, 0)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: invalid file descriptor %q", env, v)
+	}
+	return uintptr(fd), true, nil
+}
+
+// Restarter is the component that lets the app be restarted without
+// dropping connections. On SIGUSR2 it starts a new copy of the binary,
+// with the same arguments, handing it the server's listener. Once the
+// new process reports that it's ready, this one shuts down as usual,
+// draining its in-flight requests while the new one accepts
+// connections. If the new process exits or doesn't report ready within
+// Config.App.RestartTimeout, it's killed and this one keeps serving.
+type Restarter struct {
+	info     *ServerInfo
+	shutdown *ShutdownRecorder
+	timeout  time.Duration
+	clock    clock.Clock
+	log      *slog.Logger
+
+	sig  chan os.Signal
+	done chan struct{}
+}
+
+// NewRestarter builds a new Restarter.
+func NewRestarter(info *ServerInfo, shutdown *ShutdownRecorder, cfg *Config, clk clock.Clock, log *slog.Logger) *Restarter {
+	r := &Restarter{info: info, shutdown: shutdown, timeout: time.Duration(cfg.App.RestartTimeout), clock: clk, log: log}
+	if r.timeout <= 0 {
+		r.timeout = 30 * time.Second
+	}
+	return r
+}
+
+func (*Restarter) Name() string {
+	return "restarter"
+}
+
+func (*Restarter) Priority() int {
+	return priorityRestart
+}
+
+func (r *Restarter) Start(context.Context) error {
+	if err := signalReady(); err != nil {
+		return err
+	}
+	r.sig = make(chan os.Signal, 1)
+	r.done = make(chan struct{})
+	signal.Notify(r.sig, syscall.SIGUSR2)
+	go func() {
+		defer close(r.done)
+		for range r.sig {
+			if err := r.Restart(); err != nil {
+				r.log.Error("Restart failed, still serving", slog.String("err", err.Error()))
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *Restarter) Stop(ctx context.Context) error {
+	signal.Stop(r.sig)
+	close(r.sig)
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Restart starts the process to take over and, once it's ready, shuts
+// this one down.
+func (r *Restarter) Restart() error {
+	ln, ok := r.info.Listener().(interface{ File() (*os.File, error) })
+	if !ok {
+		return errors.New("listener can't be passed on")
+	}
+	lnFile, err := ln.File()
+	if err != nil {
+		return fmt.Errorf("listener file: %w", err)
+	}
+	defer lnFile.Close()
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer pr.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// ExtraFiles start at file descriptor 3.
+	cmd.ExtraFiles = []*os.File{lnFile, pw}
+	cmd.Env = append(os.Environ(), listenerFDEnv+"=3", readyFDEnv+"=4")
+	err = cmd.Start()
+	pw.Close()
+	if err != nil {
+		return fmt.Errorf("start new process: %w", err)
+	}
+	r.log.Info("Started new process, waiting for it to be ready", slog.Int("pid", cmd.Process.Pid))
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	if err := waitReady(r.clock, pr, exited, r.timeout); err != nil {
+		_ = cmd.Process.Kill()
+		return err
+	}
+	r.log.Info("New process is ready, shutting down", slog.Int("pid", cmd.Process.Pid))
+	return r.shutdown.Shutdown("restart")
+}
+
+// signalReady reports to the process that started this one, if any,
+// that the app is ready.
+func signalReady() error {
+	fd, ok, err := inheritedFD(readyFDEnv)
+	if err != nil || !ok {
+		return err
+	}
+	f := os.NewFile(fd, "ready")
+	defer f.Close()
+	if _, err := io.WriteString(f, readyMessage); err != nil {
+		return fmt.Errorf("report readiness: %w", err)
+	}
+	return nil
+}
+
+// waitReady waits for the ready message to be read from r, failing if
+// the process exits first, as reported on exited, or takes longer than
+// timeout on clk.
+func waitReady(clk clock.Clock, r io.Reader, exited <-chan error, timeout time.Duration) error {
+	ready := make(chan error, 1)
+	go func() {
+		line, err := bufio.NewReader(r).ReadString('\n')
+		if err == nil && line != readyMessage {
+			err = fmt.Errorf("unexpected readiness message %q", line)
+		}
+		ready <- err
+	}()
+	t := clk.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case err := <-ready:
+		if err != nil {
+			return fmt.Errorf("new process didn't report ready: %w", err)
+		}
+		return nil
+	case err := <-exited:
+		return fmt.Errorf("new process exited before it was ready: %v", err)
+	case <-t.C():
+		return fmt.Errorf("new process not ready after %s", timeout)
+	}
+}
+
+// NewListenAddrCheck builds the preflight check that the server can
+// listen on its address, unless it's handed a listener.
+func NewListenAddrCheck(cfg *Config) *PreflightFunc {
+	addr := serverAddr(cfg)
+	return &PreflightFunc{CheckName: "listen-addr", Fn: func(ctx context.Context) error {
+		if _, ok := os.LookupEnv(listenerFDEnv); ok {
+			return nil
+		}
+		sockets, _ := activatedSockets()
+		for _, s := range sockets {
+			if socketName(s, len(sockets)) == publicSocket {
+				return nil
+			}
+		}
+		var lc net.ListenConfig
+		ln, err := lc.Listen(ctx, "tcp", addr)
+		if errors.Is(err, syscall.EADDRINUSE) {
+			return preflightFailure(err, "stop the process listening there, or set server.addr to another address")
+		}
+		if err != nil {
+			return preflightFailure(err, "set server.addr to an address of this host, as in \":8098\"")
+		}
+		return ln.Close()
+	}}
+}
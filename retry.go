@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/uberfx/clock"
+)
+
+// Retrier is an http.RoundTripper that retries idempotent requests on
+// connection errors, 502, 503 and 504 responses, and 429 responses
+// carrying a Retry-After header, with exponential backoff and jitter.
+//
+// Requests with a body are only retried if it can be rewound through
+// Request.GetBody.
+type Retrier struct {
+	next        http.RoundTripper
+	maxAttempts int
+	maxElapsed  time.Duration
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	clock       clock.Clock
+	log         *slog.Logger
+	jitter      func(d time.Duration) time.Duration
+}
+
+// NewRetrier builds a Retrier in front of next.
+func NewRetrier(next http.RoundTripper, cfg RetryConfig, clk clock.Clock, log *slog.Logger) *Retrier {
+	r := &Retrier{
+		next:        next,
+		maxAttempts: cfg.MaxAttempts,
+		maxElapsed:  time.Duration(cfg.MaxElapsed),
+		baseDelay:   time.Duration(cfg.BaseDelay),
+		maxDelay:    time.Duration(cfg.MaxDelay),
+		clock:       clk,
+		log:         log,
+		jitter: func(d time.Duration) time.Duration {
+			// Full jitter in [d/2, d).
+			return d/2 + rand.N(d/2+1)
+		},
+	}
+	if r.maxAttempts <= 0 {
+		r.maxAttempts = 3
+	}
+	if r.maxElapsed <= 0 {
+		r.maxElapsed = 10 * time.Second
+	}
+	if r.baseDelay <= 0 {
+		r.baseDelay = 100 * time.Millisecond
+	}
+	if r.maxDelay <= 0 {
+		r.maxDelay = 2 * time.Second
+	}
+	return r
+}
+
+func (r *Retrier) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isIdempotent(req) || req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return r.next.RoundTrip(req)
+	}
+
+	start := r.clock.Now()
+	for attempt := 1; ; attempt++ {
+		resp, err := r.next.RoundTrip(req)
+		reason, wait := r.shouldRetry(resp, err)
+		if reason == "" || attempt >= r.maxAttempts {
+			return resp, err
+		}
+
+		delay := r.backoff(attempt)
+		if wait > delay {
+			delay = wait
+		}
+		if r.clock.Now().Add(delay).Sub(start) > r.maxElapsed {
+			return resp, err
+		}
+		if req.GetBody != nil {
+			body, berr := req.GetBody()
+			if berr != nil {
+				return resp, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		r.log.Info("Retrying outbound request",
+			slog.String("method", req.Method),
+			slog.String("url", req.URL.Redacted()),
+			slog.Int("attempt", attempt+1),
+			slog.String("reason", reason),
+			slog.Duration("backoff", delay))
+		if err := r.clock.Sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// shouldRetry returns why the outcome of an attempt warrants a retry, or
+// an empty string if it doesn't, along with the minimum wait requested
+// by the server.
+func (r *Retrier) shouldRetry(resp *http.Response, err error) (reason string, wait time.Duration) {
+	if err != nil {
+		if errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return "", 0
+		}
+		return "error: " + err.Error(), 0
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		wait, _ = parseRetryAfter(resp.Header.Get("Retry-After"), r.clock.Now())
+		return fmt.Sprintf("status %d", resp.StatusCode), wait
+	case http.StatusTooManyRequests:
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), r.clock.Now()); ok {
+			return fmt.Sprintf("status %d", resp.StatusCode), wait
+		}
+	}
+	return "", 0
+}
+
+// backoff returns the jittered delay before the given retry.
+func (r *Retrier) backoff(attempt int) time.Duration {
+	d := r.baseDelay << (attempt - 1)
+	if d <= 0 || d > r.maxDelay {
+		d = r.maxDelay
+	}
+	return r.jitter(d)
+}
+
+// isIdempotent reports whether req may safely be sent more than once.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds
+// or as an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+)
+
+func TestRetrierBacksOff(t *testing.T) {
+	clk := testsupport.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	var calls atomic.Int32
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if calls.Add(1) == 1 {
+			resp := respond(req, http.StatusServiceUnavailable)
+			resp.Header.Set("Retry-After", "2")
+			return resp, nil
+		}
+		return respond(req, http.StatusOK), nil
+	})
+	r := NewRetrier(next, RetryConfig{}, clk, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	done := make(chan *http.Response, 1)
+	go func() {
+		resp, err := r.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream/hello", nil))
+		if err != nil {
+			t.Error(err)
+		}
+		done <- resp
+	}()
+
+	// The Retry-After of the 503 is longer than the backoff, and waited
+	// for instead.
+	clk.BlockUntil(1)
+	clk.Advance(2*time.Second - time.Nanosecond)
+	select {
+	case <-done:
+		t.Fatal("retried before the Retry-After")
+	default:
+	}
+	clk.Advance(time.Nanosecond)
+	select {
+	case resp := <-done:
+		if resp == nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %v, want the 200 of the retry", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not retried after the Retry-After")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("got %d calls, want 2", n)
+	}
+}
+
+func TestRetrierMaxElapsed(t *testing.T) {
+	clk := testsupport.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	calls := 0
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		resp := respond(req, http.StatusServiceUnavailable)
+		resp.Header.Set("Retry-After", "20")
+		return resp, nil
+	})
+	r := NewRetrier(next, RetryConfig{MaxElapsed: Duration(10 * time.Second)}, clk, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// Waiting out the Retry-After would take longer than MaxElapsed, so
+	// the 503 is returned right away.
+	resp, err := r.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream/hello", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || calls != 1 {
+		t.Errorf("got %d after %d calls, want 503 after 1", resp.StatusCode, calls)
+	}
+	if n := clk.Pending(); n != 0 {
+		t.Errorf("%d timers left", n)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"example.com/uberfx/httpjson"
+)
+
+// RootHandler answers GET /, which would otherwise be a bare 404 that
+// looks broken to anyone trying the service out, as Config.Server.Root
+// says: with a descriptor of the service, a redirect, or the index page
+// of the static files. It's only registered if no route of the app
+// already handles /, see register.
+type RootHandler struct {
+	mode     string
+	redirect string
+	docs     string
+	name     string
+	build    *BuildInfo
+	table    *RouteTable
+	static   *StaticHandler
+}
+
+// NewRootHandler builds a new RootHandler.
+func NewRootHandler(cfg *Config, build *BuildInfo, table *RouteTable, static *StaticHandler) (*RootHandler, error) {
+	c := cfg.Server.Root
+	h := &RootHandler{
+		mode:     cmp.Or(c.Mode, "descriptor"),
+		redirect: c.Redirect,
+		docs:     c.Docs,
+		name:     cmp.Or(cfg.App.Name, "uberfx"),
+		build:    build,
+		table:    table,
+		static:   static,
+	}
+	switch h.mode {
+	case "descriptor", "spa", "none":
+	case "redirect":
+		if h.redirect == "" {
+			return nil, fmt.Errorf("root: redirect mode without a redirect")
+		}
+	default:
+		return nil, fmt.Errorf("unknown root mode %q, want descriptor, redirect, spa or none", h.mode)
+	}
+	return h, nil
+}
+
+func (*RootHandler) Pattern() string {
+	return "GET /{$}"
+}
+
+// register registers the handler on r, unless its mode is none or a
+// route of the app already handles GET /.
+func (h *RootHandler) register(r *recordingRouter) {
+	if h.mode == "none" {
+		return
+	}
+	for _, e := range h.table.Entries() {
+		method, path := splitPattern(e.Pattern)
+		if (path == "/" || path == "/{$}") && (method == "" || method == http.MethodGet) {
+			return
+		}
+	}
+	r.source = "the root handler"
+	method, path := splitPattern(h.Pattern())
+	r.Handle(method, path, h)
+}
+
+// serviceDescriptor describes the service at /.
+type serviceDescriptor struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Revision string `json:"revision,omitempty"`
+	// Routes are the patterns of the public routes, the admin and debug
+	// ones left out.
+	Routes []string          `json:"routes"`
+	Links  map[string]string `json:"links"`
+}
+
+func (h *RootHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch h.mode {
+	case "redirect":
+		http.Redirect(w, r, h.redirect, http.StatusFound)
+	case "spa":
+		h.static.ServeIndex(w, r)
+	default:
+		_ = httpjson.Respond(w, r, http.StatusOK, h.descriptor())
+	}
+}
+
+func (h *RootHandler) descriptor() *serviceDescriptor {
+	d := &serviceDescriptor{
+		Name:     h.name,
+		Version:  h.build.Version,
+		Revision: h.build.Revision,
+		Routes:   []string{},
+		Links:    map[string]string{"health": "/healthz", "ready": "/readyz"},
+	}
+	if h.docs != "" {
+		d.Links["docs"] = h.docs
+	}
+	for _, e := range h.table.Entries() {
+		_, path := splitPattern(e.Pattern)
+		if internalPath(path) || e.Pattern == h.Pattern() {
+			continue
+		}
+		d.Routes = append(d.Routes, e.Pattern)
+	}
+	return d
+}
+
+// internalPath reports whether path is that of an admin or debug route.
+func internalPath(path string) bool {
+	for _, prefix := range []string{"/admin", "/debug"} {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
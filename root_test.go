@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"go.uber.org/fx"
+)
+
+// getRoot gets / from base without following redirects.
+func getRoot(t *testing.T, base string) (*http.Response, string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(base + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp, string(b)
+}
+
+func TestRootHandler(t *testing.T) {
+	t.Run("descriptor", func(t *testing.T) {
+		base := startTestApp(t, func(cfg *Config) {
+			cfg.App.Name = "shop"
+			cfg.Server.Root.Docs = "/docs"
+		})
+		resp, body := getRoot(t, base)
+		var d serviceDescriptor
+		if err := json.Unmarshal([]byte(body), &d); resp.StatusCode != http.StatusOK || err != nil {
+			t.Fatalf("got %d %s", resp.StatusCode, body)
+		}
+		if d.Name != "shop" || d.Links["docs"] != "/docs" || d.Links["health"] != "/healthz" {
+			t.Errorf("got descriptor %+v", d)
+		}
+		if !slices.Contains(d.Routes, "/echo") || slices.ContainsFunc(d.Routes, func(p string) bool {
+			_, path := splitPattern(p)
+			return internalPath(path) || path == "/{$}"
+		}) {
+			t.Errorf("got routes %v, want the public routes alone", d.Routes)
+		}
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		base := startTestApp(t, func(cfg *Config) {
+			cfg.Server.Root.Mode = "redirect"
+			cfg.Server.Root.Redirect = "/docs"
+		})
+		if resp, _ := getRoot(t, base); resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/docs" {
+			t.Errorf("got %d to %q, want a 302 to /docs", resp.StatusCode, resp.Header.Get("Location"))
+		}
+	})
+
+	t.Run("spa", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>shop</h1>"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		base := startTestApp(t, func(cfg *Config) {
+			cfg.Server.Root.Mode = "spa"
+			cfg.Static.Dir = dir
+		})
+		if resp, body := getRoot(t, base); resp.StatusCode != http.StatusOK || body != "<h1>shop</h1>" {
+			t.Errorf("got %d %q, want the index page", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("none", func(t *testing.T) {
+		base := startTestApp(t, func(cfg *Config) { cfg.Server.Root.Mode = "none" })
+		if resp, _ := getRoot(t, base); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("got %d, want 404", resp.StatusCode)
+		}
+	})
+
+	t.Run("route of the app", func(t *testing.T) {
+		home := fx.Provide(AsRoute(func() Route {
+			return newFuncRoute("/{$}", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "home")
+			})
+		}))
+		base := startTestApp(t, nil, home)
+		if resp, body := getRoot(t, base); resp.StatusCode != http.StatusOK || body != "home" {
+			t.Errorf("got %d %q, want the app's own route", resp.StatusCode, body)
+		}
+	})
+}
+
+func TestRootHandlerConfig(t *testing.T) {
+	for _, c := range []RootConfig{{Mode: "redirect"}, {Mode: "landing"}} {
+		cfg := &Config{}
+		cfg.Server.Root = c
+		if _, err := NewRootHandler(cfg, nil, nil, nil); err == nil {
+			t.Errorf("%+v: got no error", c)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"go.uber.org/fx"
+)
+
+// funcRoute is a Route backed by a plain http.HandlerFunc.
+type funcRoute struct {
+	pattern string
+	handler http.HandlerFunc
+}
+
+func newFuncRoute(pattern, method string, h http.HandlerFunc) *funcRoute {
+	if method != "" {
+		pattern = method + " " + pattern
+	}
+	return &funcRoute{pattern: pattern, handler: h}
+}
+
+func (r *funcRoute) Pattern() string {
+	return r.pattern
+}
+
+func (r *funcRoute) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.handler(w, req)
+}
+
+// ProvideRoute registers h as a route serving pattern. An empty method
+// matches requests of any method.
+func ProvideRoute(pattern, method string, h http.HandlerFunc) fx.Option {
+	return fx.Provide(AsRoute(func() Route {
+		return newFuncRoute(pattern, method, h)
+	}))
+}
+
+var handlerFuncType = reflect.TypeOf(http.HandlerFunc(nil))
+
+// ProvideRouteFunc is like ProvideRoute, but builds the handler with
+// the given constructor so that it may depend on other values in the
+// container. The constructor must be a function returning an
+// http.HandlerFunc, optionally followed by an error.
+func ProvideRouteFunc(pattern, method string, ctor any) fx.Option {
+	ct := reflect.TypeOf(ctor)
+	if ct == nil || ct.Kind() != reflect.Func {
+		panic(fmt.Sprintf("ProvideRouteFunc(%q): constructor must be a function, got %T", pattern, ctor))
+	}
+	returnsErr := ct.NumOut() == 2 && ct.Out(1) == errorType
+	if ct.NumOut() == 0 || ct.NumOut() > 2 || ct.NumOut() == 2 && !returnsErr || !ct.Out(0).ConvertibleTo(handlerFuncType) {
+		panic(fmt.Sprintf("ProvideRouteFunc(%q): constructor %s must return http.HandlerFunc or (http.HandlerFunc, error), got %v",
+			pattern, funcDescription(ctor), ct))
+	}
+
+	in := make([]reflect.Type, ct.NumIn())
+	for i := range in {
+		in[i] = ct.In(i)
+	}
+	out := []reflect.Type{reflect.TypeOf((*Route)(nil)).Elem()}
+	if returnsErr {
+		out = append(out, errorType)
+	}
+
+	cv := reflect.ValueOf(ctor)
+	wrapped := reflect.MakeFunc(reflect.FuncOf(in, out, ct.IsVariadic()), func(args []reflect.Value) []reflect.Value {
+		var results []reflect.Value
+		if ct.IsVariadic() {
+			results = cv.CallSlice(args)
+		} else {
+			results = cv.Call(args)
+		}
+		if returnsErr && !results[1].IsNil() {
+			return []reflect.Value{reflect.Zero(out[0]), results[1]}
+		}
+		h := results[0].Convert(handlerFuncType).Interface().(http.HandlerFunc)
+		route := reflect.ValueOf(Route(newFuncRoute(pattern, method, h)))
+		if returnsErr {
+			return []reflect.Value{route, reflect.Zero(errorType)}
+		}
+		return []reflect.Value{route}
+	})
+	return fx.Provide(AsRoute(wrapped.Interface()))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/fx"
+)
+
+// provideRoutes builds an app with opts, and returns the routes it
+// provides.
+func provideRoutes(t *testing.T, opts ...fx.Option) ([]Route, error) {
+	t.Helper()
+	var routes []Route
+	app := fx.New(append(opts,
+		fx.NopLogger,
+		fx.Invoke(fx.Annotate(func(rs []Route) { routes = rs }, fx.ParamTags(`group:"routes"`))),
+	)...)
+	return routes, app.Err()
+}
+
+// serve sends a request to route and returns the body of its response.
+func serve(route Route, method string) string {
+	rec := httptest.NewRecorder()
+	route.ServeHTTP(rec, httptest.NewRequest(method, "/", nil))
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func TestProvideRoute(t *testing.T) {
+	routes, err := provideRoutes(t, ProvideRoute("/ping", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "pong")
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 1 || routes[0].Pattern() != "GET /ping" {
+		t.Fatalf("got %v, want the route GET /ping", routes)
+	}
+	if body := serve(routes[0], http.MethodGet); body != "pong" {
+		t.Errorf("got %q, want pong", body)
+	}
+
+	routes, err = provideRoutes(t, ProvideRoute("/any", "", func(http.ResponseWriter, *http.Request) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routes[0].Pattern() != "/any" {
+		t.Errorf("got pattern %q, want /any for any method", routes[0].Pattern())
+	}
+}
+
+// greeting is a dependency of the routes of TestProvideRouteFunc.
+type greeting string
+
+func TestProvideRouteFunc(t *testing.T) {
+	routes, err := provideRoutes(t,
+		fx.Supply(greeting("hello")),
+		ProvideRouteFunc("/hello", http.MethodGet, func(g greeting) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, string(g))
+			}
+		}),
+		ProvideRouteFunc("/bye", http.MethodPost, func(g greeting) (http.HandlerFunc, error) {
+			return func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, "bye after "+string(g))
+			}, nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := make(map[string]string)
+	for _, r := range routes {
+		method, _ := splitPattern(r.Pattern())
+		bodies[r.Pattern()] = serve(r, method)
+	}
+	if bodies["GET /hello"] != "hello" || bodies["POST /bye"] != "bye after hello" {
+		t.Errorf("got %v, want the handlers built with the greeting", bodies)
+	}
+}
+
+func TestProvideRouteFuncError(t *testing.T) {
+	errDown := errors.New("down")
+	_, err := provideRoutes(t, ProvideRouteFunc("/x", "", func() (http.HandlerFunc, error) {
+		return nil, errDown
+	}))
+	if !errors.Is(err, errDown) {
+		t.Errorf("got %v, want the error of the constructor", err)
+	}
+}
+
+func TestProvideRouteFuncRejects(t *testing.T) {
+	for _, ctor := range []any{
+		nil,
+		"not a function",
+		func() {},
+		func() string { return "" },
+		func() (http.HandlerFunc, string) { return nil, "" },
+	} {
+		msg := mustPanic(t, func() { ProvideRouteFunc("/x", "", ctor) })
+		if !strings.HasPrefix(msg, `ProvideRouteFunc("/x")`) {
+			t.Errorf("%T: got %q", ctor, msg)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Router registers handlers for method and path patterns and routes
+// requests to them. Patterns use the http.ServeMux syntax: "{name}"
+// matches a path segment, "{name...}" the remainder of the path, a
+// trailing slash matches a whole subtree and "{$}" anchors the end.
+type Router interface {
+	http.Handler
+	Handle(method, pattern string, h http.Handler)
+}
+
+// NewRouter builds the Router implementation selected by name. An empty
+// name selects the http.ServeMux backend.
+func NewRouter(name string) (Router, error) {
+	switch name {
+	case "", "servemux":
+		return &serveMuxRouter{mux: http.NewServeMux()}, nil
+	case "chi":
+		return &chiRouter{mux: chi.NewRouter(), head: make(map[string]bool)}, nil
+	default:
+		return nil, fmt.Errorf("unknown router %q", name)
+	}
+}
+
+// splitPattern splits a route pattern such as "GET /ping" into its
+// method and path.
+func splitPattern(pattern string) (method, path string) {
+	if m, p, ok := strings.Cut(pattern, " "); ok {
+		return m, strings.TrimLeft(p, " ")
+	}
+	return "", pattern
+}
+
+// matchRoute reports whether the route pattern matches want, a
+// path.Match pattern matched against the whole route pattern or its
+// path alone, so "/echo/*" matches "POST /echo/hash".
+func matchRoute(want, pattern string) bool {
+	if ok, _ := path.Match(want, pattern); ok {
+		return true
+	}
+	_, p := splitPattern(pattern)
+	ok, _ := path.Match(want, p)
+	return ok
+}
+
+// serveMuxRouter is a Router backed by http.ServeMux.
+type serveMuxRouter struct {
+	mux *http.ServeMux
+}
+
+func (r *serveMuxRouter) Handle(method, pattern string, h http.Handler) {
+	names := wildcardNames(pattern)
+	if method != "" {
+		pattern = method + " " + pattern
+	}
+	r.mux.Handle(pattern, withParams(h, names, func(req *http.Request, name string) string {
+		return req.PathValue(name)
+	}))
+}
+
+func (r *serveMuxRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
+// chiRouter is a Router backed by chi. Like http.ServeMux, it answers
+// HEAD requests with the GET handler of a path unless one is registered
+// for HEAD.
+type chiRouter struct {
+	mux  chi.Router
+	head map[string]bool // paths with a handler registered for HEAD
+}
+
+var wildcardRe = regexp.MustCompile(`\{([^}]*)\}`)
+
+func (r *chiRouter) Handle(method, pattern string, h http.Handler) {
+	names := wildcardNames(pattern)
+	rest := ""
+	path := wildcardRe.ReplaceAllStringFunc(pattern, func(w string) string {
+		name := w[1 : len(w)-1]
+		switch {
+		case name == "$":
+			return ""
+		case strings.HasSuffix(name, "..."):
+			rest = strings.TrimSuffix(name, "...")
+			return "*"
+		default:
+			return w
+		}
+	})
+	if strings.HasSuffix(path, "/") && !strings.HasSuffix(pattern, "{$}") {
+		path += "*"
+	}
+
+	h = withParams(h, names, func(req *http.Request, name string) string {
+		if name == rest {
+			return chi.URLParam(req, "*")
+		}
+		return chi.URLParam(req, name)
+	})
+	switch method {
+	case "":
+		r.mux.Handle(path, h)
+	case http.MethodGet:
+		r.mux.Method(method, path, h)
+		if !r.head[path] {
+			r.mux.Method(http.MethodHead, path, h)
+		}
+	case http.MethodHead:
+		r.head[path] = true
+		fallthrough
+	default:
+		r.mux.Method(method, path, h)
+	}
+}
+
+func (r *chiRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
+// wildcardNames returns the names of the wildcards in pattern.
+func wildcardNames(pattern string) []string {
+	var names []string
+	for _, m := range wildcardRe.FindAllStringSubmatch(pattern, -1) {
+		if name := strings.TrimSuffix(m[1], "..."); name != "$" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+type paramsKey struct{}
+
+// withParams wraps h so that the values of the named wildcards, looked
+// up with the backend-specific function, are available through Params.
+func withParams(h http.Handler, names []string, lookup func(*http.Request, string) string) http.Handler {
+	if len(names) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := make(map[string]string, len(names))
+		for _, name := range names {
+			params[name] = lookup(r, name)
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), paramsKey{}, params)))
+	})
+}
+
+// Params returns the path parameters matched for the current request,
+// regardless of the Router backend in use. It returns nil if the route
+// pattern has no wildcards.
+func Params(ctx context.Context) map[string]string {
+	params, _ := ctx.Value(paramsKey{}).(map[string]string)
+	return params
+}
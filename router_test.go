@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// routers are the Router backends the conformance tests run against.
+var routers = []string{"servemux", "chi"}
+
+func TestRouterConformance(t *testing.T) {
+	for _, name := range routers {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewRouter(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Each handler answers with its name, also in X-Route for HEAD
+			// requests, and the params it gets.
+			for _, route := range []struct{ method, pattern string }{
+				{"", "/{$}"},
+				{"GET", "/ping"},
+				{"HEAD", "/head"},
+				{"GET", "/head"},
+				{"POST", "/echo"},
+				{"GET", "/kv/{key}"},
+				{"PUT", "/kv/{key}"},
+				{"GET", "/users/{id}/posts/{post}"},
+				{"GET", "/files/{path...}"},
+				{"", "/static/"},
+			} {
+				label := strings.TrimSpace(route.method + " " + route.pattern)
+				r.Handle(route.method, route.pattern, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					params := Params(req.Context())
+					keys := make([]string, 0, len(params))
+					for k := range params {
+						keys = append(keys, k)
+					}
+					sort.Strings(keys)
+					w.Header().Set("X-Route", label)
+					fmt.Fprint(w, label)
+					for _, k := range keys {
+						fmt.Fprintf(w, " %s=%s", k, params[k])
+					}
+				}))
+			}
+
+			for _, tc := range []struct {
+				method, path string
+				status       int
+				body         string
+			}{
+				{"GET", "/", 200, "/{$}"},
+				{"GET", "/ping", 200, "GET /ping"},
+				{"HEAD", "/ping", 200, "GET /ping"},
+				{"POST", "/ping", 405, ""},
+				{"HEAD", "/head", 200, "HEAD /head"},
+				{"POST", "/echo", 200, "POST /echo"},
+				{"GET", "/kv/a", 200, "GET /kv/{key} key=a"},
+				{"PUT", "/kv/b", 200, "PUT /kv/{key} key=b"},
+				{"DELETE", "/kv/a", 405, ""},
+				{"GET", "/kv/a/b", 404, ""},
+				{"GET", "/users/7/posts/42", 200, "GET /users/{id}/posts/{post} id=7 post=42"},
+				{"GET", "/files/a/b.txt", 200, "GET /files/{path...} path=a/b.txt"},
+				{"GET", "/static/css/site.css", 200, "/static/"},
+				{"DELETE", "/static/x", 200, "/static/"},
+				{"GET", "/missing", 404, ""},
+			} {
+				req := httptest.NewRequest(tc.method, tc.path, nil)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				if rec.Code != tc.status {
+					t.Errorf("%s %s: got %d, want %d", tc.method, tc.path, rec.Code, tc.status)
+					continue
+				}
+				got := rec.Body.String()
+				if tc.method == http.MethodHead {
+					got = rec.Header().Get("X-Route")
+				}
+				if tc.body != "" && got != tc.body {
+					t.Errorf("%s %s: got %q, want %q", tc.method, tc.path, got, tc.body)
+				}
+			}
+		})
+	}
+}
+
+func TestAppOnRouters(t *testing.T) {
+	for _, name := range routers {
+		t.Run(name, func(t *testing.T) {
+			base := startTestApp(t, func(cfg *Config) {
+				withTokens(cfg)
+				cfg.Server.Router = name
+			})
+			for _, tc := range []struct {
+				method, path, token, body string
+				status                    int
+				want                      string
+			}{
+				{"GET", "/ping", "", "", 200, "pong"},
+				{"HEAD", "/ping", "", "", 200, ""},
+				{"POST", "/echo", "", `{"a":1}`, 200, `{"a":1}`},
+				{"PUT", "/kv/a", "", "1", 204, ""},
+				{"GET", "/kv/a", "", "", 200, "1"},
+				{"GET", "/admin/flags", "", "", 401, ""},
+				{"GET", "/admin/flags", "user-token", "", 403, ""},
+				{"GET", "/admin/flags", "admin-token", "", 200, ""},
+				{"GET", "/missing", "", "", 404, ""},
+			} {
+				status, body := do(t, tc.method, base+tc.path, tc.token, tc.body)
+				if status != tc.status || !strings.Contains(body, tc.want) {
+					t.Errorf("%s %s: got %d %q, want %d %q", tc.method, tc.path, status, body, tc.status, tc.want)
+				}
+			}
+
+			// The middleware applies whichever the backend.
+			resp, err := http.Get(base + "/ping")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			for _, h := range []string{"X-Request-Id", "X-Request-Deadline"} {
+				if resp.Header.Get(h) == "" {
+					t.Errorf("no %s on GET /ping", h)
+				}
+			}
+		})
+	}
+}
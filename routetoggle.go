@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"example.com/uberfx/httpjson"
+	"example.com/uberfx/reqctx"
+)
+
+// ErrRouteDisabled is reported by routes switched off through
+// RouteToggles.
+var ErrRouteDisabled = errors.New("this route is disabled")
+
+// routeToggleProtected reports whether the route with the given path
+// can't be switched off: the health checks, and the admin routes, the
+// toggles among them.
+func routeToggleProtected(path string) bool {
+	return path == "/healthz" || path == "/readyz" || path == "/admin" || strings.HasPrefix(path, "/admin/")
+}
+
+// RouteToggles is route middleware answering requests to the routes
+// switched off at runtime with a 503, as to take a misbehaving route out
+// of service during an incident without a deploy. Routes are toggled by
+// pattern, as in "POST /upload", or by path alone, for every method;
+// the toggle of the whole pattern wins. Readers never take a lock, the
+// whole set being swapped atomically.
+//
+// The routes of Config.RouteToggles.Disabled are off from the start,
+// and routes are switched with PUT /admin/routes/{pattern}, see
+// RouteTogglesHandler. The health checks and the admin routes can't be
+// switched off. Disabled routes don't affect readiness: the app is
+// still ready to serve its other routes.
+type RouteToggles struct {
+	toggles atomic.Pointer[map[string]bool]
+	mu      sync.Mutex // serializes writers
+	log     *slog.Logger
+	errs    *ErrorWriter
+	message string
+}
+
+// NewRouteToggles builds a new RouteToggles.
+func NewRouteToggles(cfg *Config, log *slog.Logger, errs *ErrorWriter) (*RouteToggles, error) {
+	t := &RouteToggles{log: log, errs: errs, message: cfg.RouteToggles.Message}
+	toggles := make(map[string]bool, len(cfg.RouteToggles.Disabled))
+	for _, pattern := range cfg.RouteToggles.Disabled {
+		if _, path := splitPattern(pattern); routeToggleProtected(path) {
+			return nil, fmt.Errorf("route toggles: route %q can't be disabled", pattern)
+		}
+		toggles[pattern] = false
+	}
+	t.toggles.Store(&toggles)
+	return t, nil
+}
+
+// Enabled reports whether the route with the given pattern is enabled.
+func (t *RouteToggles) Enabled(pattern string) bool {
+	toggles := *t.toggles.Load()
+	if on, ok := toggles[pattern]; ok {
+		return on
+	}
+	_, path := splitPattern(pattern)
+	on, ok := toggles[path]
+	return !ok || on
+}
+
+// Set switches the route with the given pattern on or off, on behalf of
+// principal.
+func (t *RouteToggles) Set(pattern string, on bool, principal string) error {
+	if _, path := splitPattern(pattern); routeToggleProtected(path) {
+		return fmt.Errorf("route %q can't be disabled", pattern)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	toggles := maps.Clone(*t.toggles.Load())
+	if prev, ok := toggles[pattern]; ok && prev == on || !ok && on {
+		return nil
+	}
+	toggles[pattern] = on
+	t.toggles.Store(&toggles)
+	t.log.Warn("Route toggled",
+		slog.String("route", pattern),
+		slog.Bool("enabled", on),
+		slog.String("principal", principal),
+	)
+	return nil
+}
+
+func (*RouteToggles) Order() int {
+	return orderRouteToggle
+}
+
+func (t *RouteToggles) WrapRoute(route Route, next http.Handler) http.Handler {
+	pattern := route.Pattern()
+	if _, path := splitPattern(pattern); routeToggleProtected(path) {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t.Enabled(pattern) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if t.message != "" {
+			t.errs.Write(w, r, NewStatusError(http.StatusServiceUnavailable, errors.New(t.message)))
+			return
+		}
+		t.errs.Write(w, r, ErrRouteDisabled)
+	})
+}
+
+// RouteTogglesHandler lists the registered routes and whether they're
+// enabled at GET /admin/routes, and switches one with a PUT of
+// {"enabled": bool} to /admin/routes/{pattern}, the pattern being a
+// registered one, URL-escaped, as in /admin/routes/POST%20/upload, or
+// its path, as in /admin/routes/upload. Both require the admin role.
+// Switches are audit-logged, like every PUT, and logged with the
+// principal making them.
+type RouteTogglesHandler struct {
+	toggles *RouteToggles
+	table   *RouteTable
+	errs    *ErrorWriter
+}
+
+// NewRouteTogglesHandler builds a new RouteTogglesHandler.
+func NewRouteTogglesHandler(toggles *RouteToggles, table *RouteTable, errs *ErrorWriter) *RouteTogglesHandler {
+	return &RouteTogglesHandler{toggles: toggles, table: table, errs: errs}
+}
+
+func (h *RouteTogglesHandler) RegisterRoutes(r Router) {
+	r.Handle(http.MethodGet, "/admin/routes", WithRoles(http.HandlerFunc(h.list), "admin"))
+	r.Handle(http.MethodPut, "/admin/routes/{pattern...}", WithRoles(http.HandlerFunc(h.set), "admin"))
+}
+
+// routeToggle is a registered route and whether it's enabled.
+type routeToggle struct {
+	Pattern   string `json:"pattern"`
+	Enabled   bool   `json:"enabled"`
+	Protected bool   `json:"protected,omitempty"`
+}
+
+func (h *RouteTogglesHandler) list(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	entries := h.table.Entries()
+	routes := make([]routeToggle, 0, len(entries))
+	for _, e := range entries {
+		_, path := splitPattern(e.Pattern)
+		routes = append(routes, routeToggle{
+			Pattern:   e.Pattern,
+			Enabled:   h.toggles.Enabled(e.Pattern),
+			Protected: routeToggleProtected(path),
+		})
+	}
+	_ = httpjson.Respond(w, r, http.StatusOK, routes)
+}
+
+func (h *RouteTogglesHandler) set(w http.ResponseWriter, r *http.Request) {
+	pattern := Params(r.Context())["pattern"]
+	if !strings.Contains(pattern, " ") {
+		// The wildcard doesn't take the slash leading the path.
+		pattern = "/" + pattern
+	}
+	if !h.registered(pattern) {
+		h.errs.Write(w, r, NewStatusError(http.StatusNotFound, fmt.Errorf("no route %q", pattern)))
+		return
+	}
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, err))
+		return
+	}
+	if body.Enabled == nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, fmt.Errorf("missing %q", "enabled")))
+		return
+	}
+	if err := h.toggles.Set(pattern, *body.Enabled, reqctx.Principal(r.Context())); err != nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusForbidden, err))
+		return
+	}
+	h.list(w, r)
+}
+
+// registered reports whether pattern is that of a registered route, or
+// its path.
+func (h *RouteTogglesHandler) registered(pattern string) bool {
+	for _, e := range h.table.Entries() {
+		if _, path := splitPattern(e.Pattern); e.Pattern == pattern || path == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRouteTogglesHandler(t *testing.T) {
+	for _, router := range []string{"servemux", "chi"} {
+		t.Run(router, func(t *testing.T) {
+			base := startTestApp(t, func(cfg *Config) {
+				withTokens(cfg)
+				cfg.Server.Router = router
+			})
+			if status, _ := do(t, http.MethodPut, base+"/admin/routes/hello", "", `{"enabled": false}`); status != http.StatusUnauthorized {
+				t.Errorf("anonymous switch: got %d, want 401", status)
+			}
+			if status, _ := do(t, http.MethodGet, base+"/hello", "", ""); status != http.StatusOK {
+				t.Fatalf("GET /hello: got %d, want 200", status)
+			}
+			for _, path := range []string{"/admin/routes/hello", "/admin/routes/GET%20/ping"} {
+				if status, body := do(t, http.MethodPut, base+path, "admin-token", `{"enabled": false}`); status != http.StatusOK {
+					t.Fatalf("PUT %s: got %d %s, want 200", path, status, body)
+				}
+			}
+			if status, _ := do(t, http.MethodGet, base+"/hello", "", ""); status != http.StatusServiceUnavailable {
+				t.Errorf("GET /hello switched off: got %d, want 503", status)
+			}
+			if status, _ := do(t, http.MethodGet, base+"/ping", "", ""); status != http.StatusServiceUnavailable {
+				t.Errorf("GET /ping switched off: got %d, want 503", status)
+			}
+		})
+	}
+}
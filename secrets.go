@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+)
+
+// redacted replaces secret values in the config debug output.
+const redacted = "[redacted]"
+
+// loadSecrets sets the config fields tagged `secretfile:"true"` from the
+// environment, following the Docker and Kubernetes secrets convention.
+// A field is named after its JSON path, so session.keys is SESSION_KEYS.
+// It can be set directly in that variable or, to keep the secret out of
+// the environment, read from the file named by the variable suffixed
+// with _FILE, trimmed of surrounding whitespace; setting both is an
+// error. List fields take one value per line of the file, or comma
+// separated values in the variable. The fields of structs in lists and
+// maps are named after their index or key, so the key of the first
+// auth.api_keys entry is AUTH_API_KEYS_0_KEY.
+func loadSecrets(cfg *Config) error {
+	return walkSecrets(reflect.ValueOf(cfg).Elem(), "", func(v reflect.Value, env string) error {
+		value, direct := os.LookupEnv(env)
+		path, fromFile := os.LookupEnv(env + "_FILE")
+		switch {
+		case direct && fromFile:
+			return fmt.Errorf("both %s and %s_FILE are set", env, env)
+		case fromFile:
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("read secret %s_FILE: %w", env, err)
+			}
+			setSecret(v, strings.TrimSpace(string(b)), "\n")
+		case direct:
+			setSecret(v, value, ",")
+		}
+		return nil
+	})
+}
+
+// walkSecrets calls f with each field of v tagged as a secret and the
+// name of its environment variable, including those of the structs in
+// its lists and maps.
+func walkSecrets(v reflect.Value, prefix string, f func(reflect.Value, string) error) error {
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		env := strings.ToUpper(name)
+		if prefix != "" {
+			env = prefix + "_" + env
+		}
+		switch {
+		case field.Tag.Get("secretfile") == "true":
+			if err := f(v.Field(i), env); err != nil {
+				return err
+			}
+		case field.Type.Kind() == reflect.Struct:
+			if err := walkSecrets(v.Field(i), env, f); err != nil {
+				return err
+			}
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+			s := v.Field(i)
+			for j := range s.Len() {
+				if err := walkSecrets(s.Index(j), fmt.Sprintf("%s_%d", env, j), f); err != nil {
+					return err
+				}
+			}
+		case field.Type.Kind() == reflect.Map && field.Type.Elem().Kind() == reflect.Struct:
+			// Map values can't be set in place: walk a copy, and put it back.
+			m := v.Field(i)
+			iter := m.MapRange()
+			for iter.Next() {
+				elem := reflect.New(field.Type.Elem()).Elem()
+				elem.Set(iter.Value())
+				if err := walkSecrets(elem, env+"_"+strings.ToUpper(fmt.Sprint(iter.Key())), f); err != nil {
+					return err
+				}
+				m.SetMapIndex(iter.Key(), elem)
+			}
+		}
+	}
+	return nil
+}
+
+// setSecret sets a string or string list field from s.
+func setSecret(v reflect.Value, s, sep string) {
+	if v.Kind() == reflect.String {
+		v.SetString(s)
+		return
+	}
+	var values []string
+	for _, part := range strings.Split(s, sep) {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	v.Set(reflect.ValueOf(values))
+}
+
+// redactConfig returns a copy of cfg with its secrets redacted.
+func redactConfig(cfg *Config) (*Config, error) {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	out := &Config{}
+	if err := json.Unmarshal(b, out); err != nil {
+		return nil, err
+	}
+	_ = walkSecrets(reflect.ValueOf(out).Elem(), "", func(v reflect.Value, _ string) error {
+		switch {
+		case v.Kind() == reflect.String && v.Len() > 0:
+			v.SetString(redacted)
+		case v.Kind() == reflect.Slice:
+			for i := range v.Len() {
+				v.Index(i).SetString(redacted)
+			}
+		}
+		return nil
+	})
+	return out, nil
+}
+
+// DebugConfigHandler is an HTTP handler that shows the effective
+// configuration, with secrets redacted, to principals with the "admin"
+// role.
+type DebugConfigHandler struct {
+	cfg  *Config
+	errs *ErrorWriter
+}
+
+// NewDebugConfigHandler builds a new DebugConfigHandler.
+func NewDebugConfigHandler(cfg *Config, errs *ErrorWriter) *DebugConfigHandler {
+	return &DebugConfigHandler{cfg: cfg, errs: errs}
+}
+
+func (*DebugConfigHandler) Pattern() string {
+	return "GET /debug/config"
+}
+
+func (*DebugConfigHandler) RequiredRoles() []string {
+	return []string{"admin"}
+}
+
+// PlainJSON keeps the config as it's written in config files.
+func (*DebugConfigHandler) PlainJSON() bool {
+	return true
+}
+
+func (h *DebugConfigHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg, err := redactConfig(h.cfg)
+	if err != nil {
+		h.errs.Write(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(cfg)
+}
+
+// SecretReloader is the component reloading the secrets on SIGHUP, so
+// that rotated secret files take effect without a restart. Only the
+// session keys, the bearer tokens of StaticTokens and the API keys can
+// currently be swapped at runtime.
+type SecretReloader struct {
+	sessions *SessionManager
+	tokens   TokenValidator
+	apiKeys  APIKeyStore
+	env      ConfigEnv
+	log      *slog.Logger
+
+	sig  chan os.Signal
+	done chan struct{}
+}
+
+// NewSecretReloader builds a new SecretReloader.
+func NewSecretReloader(sessions *SessionManager, tokens TokenValidator, apiKeys APIKeyStore, cfg *Config, log *slog.Logger) *SecretReloader {
+	return &SecretReloader{sessions: sessions, tokens: tokens, apiKeys: apiKeys, env: ConfigEnv(cfg.Env), log: log}
+}
+
+func (*SecretReloader) Name() string {
+	return "secret-reloader"
+}
+
+func (*SecretReloader) Priority() int {
+	return prioritySecrets
+}
+
+func (s *SecretReloader) Start(context.Context) error {
+	s.sig = make(chan os.Signal, 1)
+	s.done = make(chan struct{})
+	signal.Notify(s.sig, syscall.SIGHUP)
+	go func() {
+		defer close(s.done)
+		for range s.sig {
+			s.Reload()
+		}
+	}()
+	return nil
+}
+
+func (s *SecretReloader) Stop(ctx context.Context) error {
+	signal.Stop(s.sig)
+	close(s.sig)
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reload re-reads the configuration of the app's env and applies its
+// secrets.
+func (s *SecretReloader) Reload() {
+	cfg, err := NewConfig(s.env)
+	if err != nil {
+		s.log.Error("Failed to reload secrets", slog.String("err", err.Error()))
+		return
+	}
+	if len(cfg.Session.Keys) > 0 {
+		s.sessions.SetKeys(cfg.Session.Keys)
+	}
+	if tokens, ok := s.tokens.(*StaticTokens); ok {
+		if err := tokens.SetTokens(cfg.Auth.Tokens, cfg.Auth.Roles); err != nil {
+			s.log.Error("Failed to reload bearer tokens", slog.String("err", err.Error()))
+		}
+	}
+	switch keys := s.apiKeys.(type) {
+	case *FileAPIKeyStore:
+		err = keys.Reload()
+	case *MemoryAPIKeyStore:
+		err = keys.SetKeys(cfg.Auth.APIKeys)
+	}
+	if err != nil {
+		s.log.Error("Failed to reload API keys", slog.String("err", err.Error()))
+	}
+	s.log.Info("Reloaded secrets")
+}
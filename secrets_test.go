@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedactConfigAPIKeys(t *testing.T) {
+	cfg := &Config{}
+	cfg.Auth.APIKeys = []APIKeyConfig{{Key: "k-123456", Name: "ci"}}
+	out, err := redactConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.Auth.APIKeys[0]; got.Key != redacted || got.Name != "ci" {
+		t.Errorf("got %+v, want the key redacted and the name kept", got)
+	}
+	if cfg.Auth.APIKeys[0].Key != "k-123456" {
+		t.Errorf("the config itself was redacted")
+	}
+}
+
+func TestWalkSecretsMaps(t *testing.T) {
+	type entry struct {
+		Secret string `json:"secret" secretfile:"true"`
+		Public string `json:"public"`
+	}
+	var v struct {
+		Entries map[string]entry `json:"entries"`
+	}
+	v.Entries = map[string]entry{"a": {Secret: "s", Public: "p"}}
+	var envs []string
+	err := walkSecrets(reflect.ValueOf(&v).Elem(), "", func(f reflect.Value, env string) error {
+		envs = append(envs, env)
+		f.SetString(redacted)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"ENTRIES_A_SECRET"}; !reflect.DeepEqual(envs, want) {
+		t.Errorf("got %v, want %v", envs, want)
+	}
+	if got := v.Entries["a"]; got.Secret != redacted || got.Public != "p" {
+		t.Errorf("got %+v, want the secret redacted and the rest kept", got)
+	}
+}
+
+func TestLoadSecretsAPIKeys(t *testing.T) {
+	t.Setenv("AUTH_API_KEYS_0_KEY", "from-env")
+	cfg := &Config{}
+	cfg.Auth.APIKeys = []APIKeyConfig{{Name: "ci"}}
+	if err := loadSecrets(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Auth.APIKeys[0].Key; got != "from-env" {
+		t.Errorf("got key %q, want from-env", got)
+	}
+}
+
+func TestDebugConfigHandler(t *testing.T) {
+	base := startTestApp(t, func(cfg *Config) {
+		withTokens(cfg)
+		cfg.Auth.APIKeys = []APIKeyConfig{{Key: "k-123456", Name: "ci"}}
+	})
+	if status, _ := do(t, http.MethodGet, base+"/debug/config", "", ""); status != http.StatusUnauthorized {
+		t.Errorf("anonymous: got %d, want 401", status)
+	}
+	status, body := do(t, http.MethodGet, base+"/debug/config", "admin-token", "")
+	if status != http.StatusOK {
+		t.Fatalf("admin: got %d %s, want 200", status, body)
+	}
+	for _, secret := range []string{"k-123456", "admin-token"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("config shows %q:\n%s", secret, body)
+		}
+	}
+}
+
+func TestSecretReloaderTokens(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens")
+	rotate := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotate("ann:old-token\n")
+	t.Setenv("AUTH_TOKENS_FILE", file)
+	cfg, err := loadTestConfig(t, `{"auth": {"roles": {"ann": ["admin"]}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := NewStaticTokens(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKeys, err := NewAPIKeyStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSecretReloader(nil, tokens, apiKeys, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	validate := func(token string, want error) {
+		t.Helper()
+		principal, roles, err := tokens.Validate(context.Background(), token)
+		switch {
+		case err != want:
+			t.Errorf("%s: got error %v, want %v", token, err, want)
+		case err == nil && (principal != "ann" || !reflect.DeepEqual(roles, []string{"admin"})):
+			t.Errorf("%s: got %s %v, want ann [admin]", token, principal, roles)
+		}
+	}
+	validate("old-token", nil)
+
+	rotate("ann:new-token\n")
+	s.Reload()
+	validate("old-token", ErrInvalidToken)
+	validate("new-token", nil)
+
+	// A bad file keeps the tokens in use.
+	rotate("new-token\n")
+	s.Reload()
+	validate("new-token", nil)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/uberfx/clock"
+	"example.com/uberfx/httpjson"
+)
+
+// ErrSelfTestRunning is reported for a self-test started while another
+// one runs.
+var ErrSelfTestRunning = errors.New("a self-test is already running")
+
+// SelfTestHandler serves POST /admin/selftest, a quick benchmark of the
+// app run before it takes traffic: it drives load against one of its
+// own routes through its own listener and the shared client, as
+// described by the selfTestSpec in the request body, and answers with a
+// selfTestReport of the throughput, latencies and errors seen. The
+// report is logged too.
+//
+// The concurrency, duration, request count and payload of a run are
+// capped by Config.SelfTest. Only one self-test runs at a time, others
+// are answered with a 409; a run stops early if its client goes away,
+// or the request's deadline passes. Only principals with the "admin"
+// role may run one, unless Config.Auth.RouteRoles says otherwise.
+type SelfTestHandler struct {
+	cfg     SelfTestConfig
+	info    *ServerInfo
+	client  *http.Client
+	errs    *ErrorWriter
+	clock   clock.Clock
+	log     *slog.Logger
+	running atomic.Bool
+}
+
+// NewSelfTestHandler builds a new SelfTestHandler.
+func NewSelfTestHandler(cfg *Config, info *ServerInfo, client *http.Client, errs *ErrorWriter, clk clock.Clock, log *slog.Logger) *SelfTestHandler {
+	c := cfg.SelfTest
+	if c.MaxConcurrency <= 0 {
+		c.MaxConcurrency = 16
+	}
+	if c.MaxDuration <= 0 {
+		c.MaxDuration = Duration(30 * time.Second)
+	}
+	if c.MaxRequests <= 0 {
+		c.MaxRequests = 100000
+	}
+	if c.MaxPayloadBytes <= 0 {
+		c.MaxPayloadBytes = 1 << 20
+	}
+	return &SelfTestHandler{cfg: c, info: info, client: client, errs: errs, clock: clk, log: log}
+}
+
+func (*SelfTestHandler) Pattern() string {
+	return "POST /admin/selftest"
+}
+
+func (*SelfTestHandler) RequiredRoles() []string {
+	return []string{"admin"}
+}
+
+// selfTestSpec describes a self-test run.
+type selfTestSpec struct {
+	// Route is the route loaded, as a path, "/hello", or a method and a
+	// path, "GET /healthz". The method defaults to POST with a payload
+	// and GET otherwise.
+	Route string `json:"route"`
+	// Concurrency is how many requests are in flight at once; it
+	// defaults to 1.
+	Concurrency int `json:"concurrency"`
+	// Duration is how long the run lasts, and Requests how many requests
+	// it sends; it stops at whichever comes first. Without either, it
+	// lasts 5s, or the max duration if shorter.
+	Duration Duration `json:"duration"`
+	Requests int      `json:"requests"`
+	// PayloadBytes is the size of the body of each request.
+	PayloadBytes int64 `json:"payload_bytes"`
+}
+
+// selfTestReport is the outcome of a self-test run.
+type selfTestReport struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Concurrency int    `json:"concurrency"`
+	// Elapsed is how long the run lasted.
+	Elapsed Duration `json:"elapsed"`
+	// Requests counts the requests sent, and Errors those failing or
+	// answered with a 5xx.
+	Requests   int64            `json:"requests"`
+	Errors     int64            `json:"errors"`
+	Statuses   map[string]int64 `json:"statuses"`
+	Throughput float64          `json:"throughput_rps"`
+	Latency    selfTestLatency  `json:"latency"`
+	// Canceled tells that the run stopped before its end, its client
+	// having gone away.
+	Canceled bool `json:"canceled"`
+}
+
+// selfTestLatency are the percentiles of the latencies of the requests
+// of a run.
+type selfTestLatency struct {
+	P50 Duration `json:"p50"`
+	P90 Duration `json:"p90"`
+	P99 Duration `json:"p99"`
+	Max Duration `json:"max"`
+}
+
+func (h *SelfTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var spec selfTestSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, err))
+		return
+	}
+	method, path, err := h.check(&spec)
+	if err != nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, err))
+		return
+	}
+	if !h.running.CompareAndSwap(false, true) {
+		h.errs.Write(w, r, ErrSelfTestRunning)
+		return
+	}
+	defer h.running.Store(false)
+
+	// The load isn't part of the admin request: it isn't charged to its
+	// call budget nor does it carry its headers. It only stops with it.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(spec.Duration))
+	defer cancel()
+	stop := context.AfterFunc(r.Context(), cancel)
+	defer stop()
+
+	report := h.run(ctx, method, path, spec)
+	report.Canceled = r.Context().Err() != nil
+	h.log.InfoContext(r.Context(), "Self-test done",
+		slog.String("route", method+" "+path),
+		slog.Int("concurrency", report.Concurrency),
+		slog.Int64("requests", report.Requests),
+		slog.Int64("errors", report.Errors),
+		slog.Float64("throughput_rps", report.Throughput),
+		slog.Duration("p50", time.Duration(report.Latency.P50)),
+		slog.Duration("p99", time.Duration(report.Latency.P99)),
+		slog.Bool("canceled", report.Canceled))
+	_ = httpjson.Respond(w, r, http.StatusOK, report)
+}
+
+// check validates spec against the caps and fills in its defaults,
+// returning the method and path loaded.
+func (h *SelfTestHandler) check(spec *selfTestSpec) (method, path string, err error) {
+	method, path = splitPattern(spec.Route)
+	switch {
+	case !strings.HasPrefix(path, "/"):
+		return "", "", fmt.Errorf("route must be a path, optionally preceded by a method, such as %q", "/hello")
+	case strings.HasPrefix(path, "/admin/selftest"):
+		return "", "", fmt.Errorf("a self-test can't load itself")
+	case spec.Concurrency < 0 || spec.Concurrency > h.cfg.MaxConcurrency:
+		return "", "", fmt.Errorf("concurrency must be between 1 and %d", h.cfg.MaxConcurrency)
+	case spec.Duration < 0 || spec.Duration > h.cfg.MaxDuration:
+		return "", "", fmt.Errorf("duration must be at most %s", h.cfg.MaxDuration)
+	case spec.Requests < 0 || spec.Requests > h.cfg.MaxRequests:
+		return "", "", fmt.Errorf("requests must be at most %d", h.cfg.MaxRequests)
+	case spec.PayloadBytes < 0 || spec.PayloadBytes > h.cfg.MaxPayloadBytes:
+		return "", "", fmt.Errorf("payload_bytes must be at most %d", h.cfg.MaxPayloadBytes)
+	}
+	if method == "" {
+		method = http.MethodGet
+		if spec.PayloadBytes > 0 {
+			method = http.MethodPost
+		}
+	}
+	spec.Concurrency = max(spec.Concurrency, 1)
+	if spec.Duration == 0 {
+		spec.Duration = min(Duration(5*time.Second), h.cfg.MaxDuration)
+	}
+	if spec.Requests == 0 {
+		spec.Requests = h.cfg.MaxRequests
+	}
+	return method, path, nil
+}
+
+// run sends the requests of spec until ctx is done or they're all sent.
+func (h *SelfTestHandler) run(ctx context.Context, method, path string, spec selfTestSpec) *selfTestReport {
+	url := "http://" + h.info.Addr().String() + path
+	payload := bytes.Repeat([]byte("x"), int(spec.PayloadBytes))
+
+	var (
+		mu        sync.Mutex
+		latencies = make([]time.Duration, 0, min(spec.Requests, 4096))
+		statuses  = make(map[string]int64)
+		errs      int64
+		sent      atomic.Int64
+		wg        sync.WaitGroup
+	)
+	start := h.clock.Now()
+	for range spec.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && sent.Add(1) <= int64(spec.Requests) {
+				began := h.clock.Now()
+				status, err := h.send(ctx, method, url, payload)
+				took := h.clock.Now().Sub(began)
+				if err != nil && ctx.Err() != nil {
+					// Cut short by the end of the run rather than failed.
+					return
+				}
+				mu.Lock()
+				latencies = append(latencies, took)
+				if err != nil {
+					errs++
+					statuses["error"]++
+				} else {
+					if status >= 500 {
+						errs++
+					}
+					statuses[strconv.Itoa(status)]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := h.clock.Now().Sub(start)
+
+	report := &selfTestReport{
+		Method:      method,
+		Path:        path,
+		Concurrency: spec.Concurrency,
+		Elapsed:     Duration(elapsed),
+		Requests:    int64(len(latencies)),
+		Errors:      errs,
+		Statuses:    statuses,
+		Throughput:  float64(len(latencies)) / elapsed.Seconds(),
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	if n := len(latencies); n > 0 {
+		at := func(p float64) Duration { return Duration(latencies[min(int(p*float64(n)), n-1)]) }
+		report.Latency = selfTestLatency{P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: Duration(latencies[n-1])}
+	}
+	return report
+}
+
+// send sends one request of a run, returning the status it's answered
+// with.
+func (h *SelfTestHandler) send(ctx context.Context, method, url string, payload []byte) (int, error) {
+	var body io.Reader
+	if len(payload) > 0 {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "uberfx-selftest")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return 0, err
+	}
+	return resp.StatusCode, nil
+}
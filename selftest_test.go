@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"go.uber.org/fx"
+)
+
+func TestSelfTest(t *testing.T) {
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	block := fx.Provide(AsRoute(func() Route {
+		return newFuncRoute("/block", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case entered <- struct{}{}:
+			default:
+			}
+			<-release
+		})
+	}))
+	base := startTestApp(t, withTokens, block)
+	url := base + "/admin/selftest"
+
+	status, body := do(t, http.MethodPost, url, "admin-token", `{"route": "/hello", "concurrency": 2, "requests": 20, "payload_bytes": 5}`)
+	var report selfTestReport
+	if err := json.Unmarshal([]byte(body), &report); status != http.StatusOK || err != nil {
+		t.Fatalf("got %d %s", status, body)
+	}
+	if report.Method != http.MethodPost || report.Path != "/hello" || report.Concurrency != 2 {
+		t.Errorf("got route %s %s with concurrency %d, want POST /hello with 2", report.Method, report.Path, report.Concurrency)
+	}
+	if report.Requests != 20 || report.Errors != 0 || report.Statuses["200"] != 20 || report.Canceled {
+		t.Errorf("got %d requests, %d errors, statuses %v, canceled %t; want 20 answered with a 200", report.Requests, report.Errors, report.Statuses, report.Canceled)
+	}
+	l := report.Latency
+	if report.Throughput <= 0 || report.Elapsed <= 0 || l.P50 <= 0 || l.P50 > l.P90 || l.P90 > l.P99 || l.P99 > l.Max {
+		t.Errorf("got throughput %v over %s and latencies %+v", report.Throughput, report.Elapsed, l)
+	}
+
+	for _, tc := range []struct {
+		token, spec string
+		status      int
+	}{
+		{"user-token", `{"route": "/hello"}`, http.StatusForbidden},
+		{"admin-token", `{"route": "/hello", "concurrency": 17}`, http.StatusBadRequest},
+		{"admin-token", `{"route": "/admin/selftest"}`, http.StatusBadRequest},
+		{"admin-token", `{"route": "hello"}`, http.StatusBadRequest},
+	} {
+		if status, body := do(t, http.MethodPost, url, tc.token, tc.spec); status != tc.status {
+			t.Errorf("%s as %s: got %d %s, want %d", tc.spec, tc.token, status, body, tc.status)
+		}
+	}
+
+	// A second run is refused while the first is blocked on its route.
+	done := make(chan int)
+	go func() {
+		status, _ := do(t, http.MethodPost, url, "admin-token", `{"route": "/block", "requests": 1}`)
+		done <- status
+	}()
+	<-entered
+	if status, body := do(t, http.MethodPost, url, "admin-token", `{"route": "/hello", "requests": 1, "payload_bytes": 1}`); status != http.StatusConflict {
+		t.Errorf("concurrent run: got %d %s, want 409", status, body)
+	}
+	close(release)
+	if status := <-done; status != http.StatusOK {
+		t.Errorf("blocked run: got %d, want 200", status)
+	}
+}
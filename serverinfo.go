@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// ServerInfo describes the running HTTP server.
+type ServerInfo struct {
+	mu sync.RWMutex
+	ln net.Listener
+}
+
+// NewServerInfo builds a new ServerInfo.
+func NewServerInfo() *ServerInfo {
+	return &ServerInfo{}
+}
+
+// Addr returns the address the server is listening on,
+// or nil if it hasn't started yet.
+func (i *ServerInfo) Addr() net.Addr {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if i.ln == nil {
+		return nil
+	}
+	return i.ln.Addr()
+}
+
+// Listener returns the listener the server accepts connections from,
+// or nil if it hasn't started yet.
+func (i *ServerInfo) Listener() net.Listener {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.ln
+}
+
+func (i *ServerInfo) setListener(ln net.Listener) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.ln = ln
+}
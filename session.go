@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/uberfx/clock"
+	"example.com/uberfx/reqctx"
+	"go.uber.org/fx"
+)
+
+// SessionStore persists session data by session ID.
+type SessionStore interface {
+	// Load returns the values stored for id, or false if there are none
+	// or they have expired.
+	Load(ctx context.Context, id string) (map[string]any, bool, error)
+	Save(ctx context.Context, id string, values map[string]any, ttl time.Duration) error
+	Delete(ctx context.Context, id string) error
+}
+
+// MemorySessionStore is a SessionStore that keeps sessions in memory.
+// Expired sessions are removed by a janitor goroutine that runs while
+// the app is started.
+type MemorySessionStore struct {
+	clock clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]memorySession
+}
+
+type memorySession struct {
+	values  map[string]any
+	expires time.Time
+}
+
+// NewMemorySessionStore builds a new MemorySessionStore.
+func NewMemorySessionStore(lc fx.Lifecycle, clk clock.Clock) *MemorySessionStore {
+	s := &MemorySessionStore{clock: clk, entries: make(map[string]memorySession)}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+				t := clk.NewTicker(time.Minute)
+				defer t.Stop()
+				for {
+					select {
+					case <-t.C():
+						s.expire()
+					case <-stop:
+						return
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(stop)
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+	return s
+}
+
+func (s *MemorySessionStore) Load(_ context.Context, id string) (map[string]any, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok || !s.clock.Now().Before(e.expires) {
+		return nil, false, nil
+	}
+	return maps.Clone(e.values), true, nil
+}
+
+func (s *MemorySessionStore) Save(_ context.Context, id string, values map[string]any, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[id] = memorySession{values: maps.Clone(values), expires: s.clock.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemorySessionStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, id)
+	return nil
+}
+
+// expire removes expired sessions.
+func (s *MemorySessionStore) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	for id, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, id)
+		}
+	}
+}
+
+// Session holds the values of a client's session for the duration of a
+// request.
+type Session struct {
+	mu        sync.Mutex
+	id        string
+	values    map[string]any
+	changed   bool
+	destroyed bool
+}
+
+func sessionFromContext(ctx context.Context) *Session {
+	return reqctx.Session[*Session](ctx)
+}
+
+// SessionManager gives handlers access to the session of the current
+// request. Sessions are identified by an HMAC-signed cookie.
+type SessionManager struct {
+	store  SessionStore
+	ttl    time.Duration
+	cookie string
+	log    *slog.Logger
+
+	mu   sync.RWMutex
+	keys [][]byte
+}
+
+// NewSessionManager builds a new SessionManager. Session IDs are signed
+// with the first of Config.Session.Keys and verified against all of
+// them, so keys can be rotated. Without configured keys, a random key is
+// generated and sessions don't survive a restart.
+func NewSessionManager(store SessionStore, cfg *Config, log *slog.Logger) (*SessionManager, error) {
+	m := &SessionManager{
+		store:  store,
+		ttl:    time.Duration(cfg.Session.TTL),
+		cookie: cfg.Session.CookieName,
+		log:    log,
+	}
+	if m.ttl <= 0 {
+		m.ttl = 24 * time.Hour
+	}
+	if m.cookie == "" {
+		m.cookie = "session"
+	}
+	for _, k := range cfg.Session.Keys {
+		m.keys = append(m.keys, []byte(k))
+	}
+	if len(m.keys) == 0 {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		m.keys = [][]byte{key}
+		log.Warn("No session keys configured, using a random key")
+	}
+	return m, nil
+}
+
+// Get returns the session value stored under key.
+func (m *SessionManager) Get(ctx context.Context, key string) (any, bool) {
+	s := sessionFromContext(ctx)
+	if s == nil {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// Put stores v in the session under key.
+func (m *SessionManager) Put(ctx context.Context, key string, v any) {
+	s := sessionFromContext(ctx)
+	if s == nil {
+		m.log.Warn("Session used outside of the session middleware", slog.String("key", key))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = make(map[string]any)
+	}
+	s.values[key] = v
+	s.changed = true
+	s.destroyed = false
+}
+
+// Destroy deletes the session and clears its cookie.
+func (m *SessionManager) Destroy(ctx context.Context) {
+	s := sessionFromContext(ctx)
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = nil
+	s.changed = true
+	s.destroyed = true
+}
+
+// SetKeys replaces the keys signing and verifying session cookies.
+func (m *SessionManager) SetKeys(keys []string) {
+	b := make([][]byte, len(keys))
+	for i, k := range keys {
+		b[i] = []byte(k)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = b
+}
+
+// sign returns the cookie value for the session id.
+func (m *SessionManager) sign(id string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	mac := hmac.New(sha256.New, m.keys[0])
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the session ID from a cookie value if its signature is
+// valid under any of the keys.
+func (m *SessionManager) verify(value string) (string, bool) {
+	id, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return "", false
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return "", false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, key := range m.keys {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(id))
+		if hmac.Equal(got, mac.Sum(nil)) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// load returns the session for the request, starting an empty one if
+// the request has no valid session cookie.
+func (m *SessionManager) load(r *http.Request) (*Session, error) {
+	if c, err := r.Cookie(m.cookie); err == nil {
+		if id, ok := m.verify(c.Value); ok {
+			values, found, err := m.store.Load(r.Context(), id)
+			if err != nil {
+				return nil, err
+			}
+			if found {
+				return &Session{id: id, values: values}, nil
+			}
+		} else {
+			m.log.Warn("Rejected session cookie with an invalid signature", slog.String("path", r.URL.Path))
+		}
+	}
+	return &Session{}, nil
+}
+
+// commit saves the session if it changed and sets or clears its cookie.
+func (m *SessionManager) commit(w http.ResponseWriter, r *http.Request, s *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.changed {
+		return nil
+	}
+	s.changed = false
+
+	cookie := &http.Cookie{
+		Name:     m.cookie,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if s.destroyed {
+		if s.id != "" {
+			if err := m.store.Delete(r.Context(), s.id); err != nil {
+				return err
+			}
+		}
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+		return nil
+	}
+
+	if s.id == "" {
+		id, err := newSessionID()
+		if err != nil {
+			return err
+		}
+		s.id = id
+	}
+	if err := m.store.Save(r.Context(), s.id, s.values, m.ttl); err != nil {
+		return err
+	}
+	cookie.Value = m.sign(s.id)
+	cookie.MaxAge = int(m.ttl / time.Second)
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// SessionMiddleware loads the session before each request and saves it
+// once the handler starts writing its response, so that the cookie can
+// still be set.
+type SessionMiddleware struct {
+	sessions *SessionManager
+	errs     *ErrorWriter
+	log      *slog.Logger
+}
+
+// NewSessionMiddleware builds a new SessionMiddleware.
+func NewSessionMiddleware(sessions *SessionManager, errs *ErrorWriter, log *slog.Logger) *SessionMiddleware {
+	return &SessionMiddleware{sessions: sessions, errs: errs, log: log}
+}
+
+func (*SessionMiddleware) Order() int {
+	return orderSession
+}
+
+func (m *SessionMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := m.sessions.load(r)
+		if err != nil {
+			m.errs.Write(w, r, err)
+			return
+		}
+		r = r.WithContext(reqctx.WithSession(r.Context(), s))
+		sw := &sessionWriter{ResponseWriter: w, commit: func() {
+			if err := m.sessions.commit(w, r, s); err != nil {
+				m.log.Error("Failed to save session", slog.String("err", err.Error()))
+			}
+		}}
+		next.ServeHTTP(sw, r)
+		sw.commitOnce()
+	})
+}
+
+// sessionWriter commits the session before the response header is
+// written.
+type sessionWriter struct {
+	http.ResponseWriter
+	commit    func()
+	committed bool
+}
+
+func (w *sessionWriter) commitOnce() {
+	if !w.committed {
+		w.committed = true
+		w.commit()
+	}
+}
+
+func (w *sessionWriter) WriteHeader(status int) {
+	w.commitOnce()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.commitOnce()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionWriter) Flush() {
+	w.commitOnce()
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+	"go.uber.org/fx/fxtest"
+)
+
+// sessionTest is a handler behind the SessionMiddleware storing the
+// "put" query parameter in the session, destroying it on "destroy",
+// and answering with the value stored.
+type sessionTest struct {
+	clk     *testsupport.FakeClock
+	store   *MemorySessionStore
+	manager *SessionManager
+	handler http.Handler
+}
+
+func newSessionTest(t *testing.T, keys ...string) *sessionTest {
+	t.Helper()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	clk := testsupport.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	lc := fxtest.NewLifecycle(t)
+	store := NewMemorySessionStore(lc, clk)
+	lc.RequireStart()
+	t.Cleanup(lc.RequireStop)
+	clk.BlockUntil(1) // the janitor's ticker
+	cfg := &Config{}
+	cfg.Session.Keys = keys
+	cfg.Session.TTL = Duration(time.Hour)
+	manager, err := NewSessionManager(store, cfg, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := NewSessionMiddleware(manager, newTestErrorWriter(t), log)
+	return &sessionTest{clk: clk, store: store, manager: manager, handler: mw.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Has("put"):
+			manager.Put(r.Context(), "k", q.Get("put"))
+		case q.Has("destroy"):
+			manager.Destroy(r.Context())
+		}
+		if v, ok := manager.Get(r.Context(), "k"); ok {
+			fmt.Fprint(w, v)
+		}
+	}))}
+}
+
+// do sends a request with the given query and cookie, if any, and
+// returns the body of the response and the session cookie it sets.
+func (st *sessionTest) do(query string, cookie *http.Cookie) (string, *http.Cookie) {
+	req := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	st.handler.ServeHTTP(rec, req)
+	var set *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "session" {
+			set = c
+		}
+	}
+	return rec.Body.String(), set
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	st := newSessionTest(t, "key")
+
+	if _, c := st.do("", nil); c != nil {
+		t.Errorf("cookie set for an unchanged session: %v", c)
+	}
+	_, c := st.do("put=v1", nil)
+	if c == nil || c.Value == "" {
+		t.Fatal("no session cookie issued")
+	}
+	if !c.HttpOnly || c.SameSite != http.SameSiteLaxMode || c.Secure || c.MaxAge != 3600 {
+		t.Errorf("cookie %v isn't HttpOnly, SameSite=Lax and not Secure with a MaxAge of 1h", c)
+	}
+	body, again := st.do("", c)
+	if body != "v1" {
+		t.Errorf("got %q, want the value stored", body)
+	}
+	if again != nil {
+		t.Errorf("cookie set again for an unchanged session: %v", again)
+	}
+
+	// Changing the session keeps its ID.
+	if _, c2 := st.do("put=v2", c); c2 == nil || c2.Value != c.Value {
+		t.Errorf("got cookie %v, want the same session", c2)
+	}
+	if body, _ := st.do("", c); body != "v2" {
+		t.Errorf("got %q, want v2", body)
+	}
+
+	_, cleared := st.do("destroy", c)
+	if cleared == nil || cleared.MaxAge >= 0 {
+		t.Errorf("got cookie %v, want it cleared", cleared)
+	}
+	if body, _ := st.do("", c); body != "" {
+		t.Errorf("destroyed session still has %q", body)
+	}
+}
+
+func TestSessionSecureOverTLS(t *testing.T) {
+	st := newSessionTest(t, "key")
+	req := httptest.NewRequest(http.MethodGet, "/?put=v", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec := httptest.NewRecorder()
+	st.handler.ServeHTTP(rec, req)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].Secure {
+		t.Errorf("got cookies %v, want a Secure one", cookies)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	st := newSessionTest(t, "key")
+	_, c := st.do("put=v", nil)
+
+	st.clk.Advance(time.Hour - time.Second)
+	if body, _ := st.do("", c); body != "v" {
+		t.Fatalf("got %q before the TTL, want v", body)
+	}
+	st.clk.Advance(time.Second)
+	if body, _ := st.do("", c); body != "" {
+		t.Errorf("got %q after the TTL, want an empty session", body)
+	}
+
+	// The janitor removes the expired session on its next run, in the
+	// background.
+	st.clk.Advance(time.Minute)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		st.store.mu.Lock()
+		n := len(st.store.entries)
+		st.store.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d sessions left after the janitor ran", n)
+		}
+	}
+}
+
+func TestSessionTampering(t *testing.T) {
+	st := newSessionTest(t, "key")
+	_, c := st.do("put=v", nil)
+	_, other := st.do("put=other", nil)
+
+	id, _, _ := strings.Cut(c.Value, ".")
+	_, sig, _ := strings.Cut(other.Value, ".")
+	for name, value := range map[string]string{
+		"unsigned":       id,
+		"bad signature":  id + ".AAAA",
+		"swapped":        id + "." + sig,
+		"not base64":     id + ".!!!",
+		"other key":      signWith("other-key", id),
+		"empty":          "",
+		"signature only": "." + sig,
+	} {
+		if body, _ := st.do("", &http.Cookie{Name: "session", Value: value}); body != "" {
+			t.Errorf("%s: got %q, want an empty session", name, body)
+		}
+	}
+
+	// A key rotated out of first place still verifies.
+	st.manager.SetKeys([]string{"new-key", "key"})
+	if body, _ := st.do("", c); body != "v" {
+		t.Errorf("got %q with the old key second, want v", body)
+	}
+	st.manager.SetKeys([]string{"new-key"})
+	if body, _ := st.do("", c); body != "" {
+		t.Errorf("got %q once the old key is dropped, want an empty session", body)
+	}
+}
+
+// signWith signs id as a SessionManager with key would.
+func signWith(key, id string) string {
+	m := &SessionManager{keys: [][]byte{[]byte(key)}}
+	return m.sign(id)
+}
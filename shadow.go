@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+
+	"example.com/uberfx/clock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// shadowRequest is a request to replay against the shadow upstream,
+// along with the status the primary answered it with.
+type shadowRequest struct {
+	route  string
+	method string
+	uri    string
+	header http.Header
+	body   []byte
+	status int
+}
+
+// shadowHopHeaders are the request headers that only concern the
+// inbound connection and aren't replayed.
+var shadowHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// ShadowMirror is route middleware that mirrors a sample of the
+// requests to the routes listed in Config.Shadow.Routes to the shadow
+// upstream at Config.Shadow.URL, so that a new implementation can be
+// compared against live traffic. The body is copied as the handler
+// reads it, and once the primary response is done the request is
+// queued and replayed in the background through the shared HTTP client;
+// the shadow's response is discarded, but whether its status matched
+// the primary's is counted. Credentials, such as the Authorization,
+// Cookie and X-API-Key headers, aren't mirrored. When the queue is
+// full, mirrors are dropped rather than slowing down the primary, as
+// are requests whose body is larger than Config.Shadow.MaxBodyBytes.
+type ShadowMirror struct {
+	url      string
+	percent  map[string]float64
+	maxBody  int64
+	workers  int
+	client   *http.Client
+	clock    clock.Clock
+	log      *slog.Logger
+	outcomes *prometheus.CounterVec
+	sample   func() float64
+
+	queue  chan shadowRequest
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewShadowMirror builds a new ShadowMirror.
+func NewShadowMirror(cfg *Config, client *http.Client, clk clock.Clock, reg *prometheus.Registry, log *slog.Logger) *ShadowMirror {
+	m := &ShadowMirror{
+		url:     strings.TrimSuffix(cfg.Shadow.URL, "/"),
+		percent: cfg.Shadow.Routes,
+		maxBody: cfg.Shadow.MaxBodyBytes,
+		workers: cfg.Shadow.Workers,
+		client:  client,
+		clock:   clk,
+		log:     log,
+		outcomes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_shadow_requests_total",
+			Help: "Requests mirrored to the shadow upstream, by route and outcome: matched, mismatched, failed, dropped or oversized.",
+		}, []string{"route", "outcome"}),
+		sample: func() float64 { return rand.Float64() * 100 },
+	}
+	if m.maxBody <= 0 {
+		m.maxBody = 64 << 10
+	}
+	if m.workers <= 0 {
+		m.workers = 2
+	}
+	size := cfg.Shadow.QueueSize
+	if size <= 0 {
+		size = 100
+	}
+	m.queue = make(chan shadowRequest, size)
+	reg.MustRegister(m.outcomes)
+	return m
+}
+
+func (*ShadowMirror) Order() int {
+	return orderShadow
+}
+
+func (m *ShadowMirror) WrapRoute(route Route, next http.Handler) http.Handler {
+	pattern := route.Pattern()
+	percent := m.percent[pattern]
+	if m.url == "" || percent <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.sample() >= percent {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tee := &shadowBody{ReadCloser: r.Body, max: m.maxBody}
+		r.Body = tee
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		// The rest of the body, if the handler didn't read it all, would
+		// be drained anyway.
+		if !tee.eof && !tee.overflow {
+			_, _ = io.Copy(io.Discard, tee)
+		}
+		if tee.overflow {
+			m.outcomes.WithLabelValues(pattern, "oversized").Inc()
+			return
+		}
+		status := rec.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		header := r.Header.Clone()
+		for _, name := range shadowHopHeaders {
+			header.Del(name)
+		}
+		for name := range sensitiveHeaders {
+			header.Del(name)
+		}
+		m.enqueue(shadowRequest{
+			route:  pattern,
+			method: r.Method,
+			uri:    r.URL.RequestURI(),
+			header: header,
+			body:   tee.buf.Bytes(),
+			status: status,
+		})
+	})
+}
+
+// enqueue queues req for replay, or drops it if the queue is full.
+func (m *ShadowMirror) enqueue(req shadowRequest) {
+	select {
+	case m.queue <- req:
+	default:
+		m.outcomes.WithLabelValues(req.route, "dropped").Inc()
+	}
+}
+
+func (*ShadowMirror) Name() string {
+	return "shadow"
+}
+
+func (*ShadowMirror) Priority() int {
+	return priorityShadow
+}
+
+func (m *ShadowMirror) Start(context.Context) error {
+	if m.url == "" {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	for range m.workers {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			for {
+				select {
+				case req := <-m.queue:
+					m.replay(ctx, req)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// Stop abandons the queued mirrors and waits for those in flight to be
+// cancelled.
+func (m *ShadowMirror) Stop(ctx context.Context) error {
+	if m.cancel == nil {
+		return nil
+	}
+	m.cancel()
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// replay sends req to the shadow upstream and compares its status with
+// the primary's.
+func (m *ShadowMirror) replay(ctx context.Context, req shadowRequest) {
+	out, err := http.NewRequestWithContext(ctx, req.method, m.url+req.uri, bytes.NewReader(req.body))
+	if err != nil {
+		m.outcomes.WithLabelValues(req.route, "failed").Inc()
+		return
+	}
+	out.Header = req.header
+	out.Header.Set("X-Shadow-Request", "1")
+	start := m.clock.Now()
+	resp, err := m.client.Do(out)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			m.log.Warn("Shadow request failed", slog.String("route", req.route), slog.String("err", err.Error()))
+		}
+		m.outcomes.WithLabelValues(req.route, "failed").Inc()
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	outcome := "matched"
+	if resp.StatusCode != req.status {
+		outcome = "mismatched"
+		m.log.Debug("Shadow status mismatch",
+			slog.String("route", req.route),
+			slog.Int("primary", req.status),
+			slog.Int("shadow", resp.StatusCode),
+			slog.Duration("duration", m.clock.Now().Sub(start)),
+		)
+	}
+	m.outcomes.WithLabelValues(req.route, outcome).Inc()
+}
+
+// shadowBody copies up to max bytes of the body read through it.
+type shadowBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	max      int64
+	eof      bool
+	overflow bool
+}
+
+func (b *shadowBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.overflow {
+		if int64(b.buf.Len()+n) > b.max {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err != nil {
+		b.eof = true
+	}
+	return n, err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/uberfx/clock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestShadowMirrorStripsCredentials(t *testing.T) {
+	mirrored := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrored <- r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	cfg := &Config{}
+	cfg.Shadow.URL = upstream.URL
+	cfg.Shadow.Routes = map[string]float64{"POST /echo": 100}
+	m := NewShadowMirror(cfg, upstream.Client(), clock.Real(), prometheus.NewRegistry(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(context.Background())
+
+	route := &funcRoute{pattern: "POST /echo", handler: func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(w, r.Body)
+	}}
+	req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("hi"))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-API-Key", "secret")
+	req.Header.Set("X-Trace", "kept")
+	m.WrapRoute(route, route).ServeHTTP(httptest.NewRecorder(), req)
+
+	select {
+	case h := <-mirrored:
+		for _, name := range []string{"Authorization", "Cookie", "X-API-Key"} {
+			if v := h.Get(name); v != "" {
+				t.Errorf("%s mirrored as %q", name, v)
+			}
+		}
+		if h.Get("X-Trace") != "kept" {
+			t.Errorf("X-Trace not mirrored: %v", h)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request not mirrored")
+	}
+}
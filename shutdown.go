@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"example.com/uberfx/clock"
+	"go.uber.org/fx"
+)
+
+// ShutdownSupervisor is an fx.Lifecycle that watches the hooks
+// appended through it. Each hook is named after the function appending
+// it and recorded in a HookRegistry with the time its runs took. An
+// OnStop hook still running after a soft deadline is logged, the first
+// time along with the stacks of all goroutines to show where it's
+// stuck, and once the app has stopped the time taken by each OnStop hook
+// is logged. Hooks are appended to the underlying lifecycle in the same
+// order and their results are passed through untouched.
+type ShutdownSupervisor struct {
+	lc     fx.Lifecycle
+	hooks  *HookRegistry
+	log    *slog.Logger
+	soft   time.Duration
+	stacks io.Writer
+	clock  clock.Clock
+
+	dumpOnce    sync.Once
+	summaryOnce sync.Once
+	mu          sync.Mutex
+	began       time.Time
+	stopped     []*HookRecord
+}
+
+// NewShutdownSupervisor decorates lc with a ShutdownSupervisor. Its
+// soft deadline is Config.App.StopHookWarning.
+func NewShutdownSupervisor(lc fx.Lifecycle, hooks *HookRegistry, cfg *Config, clk clock.Clock, log *slog.Logger) fx.Lifecycle {
+	s := &ShutdownSupervisor{
+		lc:     lc,
+		hooks:  hooks,
+		log:    log,
+		soft:   time.Duration(cfg.App.StopHookWarning),
+		stacks: os.Stderr,
+		clock:  clk,
+	}
+	if s.soft <= 0 {
+		s.soft = 5 * time.Second
+	}
+	// Hooks stop in reverse order, so this one runs after all the
+	// others.
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			s.summarize()
+			return nil
+		},
+	})
+	return s
+}
+
+func (s *ShutdownSupervisor) Append(h fx.Hook) {
+	rec := &HookRecord{HasStart: h.OnStart != nil, HasStop: h.OnStop != nil}
+	if pc, file, line, ok := runtime.Caller(1); ok {
+		rec.Name = funcName(pc)
+		rec.Caller = fmt.Sprintf("%s:%d", file, line)
+	}
+	s.hooks.add(rec)
+	if h.OnStart != nil {
+		h.OnStart = s.time(rec, h.OnStart)
+	}
+	if h.OnStop != nil {
+		h.OnStop = s.watch(rec, h.OnStop)
+	}
+	s.lc.Append(h)
+}
+
+// time wraps the OnStart hook recorded in rec.
+func (s *ShutdownSupervisor) time(rec *HookRecord, start func(context.Context) error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		began := s.clock.Now()
+		err := start(ctx)
+		s.hooks.update(rec, func(rec *HookRecord) {
+			rec.StartDuration = s.clock.Now().Sub(began)
+			rec.StartError = errString(err)
+		})
+		return err
+	}
+}
+
+// watch wraps the OnStop hook recorded in rec.
+func (s *ShutdownSupervisor) watch(rec *HookRecord, stop func(context.Context) error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		start := s.clock.Now()
+		s.mu.Lock()
+		if s.began.IsZero() {
+			s.began = start
+		}
+		s.mu.Unlock()
+
+		t := s.clock.AfterFunc(s.soft, func() {
+			s.log.Warn("OnStop hook is slow", slog.String("hook", rec.Name), slog.Duration("deadline", s.soft))
+			s.dumpOnce.Do(s.dumpStacks)
+		})
+		err := stop(ctx)
+		t.Stop()
+
+		s.hooks.update(rec, func(rec *HookRecord) {
+			rec.StopDuration = s.clock.Now().Sub(start)
+			rec.StopError = errString(err)
+		})
+		s.mu.Lock()
+		s.stopped = append(s.stopped, rec)
+		s.mu.Unlock()
+		// Fx skips the remaining hooks once the stop timeout expires, so
+		// the summary is logged now if it won't be later.
+		if ctx.Err() != nil {
+			s.summarize()
+		}
+		return err
+	}
+}
+
+// dumpStacks writes the stacks of all goroutines.
+func (s *ShutdownSupervisor) dumpStacks() {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	s.log.Warn("Dumping goroutine stacks")
+	fmt.Fprintf(s.stacks, "%s\n", buf)
+}
+
+// summarize logs the time taken by each OnStop hook, once.
+func (s *ShutdownSupervisor) summarize() {
+	s.summaryOnce.Do(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		attrs := make([]any, 0, len(s.stopped))
+		failed := 0
+		for _, rec := range s.hooks.snapshot(s.stopped) {
+			attrs = append(attrs, slog.String(rec.Name, rec.StopDuration.String()))
+			if rec.StopError != "" {
+				failed++
+			}
+		}
+		var total time.Duration
+		if !s.began.IsZero() {
+			total = s.clock.Now().Sub(s.began)
+		}
+		s.log.Info("Shutdown summary",
+			slog.Duration("total", total),
+			slog.Int("failed", failed),
+			slog.Group("hooks", attrs...),
+		)
+	})
+}
+
+// funcName returns the name of the function containing pc.
+func funcName(pc uintptr) string {
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		return fn.Name()
+	}
+	return "unknown"
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+)
+
+func TestShutdownSupervisor(t *testing.T) {
+	clk := testsupport.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	var logs, stacks bytes.Buffer
+	cfg := &Config{}
+	cfg.App.StopHookWarning = Duration(5 * time.Second)
+	hooks := NewHookRegistry()
+	lc := fxtest.NewLifecycle(t)
+	s := NewShutdownSupervisor(lc, hooks, cfg, clk, slog.New(slog.NewTextHandler(&logs, nil))).(*ShutdownSupervisor)
+	s.stacks = &stacks
+
+	s.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			clk.Advance(time.Second)
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			clk.Advance(5*time.Second - time.Nanosecond)
+			if strings.Contains(logs.String(), "OnStop hook is slow") {
+				t.Error("hook reported as slow before the soft deadline")
+			}
+			clk.Advance(2*time.Second + time.Nanosecond)
+			return nil
+		},
+	})
+	lc.RequireStart().RequireStop()
+
+	out := logs.String()
+	if !strings.Contains(out, "OnStop hook is slow") {
+		t.Errorf("slow hook not reported:\n%s", out)
+	}
+	if !strings.Contains(stacks.String(), "goroutine") {
+		t.Error("stacks not dumped")
+	}
+	if !strings.Contains(out, "total=7s") {
+		t.Errorf("summary total not 7s:\n%s", out)
+	}
+	records := hooks.Records()
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	if r := records[0]; r.StartDuration != time.Second || r.StopDuration != 7*time.Second {
+		t.Errorf("got start %s and stop %s, want 1s and 7s", r.StartDuration, r.StopDuration)
+	}
+}
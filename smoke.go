@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// SmokeCheck is a self-check run against a live instance of the app
+// by the --smoke mode.
+type SmokeCheck interface {
+	Name() string
+	Check(ctx context.Context, client *http.Client, baseURL string) error
+}
+
+// AsSmokeCheck annotates the given constructor to state that it
+// provides a check to the "smokechecks" group.
+func AsSmokeCheck(f any) any {
+	return AsGroupMember[SmokeCheck]("smokechecks", f)
+}
+
+// HTTPSmokeCheck is a SmokeCheck that sends a single request and
+// verifies the status code and, if set, the response body.
+type HTTPSmokeCheck struct {
+	CheckName  string
+	Method     string
+	Path       string
+	Body       string
+	WantStatus int
+	WantBody   string
+}
+
+func (c *HTTPSmokeCheck) Name() string {
+	return c.CheckName
+}
+
+func (c *HTTPSmokeCheck) Check(ctx context.Context, client *http.Client, baseURL string) error {
+	var body io.Reader
+	if c.Body != "" {
+		body = strings.NewReader(c.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, c.Method, strings.TrimSuffix(baseURL, "/")+c.Path, body)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	got, err := ReadAllLimited(resp)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if want := c.WantStatus; resp.StatusCode != want {
+		return fmt.Errorf("got status %d, want %d", resp.StatusCode, want)
+	}
+	if c.WantBody != "" && !bytes.Equal(got, []byte(c.WantBody)) {
+		return fmt.Errorf("got body %q, want %q", got, c.WantBody)
+	}
+	return nil
+}
+
+// DefaultSmokeChecks are the constructors for the checks covering the
+// built-in routes.
+var DefaultSmokeChecks = []any{
+	AsSmokeCheck(func() *HTTPSmokeCheck {
+		return &HTTPSmokeCheck{CheckName: "healthz", Method: http.MethodGet, Path: "/healthz", WantStatus: http.StatusOK}
+	}),
+	AsSmokeCheck(func() *HTTPSmokeCheck {
+		return &HTTPSmokeCheck{CheckName: "hello", Method: http.MethodPost, Path: "/hello", Body: "smoke",
+			WantStatus: http.StatusOK, WantBody: "Hello, smoke\n"}
+	}),
+	AsSmokeCheck(func() *HTTPSmokeCheck {
+		return &HTTPSmokeCheck{CheckName: "echo", Method: http.MethodPost, Path: "/echo", Body: "smoke test payload",
+			WantStatus: http.StatusOK, WantBody: "smoke test payload"}
+	}),
+}
+
+// RunSmoke runs the smoke checks and returns the process exit code. If
+// target is empty, the app is started on an ephemeral port and checked
+// against itself; otherwise the app is only constructed to collect the
+// checks, which are run against target. The app is built with the given
+// extra options.
+func RunSmoke(target string, extra ...fx.Option) int {
+	var (
+		checks []SmokeCheck
+		info   *ServerInfo
+	)
+	opts := append(extra,
+		fx.Invoke(fx.Annotate(func(c []SmokeCheck) {
+			checks = c
+		}, fx.ParamTags(`group:"smokechecks"`))),
+		fx.Populate(&info),
+	)
+	if target == "" {
+		opts = append(opts, ephemeralAddr)
+	} else {
+		// The app isn't started: its prerequisites are the target's.
+		opts = append(opts, skipPreflight)
+	}
+	app := NewApp(opts...)
+	if err := app.Err(); err != nil {
+		fmt.Println("smoke: failed to build app:", err)
+		return 1
+	}
+
+	if target == "" {
+		stop, err := startApp(app)
+		if err != nil {
+			fmt.Println("smoke: failed to start app:", err)
+			return 1
+		}
+		defer func() {
+			if err := stop(); err != nil {
+				fmt.Println("smoke: failed to stop app:", err)
+			}
+		}()
+		target = "http://" + info.Addr().String()
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	failed := 0
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := c.Check(ctx, client, target)
+		cancel()
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", c.Name(), err)
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.Name())
+	}
+	fmt.Printf("smoke: %d/%d checks passed against %s\n", len(checks)-failed, len(checks), target)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// ephemeralAddr has the app listen on an ephemeral port of the loopback
+// interface, for the modes running it against itself.
+var ephemeralAddr = fx.Decorate(func(c *Config) *Config {
+	cfg := *c
+	cfg.Server.Addr = "127.0.0.1:0"
+	return &cfg
+})
+
+// startApp starts app within its start timeout and returns the function
+// stopping it within its stop timeout. Running out of time is reported
+// along with the timeout, so that it can be told apart from a hook's own
+// deadline and raised through Config.App.StartTimeout.
+func startApp(app *fx.App) (stop func() error, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), app.StartTimeout())
+	defer cancel()
+	if err := app.Start(ctx); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+			err = fmt.Errorf("start timeout of %s exceeded: %w", app.StartTimeout(), err)
+		}
+		return nil, err
+	}
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), app.StopTimeout())
+		defer cancel()
+		return app.Stop(ctx)
+	}, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/fx"
+)
+
+// quietConfig has the apps built by the test log Fx events only on
+// errors.
+func quietConfig(t *testing.T) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(`{"log":{"fx_events":"on-error"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", file)
+}
+
+func TestRunSmokeAgainstItself(t *testing.T) {
+	quietConfig(t)
+	if code := RunSmoke(""); code != 0 {
+		t.Errorf("got exit code %d, want 0", code)
+	}
+}
+
+func TestRunSmokeFailingCheck(t *testing.T) {
+	quietConfig(t)
+	missing := fx.Provide(AsSmokeCheck(func() *HTTPSmokeCheck {
+		return &HTTPSmokeCheck{CheckName: "missing", Method: http.MethodGet, Path: "/missing", WantStatus: http.StatusOK}
+	}))
+	if code := RunSmoke("", missing); code != 1 {
+		t.Errorf("got exit code %d, want 1", code)
+	}
+}
+
+func TestRunSmokeAgainstTarget(t *testing.T) {
+	quietConfig(t)
+	target := startTestApp(t, nil)
+	if code := RunSmoke(target); code != 0 {
+		t.Errorf("got exit code %d, want 0", code)
+	}
+}
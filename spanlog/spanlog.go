@@ -0,0 +1,144 @@
+// Package spanlog times the nested steps of a request as spans and logs
+// them as a single structured record, for when full tracing isn't set
+// up.
+//
+// Middleware opens a root span with New and logs it once the request is
+// done; code handling the request opens child spans with Start:
+//
+//	span, ctx := spanlog.Start(ctx, "render")
+//	defer span.End()
+//
+// Without a root span in the context, Start returns a nil *Span, whose
+// methods do nothing, so code can be instrumented unconditionally.
+package spanlog
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type spanKey struct{}
+
+// Span is a timed step of a request.
+type Span struct {
+	name  string
+	start time.Time
+	dur   time.Duration
+	ended bool
+	// children are guarded by the trace's mutex, since spans may be
+	// started from several goroutines.
+	children []*Span
+	trace    *trace
+}
+
+// trace is shared by the spans of a request.
+type trace struct {
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// New starts a root span, timed with now, or time.Now if nil, and
+// returns a copy of ctx carrying it.
+func New(ctx context.Context, name string, now func() time.Time) (*Span, context.Context) {
+	if now == nil {
+		now = time.Now
+	}
+	s := &Span{name: name, start: now(), trace: &trace{now: now}}
+	return s, context.WithValue(ctx, spanKey{}, s)
+}
+
+// Start starts a child of the span in ctx and returns a copy of ctx
+// carrying it. It returns nil and ctx itself if ctx has no span.
+func Start(ctx context.Context, name string) (*Span, context.Context) {
+	parent, _ := ctx.Value(spanKey{}).(*Span)
+	if parent == nil {
+		return nil, ctx
+	}
+	t := parent.trace
+	s := &Span{name: name, start: t.now(), trace: t}
+	t.mu.Lock()
+	parent.children = append(parent.children, s)
+	t.mu.Unlock()
+	return s, context.WithValue(ctx, spanKey{}, s)
+}
+
+// End records the duration of the span. Only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	now := s.trace.now()
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	if !s.ended {
+		s.ended = true
+		s.dur = now.Sub(s.start)
+	}
+}
+
+// Duration returns the duration of the span, or the time it has been
+// running if it hasn't ended.
+func (s *Span) Duration() time.Duration {
+	if s == nil {
+		return 0
+	}
+	now := s.trace.now()
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	return s.duration(now)
+}
+
+func (s *Span) duration(now time.Time) time.Duration {
+	if s.ended {
+		return s.dur
+	}
+	return now.Sub(s.start)
+}
+
+// LogValue represents the span as a group holding its duration and a
+// group for each of its children, by name. Children sharing a name are
+// told apart by a "#2", "#3"... suffix. Spans that haven't ended are
+// marked as such.
+func (s *Span) LogValue() slog.Value {
+	if s == nil {
+		return slog.Value{}
+	}
+	now := s.trace.now()
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	return s.value(now)
+}
+
+func (s *Span) value(now time.Time) slog.Value {
+	attrs := make([]slog.Attr, 0, 2+len(s.children))
+	attrs = append(attrs, slog.Duration("duration", s.duration(now)))
+	if !s.ended {
+		attrs = append(attrs, slog.Bool("unfinished", true))
+	}
+	var seen map[string]int
+	for _, c := range s.children {
+		key := c.name
+		if len(s.children) > 1 {
+			if seen == nil {
+				seen = make(map[string]int, len(s.children))
+			}
+			seen[c.name]++
+			if n := seen[c.name]; n > 1 {
+				key += "#" + strconv.Itoa(n)
+			}
+		}
+		attrs = append(attrs, slog.Attr{Key: key, Value: c.value(now)})
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// Name returns the name of the span.
+func (s *Span) Name() string {
+	if s == nil {
+		return ""
+	}
+	return s.name
+}
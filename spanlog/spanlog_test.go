@@ -0,0 +1,84 @@
+package spanlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+)
+
+func TestSpans(t *testing.T) {
+	clk := testsupport.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	root, ctx := New(context.Background(), "request", clk.Now)
+
+	render, renderCtx := Start(ctx, "render")
+	clk.Advance(10 * time.Millisecond)
+	load, _ := Start(renderCtx, "load")
+	clk.Advance(5 * time.Millisecond)
+	load.End()
+	render.End()
+	clk.Advance(time.Millisecond)
+	load.End()
+
+	// A second span of the same name, left running.
+	Start(ctx, "render")
+	clk.Advance(2 * time.Millisecond)
+	root.End()
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("done", slog.Any("spans", root))
+	var rec struct {
+		Spans map[string]any `json:"spans"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"duration": float64(18 * time.Millisecond),
+		"render": map[string]any{
+			"duration": float64(15 * time.Millisecond),
+			"load":     map[string]any{"duration": float64(5 * time.Millisecond)},
+		},
+		"render#2": map[string]any{
+			"duration":   float64(2 * time.Millisecond),
+			"unfinished": true,
+		},
+	}
+	if got, wantJSON := mustJSON(t, rec.Spans), mustJSON(t, want); got != wantJSON {
+		t.Errorf("got spans %s, want %s", got, wantJSON)
+	}
+	if d := render.Duration(); d != 15*time.Millisecond {
+		t.Errorf("render duration = %s, want 15ms", d)
+	}
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestNoRoot(t *testing.T) {
+	ctx := context.Background()
+	span, got := Start(ctx, "render")
+	if span != nil || got != ctx {
+		t.Fatalf("without a root, got span %v and a new context, want nil and ctx", span)
+	}
+	span.End()
+	if span.Duration() != 0 || span.Name() != "" || !span.LogValue().Equal(slog.Value{}) {
+		t.Error("a nil span isn't a no-op")
+	}
+	if n := testing.AllocsPerRun(100, func() {
+		s, _ := Start(ctx, "render")
+		s.End()
+	}); n != 0 {
+		t.Errorf("without a root, Start and End allocate %v times, want 0", n)
+	}
+}
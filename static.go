@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StaticHandler is an HTTP handler that serves files from a directory,
+// with support for range requests and conditional GETs. In SPA mode,
+// paths that don't match a file are served the index page instead.
+// Without a configured directory, a minimal page embedded in the binary
+// is served. Files with a pre-compressed sibling, such as app.js.br or
+// app.js.gz next to app.js, are served compressed to clients accepting
+// that encoding.
+type StaticHandler struct {
+	prefix string
+	files  fs.FS
+	spa    bool
+	index  string
+	etags  *etagCache
+}
+
+// NewStaticHandler builds a new StaticHandler serving Config.Static.Dir.
+func NewStaticHandler(cfg *Config) *StaticHandler {
+	h := &StaticHandler{
+		prefix: cfg.Static.Prefix,
+		spa:    cfg.Static.SPA,
+		index:  cfg.Static.Index,
+		etags:  newETagCache(),
+	}
+	if h.prefix == "" {
+		h.prefix = "/static/"
+	}
+	if !strings.HasSuffix(h.prefix, "/") {
+		h.prefix += "/"
+	}
+	if h.index == "" {
+		h.index = "index.html"
+	}
+	if cfg.Static.Dir != "" {
+		h.files = os.DirFS(cfg.Static.Dir)
+	} else {
+		h.files = embeddedDir("static")
+	}
+	return h
+}
+
+func (h *StaticHandler) Pattern() string {
+	return "GET " + h.prefix
+}
+
+func (h *StaticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Cleaning a rooted path resolves any dot segments without escaping
+	// the root, and fs.FS rejects whatever isn't a valid relative path.
+	name := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(r.URL.Path, h.prefix)), "/")
+	if name == "" {
+		name = h.index
+	}
+	if !fs.ValidPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+	h.serve(w, r, name)
+}
+
+// ServeIndex serves the index page.
+func (h *StaticHandler) ServeIndex(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, h.index)
+}
+
+// serve serves the named file, or the index page in SPA mode if there's
+// none.
+func (h *StaticHandler) serve(w http.ResponseWriter, r *http.Request, name string) {
+	f, info, err := h.open(name)
+	if errors.Is(err, fs.ErrNotExist) && h.spa {
+		name = h.index
+		f, info, err = h.open(name)
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer f.Close()
+
+	// The ETag is that of the file actually sent, so each encoding gets
+	// its own.
+	sent := name
+	w.Header().Add("Vary", "Accept-Encoding")
+	for _, enc := range precompressed {
+		if !acceptsEncoding(r.Header.Get("Accept-Encoding"), enc.coding) {
+			continue
+		}
+		cf, cinfo, err := h.open(name + enc.ext)
+		if err != nil {
+			continue
+		}
+		defer cf.Close()
+		f, info, sent = cf, cinfo, name+enc.ext
+		w.Header().Set("Content-Encoding", enc.coding)
+		break
+	}
+
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if etag, err := h.etags.get(h.files, sent, info); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+	http.ServeContent(w, r, name, info.ModTime(), rs)
+}
+
+// precompressed lists the encodings of pre-compressed siblings, in
+// order of preference.
+var precompressed = []struct {
+	coding string
+	ext    string
+}{
+	{coding: "br", ext: ".br"},
+	{coding: "gzip", ext: ".gz"},
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header value
+// accepts the given content coding, explicitly or through "*".
+func acceptsEncoding(header, coding string) bool {
+	accepted := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+		if !strings.EqualFold(name, coding) && name != "*" {
+			continue
+		}
+		ok := true
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			v, err := strconv.ParseFloat(q, 64)
+			ok = err == nil && v > 0
+		}
+		if strings.EqualFold(name, coding) {
+			// An explicit entry overrides the wildcard.
+			return ok
+		}
+		accepted = ok
+	}
+	return accepted
+}
+
+// open opens the named regular file.
+func (h *StaticHandler) open(name string) (fs.File, fs.FileInfo, error) {
+	f, err := h.files.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, nil, fs.ErrNotExist
+	}
+	return f, info, nil
+}
+
+// etagCache caches content-hash ETags of files, computed lazily and
+// recomputed when a file's modification time or size changes.
+type etagCache struct {
+	mu      sync.RWMutex
+	entries map[string]etagEntry
+}
+
+type etagEntry struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+func newETagCache() *etagCache {
+	return &etagCache{entries: make(map[string]etagEntry)}
+}
+
+// get returns the ETag of the named file, whose current info is given.
+func (c *etagCache) get(files fs.FS, name string, info fs.FileInfo) (string, error) {
+	c.mu.RLock()
+	e, ok := c.entries[name]
+	c.mu.RUnlock()
+	if ok && e.modTime.Equal(info.ModTime()) && e.size == info.Size() {
+		return e.etag, nil
+	}
+
+	f, err := files.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+
+	c.mu.Lock()
+	c.entries[name] = etagEntry{modTime: info.ModTime(), size: info.Size(), etag: etag}
+	c.mu.Unlock()
+	return etag, nil
+}
+
+// NewStaticDirCheck builds the preflight check that Config.Static.Dir
+// is a directory, if set.
+func NewStaticDirCheck(cfg *Config) *PreflightFunc {
+	dir := cfg.Static.Dir
+	return &PreflightFunc{CheckName: "static-dir", Fn: func(context.Context) error {
+		if dir == "" {
+			return nil
+		}
+		info, err := os.Stat(dir)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return preflightFailure(err, "create the directory, or set static.dir to the directory of the files to serve")
+		case err != nil:
+			return preflightFailure(err, "make the directory readable by the user running the app")
+		case !info.IsDir():
+			return preflightFailure(fmt.Errorf("%s is not a directory", dir), "set static.dir to the directory of the files to serve")
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newStaticTest builds a StaticHandler for a directory holding the given
+// files, returning both.
+func newStaticTest(t *testing.T, files map[string]string) (*StaticHandler, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &Config{}
+	cfg.Static.Dir = dir
+	return NewStaticHandler(cfg), dir
+}
+
+func getStatic(h http.Handler, path string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestStaticRange(t *testing.T) {
+	h, _ := newStaticTest(t, map[string]string{"digits.txt": "0123456789"})
+
+	rec := getStatic(h, "/static/digits.txt", "Range", "bytes=2-5")
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "2345" {
+		t.Errorf("range: got %d %q, want 206 %q", rec.Code, rec.Body, "2345")
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes 2-5/10" {
+		t.Errorf("Content-Range = %q, want %q", got, "bytes 2-5/10")
+	}
+
+	rec = getStatic(h, "/static/digits.txt", "Range", "bytes=20-30")
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("unsatisfiable range: got %d, want 416", rec.Code)
+	}
+}
+
+func TestStaticETag(t *testing.T) {
+	h, dir := newStaticTest(t, map[string]string{"app.js": "console.log(1)"})
+
+	rec := getStatic(h, "/static/app.js")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got %d with ETag %q, want 200 with an ETag", rec.Code, etag)
+	}
+	if rec := getStatic(h, "/static/app.js", "If-None-Match", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("matching If-None-Match: got %d, want 304", rec.Code)
+	}
+
+	// Changing the content changes the ETag, and a stale one no longer
+	// matches.
+	path := filepath.Join(dir, "app.js")
+	if err := os.WriteFile(path, []byte("console.log(2)"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	rec = getStatic(h, "/static/app.js", "If-None-Match", etag)
+	if rec.Code != http.StatusOK || rec.Body.String() != "console.log(2)" {
+		t.Errorf("stale If-None-Match: got %d %q, want 200 with the new content", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("ETag"); got == etag || got == "" {
+		t.Errorf("ETag after a change = %q, want a new one", got)
+	}
+}
+
+func TestStaticNotFound(t *testing.T) {
+	h, _ := newStaticTest(t, map[string]string{"index.html": "<h1>app</h1>"})
+	for _, path := range []string{"/static/missing.js", "/static/../static_test.go"} {
+		if rec := getStatic(h, path); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: got %d, want 404", path, rec.Code)
+		}
+	}
+
+	h.spa = true
+	if rec := getStatic(h, "/static/some/client/route"); rec.Code != http.StatusOK || rec.Body.String() != "<h1>app</h1>" {
+		t.Errorf("SPA fallback: got %d %q, want the index page", rec.Code, rec.Body)
+	}
+}
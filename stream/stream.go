@@ -0,0 +1,146 @@
+// Package stream writes responses as a stream of chunks, flushing them
+// to the client as they're written and stopping as soon as the client
+// goes away.
+//
+//	sw := stream.New(w, r, stream.Options{FlushInterval: 100 * time.Millisecond})
+//	for chunk := range chunks {
+//		if err := sw.WriteChunk(chunk); err != nil {
+//			return err
+//		}
+//	}
+//	return sw.Flush()
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ErrDisconnected is reported, wrapping the cause, by writes made once
+// the client has gone away or the request's context is otherwise done.
+var ErrDisconnected = errors.New("client disconnected")
+
+// Options configure a Writer.
+type Options struct {
+	// FlushInterval is how long written chunks may be held before
+	// they're flushed; zero flushes after every chunk.
+	FlushInterval time.Duration
+	// Log is told, once, if the response can't be flushed. It defaults
+	// to slog.Default().
+	Log *slog.Logger
+	// Now returns the current time; it defaults to time.Now.
+	Now func() time.Time
+}
+
+// Writer writes a response as a stream of chunks. It's an io.Writer, so
+// that it can be the destination of io.Copy, each write being a chunk.
+// If the underlying ResponseWriter can't be flushed, chunks are left
+// to its buffering, and the Writer warns once.
+type Writer struct {
+	w        http.ResponseWriter
+	rc       *http.ResponseController
+	ctx      context.Context
+	interval time.Duration
+	log      *slog.Logger
+	now      func() time.Time
+
+	lastFlush time.Time
+	pending   bool
+	noFlush   bool
+	bytes     int64
+	chunks    int64
+}
+
+// New returns a Writer streaming the response to r through w.
+func New(w http.ResponseWriter, r *http.Request, opts Options) *Writer {
+	sw := &Writer{
+		w:        w,
+		rc:       http.NewResponseController(w),
+		ctx:      r.Context(),
+		interval: opts.FlushInterval,
+		log:      opts.Log,
+		now:      opts.Now,
+	}
+	if sw.log == nil {
+		sw.log = slog.Default()
+	}
+	if sw.now == nil {
+		sw.now = time.Now
+	}
+	sw.lastFlush = sw.now()
+	return sw
+}
+
+// WriteChunk writes p, flushing it and any chunks held before it if
+// the flush interval has elapsed since the last flush.
+func (sw *Writer) WriteChunk(p []byte) error {
+	if err := sw.disconnected(); err != nil {
+		return err
+	}
+	n, err := sw.w.Write(p)
+	sw.bytes += int64(n)
+	sw.chunks++
+	if err != nil {
+		if derr := sw.disconnected(); derr != nil {
+			return derr
+		}
+		return err
+	}
+	sw.pending = true
+	if sw.now().Sub(sw.lastFlush) >= sw.interval {
+		return sw.Flush()
+	}
+	return nil
+}
+
+// Write writes p as a chunk.
+func (sw *Writer) Write(p []byte) (int, error) {
+	if err := sw.WriteChunk(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush sends the chunks written so far to the client.
+func (sw *Writer) Flush() error {
+	if !sw.pending || sw.noFlush {
+		return nil
+	}
+	sw.pending = false
+	sw.lastFlush = sw.now()
+	err := sw.rc.Flush()
+	if errors.Is(err, http.ErrNotSupported) {
+		sw.noFlush = true
+		sw.log.Warn("Response can't be flushed, leaving it buffered", slog.String("writer", fmt.Sprintf("%T", sw.w)))
+		return nil
+	}
+	if err != nil {
+		if derr := sw.disconnected(); derr != nil {
+			return derr
+		}
+	}
+	return err
+}
+
+// Bytes returns the number of bytes written.
+func (sw *Writer) Bytes() int64 {
+	return sw.bytes
+}
+
+// Chunks returns the number of chunks written.
+func (sw *Writer) Chunks() int64 {
+	return sw.chunks
+}
+
+// disconnected returns an error wrapping ErrDisconnected if the
+// request's context is done.
+func (sw *Writer) disconnected() error {
+	if sw.ctx.Err() == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrDisconnected, context.Cause(sw.ctx))
+}
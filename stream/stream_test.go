@@ -0,0 +1,149 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/uberfx/testsupport"
+)
+
+// flushRecorder is a ResponseRecorder counting its flushes.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushes++
+	r.ResponseRecorder.Flush()
+}
+
+func TestFlushInterval(t *testing.T) {
+	clk := testsupport.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	sw := New(rec, httptest.NewRequest(http.MethodGet, "/", nil), Options{FlushInterval: 100 * time.Millisecond, Now: clk.Now})
+
+	for _, step := range []struct {
+		advance time.Duration
+		flushes int
+	}{
+		// Chunks are held until the interval has elapsed.
+		{0, 0},
+		{50 * time.Millisecond, 0},
+		{50 * time.Millisecond, 1},
+		// The interval runs from the last flush.
+		{99 * time.Millisecond, 1},
+		{time.Millisecond, 2},
+	} {
+		clk.Advance(step.advance)
+		if err := sw.WriteChunk([]byte("chunk\n")); err != nil {
+			t.Fatal(err)
+		}
+		if rec.flushes != step.flushes {
+			t.Fatalf("at %s: got %d flushes, want %d", clk.Now().Format(time.StampMilli), rec.flushes, step.flushes)
+		}
+	}
+	// Flush sends the chunks held, and nothing once they're sent.
+	if err := sw.WriteChunk([]byte("chunk\n")); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := sw.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rec.flushes != 3 || sw.Chunks() != 6 || sw.Bytes() != 36 || rec.Body.Len() != 36 {
+		t.Errorf("got %d flushes, %d chunks and %d bytes, want 3, 6 and 36", rec.flushes, sw.Chunks(), sw.Bytes())
+	}
+}
+
+func TestFlushEveryChunk(t *testing.T) {
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	sw := New(rec, httptest.NewRequest(http.MethodGet, "/", nil), Options{})
+	if _, err := io.Copy(sw, strings.NewReader("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sw.Write([]byte("def")); err != nil {
+		t.Fatal(err)
+	}
+	if rec.flushes != 2 || rec.Body.String() != "abcdef" {
+		t.Errorf("got %d flushes of %q, want 2 of abcdef", rec.flushes, rec.Body)
+	}
+}
+
+// failingWriter is a ResponseWriter whose writes fail after calling
+// fail.
+type failingWriter struct {
+	http.ResponseWriter
+	fail func()
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	w.fail()
+	return 0, errors.New("broken pipe")
+}
+
+func TestDisconnected(t *testing.T) {
+	cause := errors.New("client went away")
+	t.Run("before write", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		rec := httptest.NewRecorder()
+		sw := New(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx), Options{})
+		if err := sw.WriteChunk([]byte("one")); err != nil {
+			t.Fatal(err)
+		}
+		cancel(cause)
+		err := sw.WriteChunk([]byte("two"))
+		if !errors.Is(err, ErrDisconnected) || !errors.Is(err, cause) {
+			t.Errorf("got %v, want ErrDisconnected wrapping the cause", err)
+		}
+		if rec.Body.String() != "one" || sw.Chunks() != 1 {
+			t.Errorf("got %q in %d chunks, want the chunk before the disconnect only", rec.Body, sw.Chunks())
+		}
+	})
+	t.Run("failed write", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		w := failingWriter{httptest.NewRecorder(), func() { cancel(cause) }}
+		sw := New(w, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx), Options{})
+		if err := sw.WriteChunk([]byte("one")); !errors.Is(err, ErrDisconnected) || !errors.Is(err, cause) {
+			t.Errorf("got %v, want ErrDisconnected wrapping the cause", err)
+		}
+	})
+	t.Run("failed write while connected", func(t *testing.T) {
+		w := failingWriter{httptest.NewRecorder(), func() {}}
+		sw := New(w, httptest.NewRequest(http.MethodGet, "/", nil), Options{})
+		if err := sw.WriteChunk([]byte("one")); err == nil || errors.Is(err, ErrDisconnected) {
+			t.Errorf("got %v, want the write error", err)
+		}
+	})
+}
+
+func TestNoFlusher(t *testing.T) {
+	var logs bytes.Buffer
+	rec := httptest.NewRecorder()
+	// The struct hides the recorder's Flush method.
+	w := struct{ http.ResponseWriter }{rec}
+	sw := New(w, httptest.NewRequest(http.MethodGet, "/", nil), Options{Log: slog.New(slog.NewTextHandler(&logs, nil))})
+	for range 3 {
+		if err := sw.WriteChunk([]byte("chunk\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(logs.String(), "Response can't be flushed"); n != 1 {
+		t.Errorf("got %d warnings, want 1:\n%s", n, logs.String())
+	}
+	if rec.Flushed || rec.Body.Len() != 18 {
+		t.Errorf("got flushed %v with %d bytes, want the 18 bytes left buffered", rec.Flushed, rec.Body.Len())
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.uber.org/fx"
+)
+
+// SubRouter is a Route that serves a whole subtree of paths from its
+// own router, behind its own middleware chain. Its routes are wrapped
+// with the route middleware, each under its own pattern, and listed in
+// the RouteTable under their full path; the sub-router itself isn't
+// wrapped, so that the route middleware applies to each request once.
+type SubRouter struct {
+	prefix  string
+	handler http.Handler
+}
+
+// SubRouterOption configures a SubRouter.
+type SubRouterOption func(*subRouterOptions)
+
+type subRouterOptions struct {
+	middleware []Middleware
+	keepPaths  bool
+}
+
+// WithSubRouterMiddleware adds middleware that applies only to routes
+// mounted in the sub-router.
+func WithSubRouterMiddleware(mws ...Middleware) SubRouterOption {
+	return func(o *subRouterOptions) {
+		o.middleware = append(o.middleware, mws...)
+	}
+}
+
+// KeepAbsolutePaths stops the sub-router from stripping its prefix, so
+// inner routes must include the mount point in their patterns and see
+// the original request path.
+func KeepAbsolutePaths() SubRouterOption {
+	return func(o *subRouterOptions) {
+		o.keepPaths = true
+	}
+}
+
+// NewSubRouter builds a SubRouter mounted at prefix that routes requests
+// to the given routes, wrapped with mws, using the router backend
+// selected in the config. Unless KeepAbsolutePaths is used, inner routes
+// see paths relative to the mount point, so a route with pattern "/get"
+// in a sub-router mounted at "/kv/" serves "/kv/get"; the route
+// middleware sees the patterns as the routes declare them. Two routes
+// with the same pattern are reported as an error.
+func NewSubRouter(cfg *Config, table *RouteTable, prefix string, routes []Route, mws []RouteMiddleware, opts ...SubRouterOption) (*SubRouter, error) {
+	var o subRouterOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	mux, err := NewRouter(cfg.Server.Router)
+	if err != nil {
+		return nil, err
+	}
+	rec := newRecordingRouter(mux, sortByOrder(mws), table)
+	if !o.keepPaths {
+		rec.mount = strings.TrimSuffix(prefix, "/")
+	}
+	for _, route := range routes {
+		rec.source = fmt.Sprintf("route %T in sub-router %q", route, prefix)
+		method, pattern := splitPattern(route.Pattern())
+		rec.Handle(method, pattern, route)
+	}
+	if rec.err != nil {
+		return nil, rec.err
+	}
+
+	var h http.Handler = mux
+	if !o.keepPaths {
+		h = http.StripPrefix(strings.TrimSuffix(prefix, "/"), h)
+	}
+	return &SubRouter{
+		prefix:  prefix,
+		handler: Chain(h, sortByOrder(o.middleware)...),
+	}, nil
+}
+
+// Pattern returns the subtree pattern the sub-router is mounted at.
+func (s *SubRouter) Pattern() string {
+	return s.prefix
+}
+
+func (s *SubRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// AsSubRouter builds a constructor for a SubRouter mounted at prefix and
+// provides it to the "routes" group. The sub-router is fed by the given
+// group of routes, and by the group of that name suffixed with
+// "_middleware" for middleware that needs dependencies from the
+// container, ordered like the app's. Use AsSubRoute to add routes to the
+// group.
+//
+//	fx.Provide(
+//		AsSubRouter("/kv/", "kv_routes"),
+//		AsSubRoute("kv_routes", NewGetHandler),
+//	)
+func AsSubRouter(prefix, group string, opts ...SubRouterOption) any {
+	return AsGroupMember[Route](
+		"routes",
+		func(cfg *Config, table *RouteTable, routes []Route, routeMws []RouteMiddleware, mws []Middleware) (*SubRouter, error) {
+			return NewSubRouter(cfg, table, prefix, routes, routeMws, append(opts, WithSubRouterMiddleware(mws...))...)
+		},
+		fx.ParamTags("", "", `group:"`+group+`"`, `group:"route_middleware"`, `group:"`+group+`_middleware"`),
+	)
+}
+
+// AsSubRoute annotates the given constructor to state that it provides
+// a route to the given sub-router group.
+func AsSubRoute(group string, f any) any {
+	return AsGroupMember[Route](group, f)
+}
+
+// AsSubRouterMiddleware annotates the given constructor to state that it
+// provides middleware to the sub-router fed by the given group.
+func AsSubRouterMiddleware(group string, f any) any {
+	return AsGroupMember[Middleware](group+"_middleware", f)
+}
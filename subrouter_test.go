@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.uber.org/fx"
+)
+
+// headerMiddleware is middleware setting a response header.
+type headerMiddleware struct{ name string }
+
+func (m headerMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Middleware", m.name)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// pathRoute is a route answering with the path it sees.
+func pathRoute(pattern string) func() Route {
+	return func() Route {
+		return newFuncRoute(pattern, "", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, r.URL.Path)
+		})
+	}
+}
+
+func TestSubRouter(t *testing.T) {
+	for _, router := range []string{"servemux", "chi"} {
+		t.Run(router, func(t *testing.T) {
+			base := startTestApp(t, func(cfg *Config) {
+				withTokens(cfg)
+				cfg.Server.Router = router
+				cfg.Auth.RouteRoles = map[string][]string{"/private": {"admin"}}
+			}, fx.Provide(
+				AsSubRouter("/sub/", "sub_routes"),
+				AsSubRoute("sub_routes", pathRoute("GET /a")),
+				AsSubRoute("sub_routes", pathRoute("/private")),
+				AsSubRouterMiddleware("sub_routes", func() Middleware { return headerMiddleware{"sub"} }),
+				AsSubRouter("/abs/", "abs_routes", KeepAbsolutePaths()),
+				AsSubRoute("abs_routes", pathRoute("GET /abs/b")),
+			))
+			get := func(path, token string) (int, string, string) {
+				req, _ := http.NewRequest(http.MethodGet, base+path, nil)
+				if token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+				b, _ := io.ReadAll(resp.Body)
+				return resp.StatusCode, string(b), resp.Header.Get("X-Middleware")
+			}
+
+			if status, body, mw := get("/sub/a", ""); status != http.StatusOK || body != "/a" || mw != "sub" {
+				t.Errorf("/sub/a: got %d %q middleware %q, want 200 /a sub", status, body, mw)
+			}
+			if status, body, _ := get("/abs/b", ""); status != http.StatusOK || body != "/abs/b" {
+				t.Errorf("/abs/b: got %d %q, want 200 /abs/b", status, body)
+			}
+			if _, _, mw := get("/ping", ""); mw != "" {
+				t.Errorf("/ping: sub-router middleware leaked, got %q", mw)
+			}
+			// The route middleware applies to the inner routes.
+			if status, _, _ := get("/sub/private", ""); status != http.StatusUnauthorized {
+				t.Errorf("/sub/private: got %d, want 401", status)
+			}
+			if status, body, _ := get("/sub/private", "admin-token"); status != http.StatusOK || body != "/private" {
+				t.Errorf("/sub/private as admin: got %d %q, want 200 /private", status, body)
+			}
+
+			status, body, _ := get("/admin/routes", "admin-token")
+			for _, pattern := range []string{`"GET /sub/a"`, `"/sub/private"`, `"GET /abs/b"`} {
+				if status != http.StatusOK || !strings.Contains(body, pattern) {
+					t.Errorf("route table: got %d %s, want %s listed", status, body, pattern)
+				}
+			}
+		})
+	}
+}
+
+func TestSubRouterConflict(t *testing.T) {
+	app := NewApp(fx.Provide(
+		AsSubRouter("/sub/", "sub_routes"),
+		AsSubRoute("sub_routes", pathRoute("GET /a")),
+		AsSubRoute("sub_routes", pathRoute("GET /a")),
+	))
+	if err := app.Err(); err == nil || !strings.Contains(err.Error(), "conflicts") {
+		t.Errorf("got %v, want the conflict reported", err)
+	}
+}
+
+func TestKVModule(t *testing.T) {
+	base := startTestApp(t, nil)
+	if status, _ := do(t, http.MethodPut, base+"/kv/greeting", "", "hello"); status != http.StatusNoContent {
+		t.Fatalf("put: got %d, want 204", status)
+	}
+	if status, body := do(t, http.MethodGet, base+"/kv/greeting", "", ""); status != http.StatusOK || body != "hello" {
+		t.Errorf("get: got %d %q, want 200 hello", status, body)
+	}
+	if status, _ := do(t, http.MethodGet, base+"/kv/missing", "", ""); status != http.StatusNotFound {
+		t.Errorf("get missing: got %d, want 404", status)
+	}
+}
@@ -0,0 +1,209 @@
+package testsupport
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/uberfx/clock"
+)
+
+// FakeClock is a clock.Clock whose time only passes when told to, with
+// Advance, firing the timers and tickers due on the way in order, as
+// they would have fired in real time. Supply it to the app in place of
+// the real clock:
+//
+//	clk := testsupport.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+//	app := NewApp(fx.Decorate(func(clock.Clock) clock.Clock { return clk }))
+//	...
+//	clk.BlockUntil(1) // the component is waiting on its timer
+//	clk.Advance(time.Minute)
+//
+// Timers made with AfterFunc run their function synchronously, within
+// Advance, rather than in a goroutine of their own.
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond // signaled when waiters change
+	now     time.Time
+	waiters []*fakeWaiter
+	seq     int
+}
+
+// fakeWaiter is a timer or ticker of a FakeClock.
+type fakeWaiter struct {
+	clock  *FakeClock
+	at     time.Time
+	period time.Duration
+	seq    int
+	c      chan time.Time
+	f      func()
+}
+
+// NewFakeClock returns a FakeClock set to start.
+func NewFakeClock(start time.Time) *FakeClock {
+	c := &FakeClock{now: start}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) clock.Timer {
+	w := &fakeWaiter{clock: c, c: make(chan time.Time, 1)}
+	c.schedule(w, d)
+	return (*fakeTimer)(w)
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("testsupport: non-positive interval for NewTicker")
+	}
+	w := &fakeWaiter{clock: c, c: make(chan time.Time, 1), period: d}
+	c.schedule(w, d)
+	return (*fakeTicker)(w)
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) clock.Timer {
+	w := &fakeWaiter{clock: c, f: f}
+	c.schedule(w, d)
+	return (*fakeTimer)(w)
+}
+
+func (c *FakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := c.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Advance moves the clock d forward, firing the timers and tickers due
+// by then, earliest first; tickers fire once per period passed.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	for {
+		w := c.next(target)
+		if w == nil {
+			break
+		}
+		c.now = w.at
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			c.remove(w)
+		}
+		now := c.now
+		if w.f != nil {
+			c.mu.Unlock()
+			w.f()
+			c.mu.Lock()
+			continue
+		}
+		select {
+		case w.c <- now:
+		default:
+			// Like a real ticker, drop the tick the receiver is behind.
+		}
+	}
+	c.now = target
+	c.mu.Unlock()
+}
+
+// Pending returns how many timers and tickers are waiting to fire.
+func (c *FakeClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// BlockUntil waits until at least n timers and tickers are waiting to
+// fire, as to know that the goroutine of a component is waiting on its
+// own before advancing the clock.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// schedule (re)schedules w to fire in d.
+func (c *FakeClock) schedule(w *fakeWaiter, d time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	active := c.remove(w)
+	c.seq++
+	w.at, w.seq = c.now.Add(d), c.seq
+	c.waiters = append(c.waiters, w)
+	c.cond.Broadcast()
+	return active
+}
+
+// next returns the earliest waiter due by target, the first scheduled
+// among those due at once, or nil if there's none.
+func (c *FakeClock) next(target time.Time) *fakeWaiter {
+	sort.Slice(c.waiters, func(i, j int) bool {
+		a, b := c.waiters[i], c.waiters[j]
+		return a.at.Before(b.at) || a.at.Equal(b.at) && a.seq < b.seq
+	})
+	if len(c.waiters) == 0 || c.waiters[0].at.After(target) {
+		return nil
+	}
+	return c.waiters[0]
+}
+
+// remove removes w from the waiters, reporting whether it was one.
+func (c *FakeClock) remove(w *fakeWaiter) bool {
+	for i, x := range c.waiters {
+		if x == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			c.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer fakeWaiter
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.remove((*fakeWaiter)(t))
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	return t.clock.schedule((*fakeWaiter)(t), d)
+}
+
+type fakeTicker fakeWaiter
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove((*fakeWaiter)(t))
+}
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	t.period = d
+	t.clock.schedule((*fakeWaiter)(t), d)
+}
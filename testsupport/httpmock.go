@@ -0,0 +1,178 @@
+// Package testsupport helps tests of the app. HTTPMock answers the
+// outbound requests of the shared client with scripted responses, in
+// place of the upstreams, when supplied to the app as its
+// TransportOverride:
+//
+//	mock := testsupport.NewHTTPMock()
+//	mock.On("GET http://upstream/hello").
+//		Respond(http.StatusServiceUnavailable, "").
+//		Respond(http.StatusOK, "Hello, ann").
+//		Times(2)
+//	app := NewApp(fx.Supply(fx.Annotate(mock, fx.As(new(TransportOverride)))))
+//	...
+//	mock.Verify(t)
+package testsupport
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// HTTPMock is an http.RoundTripper answering requests with the
+// responses scripted for the first route, added with On, matching them.
+// Requests matching no route fail, and are reported by Verify.
+type HTTPMock struct {
+	mu        sync.Mutex
+	routes    []*MockRoute
+	unmatched []string
+}
+
+// NewHTTPMock builds a new HTTPMock without routes.
+func NewHTTPMock() *HTTPMock {
+	return &HTTPMock{}
+}
+
+// On adds a route for the requests matching pattern: a URL, optionally
+// preceded by a method and a space, such as "GET http://upstream/hello".
+// The URL is matched without its query with path.Match, so its "*"
+// match any part of a host or of a path segment.
+func (m *HTTPMock) On(pattern string) *MockRoute {
+	r := &MockRoute{mock: m, pattern: pattern, times: -1}
+	r.method, r.url, _ = strings.Cut(pattern, " ")
+	if r.url == "" {
+		r.method, r.url = "", pattern
+	}
+	m.mu.Lock()
+	m.routes = append(m.routes, r)
+	m.mu.Unlock()
+	return r
+}
+
+func (m *HTTPMock) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	u := *req.URL
+	u.RawQuery, u.Fragment = "", ""
+	target := u.String()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.routes {
+		if r.match(req.Method, target) {
+			return r.respond(req)
+		}
+	}
+	m.unmatched = append(m.unmatched, req.Method+" "+target)
+	return nil, fmt.Errorf("httpmock: no route for %s %s", req.Method, target)
+}
+
+// Verify fails tb if requests matched no route, or if routes expected
+// to be called a number of times, see MockRoute.Times, weren't.
+func (m *HTTPMock) Verify(tb testing.TB) {
+	tb.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, req := range m.unmatched {
+		tb.Errorf("httpmock: unexpected request %s", req)
+	}
+	for _, r := range m.routes {
+		if r.times >= 0 && r.calls != r.times {
+			tb.Errorf("httpmock: %s called %d times, want %d", r.pattern, r.calls, r.times)
+		}
+	}
+}
+
+// MockRoute is a route of an HTTPMock, answering the requests matching
+// it with its scripted responses in turn, repeating the last one once
+// they're used up.
+type MockRoute struct {
+	mock      *HTTPMock
+	pattern   string
+	method    string
+	url       string
+	responses []mockResponse
+	times     int
+	calls     int
+}
+
+// mockResponse is a scripted response, or error.
+type mockResponse struct {
+	status int
+	header http.Header
+	body   string
+	err    error
+}
+
+// Respond scripts a response with the given status and body.
+func (r *MockRoute) Respond(status int, body string) *MockRoute {
+	return r.RespondHeader(status, nil, body)
+}
+
+// RespondHeader scripts a response with the given status, headers and
+// body.
+func (r *MockRoute) RespondHeader(status int, header http.Header, body string) *MockRoute {
+	r.responses = append(r.responses, mockResponse{status: status, header: header, body: body})
+	return r
+}
+
+// Fail scripts the failure of the request with err, as though the
+// upstream couldn't be reached.
+func (r *MockRoute) Fail(err error) *MockRoute {
+	r.responses = append(r.responses, mockResponse{err: err})
+	return r
+}
+
+// Times sets how many times the route is expected to be called, see
+// HTTPMock.Verify.
+func (r *MockRoute) Times(n int) *MockRoute {
+	r.times = n
+	return r
+}
+
+// Calls returns how many requests the route has answered.
+func (r *MockRoute) Calls() int {
+	r.mock.mu.Lock()
+	defer r.mock.mu.Unlock()
+	return r.calls
+}
+
+func (r *MockRoute) match(method, target string) bool {
+	if r.method != "" && r.method != method {
+		return false
+	}
+	ok, _ := path.Match(r.url, target)
+	return ok
+}
+
+func (r *MockRoute) respond(req *http.Request) (*http.Response, error) {
+	r.calls++
+	if len(r.responses) == 0 {
+		return nil, fmt.Errorf("httpmock: no response scripted for %s", r.pattern)
+	}
+	s := r.responses[min(r.calls, len(r.responses))-1]
+	if s.err != nil {
+		return nil, s.err
+	}
+	header := s.header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", s.status, http.StatusText(s.status)),
+		StatusCode:    s.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(s.body)),
+		ContentLength: int64(len(s.body)),
+		Request:       req,
+	}, nil
+}
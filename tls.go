@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"example.com/uberfx/httpjson"
+	"example.com/uberfx/reqctx"
+)
+
+// ServerTLS is the TLS setup of the server, from Config.Server.TLS: the
+// certificate chains it serves and, for mutual TLS, the CAs client
+// certificates are verified against. TLS is off unless certificates are
+// configured. Certificates expiring within
+// Config.Server.TLS.ExpiryWarning are logged at startup and flagged at
+// /debug/tls.
+type ServerTLS struct {
+	config *tls.Config
+	chains [][]*x509.Certificate
+	warn   time.Duration
+	now    func() time.Time
+}
+
+// NewServerTLS loads the certificates and client CAs.
+func NewServerTLS(cfg *Config, log *slog.Logger) (*ServerTLS, error) {
+	c := cfg.Server.TLS
+	t := &ServerTLS{warn: time.Duration(c.ExpiryWarning), now: time.Now}
+	if t.warn <= 0 {
+		t.warn = 30 * 24 * time.Hour
+	}
+	if len(c.Certificates) == 0 {
+		if c.ClientCAFile != "" {
+			return nil, errors.New("tls: client CAs set without certificates")
+		}
+		return t, nil
+	}
+	t.config = &tls.Config{MinVersion: tls.VersionTLS12}
+	for _, cc := range c.Certificates {
+		pair, err := tls.LoadX509KeyPair(cc.CertFile, cc.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: load %s: %w", cc.CertFile, err)
+		}
+		chain := make([]*x509.Certificate, 0, len(pair.Certificate))
+		for _, der := range pair.Certificate {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("tls: parse %s: %w", cc.CertFile, err)
+			}
+			chain = append(chain, cert)
+		}
+		pair.Leaf = chain[0]
+		t.config.Certificates = append(t.config.Certificates, pair)
+		t.chains = append(t.chains, chain)
+	}
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: read client CAs: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificates in %s", c.ClientCAFile)
+		}
+		t.config.ClientCAs = pool
+		t.config.ClientAuth = tls.VerifyClientCertIfGiven
+		if c.RequireClientCert {
+			t.config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	for _, info := range t.Certificates() {
+		if info.ExpiringSoon {
+			log.Warn("TLS certificate expiring soon",
+				slog.String("subject", info.Subject),
+				slog.Time("not_after", info.NotAfter))
+		}
+	}
+	return t, nil
+}
+
+// Enabled reports whether the server serves TLS.
+func (t *ServerTLS) Enabled() bool {
+	return t.config != nil
+}
+
+// Config returns the server's TLS config, or nil if TLS is off.
+func (t *ServerTLS) Config() *tls.Config {
+	return t.config
+}
+
+// CertificateInfo describes a certificate of a chain the server serves.
+type CertificateInfo struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	// ExpiringSoon is set for certificates expiring within the warning
+	// window, or expired.
+	ExpiringSoon bool `json:"expiring_soon"`
+	// Chain describes the rest of the chain, for leaf certificates.
+	Chain []CertificateInfo `json:"chain,omitempty"`
+}
+
+// Certificates describes the chains the server serves, by leaf.
+func (t *ServerTLS) Certificates() []CertificateInfo {
+	now := t.now()
+	describe := func(c *x509.Certificate) CertificateInfo {
+		return CertificateInfo{
+			Subject:      c.Subject.String(),
+			Issuer:       c.Issuer.String(),
+			DNSNames:     c.DNSNames,
+			NotBefore:    c.NotBefore,
+			NotAfter:     c.NotAfter,
+			ExpiringSoon: c.NotAfter.Sub(now) < t.warn,
+		}
+	}
+	infos := make([]CertificateInfo, 0, len(t.chains))
+	for _, chain := range t.chains {
+		leaf := describe(chain[0])
+		for _, c := range chain[1:] {
+			leaf.Chain = append(leaf.Chain, describe(c))
+		}
+		infos = append(infos, leaf)
+	}
+	return infos
+}
+
+// TLSContext is middleware that makes the TLS parameters of each
+// request's connection available through reqctx.TLS, and so in its log
+// records. It's only installed when the server serves TLS.
+type TLSContext struct {
+	enabled bool
+}
+
+// NewTLSContext builds a new TLSContext.
+func NewTLSContext(t *ServerTLS) *TLSContext {
+	return &TLSContext{enabled: t.Enabled()}
+}
+
+func (*TLSContext) Order() int {
+	return orderTLSContext
+}
+
+func (m *TLSContext) Wrap(next http.Handler) http.Handler {
+	if !m.enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		info := reqctx.TLSInfo{
+			Version:     tls.VersionName(r.TLS.Version),
+			CipherSuite: tls.CipherSuiteName(r.TLS.CipherSuite),
+			ServerName:  r.TLS.ServerName,
+		}
+		if len(r.TLS.PeerCertificates) > 0 {
+			info.ClientSubject = r.TLS.PeerCertificates[0].Subject.String()
+		}
+		next.ServeHTTP(w, r.WithContext(reqctx.WithTLS(r.Context(), info)))
+	})
+}
+
+// TLSDebugHandler reports at GET /debug/tls whether the server serves
+// TLS and the certificate chains it serves, flagging those expiring
+// soon.
+type TLSDebugHandler struct {
+	tls *ServerTLS
+}
+
+// NewTLSDebugHandler builds a new TLSDebugHandler.
+func NewTLSDebugHandler(t *ServerTLS) *TLSDebugHandler {
+	return &TLSDebugHandler{tls: t}
+}
+
+func (*TLSDebugHandler) Pattern() string {
+	return "GET /debug/tls"
+}
+
+func (h *TLSDebugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	_ = httpjson.Respond(w, r, http.StatusOK, struct {
+		Enabled       bool              `json:"enabled"`
+		ExpiryWarning string            `json:"expiry_warning"`
+		Certificates  []CertificateInfo `json:"certificates"`
+	}{h.tls.Enabled(), h.tls.warn.String(), h.tls.Certificates()})
+}
+
+// NewTLSFilesCheck builds the preflight check that the certificates and
+// client CAs of Config.Server.TLS can be loaded.
+func NewTLSFilesCheck(cfg *Config) *PreflightFunc {
+	c := cfg.Server.TLS
+	return &PreflightFunc{CheckName: "tls-files", Fn: func(context.Context) error {
+		var errs []error
+		for i, cc := range c.Certificates {
+			if _, err := tls.LoadX509KeyPair(cc.CertFile, cc.KeyFile); err != nil {
+				errs = append(errs, preflightFailure(fmt.Errorf("certificate %s: %w", cc.CertFile, err),
+					fmt.Sprintf("set server.tls.certificates[%d] to a PEM certificate chain and its key, readable by the user running the app", i)))
+			}
+		}
+		if c.ClientCAFile != "" {
+			pem, err := os.ReadFile(c.ClientCAFile)
+			if err == nil && !x509.NewCertPool().AppendCertsFromPEM(pem) {
+				err = fmt.Errorf("no certificates in %s", c.ClientCAFile)
+			}
+			if err != nil {
+				errs = append(errs, preflightFailure(fmt.Errorf("client CAs: %w", err),
+					"set server.tls.client_ca_file to a PEM file of CA certificates, readable by the user running the app"))
+			}
+		}
+		return errors.Join(errs...)
+	}}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Transformer is a named transformation of a body, applied by the echo
+// endpoint with ?transform=name1,name2.
+type Transformer interface {
+	Name() string
+	// Streaming reports whether Apply transforms the body as it's read.
+	// Other transformers read the whole body first, which is capped.
+	Streaming() bool
+	Apply(r io.Reader) io.Reader
+}
+
+// AsTransformer annotates the given constructor to state that it
+// provides a transformer to the "transformers" group.
+func AsTransformer(f any) any {
+	return AsGroupMember[Transformer]("transformers", f)
+}
+
+// Transformers is the registry of the available transformers, by name.
+type Transformers struct {
+	byName map[string]Transformer
+	max    int64
+}
+
+// NewTransformers builds the registry of the given transformers. The
+// bodies read whole by non-streaming transformers are capped at
+// Config.Echo.MaxTransformBytes.
+func NewTransformers(cfg *Config, ts []Transformer) (*Transformers, error) {
+	r := &Transformers{byName: make(map[string]Transformer, len(ts)), max: cfg.Echo.MaxTransformBytes}
+	if r.max <= 0 {
+		r.max = 1 << 20
+	}
+	for _, t := range ts {
+		if _, dup := r.byName[t.Name()]; dup {
+			return nil, fmt.Errorf("transformer %q registered twice", t.Name())
+		}
+		r.byName[t.Name()] = t
+	}
+	return r, nil
+}
+
+// Names returns the names of the available transformers, sorted.
+func (r *Transformers) Names() []string {
+	names := make([]string, 0, len(r.byName))
+	for name := range r.byName {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Pipeline returns a function applying the comma-separated list of
+// transformers in order. All names are checked up front; an unknown one
+// is a 400.
+func (r *Transformers) Pipeline(list string) (func(io.Reader) io.Reader, error) {
+	var pipeline []Transformer
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		t, ok := r.byName[name]
+		if !ok {
+			return nil, NewStatusError(http.StatusBadRequest, fmt.Errorf("unknown transform %q, want one of %s", name, strings.Join(r.Names(), ", ")))
+		}
+		pipeline = append(pipeline, t)
+	}
+	return func(rd io.Reader) io.Reader {
+		for _, t := range pipeline {
+			if !t.Streaming() {
+				rd = &cappedReader{r: rd, n: r.max}
+			}
+			rd = t.Apply(rd)
+		}
+		return rd
+	}, nil
+}
+
+// cappedReader fails with a 413 once more than n bytes are read.
+type cappedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *cappedReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n -= int64(n)
+	if c.n < 0 {
+		return 0, NewStatusError(http.StatusRequestEntityTooLarge, fmt.Errorf("body too large to transform"))
+	}
+	return n, err
+}
+
+// runeMapper is a streaming Transformer mapping each rune of a UTF-8
+// body.
+type runeMapper struct {
+	name    string
+	mapping func(rune) rune
+}
+
+func (t *runeMapper) Name() string {
+	return t.name
+}
+
+func (*runeMapper) Streaming() bool {
+	return true
+}
+
+func (t *runeMapper) Apply(r io.Reader) io.Reader {
+	return &runeMapReader{r: r, mapping: t.mapping}
+}
+
+// runeMapReader maps the runes read through it, holding back runes
+// split across reads until they're complete.
+type runeMapReader struct {
+	r       io.Reader
+	mapping func(rune) rune
+	in      []byte
+	out     []byte
+	err     error
+}
+
+func (m *runeMapReader) Read(b []byte) (int, error) {
+	for len(m.out) == 0 {
+		if m.err != nil {
+			return 0, m.err
+		}
+		buf := make([]byte, 4096)
+		n, err := m.r.Read(buf)
+		m.in = append(m.in, buf[:n]...)
+		m.err = err
+		// A rune split across reads is held back until it's complete,
+		// unless the input has ended.
+		end := len(m.in)
+		if i := lastRuneStart(m.in); err == nil && i < end && !utf8.FullRune(m.in[i:]) {
+			end = i
+		}
+		m.out = append(m.out, []byte(strings.Map(m.mapping, string(m.in[:end])))...)
+		m.in = m.in[end:]
+	}
+	n := copy(b, m.out)
+	m.out = m.out[n:]
+	return n, nil
+}
+
+// lastRuneStart returns the index where the last, possibly incomplete,
+// rune of b starts.
+func lastRuneStart(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			return i
+		}
+	}
+	return len(b)
+}
+
+func rot13(r rune) rune {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return 'a' + (r-'a'+13)%26
+	case r >= 'A' && r <= 'Z':
+		return 'A' + (r-'A'+13)%26
+	}
+	return r
+}
+
+// NewUpperTransformer builds the "upper" transformer.
+func NewUpperTransformer() Transformer {
+	return &runeMapper{name: "upper", mapping: unicode.ToUpper}
+}
+
+// NewLowerTransformer builds the "lower" transformer.
+func NewLowerTransformer() Transformer {
+	return &runeMapper{name: "lower", mapping: unicode.ToLower}
+}
+
+// NewROT13Transformer builds the "rot13" transformer.
+func NewROT13Transformer() Transformer {
+	return &runeMapper{name: "rot13", mapping: rot13}
+}
+
+// Base64Transformer encodes the body in standard base64, streaming.
+type Base64Transformer struct{}
+
+// NewBase64Transformer builds the "base64" transformer.
+func NewBase64Transformer() *Base64Transformer {
+	return &Base64Transformer{}
+}
+
+func (*Base64Transformer) Name() string {
+	return "base64"
+}
+
+func (*Base64Transformer) Streaming() bool {
+	return true
+}
+
+func (*Base64Transformer) Apply(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		enc := base64.NewEncoder(base64.StdEncoding, pw)
+		if _, err := io.Copy(enc, r); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(enc.Close())
+	}()
+	return pr
+}
+
+// ReverseTransformer reverses the runes of the body, or its bytes if
+// it isn't valid UTF-8. It needs the whole body.
+type ReverseTransformer struct{}
+
+// NewReverseTransformer builds the "reverse" transformer.
+func NewReverseTransformer() *ReverseTransformer {
+	return &ReverseTransformer{}
+}
+
+func (*ReverseTransformer) Name() string {
+	return "reverse"
+}
+
+func (*ReverseTransformer) Streaming() bool {
+	return false
+}
+
+func (*ReverseTransformer) Apply(r io.Reader) io.Reader {
+	return &lazyReader{fill: func() ([]byte, error) {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if !utf8.Valid(b) {
+			slices.Reverse(b)
+			return b, nil
+		}
+		runes := []rune(string(b))
+		slices.Reverse(runes)
+		return []byte(string(runes)), nil
+	}}
+}
+
+// lazyReader reads the bytes produced by fill on its first read.
+type lazyReader struct {
+	fill func() ([]byte, error)
+	r    io.Reader
+}
+
+func (l *lazyReader) Read(b []byte) (int, error) {
+	if l.r == nil {
+		data, err := l.fill()
+		if err != nil {
+			return 0, err
+		}
+		l.r = bytes.NewReader(data)
+	}
+	return l.r.Read(b)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.uber.org/fx"
+)
+
+// shoutTransformer is a transformer contributed from outside the
+// built-in set.
+type shoutTransformer struct{}
+
+func (shoutTransformer) Name() string    { return "shout" }
+func (shoutTransformer) Streaming() bool { return true }
+func (shoutTransformer) Apply(r io.Reader) io.Reader {
+	return io.MultiReader(r, strings.NewReader("!"))
+}
+
+func TestEchoTransform(t *testing.T) {
+	base := startTestApp(t, func(cfg *Config) { cfg.Echo.MaxTransformBytes = 16 },
+		fx.Provide(AsTransformer(func() Transformer { return shoutTransformer{} })))
+
+	for _, tc := range []struct {
+		transform string
+		body      string
+		status    int
+		want      string
+	}{
+		{"upper", "héllo", http.StatusOK, "HÉLLO"},
+		{"rot13", "Hello", http.StatusOK, "Uryyb"},
+		{"reverse", "héllo", http.StatusOK, "olléh"},
+		{"upper,reverse,base64", "abc", http.StatusOK, base64.StdEncoding.EncodeToString([]byte("CBA"))},
+		{"lower,shout", "HEY", http.StatusOK, "hey!"},
+		{"upper,nope", "abc", http.StatusBadRequest, ""},
+		// Streaming transformers aren't capped, unlike those reading
+		// the whole body.
+		{"upper", strings.Repeat("a", 32), http.StatusOK, strings.Repeat("A", 32)},
+		{"upper,reverse", strings.Repeat("a", 32), http.StatusRequestEntityTooLarge, ""},
+	} {
+		status, got := do(t, http.MethodPost, base+"/echo?transform="+tc.transform, "", tc.body)
+		if status != tc.status || (status == http.StatusOK && got != tc.want) {
+			t.Errorf("%s of %q: got %d %q, want %d %q", tc.transform, tc.body, status, got, tc.status, tc.want)
+		}
+	}
+}
+
+func TestTransformersRejectDuplicates(t *testing.T) {
+	if _, err := NewTransformers(&Config{}, []Transformer{NewUpperTransformer(), NewUpperTransformer()}); err == nil {
+		t.Error("got no error for two transformers of the same name")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+
+	"example.com/uberfx/httpjson"
+)
+
+const quotaRemainingHeader = "X-Quota-Remaining"
+
+// UploadResult describes an accepted upload: its files, and their
+// combined size.
+type UploadResult struct {
+	Bytes int64      `json:"bytes"`
+	Files []PartInfo `json:"files"`
+}
+
+// UploadHandler is an HTTP handler that accepts files, uploaded as a
+// multipart/form-data form, within the client's daily quota. The upload
+// is checked against the quota before it's read and charged as it
+// streams in, the whole body counting, so one exceeding the quota is
+// aborted with a 413. Each part is streamed through a hash, and capped
+// by Config.Upload.MaxFileBytes; the response describes the files. Every response tells the client how
+// much of its quota remains in the X-Quota-Remaining header.
+type UploadHandler struct {
+	maxFile int64
+	quotas  *QuotaTracker
+	errs    *ErrorWriter
+}
+
+// NewUploadHandler builds a new UploadHandler.
+func NewUploadHandler(cfg *Config, quotas *QuotaTracker, errs *ErrorWriter) *UploadHandler {
+	h := &UploadHandler{maxFile: cfg.Upload.MaxFileBytes, quotas: quotas, errs: errs}
+	if h.maxFile <= 0 {
+		h.maxFile = math.MaxInt64
+	}
+	return h
+}
+
+func (*UploadHandler) Pattern() string {
+	return "POST /upload"
+}
+
+// MaxBodyBytes lifts the body limit: uploads are bounded by the quota.
+func (*UploadHandler) MaxBodyBytes() int64 {
+	return -1
+}
+
+// AcceptedContentTypes limits uploads to forms.
+func (*UploadHandler) AcceptedContentTypes() []string {
+	return []string{"multipart/form-data"}
+}
+
+func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, limit := h.quotas.Client(r)
+	remaining, err := h.quotas.Remaining(r.Context(), key, limit)
+	if err != nil {
+		h.errs.Write(w, r, err)
+		return
+	}
+	w.Header().Set(quotaRemainingHeader, strconv.FormatInt(remaining, 10))
+	if remaining == 0 || r.ContentLength > remaining {
+		h.errs.Write(w, r, ErrQuotaExceeded)
+		return
+	}
+
+	body := &quotaReader{r: r.Body, ctx: r.Context(), tracker: h.quotas, key: key, limit: limit, remaining: remaining}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, r.Body}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		h.errs.Write(w, r, NewStatusError(http.StatusBadRequest, err))
+		return
+	}
+	parts, err := readParts(mr, h.maxFile, math.MaxInt64)
+	w.Header().Set(quotaRemainingHeader, strconv.FormatInt(body.remaining, 10))
+	if err != nil {
+		h.errs.Write(w, r, err)
+		return
+	}
+	res := UploadResult{Files: []PartInfo{}}
+	for _, p := range parts {
+		if p.Filename == "" {
+			continue
+		}
+		res.Bytes += p.Size
+		res.Files = append(res.Files, p)
+	}
+	_ = httpjson.Respond(w, r, http.StatusOK, res)
+}
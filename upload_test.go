@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// upload posts body to the upload endpoint at url, returning the status,
+// the response body and the quota remaining.
+func upload(t *testing.T, url string, body io.Reader, contentType string) (int, string, string) {
+	t.Helper()
+	resp, err := http.Post(url, contentType, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b), resp.Header.Get(quotaRemainingHeader)
+}
+
+func TestUpload(t *testing.T) {
+	base := startTestApp(t, func(cfg *Config) {
+		cfg.Upload.DailyQuotaBytes = 1 << 20
+		cfg.Upload.MaxFileBytes = 16
+	})
+	url := base + "/upload"
+
+	body, contentType := multipartBody(t, []string{"note", "for ann"}, []string{"a.txt", "abc", "b.txt", "hello"})
+	status, resp, remaining := upload(t, url, bytes.NewReader(body), contentType)
+	if status != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", status, resp)
+	}
+	var got UploadResult
+	if err := json.Unmarshal([]byte(resp), &got); err != nil {
+		t.Fatal(err)
+	}
+	// Only the files are reported, by their own size and hash rather
+	// than that of the form.
+	want := UploadResult{Bytes: 8, Files: []PartInfo{
+		{Field: "file", Filename: "a.txt", ContentType: "application/octet-stream", Size: 3, SHA256: sha256Hex("abc")},
+		{Field: "file", Filename: "b.txt", ContentType: "application/octet-stream", Size: 5, SHA256: sha256Hex("hello")},
+	}}
+	if got.Bytes != want.Bytes || len(got.Files) != 2 || got.Files[0] != want.Files[0] || got.Files[1] != want.Files[1] {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	// The whole form counts towards the quota.
+	if want := strconv.Itoa(1<<20 - len(body)); remaining != want {
+		t.Errorf("got %s remaining, want %s", remaining, want)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		body        []byte
+		contentType string
+		want        int
+	}{
+		{"file over the limit", nil, "", http.StatusRequestEntityTooLarge},
+		{"not a form", []byte("abc"), "text/plain", http.StatusUnsupportedMediaType},
+		{"truncated form", body[:len(body)-10], contentType, http.StatusBadRequest},
+	} {
+		if tc.body == nil {
+			tc.body, tc.contentType = multipartBody(t, nil, []string{"big.bin", strings.Repeat("x", 17)})
+		}
+		if status, resp, _ := upload(t, url, bytes.NewReader(tc.body), tc.contentType); status != tc.want {
+			t.Errorf("%s: got %d %s, want %d", tc.name, status, resp, tc.want)
+		}
+	}
+}
+
+func TestUploadQuota(t *testing.T) {
+	base := startTestApp(t, func(cfg *Config) { cfg.Upload.DailyQuotaBytes = 1024 })
+	url := base + "/upload"
+
+	body, contentType := multipartBody(t, nil, []string{"big.bin", strings.Repeat("x", 2048)})
+	// Announced as too large, it's turned away before it's read.
+	if status, resp, remaining := upload(t, url, bytes.NewReader(body), contentType); status != http.StatusRequestEntityTooLarge || remaining != "1024" {
+		t.Errorf("got %d %s with %s remaining, want 413 with 1024", status, resp, remaining)
+	}
+	// Streamed without a length, it's cut off once over the quota.
+	status, resp, remaining := upload(t, url, io.MultiReader(bytes.NewReader(body)), contentType)
+	if status != http.StatusRequestEntityTooLarge || remaining != "0" {
+		t.Errorf("streamed: got %d %s with %s remaining, want 413 with 0", status, resp, remaining)
+	}
+}
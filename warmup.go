@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"example.com/uberfx/clock"
+	"go.uber.org/fx"
+)
+
+// Warmer is a component that needs to warm up, for instance by filling
+// a cache or probing an upstream, before the app reports ready. Warming
+// up doesn't hold back the listener.
+type Warmer interface {
+	Warm(ctx context.Context) error
+}
+
+// AsWarmer annotates the given constructor to state that it provides a
+// warmer to the "warmers" group.
+func AsWarmer(f any) any {
+	return AsGroupMember[Warmer]("warmers", f)
+}
+
+// WarmupCoordinator runs the warmers concurrently once the app has
+// started, each with its own timeout, and marks the app ready when they
+// have all succeeded. If any fails, Config.Warmup.Policy decides whether
+// the app shuts down ("fail", the default) or keeps running without
+// ever becoming ready ("degrade").
+type WarmupCoordinator struct {
+	warmers   []Warmer
+	timeout   time.Duration
+	degrade   bool
+	readiness *Readiness
+	shutdown  *ShutdownRecorder
+	clock     clock.Clock
+	log       *slog.Logger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewWarmupCoordinator builds a new WarmupCoordinator.
+func NewWarmupCoordinator(warmers []Warmer, cfg *Config, clk clock.Clock, readiness *Readiness, shutdown *ShutdownRecorder, log *slog.Logger) (*WarmupCoordinator, error) {
+	c := &WarmupCoordinator{
+		warmers:   warmers,
+		timeout:   time.Duration(cfg.Warmup.Timeout),
+		readiness: readiness,
+		shutdown:  shutdown,
+		clock:     clk,
+		log:       log,
+	}
+	if c.timeout <= 0 {
+		c.timeout = 30 * time.Second
+	}
+	switch cfg.Warmup.Policy {
+	case "", "fail":
+	case "degrade":
+		c.degrade = true
+	default:
+		return nil, fmt.Errorf("unknown warm-up policy %q", cfg.Warmup.Policy)
+	}
+	return c, nil
+}
+
+// Start begins warming up in the background.
+func (c *WarmupCoordinator) Start(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		c.run(ctx)
+	}()
+	return nil
+}
+
+// Stop abandons a warm-up still in progress.
+func (c *WarmupCoordinator) Stop(ctx context.Context) error {
+	c.readiness.SetReady(false)
+	c.cancel()
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *WarmupCoordinator) run(ctx context.Context) {
+	start := c.clock.Now()
+	c.log.Info("Warming up", slog.Int("warmers", len(c.warmers)))
+
+	errs := make([]error, len(c.warmers))
+	var wg sync.WaitGroup
+	for i, w := range c.warmers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = c.warm(ctx, w)
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+	if err := errors.Join(errs...); err != nil {
+		if c.degrade {
+			c.log.Error("Warm-up failed, staying unready", slog.Duration("duration", c.clock.Now().Sub(start)), slog.String("err", err.Error()))
+			return
+		}
+		c.log.Error("Warm-up failed, shutting down", slog.Duration("duration", c.clock.Now().Sub(start)), slog.String("err", err.Error()))
+		_ = c.shutdown.Shutdown("warm-up failed", fx.ExitCode(1))
+		return
+	}
+	c.readiness.SetReady(true)
+	c.log.Info("Warm-up complete, ready", slog.Duration("duration", c.clock.Now().Sub(start)))
+}
+
+// warm runs a single warmer within the per-warmer timeout.
+func (c *WarmupCoordinator) warm(ctx context.Context, w Warmer) error {
+	name := fmt.Sprintf("%T", w)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := c.clock.Now()
+	err := w.Warm(ctx)
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		c.log.Warn("Warmer failed", slog.String("warmer", name), slog.Duration("duration", c.clock.Now().Sub(start)), slog.String("err", err.Error()))
+		return fmt.Errorf("warm %s: %w", name, err)
+	}
+	c.log.Info("Warmer done", slog.String("warmer", name), slog.Duration("duration", c.clock.Now().Sub(start)))
+	return nil
+}
+
+// RegisterWarmup drives the warm-up from the app lifecycle. It must be
+// invoked after RegisterComponents so that warming up starts once the
+// server is listening.
+func RegisterWarmup(lc fx.Lifecycle, c *WarmupCoordinator) {
+	lc.Append(fx.Hook{
+		OnStart: c.Start,
+		OnStop:  c.Stop,
+	})
+}
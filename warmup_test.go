@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"example.com/uberfx/clock"
+	"go.uber.org/fx"
+)
+
+type warmerFunc func(ctx context.Context) error
+
+func (f warmerFunc) Warm(ctx context.Context) error { return f(ctx) }
+
+// recordingShutdowner is an fx.Shutdowner counting the shutdowns asked
+// of it.
+type recordingShutdowner struct {
+	calls int
+}
+
+func (s *recordingShutdowner) Shutdown(...fx.ShutdownOption) error {
+	s.calls++
+	return nil
+}
+
+func TestWarmupCoordinator(t *testing.T) {
+	ok := warmerFunc(func(context.Context) error { return nil })
+	slow := warmerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	broken := warmerFunc(func(context.Context) error { return errors.New("no upstream") })
+
+	for _, tc := range []struct {
+		name      string
+		policy    string
+		warmers   []Warmer
+		ready     bool
+		shutdowns int
+	}{
+		{"success", "", []Warmer{ok, ok}, true, 0},
+		{"slow warmer", "degrade", []Warmer{ok, slow}, false, 0},
+		{"failure, degrade", "degrade", []Warmer{broken, ok}, false, 0},
+		{"failure, fail", "fail", []Warmer{broken, ok}, false, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Warmup.Policy = tc.policy
+			cfg.Warmup.Timeout = Duration(20 * time.Millisecond)
+			readiness := NewReadiness()
+			shutdowner := &recordingShutdowner{}
+			c, err := NewWarmupCoordinator(tc.warmers, cfg, clock.Real(), readiness, NewShutdownRecorder(shutdowner), slog.New(slog.NewTextHandler(io.Discard, nil)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.run(context.Background())
+			if readiness.Ready() != tc.ready || shutdowner.calls != tc.shutdowns {
+				t.Errorf("got ready %t after %d shutdowns, want ready %t after %d", readiness.Ready(), shutdowner.calls, tc.ready, tc.shutdowns)
+			}
+		})
+	}
+}
+
+func TestWarmupCoordinatorPolicy(t *testing.T) {
+	cfg := &Config{}
+	cfg.Warmup.Policy = "retry"
+	if _, err := NewWarmupCoordinator(nil, cfg, clock.Real(), NewReadiness(), nil, nil); err == nil {
+		t.Error("got no error for an unknown policy")
+	}
+}
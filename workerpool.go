@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrWorkersBusy is reported, wrapped in a *WorkersBusyError, for work
+// submitted to the WorkerPool while its queue is full.
+var ErrWorkersBusy = errors.New("all workers are busy")
+
+// ErrPoolStopped is reported for work submitted to the WorkerPool once
+// it has stopped.
+var ErrPoolStopped = errors.New("worker pool stopped")
+
+// WorkersBusyError is the error of work turned away by the WorkerPool.
+type WorkersBusyError struct {
+	// Wait is how long the client is advised to wait before retrying.
+	Wait time.Duration
+}
+
+func (e *WorkersBusyError) Error() string {
+	return ErrWorkersBusy.Error()
+}
+
+func (e *WorkersBusyError) Is(target error) bool {
+	return target == ErrWorkersBusy
+}
+
+// RetryAfter is sent to the client as the Retry-After header, see
+// ErrorWriter.Write.
+func (e *WorkersBusyError) RetryAfter() time.Duration {
+	return e.Wait
+}
+
+// job is work submitted to the WorkerPool.
+type job struct {
+	fn    func() error
+	state atomic.Int32
+	done  chan error
+}
+
+const (
+	jobQueued int32 = iota
+	jobRunning
+	jobCanceled
+)
+
+// WorkerPool is the component running CPU-heavy work, such as hashing
+// and transforming request bodies, on a fixed number of workers, so
+// that it can't starve the rest of the app under load: Config.Workers.Size,
+// GOMAXPROCS by default. Work waits for a worker in a queue of
+// Config.Workers.QueueSize; work submitted while it's full is turned
+// away at once with a *WorkersBusyError, which the ErrorWriter reports
+// as a 503 with a Retry-After header.
+//
+// Routes opt in by pattern with Config.Workers.Routes, see Run. When
+// the pool stops, the work queued is done before the workers exit.
+type WorkerPool struct {
+	size   int
+	jobs   chan *job
+	routes []string
+	wait   time.Duration
+
+	mu      sync.RWMutex // guards stopped against the close of jobs
+	stopped bool
+	wg      sync.WaitGroup
+
+	rejected prometheus.Counter
+}
+
+// NewWorkerPool builds a new WorkerPool.
+func NewWorkerPool(cfg *Config, reg *prometheus.Registry) *WorkerPool {
+	c := cfg.Workers
+	p := &WorkerPool{size: c.Size, routes: c.Routes, wait: time.Duration(c.RetryAfter)}
+	if p.size <= 0 {
+		p.size = runtime.GOMAXPROCS(0)
+	}
+	queue := c.QueueSize
+	if queue <= 0 {
+		queue = 4 * p.size
+	}
+	if p.wait <= 0 {
+		p.wait = time.Second
+	}
+	p.jobs = make(chan *job, queue)
+	p.rejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "worker_pool_rejected_total",
+		Help: "Work turned away by the worker pool for its queue being full.",
+	})
+	reg.MustRegister(p.rejected, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "worker_pool_queue_depth",
+		Help: "Work waiting for a worker of the worker pool.",
+	}, func() float64 { return float64(len(p.jobs)) }))
+	return p
+}
+
+func (*WorkerPool) Name() string {
+	return "worker-pool"
+}
+
+func (*WorkerPool) Priority() int {
+	return priorityWorkers
+}
+
+// Start starts the workers.
+func (p *WorkerPool) Start(context.Context) error {
+	for range p.size {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return nil
+}
+
+// Stop turns new work away, and waits for the workers to be done with
+// the work queued.
+func (p *WorkerPool) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("worker pool: %d jobs left: %w", len(p.jobs), ctx.Err())
+	}
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	for j := range p.jobs {
+		if !j.state.CompareAndSwap(jobQueued, jobRunning) {
+			continue
+		}
+		j.done <- j.fn()
+	}
+}
+
+// Submit runs fn on a worker and returns its error. If all workers are
+// busy, fn waits in the queue until one is free or ctx is done, in which
+// case Submit returns ctx's error and fn doesn't run; once fn runs,
+// Submit waits for it to return. Work submitted while the queue is full
+// fails at once with a *WorkersBusyError.
+func (p *WorkerPool) Submit(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j := &job{fn: fn, done: make(chan error, 1)}
+	p.mu.RLock()
+	if p.stopped {
+		p.mu.RUnlock()
+		return NewStatusError(http.StatusServiceUnavailable, ErrPoolStopped)
+	}
+	select {
+	case p.jobs <- j:
+		p.mu.RUnlock()
+	default:
+		p.mu.RUnlock()
+		p.rejected.Inc()
+		return &WorkersBusyError{Wait: p.wait}
+	}
+
+	select {
+	case err := <-j.done:
+		return err
+	case <-ctx.Done():
+		if j.state.CompareAndSwap(jobQueued, jobCanceled) {
+			return ctx.Err()
+		}
+		return <-j.done
+	}
+}
+
+// Run runs fn for the route with the given pattern: on a worker, see
+// Submit, if the route is listed in Config.Workers.Routes, or else
+// right away.
+func (p *WorkerPool) Run(ctx context.Context, pattern string, fn func() error) error {
+	for _, want := range p.routes {
+		if matchRoute(want, pattern) {
+			return p.Submit(ctx, fn)
+		}
+	}
+	return fn()
+}